@@ -0,0 +1,842 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: api/proto/rdns.proto
+
+package rdnspb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type Hosts struct {
+	Hosts                []string `protobuf:"bytes,1,rep,name=hosts,proto3" json:"hosts,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Hosts) Reset()         { *m = Hosts{} }
+func (m *Hosts) String() string { return proto.CompactTextString(m) }
+func (*Hosts) ProtoMessage()    {}
+func (*Hosts) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{0}
+}
+
+func (m *Hosts) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Hosts.Unmarshal(m, b)
+}
+func (m *Hosts) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Hosts.Marshal(b, m, deterministic)
+}
+func (m *Hosts) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Hosts.Merge(m, src)
+}
+func (m *Hosts) XXX_Size() int {
+	return xxx_messageInfo_Hosts.Size(m)
+}
+func (m *Hosts) XXX_DiscardUnknown() {
+	xxx_messageInfo_Hosts.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Hosts proto.InternalMessageInfo
+
+func (m *Hosts) GetHosts() []string {
+	if m != nil {
+		return m.Hosts
+	}
+	return nil
+}
+
+type CreateDomainRequest struct {
+	Hosts                []string          `protobuf:"bytes,1,rep,name=hosts,proto3" json:"hosts,omitempty"`
+	SubDomain            map[string]*Hosts `protobuf:"bytes,2,rep,name=sub_domain,json=subDomain,proto3" json:"sub_domain,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Ttl                  int64             `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	LeaseTime            string            `protobuf:"bytes,4,opt,name=lease_time,json=leaseTime,proto3" json:"lease_time,omitempty"`
+	Owner                string            `protobuf:"bytes,5,opt,name=owner,proto3" json:"owner,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *CreateDomainRequest) Reset()         { *m = CreateDomainRequest{} }
+func (m *CreateDomainRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateDomainRequest) ProtoMessage()    {}
+func (*CreateDomainRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{1}
+}
+
+func (m *CreateDomainRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateDomainRequest.Unmarshal(m, b)
+}
+func (m *CreateDomainRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateDomainRequest.Marshal(b, m, deterministic)
+}
+func (m *CreateDomainRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateDomainRequest.Merge(m, src)
+}
+func (m *CreateDomainRequest) XXX_Size() int {
+	return xxx_messageInfo_CreateDomainRequest.Size(m)
+}
+func (m *CreateDomainRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateDomainRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateDomainRequest proto.InternalMessageInfo
+
+func (m *CreateDomainRequest) GetHosts() []string {
+	if m != nil {
+		return m.Hosts
+	}
+	return nil
+}
+
+func (m *CreateDomainRequest) GetSubDomain() map[string]*Hosts {
+	if m != nil {
+		return m.SubDomain
+	}
+	return nil
+}
+
+func (m *CreateDomainRequest) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+func (m *CreateDomainRequest) GetLeaseTime() string {
+	if m != nil {
+		return m.LeaseTime
+	}
+	return ""
+}
+
+func (m *CreateDomainRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+type DomainRequest struct {
+	Fqdn                 string   `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DomainRequest) Reset()         { *m = DomainRequest{} }
+func (m *DomainRequest) String() string { return proto.CompactTextString(m) }
+func (*DomainRequest) ProtoMessage()    {}
+func (*DomainRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{2}
+}
+
+func (m *DomainRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DomainRequest.Unmarshal(m, b)
+}
+func (m *DomainRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DomainRequest.Marshal(b, m, deterministic)
+}
+func (m *DomainRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DomainRequest.Merge(m, src)
+}
+func (m *DomainRequest) XXX_Size() int {
+	return xxx_messageInfo_DomainRequest.Size(m)
+}
+func (m *DomainRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DomainRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DomainRequest proto.InternalMessageInfo
+
+func (m *DomainRequest) GetFqdn() string {
+	if m != nil {
+		return m.Fqdn
+	}
+	return ""
+}
+
+type SetTextsRequest struct {
+	Fqdn                 string   `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	Texts                []string `protobuf:"bytes,2,rep,name=texts,proto3" json:"texts,omitempty"`
+	Ttl                  int64    `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetTextsRequest) Reset()         { *m = SetTextsRequest{} }
+func (m *SetTextsRequest) String() string { return proto.CompactTextString(m) }
+func (*SetTextsRequest) ProtoMessage()    {}
+func (*SetTextsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{3}
+}
+
+func (m *SetTextsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetTextsRequest.Unmarshal(m, b)
+}
+func (m *SetTextsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetTextsRequest.Marshal(b, m, deterministic)
+}
+func (m *SetTextsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetTextsRequest.Merge(m, src)
+}
+func (m *SetTextsRequest) XXX_Size() int {
+	return xxx_messageInfo_SetTextsRequest.Size(m)
+}
+func (m *SetTextsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetTextsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetTextsRequest proto.InternalMessageInfo
+
+func (m *SetTextsRequest) GetFqdn() string {
+	if m != nil {
+		return m.Fqdn
+	}
+	return ""
+}
+
+func (m *SetTextsRequest) GetTexts() []string {
+	if m != nil {
+		return m.Texts
+	}
+	return nil
+}
+
+func (m *SetTextsRequest) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+type SetSubRecordsRequest struct {
+	Fqdn                 string            `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	SubDomain            map[string]*Hosts `protobuf:"bytes,2,rep,name=sub_domain,json=subDomain,proto3" json:"sub_domain,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Ttl                  int64             `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *SetSubRecordsRequest) Reset()         { *m = SetSubRecordsRequest{} }
+func (m *SetSubRecordsRequest) String() string { return proto.CompactTextString(m) }
+func (*SetSubRecordsRequest) ProtoMessage()    {}
+func (*SetSubRecordsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{4}
+}
+
+func (m *SetSubRecordsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetSubRecordsRequest.Unmarshal(m, b)
+}
+func (m *SetSubRecordsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetSubRecordsRequest.Marshal(b, m, deterministic)
+}
+func (m *SetSubRecordsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetSubRecordsRequest.Merge(m, src)
+}
+func (m *SetSubRecordsRequest) XXX_Size() int {
+	return xxx_messageInfo_SetSubRecordsRequest.Size(m)
+}
+func (m *SetSubRecordsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetSubRecordsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetSubRecordsRequest proto.InternalMessageInfo
+
+func (m *SetSubRecordsRequest) GetFqdn() string {
+	if m != nil {
+		return m.Fqdn
+	}
+	return ""
+}
+
+func (m *SetSubRecordsRequest) GetSubDomain() map[string]*Hosts {
+	if m != nil {
+		return m.SubDomain
+	}
+	return nil
+}
+
+func (m *SetSubRecordsRequest) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+type WatchRecordsRequest struct {
+	Fqdn                 string   `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchRecordsRequest) Reset()         { *m = WatchRecordsRequest{} }
+func (m *WatchRecordsRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchRecordsRequest) ProtoMessage()    {}
+func (*WatchRecordsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{5}
+}
+
+func (m *WatchRecordsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchRecordsRequest.Unmarshal(m, b)
+}
+func (m *WatchRecordsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchRecordsRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchRecordsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchRecordsRequest.Merge(m, src)
+}
+func (m *WatchRecordsRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchRecordsRequest.Size(m)
+}
+func (m *WatchRecordsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchRecordsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchRecordsRequest proto.InternalMessageInfo
+
+func (m *WatchRecordsRequest) GetFqdn() string {
+	if m != nil {
+		return m.Fqdn
+	}
+	return ""
+}
+
+type Domain struct {
+	Fqdn                 string            `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	Hosts                []string          `protobuf:"bytes,2,rep,name=hosts,proto3" json:"hosts,omitempty"`
+	SubDomain            map[string]*Hosts `protobuf:"bytes,3,rep,name=sub_domain,json=subDomain,proto3" json:"sub_domain,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Texts                []string          `protobuf:"bytes,4,rep,name=texts,proto3" json:"texts,omitempty"`
+	Ttl                  int64             `protobuf:"varint,5,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	Expiration           int64             `protobuf:"varint,6,opt,name=expiration,proto3" json:"expiration,omitempty"`
+	Token                string            `protobuf:"bytes,7,opt,name=token,proto3" json:"token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Domain) Reset()         { *m = Domain{} }
+func (m *Domain) String() string { return proto.CompactTextString(m) }
+func (*Domain) ProtoMessage()    {}
+func (*Domain) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{6}
+}
+
+func (m *Domain) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Domain.Unmarshal(m, b)
+}
+func (m *Domain) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Domain.Marshal(b, m, deterministic)
+}
+func (m *Domain) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Domain.Merge(m, src)
+}
+func (m *Domain) XXX_Size() int {
+	return xxx_messageInfo_Domain.Size(m)
+}
+func (m *Domain) XXX_DiscardUnknown() {
+	xxx_messageInfo_Domain.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Domain proto.InternalMessageInfo
+
+func (m *Domain) GetFqdn() string {
+	if m != nil {
+		return m.Fqdn
+	}
+	return ""
+}
+
+func (m *Domain) GetHosts() []string {
+	if m != nil {
+		return m.Hosts
+	}
+	return nil
+}
+
+func (m *Domain) GetSubDomain() map[string]*Hosts {
+	if m != nil {
+		return m.SubDomain
+	}
+	return nil
+}
+
+func (m *Domain) GetTexts() []string {
+	if m != nil {
+		return m.Texts
+	}
+	return nil
+}
+
+func (m *Domain) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+func (m *Domain) GetExpiration() int64 {
+	if m != nil {
+		return m.Expiration
+	}
+	return 0
+}
+
+func (m *Domain) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+type RecordEvent struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type                 string   `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Fqdn                 string   `protobuf:"bytes,3,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	ValueType            string   `protobuf:"bytes,4,opt,name=value_type,json=valueType,proto3" json:"value_type,omitempty"`
+	RequestId            string   `protobuf:"bytes,5,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Timestamp            int64    `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RecordEvent) Reset()         { *m = RecordEvent{} }
+func (m *RecordEvent) String() string { return proto.CompactTextString(m) }
+func (*RecordEvent) ProtoMessage()    {}
+func (*RecordEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{7}
+}
+
+func (m *RecordEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RecordEvent.Unmarshal(m, b)
+}
+func (m *RecordEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RecordEvent.Marshal(b, m, deterministic)
+}
+func (m *RecordEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RecordEvent.Merge(m, src)
+}
+func (m *RecordEvent) XXX_Size() int {
+	return xxx_messageInfo_RecordEvent.Size(m)
+}
+func (m *RecordEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_RecordEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RecordEvent proto.InternalMessageInfo
+
+func (m *RecordEvent) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *RecordEvent) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *RecordEvent) GetFqdn() string {
+	if m != nil {
+		return m.Fqdn
+	}
+	return ""
+}
+
+func (m *RecordEvent) GetValueType() string {
+	if m != nil {
+		return m.ValueType
+	}
+	return ""
+}
+
+func (m *RecordEvent) GetRequestId() string {
+	if m != nil {
+		return m.RequestId
+	}
+	return ""
+}
+
+func (m *RecordEvent) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+type Empty struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Empty) Reset()         { *m = Empty{} }
+func (m *Empty) String() string { return proto.CompactTextString(m) }
+func (*Empty) ProtoMessage()    {}
+func (*Empty) Descriptor() ([]byte, []int) {
+	return fileDescriptor_04948537164e8d8f, []int{8}
+}
+
+func (m *Empty) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Empty.Unmarshal(m, b)
+}
+func (m *Empty) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Empty.Marshal(b, m, deterministic)
+}
+func (m *Empty) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Empty.Merge(m, src)
+}
+func (m *Empty) XXX_Size() int {
+	return xxx_messageInfo_Empty.Size(m)
+}
+func (m *Empty) XXX_DiscardUnknown() {
+	xxx_messageInfo_Empty.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Empty proto.InternalMessageInfo
+
+func init() {
+	proto.RegisterType((*Hosts)(nil), "rdns.Hosts")
+	proto.RegisterType((*CreateDomainRequest)(nil), "rdns.CreateDomainRequest")
+	proto.RegisterMapType((map[string]*Hosts)(nil), "rdns.CreateDomainRequest.SubDomainEntry")
+	proto.RegisterType((*DomainRequest)(nil), "rdns.DomainRequest")
+	proto.RegisterType((*SetTextsRequest)(nil), "rdns.SetTextsRequest")
+	proto.RegisterType((*SetSubRecordsRequest)(nil), "rdns.SetSubRecordsRequest")
+	proto.RegisterMapType((map[string]*Hosts)(nil), "rdns.SetSubRecordsRequest.SubDomainEntry")
+	proto.RegisterType((*WatchRecordsRequest)(nil), "rdns.WatchRecordsRequest")
+	proto.RegisterType((*Domain)(nil), "rdns.Domain")
+	proto.RegisterMapType((map[string]*Hosts)(nil), "rdns.Domain.SubDomainEntry")
+	proto.RegisterType((*RecordEvent)(nil), "rdns.RecordEvent")
+	proto.RegisterType((*Empty)(nil), "rdns.Empty")
+}
+
+func init() { proto.RegisterFile("api/proto/rdns.proto", fileDescriptor_04948537164e8d8f) }
+
+var fileDescriptor_04948537164e8d8f = []byte{
+	// 599 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0xcd, 0x6e, 0xd3, 0x4c,
+	0x14, 0x95, 0xed, 0xb8, 0xfd, 0x7c, 0x93, 0xf6, 0x83, 0x49, 0x90, 0x4c, 0x20, 0x28, 0x98, 0x8d,
+	0xbb, 0x20, 0x2e, 0x61, 0x01, 0x14, 0x89, 0x05, 0x24, 0xa2, 0x5d, 0xc0, 0xc2, 0x89, 0x84, 0xc4,
+	0x26, 0xb2, 0xe3, 0x0b, 0xb1, 0x1a, 0xff, 0x74, 0x3c, 0x4e, 0x9b, 0x17, 0xe0, 0x15, 0x58, 0xf3,
+	0x44, 0x88, 0x37, 0x42, 0x33, 0xe3, 0xa4, 0x4e, 0x71, 0x82, 0x90, 0xba, 0xbb, 0x7f, 0x73, 0xe6,
+	0xde, 0x73, 0xe7, 0x0c, 0xb4, 0xbc, 0x34, 0x74, 0x52, 0x9a, 0xb0, 0xc4, 0xa1, 0x41, 0x9c, 0xf5,
+	0x84, 0x49, 0x6a, 0xdc, 0xb6, 0x3a, 0xa0, 0x9f, 0x26, 0x19, 0xcb, 0x48, 0x0b, 0xf4, 0x19, 0x37,
+	0x4c, 0xa5, 0xab, 0xd9, 0x86, 0x2b, 0x1d, 0xeb, 0x9b, 0x0a, 0xcd, 0x77, 0x14, 0x3d, 0x86, 0x83,
+	0x24, 0xf2, 0xc2, 0xd8, 0xc5, 0x8b, 0x1c, 0x33, 0x56, 0x5d, 0x4d, 0xde, 0x03, 0x64, 0xb9, 0x3f,
+	0x09, 0x44, 0xa9, 0xa9, 0x76, 0x35, 0xbb, 0xde, 0xb7, 0x7b, 0xe2, 0xce, 0x0a, 0x90, 0xde, 0x28,
+	0xf7, 0x65, 0x60, 0x18, 0x33, 0xba, 0x74, 0x8d, 0x6c, 0xe5, 0x93, 0x3b, 0xa0, 0x31, 0x36, 0x37,
+	0xb5, 0xae, 0x62, 0x6b, 0x2e, 0x37, 0x49, 0x07, 0x60, 0x8e, 0x5e, 0x86, 0x13, 0x16, 0x46, 0x68,
+	0xd6, 0xba, 0x8a, 0x6d, 0xb8, 0x86, 0x88, 0x8c, 0xc3, 0x08, 0x79, 0x3f, 0xc9, 0x65, 0x8c, 0xd4,
+	0xd4, 0x45, 0x46, 0x3a, 0xed, 0x33, 0x38, 0xdc, 0xbc, 0x83, 0x03, 0x9f, 0xe3, 0xd2, 0x54, 0x44,
+	0x15, 0x37, 0xc9, 0x63, 0xd0, 0x17, 0xde, 0x3c, 0x47, 0x53, 0xed, 0x2a, 0x76, 0xbd, 0x5f, 0x97,
+	0xed, 0x0a, 0x4e, 0x5c, 0x99, 0x39, 0x51, 0x5f, 0x2a, 0xd6, 0x13, 0x38, 0xd8, 0x64, 0x80, 0x40,
+	0xed, 0xcb, 0x45, 0x10, 0x17, 0x50, 0xc2, 0xb6, 0x3e, 0xc0, 0xff, 0x23, 0x64, 0x63, 0xbc, 0x62,
+	0xd9, 0x8e, 0x32, 0xde, 0x2c, 0xe3, 0x35, 0x82, 0x21, 0xc3, 0x95, 0xce, 0x9f, 0x33, 0x5b, 0xbf,
+	0x14, 0x68, 0x8d, 0x90, 0x8d, 0x72, 0xdf, 0xc5, 0x69, 0x42, 0x83, 0x9d, 0xa0, 0xa7, 0x15, 0xdc,
+	0x1f, 0xc9, 0x61, 0xaa, 0x30, 0xfe, 0x85, 0xfc, 0xdb, 0xe4, 0xf1, 0x08, 0x9a, 0x9f, 0x3c, 0x36,
+	0x9d, 0xfd, 0x7d, 0x22, 0xeb, 0xbb, 0x0a, 0x7b, 0x45, 0x4b, 0x5b, 0x58, 0x94, 0x4f, 0x50, 0x2d,
+	0x3f, 0xc1, 0x93, 0x0d, 0x1a, 0x34, 0x41, 0xc3, 0x03, 0xd9, 0x8b, 0xc4, 0xda, 0x31, 0xf8, 0x7a,
+	0x2f, 0xb5, 0x8a, 0xbd, 0xe8, 0xd7, 0x6f, 0xf1, 0x11, 0x00, 0x5e, 0xa5, 0x21, 0xf5, 0x58, 0x98,
+	0xc4, 0xe6, 0x9e, 0x48, 0x94, 0x22, 0x02, 0x27, 0x39, 0xc7, 0xd8, 0xdc, 0x97, 0x8f, 0x51, 0x38,
+	0xb7, 0x49, 0xe2, 0x0f, 0x05, 0xea, 0x92, 0xc0, 0xe1, 0x02, 0x63, 0x46, 0x0e, 0x41, 0x0d, 0x03,
+	0x81, 0xa3, 0xb9, 0x6a, 0x18, 0x70, 0xba, 0xd8, 0x32, 0x95, 0x28, 0x86, 0x2b, 0xec, 0x35, 0x85,
+	0x5a, 0x89, 0xc2, 0x0e, 0x80, 0x00, 0x9d, 0x88, 0xea, 0x42, 0x54, 0x22, 0x32, 0xe6, 0x47, 0x3a,
+	0x00, 0x54, 0xee, 0x67, 0x12, 0x06, 0x85, 0xb2, 0x8c, 0x22, 0x72, 0x16, 0x90, 0x87, 0x60, 0x70,
+	0x31, 0x66, 0xcc, 0x8b, 0xd2, 0x82, 0x85, 0xeb, 0x80, 0xb5, 0x0f, 0xfa, 0x30, 0x4a, 0xd9, 0xb2,
+	0xff, 0x53, 0x85, 0x9a, 0x3b, 0xf8, 0x38, 0x22, 0x2f, 0xa0, 0x51, 0xfe, 0x05, 0xc8, 0xfd, 0xad,
+	0x3f, 0x43, 0xbb, 0x51, 0xde, 0x18, 0x39, 0xe6, 0xd3, 0xc6, 0x78, 0x59, 0xb8, 0xcd, 0x72, 0x72,
+	0xdb, 0x89, 0xc6, 0x00, 0xe7, 0xc8, 0x70, 0xd7, 0x91, 0x82, 0x5d, 0xd1, 0x25, 0x71, 0xe0, 0xbf,
+	0x95, 0x74, 0xc9, 0xbd, 0xb5, 0x6c, 0xca, 0x52, 0xbe, 0x71, 0xc5, 0x2b, 0x38, 0xd8, 0xd0, 0x15,
+	0x69, 0x6f, 0x17, 0xdb, 0x8d, 0xa3, 0x6f, 0xa0, 0x51, 0xd6, 0xc0, 0x8a, 0x88, 0x0a, 0x5d, 0xb4,
+	0xef, 0xca, 0x54, 0x69, 0xd9, 0xc7, 0xca, 0xdb, 0x67, 0x9f, 0x9d, 0xaf, 0x21, 0x9b, 0xe5, 0x7e,
+	0x6f, 0x9a, 0x44, 0x0e, 0xf5, 0xe2, 0xe9, 0x0c, 0xa9, 0xf8, 0xda, 0x9f, 0x66, 0x48, 0x17, 0x48,
+	0x9d, 0xf5, 0x87, 0xff, 0x9a, 0x47, 0x53, 0xdf, 0xdf, 0x13, 0xde, 0xf3, 0xdf, 0x03, 0x00, 0x96,
+	0xed, 0x65, 0x92, 0x0b, 0x06, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// RDNSClient is the client API for RDNS service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type RDNSClient interface {
+	CreateDomain(ctx context.Context, in *CreateDomainRequest, opts ...grpc.CallOption) (*Domain, error)
+	RenewDomain(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*Domain, error)
+	DeleteDomain(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*Empty, error)
+	SetTexts(ctx context.Context, in *SetTextsRequest, opts ...grpc.CallOption) (*Domain, error)
+	SetSubRecords(ctx context.Context, in *SetSubRecordsRequest, opts ...grpc.CallOption) (*Domain, error)
+	WatchRecords(ctx context.Context, in *WatchRecordsRequest, opts ...grpc.CallOption) (RDNS_WatchRecordsClient, error)
+}
+
+type rDNSClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewRDNSClient(cc *grpc.ClientConn) RDNSClient {
+	return &rDNSClient{cc}
+}
+
+func (c *rDNSClient) CreateDomain(ctx context.Context, in *CreateDomainRequest, opts ...grpc.CallOption) (*Domain, error) {
+	out := new(Domain)
+	err := c.cc.Invoke(ctx, "/rdns.RDNS/CreateDomain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rDNSClient) RenewDomain(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*Domain, error) {
+	out := new(Domain)
+	err := c.cc.Invoke(ctx, "/rdns.RDNS/RenewDomain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rDNSClient) DeleteDomain(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/rdns.RDNS/DeleteDomain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rDNSClient) SetTexts(ctx context.Context, in *SetTextsRequest, opts ...grpc.CallOption) (*Domain, error) {
+	out := new(Domain)
+	err := c.cc.Invoke(ctx, "/rdns.RDNS/SetTexts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rDNSClient) SetSubRecords(ctx context.Context, in *SetSubRecordsRequest, opts ...grpc.CallOption) (*Domain, error) {
+	out := new(Domain)
+	err := c.cc.Invoke(ctx, "/rdns.RDNS/SetSubRecords", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rDNSClient) WatchRecords(ctx context.Context, in *WatchRecordsRequest, opts ...grpc.CallOption) (RDNS_WatchRecordsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_RDNS_serviceDesc.Streams[0], "/rdns.RDNS/WatchRecords", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rDNSWatchRecordsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RDNS_WatchRecordsClient interface {
+	Recv() (*RecordEvent, error)
+	grpc.ClientStream
+}
+
+type rDNSWatchRecordsClient struct {
+	grpc.ClientStream
+}
+
+func (x *rDNSWatchRecordsClient) Recv() (*RecordEvent, error) {
+	m := new(RecordEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RDNSServer is the server API for RDNS service.
+type RDNSServer interface {
+	CreateDomain(context.Context, *CreateDomainRequest) (*Domain, error)
+	RenewDomain(context.Context, *DomainRequest) (*Domain, error)
+	DeleteDomain(context.Context, *DomainRequest) (*Empty, error)
+	SetTexts(context.Context, *SetTextsRequest) (*Domain, error)
+	SetSubRecords(context.Context, *SetSubRecordsRequest) (*Domain, error)
+	WatchRecords(*WatchRecordsRequest, RDNS_WatchRecordsServer) error
+}
+
+func RegisterRDNSServer(s *grpc.Server, srv RDNSServer) {
+	s.RegisterService(&_RDNS_serviceDesc, srv)
+}
+
+func _RDNS_CreateDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateDomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RDNSServer).CreateDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rdns.RDNS/CreateDomain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RDNSServer).CreateDomain(ctx, req.(*CreateDomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RDNS_RenewDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RDNSServer).RenewDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rdns.RDNS/RenewDomain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RDNSServer).RenewDomain(ctx, req.(*DomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RDNS_DeleteDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RDNSServer).DeleteDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rdns.RDNS/DeleteDomain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RDNSServer).DeleteDomain(ctx, req.(*DomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RDNS_SetTexts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetTextsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RDNSServer).SetTexts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rdns.RDNS/SetTexts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RDNSServer).SetTexts(ctx, req.(*SetTextsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RDNS_SetSubRecords_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetSubRecordsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RDNSServer).SetSubRecords(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rdns.RDNS/SetSubRecords",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RDNSServer).SetSubRecords(ctx, req.(*SetSubRecordsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RDNS_WatchRecords_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRecordsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RDNSServer).WatchRecords(m, &rDNSWatchRecordsServer{stream})
+}
+
+type RDNS_WatchRecordsServer interface {
+	Send(*RecordEvent) error
+	grpc.ServerStream
+}
+
+type rDNSWatchRecordsServer struct {
+	grpc.ServerStream
+}
+
+func (x *rDNSWatchRecordsServer) Send(m *RecordEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _RDNS_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "rdns.RDNS",
+	HandlerType: (*RDNSServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateDomain",
+			Handler:    _RDNS_CreateDomain_Handler,
+		},
+		{
+			MethodName: "RenewDomain",
+			Handler:    _RDNS_RenewDomain_Handler,
+		},
+		{
+			MethodName: "DeleteDomain",
+			Handler:    _RDNS_DeleteDomain_Handler,
+		},
+		{
+			MethodName: "SetTexts",
+			Handler:    _RDNS_SetTexts_Handler,
+		},
+		{
+			MethodName: "SetSubRecords",
+			Handler:    _RDNS_SetSubRecords_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRecords",
+			Handler:       _RDNS_WatchRecords_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/proto/rdns.proto",
+}
@@ -1,6 +1,8 @@
 package backend
 
 import (
+	"time"
+
 	"github.com/rancher/rdns-server/model"
 
 	"github.com/sirupsen/logrus"
@@ -10,10 +12,16 @@ var currentBackend Backend
 
 type Backend interface {
 	Get(opts *model.DomainOptions) (model.Domain, error)
+	ListSubDomains(opts *model.DomainOptions) ([]model.SubDomain, error)
 	Set(opts *model.DomainOptions) (model.Domain, error)
 	Update(opts *model.DomainOptions) (model.Domain, error)
 	Delete(opts *model.DomainOptions) error
 	Renew(opts *model.DomainOptions) (model.Domain, error)
+	Transfer(opts *model.DomainOptions) (model.Domain, error)
+	Release(opts *model.DomainOptions, frozen time.Duration) (model.Domain, error)
+	UpsertRecords(fqdn string, batch *model.RecordsBatch) (model.Domain, error)
+	AddAHosts(fqdn string, hosts []string) (model.Domain, error)
+	RemoveAHosts(fqdn string, hosts []string) (model.Domain, error)
 	SetText(opts *model.DomainOptions) (model.Domain, error)
 	GetText(opts *model.DomainOptions) (model.Domain, error)
 	UpdateText(opts *model.DomainOptions) (model.Domain, error)
@@ -22,11 +30,39 @@ type Backend interface {
 	GetCNAME(opts *model.DomainOptions) (model.Domain, error)
 	UpdateCNAME(opts *model.DomainOptions) (model.Domain, error)
 	DeleteCNAME(opts *model.DomainOptions) error
+	SetCAA(opts *model.DomainOptions) (model.Domain, error)
+	GetCAA(opts *model.DomainOptions) (model.Domain, error)
+	UpdateCAA(opts *model.DomainOptions) (model.Domain, error)
+	DeleteCAA(opts *model.DomainOptions) error
+	SetMX(opts *model.DomainOptions) (model.Domain, error)
+	GetMX(opts *model.DomainOptions) (model.Domain, error)
+	UpdateMX(opts *model.DomainOptions) (model.Domain, error)
+	DeleteMX(opts *model.DomainOptions) error
 	GetToken(fqdn string) (string, error)
+	GetPreviousToken(fqdn string) (string, error)
+	GetReplacedTokens(fqdn string) ([]string, error)
+	RehashToken(fqdn, origin, hashed string) error
+	GetTokenScopes(fqdn string) ([]string, error)
+	GetTokenLimits(fqdn string) (model.TokenLimits, error)
+	SetTokenLimits(fqdn string, limits model.TokenLimits) (model.TokenLimits, error)
 	GetTokenCount() (int64, error)
+	GetStats() (*model.Stats, error)
+	Healthy() error
+	ReserveIdempotency(r *model.Idempotency) (bool, error)
+	SetIdempotency(r *model.Idempotency) error
+	GetIdempotency(key string) (*model.Idempotency, error)
+	DeleteIdempotency(key string) error
 	GetZone() string
+	GetNameServers() []string
 	GetName() string
 	MigrateFrozen(opts *model.MigrateFrozen) error
+	GetFrozen(prefix string) (model.Frozen, error)
+	SetFrozen(prefix string, ttl time.Duration) (model.Frozen, error)
+	DeleteFrozen(prefix string) error
+	GetFrozenOwner(prefix string) (string, error)
+	ReserveFrozen(prefix string, ttl time.Duration) (model.Frozen, error)
+	RenewFrozen(prefix, owner string, ttl time.Duration) (model.Frozen, error)
+	ReleaseFrozen(prefix, owner string) error
 	MigrateToken(opts *model.MigrateToken) error
 	MigrateRecord(opts *model.MigrateRecord) error
 }
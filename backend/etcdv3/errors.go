@@ -2,9 +2,13 @@ package etcdv3
 
 const (
 	errDeleteRecord           = "failed to delete %s record: %s"
+	errDecodeRecord           = "failed to decode %s record: %s"
 	errEmptyRecord            = "failed to found %s record: %s"
+	errExistRecord            = "%s record: %s already exist"
 	errExistSlug              = "slug name %s can not be used, try another"
+	errGenerateSlug           = "failed to generate a slug name which is not frozen or used in %d times"
 	errGrantLease             = "failed to grant lease"
+	errSetRecord              = "failed to set %s record: %s"
 	errSetRecordWithLease     = "failed to set %s record %s with lease %d"
 	errSyncRecords            = "failed to sync %s records: %s"
 	errSyncSubRecords         = "failed to sync sub %s records: %s"
@@ -14,4 +18,21 @@ const (
 	errMultiRecords           = "multiple %s records: %s"
 	errNoLookupResults        = "no lookup results for %s record: %s"
 	errNotValidDomainName     = "not valid domain name: %s"
+	errNotValidHost           = "not valid host %s of %s, must be an ip address"
+	errNotValidText           = "not valid %s record of %s: %s"
+	errNotValidFrozen         = "not valid frozen duration %s, must not be greater than %s"
+	errNotValidPrefix         = "not valid prefix %s, must be a dns label"
+	errFrozenInUse            = "prefix %s is used by a domain, release the domain instead"
+	errReservedPrefix         = "prefix %s is already frozen or used by a domain"
+	errNotReservedBy          = "prefix %s is not reserved by the token"
+	errReplacedToken          = "token of %s is replaced by another transfer"
+	errQuotaExceeded          = "%s records of %s exceed the quota %d"
+	errCountConflict          = "failed to count the records of %s in %d tries, too many concurrent writes"
+	errHostsConflict          = "failed to merge the hosts of %s in %d tries, too many concurrent writes"
+	errPartialRelease         = "domain %s is frozen, but failed to delete some records: %s"
+	errNotValidCAA            = "not valid %s record %s: %s"
+	errNotValidMX             = "not valid %s record %s: %s"
+	errNotValidDepth          = "not valid depth of %s, must not be greater than %d"
+	errNotValidTTL            = "not valid ttl %d, must be in range [%d, %d]"
+	errNotValidLeaseTime      = "not valid lease time %s, must be in range [%s, %s]"
 )
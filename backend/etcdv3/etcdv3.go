@@ -2,10 +2,16 @@ package etcdv3
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rancher/rdns-server/model"
@@ -14,6 +20,7 @@ import (
 	"github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
 	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -22,21 +29,59 @@ const (
 	Name             = "etcdv3"
 	typeA            = "A"
 	typeTXT          = "TXT"
+	typeCAA          = "CAA"
+	typeMX           = "MX"
+	typePTR          = "PTR"
 	typeToken        = "TOKEN"
 	typeFrozen       = "FROZEN"
+	typeIdempotency  = "IDEMPOTENCY"
+	typeMigration    = "MIGRATION"
 	tokenPath        = "/tokenv3"
+	previousPath     = "/previoustokenv3"
+	scopePath        = "/scopev3"
+	ownerPath        = "/ownerv3"
+	limitsPath       = "/limitsv3"
+	countPath        = "/countv3"
+	replacedPath     = "/replacedtokenv3"
 	frozenPath       = "/frozenv3"
+	idempotencyPath  = "/idempotencyv3"
+	migrationPath    = "/migrationv3"
+	caaPath          = "/_caa"
+	mxPath           = "/_mx"
+	txtPath          = "/_txt"
 	maxSlugHashTimes = 100
 	tokenLength      = 32
 	slugLength       = 6
+	maxTextLength    = 255
+	batchWorkers     = 8
+	maxCountRetries  = 16
 	operationTimeout = 100 * time.Millisecond
+	statsTimeout     = 5 * time.Second
 )
 
+// the prefix is a dns label in lower case, as the slug names are
+var prefixPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
 type Backend struct {
 	Domain    string
 	Prefix    string
 	FrozenTTL time.Duration
 	LeaseTime time.Duration
+	// the range of the lease time which can be set to a token on creation
+	MinLeaseTime time.Duration
+	MaxLeaseTime time.Duration
+	MinTTL       int64
+	MaxTTL       int64
+	MaxDepth     int
+	// the quotas of the sub-domains and the TXT values of a domain, and of the A, sub A and TXT records of a token,
+	// zero means no limit
+	MaxSubDomains int
+	MaxTexts      int
+	MaxRecords    int
+	// the token replaced by a transfer is still valid for read-only requests during the grace period
+	TokenGrace time.Duration
+	// the server secret which the tokens are hashed at rest with, the tokens are stored as plaintext when it is empty
+	TokenSecret string
 
 	C *clientv3.Client
 }
@@ -54,17 +99,63 @@ func NewBackend() (*Backend, error) {
 	if err != nil {
 		return nil, err
 	}
+	minLeaseTime, err := time.ParseDuration(os.Getenv("MIN_LEASE_TIME"))
+	if err != nil {
+		return nil, err
+	}
+	maxLeaseTime, err := time.ParseDuration(os.Getenv("MAX_LEASE_TIME"))
+	if err != nil {
+		return nil, err
+	}
 	frozen, err := time.ParseDuration(os.Getenv("FROZEN"))
 	if err != nil {
 		return nil, err
 	}
+	minTTL, err := strconv.ParseInt(os.Getenv("MIN_TTL"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	maxTTL, err := strconv.ParseInt(os.Getenv("MAX_TTL"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	maxDepth, err := strconv.Atoi(os.Getenv("MAX_SUBDOMAIN_DEPTH"))
+	if err != nil {
+		return nil, err
+	}
+	tokenGrace, err := time.ParseDuration(os.Getenv("TOKEN_GRACE_PERIOD"))
+	if err != nil {
+		return nil, err
+	}
+	maxSubDomains, err := strconv.Atoi(os.Getenv("MAX_SUBDOMAINS"))
+	if err != nil {
+		return nil, err
+	}
+	maxTexts, err := strconv.Atoi(os.Getenv("MAX_TEXTS"))
+	if err != nil {
+		return nil, err
+	}
+	maxRecords, err := strconv.Atoi(os.Getenv("MAX_RECORDS"))
+	if err != nil {
+		return nil, err
+	}
 
 	return &Backend{
-		Domain:    os.Getenv("DOMAIN"),
-		Prefix:    os.Getenv("ETCD_PREFIX_PATH"),
-		FrozenTTL: frozen,
-		LeaseTime: leaseTime,
-		C:         c,
+		Domain:        os.Getenv("DOMAIN"),
+		Prefix:        os.Getenv("ETCD_PREFIX_PATH"),
+		FrozenTTL:     frozen,
+		LeaseTime:     leaseTime,
+		MinLeaseTime:  minLeaseTime,
+		MaxLeaseTime:  maxLeaseTime,
+		MinTTL:        minTTL,
+		MaxTTL:        maxTTL,
+		MaxDepth:      maxDepth,
+		MaxSubDomains: maxSubDomains,
+		MaxTexts:      maxTexts,
+		MaxRecords:    maxRecords,
+		TokenGrace:    tokenGrace,
+		TokenSecret:   os.Getenv("TOKEN_SECRET"),
+		C:             c,
 	}, nil
 }
 
@@ -76,6 +167,10 @@ func (b *Backend) GetZone() string {
 	return b.Domain
 }
 
+func (b *Backend) GetNameServers() []string {
+	return []string{net.JoinHostPort("127.0.0.1", os.Getenv("CORE_DNS_PORT"))}
+}
+
 func (b *Backend) Get(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("get %s record for domain options: %s", typeA, opts.String())
 
@@ -87,12 +182,13 @@ func (b *Backend) Get(opts *model.DomainOptions) (d model.Domain, err error) {
 	}
 
 	if len(kvs) <= 0 {
-		return d, errors.Errorf(errNoLookupResults, typeA, path)
+		return d, errors.Wrapf(model.ErrNotFound, errNoLookupResults, typeA, path)
 	}
 
 	subs := make(map[string][]string, 0)
 	hosts := make([]string, 0)
 
+	var ttl int64
 	for _, v := range kvs {
 		k := string(v.Key)
 		prefix := findSubPrefix(k, path)
@@ -116,6 +212,10 @@ func (b *Backend) Get(opts *model.DomainOptions) (d model.Domain, err error) {
 			continue
 		}
 
+		if t, err := strconv.ParseInt(m["ttl"], 10, 64); err == nil {
+			ttl = t
+		}
+
 		hosts = append(hosts, m["host"])
 	}
 
@@ -145,19 +245,87 @@ func (b *Backend) Get(opts *model.DomainOptions) (d model.Domain, err error) {
 		subs[k] = ss
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	owner, err := b.C.Get(ctx, getOwnerPath(opts.Fqdn))
+	if err != nil {
+		return d, errors.Wrapf(err, errLookupRecords, typeToken, getOwnerPath(opts.Fqdn))
+	}
+	if owner.Count > 0 {
+		d.Owner = string(owner.Kvs[0].Value)
+	}
+
 	d.Fqdn = opts.Fqdn
 	d.Hosts = hosts
 	d.SubDomain = subs
+	d.TTL = ttl
 	d.Expiration = getExpiration(lease.TTL)
 
 	return d, nil
 }
 
+// ListSubDomains returns the sub domains of the domain, etcd keeps no creation time of the keys, so it is not returned.
+func (b *Backend) ListSubDomains(opts *model.DomainOptions) ([]model.SubDomain, error) {
+	d, err := b.Get(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	subs := make([]model.SubDomain, 0, len(d.SubDomain))
+	for k, v := range d.SubDomain {
+		hosts := make([]string, 0, len(v))
+		for _, h := range v {
+			if h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+		subs = append(subs, model.SubDomain{Name: k, Hosts: hosts, Wildcard: len(hosts) == 0})
+	}
+
+	return subs, nil
+}
+
 func (b *Backend) Set(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("set %s record for domain options: %s", typeA, opts.String())
 
+	// the fqdn is always generated, it is only set when a slug name is found,
+	// so the checks below never pick up the limits of the token of another domain
+	opts.Fqdn = ""
+
+	if err := b.checkTTL(opts.TTL); err != nil {
+		return d, err
+	}
+
+	if _, err := b.getLeaseTime(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkDepth(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkHosts(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkSubDomains(opts); err != nil {
+		return d, err
+	}
+
 	var path, slug string
-	for i := 0; i < maxSlugHashTimes; i++ {
+	var restore func()
+	if opts.Prefix != "" {
+		slug = opts.Prefix
+		path = getPath(b.Prefix, fmt.Sprintf("%s.%s", slug, b.Domain))
+
+		if restore, err = b.claimFrozen(slug, path, opts.Reservation); err != nil {
+			return d, err
+		}
+		opts.Fqdn = fmt.Sprintf("%s.%s", slug, b.Domain)
+	}
+
+	for i := 0; i < maxSlugHashTimes && opts.Fqdn == ""; i++ {
 		slug = generateSlug()
 
 		if b.checkSlugName(slug) {
@@ -174,8 +342,16 @@ func (b *Backend) Set(opts *model.DomainOptions) (d model.Domain, err error) {
 		}
 	}
 
+	if opts.Fqdn == "" {
+		return d, errors.Wrapf(model.ErrFrozen, errGenerateSlug, maxSlugHashTimes)
+	}
+
 	d, err = b.setRecord(path, opts, false)
 	if err != nil {
+		// the reservation is given back, so that the owner can claim it again
+		if restore != nil {
+			restore()
+		}
 		return d, err
 	}
 
@@ -183,12 +359,31 @@ func (b *Backend) Set(opts *model.DomainOptions) (d model.Domain, err error) {
 		return d, err
 	}
 
-	return b.Get(opts)
+	token := d.Token
+	d, err = b.Get(opts)
+	d.Token = token
+	return d, err
 }
 
 func (b *Backend) Update(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("update %s record for domain options: %s", typeA, opts.String())
 
+	if err := b.checkTTL(opts.TTL); err != nil {
+		return d, err
+	}
+
+	if err := b.checkDepth(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkHosts(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkSubDomains(opts); err != nil {
+		return d, err
+	}
+
 	path := getPath(b.Prefix, opts.Fqdn)
 
 	kvs, err := b.lookupKeys(path)
@@ -197,7 +392,7 @@ func (b *Backend) Update(opts *model.DomainOptions) (d model.Domain, err error)
 	}
 
 	if len(kvs) <= 0 {
-		return d, errors.Errorf(errNoLookupResults, typeA, path)
+		return d, errors.Wrapf(model.ErrNotFound, errNoLookupResults, typeA, path)
 	}
 
 	if _, err = b.setRecord(path, opts, true); err != nil {
@@ -220,6 +415,15 @@ func (b *Backend) Delete(opts *model.DomainOptions) error {
 		return err
 	}
 
+	if err := b.deleteReverses(opts.Fqdn, d.Hosts); err != nil {
+		return err
+	}
+	for prefix, hosts := range d.SubDomain {
+		if err := b.deleteReverses(fmt.Sprintf("%s.%s", prefix, opts.Fqdn), hosts); err != nil {
+			return err
+		}
+	}
+
 	path := getPath(b.Prefix, opts.Fqdn)
 
 	kvs, err := b.lookupKeys(path)
@@ -248,6 +452,15 @@ func (b *Backend) Delete(opts *model.DomainOptions) error {
 	if _, err := b.C.Delete(ctx, path); err != nil {
 		return errors.Wrapf(err, errDeleteRecord, typeA, path)
 	}
+	if err := b.DeleteCAA(opts); err != nil {
+		return err
+	}
+	if err := b.DeleteMX(opts); err != nil {
+		return err
+	}
+	if err := b.deleteTexts(opts.Fqdn); err != nil {
+		return err
+	}
 	for prefix := range d.SubDomain {
 		path := getPath(b.Prefix, fmt.Sprintf("%s.%s", prefix, opts.Fqdn))
 
@@ -259,6 +472,9 @@ func (b *Backend) Delete(opts *model.DomainOptions) error {
 		}
 	}
 
+	// all the records of the token are deleted
+	b.resetCount(opts.Fqdn)
+
 	return nil
 }
 
@@ -267,7 +483,7 @@ func (b *Backend) Renew(opts *model.DomainOptions) (d model.Domain, err error) {
 
 	path := getPath(b.Prefix, opts.Fqdn)
 
-	leaseID, leaseTTL, err := b.setToken(opts, true)
+	leaseID, leaseTTL, _, err := b.setToken(opts, true)
 	if err != nil {
 		return d, err
 	}
@@ -283,7 +499,7 @@ func (b *Backend) Renew(opts *model.DomainOptions) (d model.Domain, err error) {
 	}
 
 	if len(kvs) <= 0 {
-		return d, errors.Errorf(errNoLookupResults, typeA, path)
+		return d, errors.Wrapf(model.ErrNotFound, errNoLookupResults, typeA, path)
 	}
 
 	subs := make(map[string][]string, 0)
@@ -340,218 +556,1379 @@ func (b *Backend) Renew(opts *model.DomainOptions) (d model.Domain, err error) {
 	return d, nil
 }
 
-func (b *Backend) SetCNAME(opts *model.DomainOptions) (model.Domain, error) {
-	return model.Domain{}, nil
-}
-
-func (b *Backend) GetCNAME(opts *model.DomainOptions) (model.Domain, error) {
-	return model.Domain{}, nil
-}
+func (b *Backend) Transfer(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("transfer %s record for domain options: %s", typeToken, opts.String())
 
-func (b *Backend) UpdateCNAME(opts *model.DomainOptions) (model.Domain, error) {
-	return model.Domain{}, nil
-}
+	path := getTokenPath(opts.Fqdn)
 
-func (b *Backend) DeleteCNAME(opts *model.DomainOptions) error {
-	return nil
-}
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
 
-func (b *Backend) SetText(opts *model.DomainOptions) (d model.Domain, err error) {
-	logrus.Debugf("set %s record for domain options: %s", typeTXT, opts.String())
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return d, errors.Wrapf(err, errEmptyRecord, typeToken, path)
+	}
 
-	if len(strings.Split(opts.Fqdn, "."))-len(strings.Split(b.Domain, ".")) <= 1 {
-		return d, errors.Errorf(errNotValidDomainName, opts.Fqdn)
+	if resp.Count <= 0 {
+		return d, errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeToken, path)
 	}
 
-	path := getPath(b.Prefix, opts.Fqdn)
-	slug := findSlugWithZone(opts.Fqdn, b.Domain)
-	base := fmt.Sprintf("%s.%s", slug, b.Domain)
+	// keep the origin lease, so that records and expiration are not changed
+	origin := string(resp.Kvs[0].Value)
+	leaseID := clientv3.LeaseID(resp.Kvs[0].Lease)
 
-	leaseID, _, err := b.setToken(&model.DomainOptions{Fqdn: base}, true)
+	lease, err := b.getLease(int64(leaseID))
 	if err != nil {
 		return d, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-	defer cancel()
-
-	if _, err := b.C.Put(ctx, path, formatTextValue(opts.Text), clientv3.WithLease(clientv3.LeaseID(leaseID))); err != nil {
-		return d, errors.Wrapf(err, errSetRecordWithLease, typeTXT, path, leaseID)
+	stored, token, err := b.newToken()
+	if err != nil {
+		return d, err
 	}
 
-	return b.GetText(opts)
-}
-
-func (b *Backend) GetText(opts *model.DomainOptions) (d model.Domain, err error) {
-	logrus.Debugf("get %s record for domain options: %s", typeTXT, opts.String())
-
-	if len(strings.Split(opts.Fqdn, "."))-len(strings.Split(b.Domain, ".")) <= 1 {
-		return d, errors.Errorf(errNotValidDomainName, opts.Fqdn)
+	// the replaced token is kept with the lease of the domain, so that it is told apart from an unknown token
+	ops := []clientv3.Op{
+		clientv3.OpPut(path, stored, clientv3.WithLease(leaseID)),
+		clientv3.OpPut(getReplacedTokenPath(opts.Fqdn, origin), origin, clientv3.WithLease(leaseID)),
 	}
-
-	path := getPath(b.Prefix, opts.Fqdn)
-
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-	defer cancel()
-
-	resp, err := b.C.Get(ctx, path)
-	if err != nil {
-		return d, errors.Wrapf(err, errEmptyRecord, typeTXT, path)
+	if opts.Owner != "" {
+		ops = append(ops, clientv3.OpPut(getOwnerPath(opts.Fqdn), opts.Owner, clientv3.WithLease(leaseID)))
 	}
 
-	if resp.Count <= 0 {
-		return d, errors.Errorf(errEmptyRecord, typeTXT, path)
+	// keep the replaced token for the grace period, so that the in-flight requests with it do not fail
+	if b.TokenGrace > 0 {
+		graceID, _, err := b.grantLease(int64(b.TokenGrace.Seconds()))
+		if err != nil {
+			return d, err
+		}
+		ops = append(ops, clientv3.OpPut(getPreviousTokenPath(opts.Fqdn), origin, clientv3.WithLease(clientv3.LeaseID(graceID))))
 	}
 
-	lease, err := b.getLease(resp.Kvs[0].Lease)
-	if err != nil {
-		return d, err
-	}
+	ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
 
-	m, err := unmarshalToMap(resp.Kvs[0].Value)
+	// the token must not be replaced in between, only one of the concurrent transfers with the same token wins
+	txn, err := b.C.Txn(ctx).If(clientv3.Compare(clientv3.Value(path), "=", origin)).Then(ops...).Commit()
 	if err != nil {
-		return d, err
+		return d, errors.Wrapf(err, errSetRecordWithLease, typeToken, path, leaseID)
 	}
-
-	if _, ok := m["text"]; ok {
-		d.Text = m["text"]
+	if !txn.Succeeded {
+		return d, errors.Wrapf(model.ErrUnauthorized, errReplacedToken, opts.Fqdn)
 	}
 
 	d.Fqdn = opts.Fqdn
+	d.Owner = opts.Owner
+	d.Token = token
 	d.Expiration = getExpiration(lease.TTL)
 
 	return d, nil
 }
 
-func (b *Backend) UpdateText(opts *model.DomainOptions) (d model.Domain, err error) {
-	logrus.Debugf("update %s record for domain options: %s", typeTXT, opts.String())
+func (b *Backend) Release(opts *model.DomainOptions, frozen time.Duration) (d model.Domain, err error) {
+	logrus.Debugf("release records for domain options: %s", opts.String())
 
-	if len(strings.Split(opts.Fqdn, "."))-len(strings.Split(b.Domain, ".")) <= 1 {
-		return d, errors.Errorf(errNotValidDomainName, opts.Fqdn)
+	if frozen <= 0 {
+		frozen = b.FrozenTTL
 	}
-
-	if _, err := b.GetText(opts); err != nil {
-		return d, err
+	if frozen > b.FrozenTTL {
+		return d, errors.Wrapf(model.ErrInvalidRecord, errNotValidFrozen, frozen, b.FrozenTTL)
 	}
 
-	path := getPath(b.Prefix, opts.Fqdn)
+	// freeze the slug name first, so that nobody can pick up the name even if some deletions fail
 	slug := findSlugWithZone(opts.Fqdn, b.Domain)
-	base := fmt.Sprintf("%s.%s", slug, b.Domain)
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, slug)
 
-	leaseID, _, err := b.setToken(&model.DomainOptions{Fqdn: base}, true)
+	leaseID, leaseTTL, err := b.grantLease(int64(frozen.Seconds()))
 	if err != nil {
 		return d, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-	defer cancel()
-
-	if _, err := b.C.Put(ctx, path, formatTextValue(opts.Text), clientv3.WithLease(clientv3.LeaseID(leaseID)), clientv3.WithPrevKV()); err != nil {
-		return d, errors.Wrapf(err, errSetRecordWithLease, typeTXT, path, leaseID)
+	// the released prefix is reserved by the token of the domain, so that only its owner can claim it again
+	owner, err := b.GetToken(opts.Fqdn)
+	if err != nil {
+		return d, err
 	}
 
-	return b.GetText(opts)
-}
-
-func (b *Backend) DeleteText(opts *model.DomainOptions) error {
-	logrus.Debugf("delete %s record for domain options: %s", typeTXT, opts.String())
+	if err := b.putFrozen(path, leaseID, owner); err != nil {
+		return d, err
+	}
 
-	path := getPath(b.Prefix, opts.Fqdn)
+	d.Fqdn = opts.Fqdn
+	d.Expiration = getExpiration(leaseTTL)
 
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-	defer cancel()
+	failed := make([]string, 0)
 
-	if _, err := b.C.Delete(ctx, path); err != nil {
-		return errors.Wrapf(err, errDeleteRecord, typeTXT, path)
+	// delete the reverse records before the A records, which are needed to find them
+	if o, err := b.Get(opts); err == nil {
+		if err := b.deleteReverses(opts.Fqdn, o.Hosts); err != nil {
+			failed = append(failed, err.Error())
+		}
+		for prefix, hosts := range o.SubDomain {
+			if err := b.deleteReverses(fmt.Sprintf("%s.%s", prefix, opts.Fqdn), hosts); err != nil {
+				failed = append(failed, err.Error())
+			}
+		}
 	}
 
-	return nil
-}
-
-func (b *Backend) GetToken(fqdn string) (string, error) {
-	logrus.Debugf("get %s record for fqdn: %s", typeToken, fqdn)
-
+	// delete A, sub domain and TXT records, the path itself is deleted exactly for not touching its sibling domains
+	path = getPath(b.Prefix, opts.Fqdn)
 	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
 	defer cancel()
-
-	path := getTokenPath(fqdn)
-
-	resp, err := b.C.Get(ctx, path)
-	if err != nil {
-		return "", err
+	if _, err := b.C.Txn(ctx).Then(
+		clientv3.OpDelete(path),
+		clientv3.OpDelete(path+"/", clientv3.WithPrefix()),
+	).Commit(); err != nil {
+		failed = append(failed, errors.Wrapf(err, errDeleteRecord, typeA, path).Error())
 	}
 
-	if resp.Count <= 0 {
-		return "", errors.Errorf(errEmptyRecord, typeToken, path)
+	// delete token, the token can not be used any more
+	path = getTokenPath(opts.Fqdn)
+	ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+	if _, err := b.C.Txn(ctx).Then(clientv3.OpDelete(path), clientv3.OpDelete(getScopePath(opts.Fqdn)), clientv3.OpDelete(getLimitsPath(opts.Fqdn)), clientv3.OpDelete(getCountPath(opts.Fqdn))).Commit(); err != nil {
+		failed = append(failed, errors.Wrapf(err, errDeleteRecord, typeToken, path).Error())
 	}
 
-	if resp.Count > 1 {
-		return "", errors.Errorf(errMultiRecords, typeToken, path)
+	if len(failed) > 0 {
+		return d, errors.Errorf(errPartialRelease, opts.Fqdn, strings.Join(failed, "; "))
 	}
 
-	return string(resp.Kvs[0].Value), nil
+	return d, nil
 }
 
-func (b *Backend) GetTokenCount() (int64, error) {
-	logrus.Debugf("get %s record count", typeToken)
+// UpsertRecords writes the hosts, the sub domains and the text records of the batch below an existing domain.
+// Every record is checked and written on its own by a bounded pool of workers, so that a failed record
+// is reported by the batch error and does not stop the others.
+func (b *Backend) UpsertRecords(fqdn string, batch *model.RecordsBatch) (d model.Domain, err error) {
+	logrus.Debugf("upsert records for domain: %s", fqdn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-	defer cancel()
+	if err := b.checkTTL(batch.TTL); err != nil {
+		return d, err
+	}
 
-	resp, err := b.C.Get(ctx, tokenPath, clientv3.WithPrefix())
+	opts := &model.DomainOptions{Fqdn: fqdn}
+	origin, err := b.Get(opts)
 	if err != nil {
-		if err == rpctypes.ErrKeyNotFound {
-
-			return 0, nil
-		}
-		return 0, err
+		return d, err
 	}
 
-	return resp.Count, nil
-}
-
-func (b *Backend) MigrateFrozen(opts *model.MigrateFrozen) error {
-	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, opts.Path)
-
-	id, _, err := b.grantLease(opts.Expiration.Unix() - time.Now().Unix())
+	leaseID, _, _, err := b.setToken(opts, true)
 	if err != nil {
-		return err
+		return d, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-	defer cancel()
-
-	if _, err := b.C.Put(ctx, path, "", clientv3.WithLease(clientv3.LeaseID(id))); err != nil {
-		return errors.Wrapf(err, errSetRecordWithLease, typeFrozen, path, id)
+	quotas, err := b.getQuotas(fqdn)
+	if err != nil {
+		return d, err
 	}
 
-	return nil
-}
-
-func (b *Backend) MigrateToken(opts *model.MigrateToken) error {
-	path := getTokenPath(strings.Split(opts.Path, "/")[2])
+	jobs := make(map[string]func() error)
 
-	id, _, err := b.grantLease(opts.Expiration.Unix() - time.Now().Unix())
-	if err != nil {
-		return err
+	if batch.Hosts != nil {
+		jobs[fqdn] = func() error {
+			if err := b.checkHosts(&model.DomainOptions{Fqdn: fqdn, Hosts: batch.Hosts}); err != nil {
+				return err
+			}
+			if err := b.addCount(fqdn, countDelta(batch.Hosts, origin.Hosts)); err != nil {
+				return err
+			}
+			path := getPath(b.Prefix, fqdn)
+			if err := b.syncRecords(fqdn, batch.Hosts, origin.Hosts, path, clientv3.LeaseID(leaseID), batch.TTL); err != nil {
+				b.resetCount(fqdn)
+				return errors.Wrapf(err, errSyncRecords, typeA, path)
+			}
+			return nil
+		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-	defer cancel()
-
-	if _, err := b.C.Put(ctx, path, opts.Token, clientv3.WithLease(clientv3.LeaseID(id))); err != nil {
-		return errors.Wrapf(err, errSetRecordWithLease, typeToken, path, id)
+	// the new sub domains which are valid are counted in the order of their names, the ones over the quota are failed
+	names := make([]string, 0, len(batch.SubDomain))
+	for k := range batch.SubDomain {
+		names = append(names, k)
 	}
+	sort.Strings(names)
 
-	return nil
-}
+	count := len(origin.SubDomain)
+	for _, name := range names {
+		sub := fmt.Sprintf("%s.%s", name, fqdn)
+		hosts := batch.SubDomain[name]
+		path := getPath(b.Prefix, sub)
 
-func (b *Backend) MigrateRecord(opts *model.MigrateRecord) error {
-	if opts.Text != "" {
-		// migrate TXT record
-		dopts := &model.DomainOptions{
-			Fqdn: opts.Fqdn,
-			Text: opts.Text,
+		o := &model.DomainOptions{Fqdn: fqdn, SubDomain: map[string][]string{name: hosts}}
+		err := b.checkHosts(o)
+		if err == nil {
+			err = b.checkDepth(o)
+		}
+		if err != nil {
+			jobs[sub] = func() error { return err }
+			continue
+		}
+
+		kvs, err := b.lookupKeys(path)
+		if err != nil {
+			return d, err
+		}
+		if len(kvs) <= 0 {
+			count++
+			if quotas.MaxSubDomains > 0 && count > quotas.MaxSubDomains {
+				jobs[sub] = func() error {
+					return errors.Wrapf(model.ErrQuotaExceeded, errQuotaExceeded, "sub-domain", fqdn, quotas.MaxSubDomains)
+				}
+				continue
+			}
+		}
+
+		// the hosts of the deeper sub domains below the path are not replaced
+		exist := make([]string, 0, len(kvs))
+		for _, v := range kvs {
+			if strings.Contains(strings.TrimPrefix(string(v.Key), path+"/"), "/") {
+				continue
+			}
+			if m, err := unmarshalToMap(v.Value); err == nil {
+				exist = append(exist, m["host"])
+			}
+		}
+
+		jobs[sub] = func() error {
+			if err := b.addCount(fqdn, countDelta(hosts, exist)); err != nil {
+				return err
+			}
+			if err := b.syncRecords(sub, hosts, exist, path, clientv3.LeaseID(leaseID), batch.TTL); err != nil {
+				b.resetCount(fqdn)
+				return errors.Wrapf(err, errSyncSubRecords, typeA, path)
+			}
+			return nil
+		}
+	}
+
+	// the values of the same name are set by one job, so that they are not racing on the TXT quota of the name
+	texts := make(map[string][]string)
+	for _, t := range batch.Texts {
+		texts[t.Fqdn] = append(texts[t.Fqdn], t.Text)
+	}
+	for name, values := range texts {
+		name, values := name, values
+		jobs[name] = func() error {
+			if !strings.HasSuffix(name, "."+fqdn) {
+				return errors.Wrapf(model.ErrInvalidRecord, errNotValidText, typeTXT, name, "must be below "+fqdn)
+			}
+			for _, v := range values {
+				if _, err := b.SetText(&model.DomainOptions{Fqdn: name, Text: v, TTL: batch.TTL}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	batchErr := &model.BatchError{}
+	b.runJobs(jobs, batchErr)
+
+	d, err = b.Get(opts)
+	if err != nil {
+		return d, err
+	}
+	if len(batchErr.Errors) > 0 {
+		return d, batchErr
+	}
+	return d, nil
+}
+
+// AddAHosts adds the hosts to the A records of the domain or of its sub domain, the hosts which exist are kept.
+// The sub domain which has no records is created with the hosts.
+func (b *Backend) AddAHosts(fqdn string, hosts []string) (model.Domain, error) {
+	logrus.Debugf("add %s hosts %v to domain: %s", typeA, hosts, fqdn)
+	return b.patchHosts(fqdn, hosts, nil)
+}
+
+// RemoveAHosts removes the hosts from the A records of the domain or of its sub domain, the absent hosts are ignored.
+// The sub domain is deleted with its last host, the domain itself is kept without hosts.
+func (b *Backend) RemoveAHosts(fqdn string, hosts []string) (model.Domain, error) {
+	logrus.Debugf("remove %s hosts %v from domain: %s", typeA, hosts, fqdn)
+	return b.patchHosts(fqdn, nil, hosts)
+}
+
+// Used to merge the hosts into the A records of the fqdn by a transaction, which compares the revisions of the domain
+// and of the hosts read before, so that the concurrent merges of the same records are retried instead of lost.
+func (b *Backend) patchHosts(fqdn string, add, remove []string) (d model.Domain, err error) {
+	domain := findSlugWithZone(fqdn, b.Domain) + "." + b.Domain
+	root := getPath(b.Prefix, domain)
+	path := getPath(b.Prefix, fqdn)
+	name := strings.TrimSuffix(strings.TrimSuffix(fqdn, domain), ".")
+
+	opts := &model.DomainOptions{Fqdn: domain, Hosts: add}
+	if name != "" {
+		opts = &model.DomainOptions{Fqdn: domain, SubDomain: map[string][]string{name: add}}
+	}
+	if err := b.checkHosts(opts); err != nil {
+		return d, err
+	}
+
+	for i := 0; i < maxCountRetries; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		marker, err := b.C.Get(ctx, root)
+		cancel()
+		if err != nil {
+			return d, errors.Wrapf(err, errLookupRecords, typeA, root)
+		}
+		if marker.Count <= 0 {
+			return d, errors.Wrapf(model.ErrNotFound, errNoLookupResults, typeA, root)
+		}
+		leaseID := clientv3.LeaseID(marker.Kvs[0].Lease)
+
+		kvs, err := b.lookupKeys(path)
+		if err != nil {
+			return d, err
+		}
+
+		// only the hosts of the fqdn itself are merged, the marker and the deeper sub domains are not
+		var ttl int64
+		exist := make(map[string]*mvccpb.KeyValue)
+		for _, v := range kvs {
+			if string(v.Key) == path || strings.Contains(strings.TrimPrefix(string(v.Key), path+"/"), "/") {
+				continue
+			}
+			m, err := unmarshalToMap(v.Value)
+			if err != nil || m["host"] == "" {
+				continue
+			}
+			if t, err := strconv.ParseInt(m["ttl"], 10, 64); err == nil {
+				ttl = t
+			}
+			exist[m["host"]] = v
+		}
+
+		added, removed := make([]string, 0), make([]string, 0)
+		for h := range sliceToMap(add) {
+			if _, ok := exist[h]; !ok {
+				added = append(added, h)
+			}
+		}
+		for h := range sliceToMap(remove) {
+			if _, ok := exist[h]; ok {
+				removed = append(removed, h)
+			}
+		}
+		if len(added) == 0 && len(removed) == 0 {
+			return b.Get(&model.DomainOptions{Fqdn: domain})
+		}
+
+		if name != "" && len(exist) == 0 {
+			if err := b.checkDepth(opts); err != nil {
+				return d, err
+			}
+			if err := b.checkSubQuota(domain, root); err != nil {
+				return d, err
+			}
+		}
+
+		delta := len(added) - len(removed)
+		if err := b.addCount(domain, delta); err != nil {
+			return d, err
+		}
+
+		// the marker is written again, so that the merges of the sub domains of the domain are serialized as well
+		cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(root), "=", marker.Kvs[0].ModRevision)}
+		ops := []clientv3.Op{clientv3.OpPut(root, string(marker.Kvs[0].Value), clientv3.WithLease(leaseID))}
+		for _, v := range exist {
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(string(v.Key)), "=", v.ModRevision))
+		}
+		for _, h := range added {
+			key := fmt.Sprintf("%s/%s", path, formatKey(h))
+			cmps = append(cmps, clientv3.Compare(clientv3.Version(key), "=", 0))
+			ops = append(ops, clientv3.OpPut(key, formatValue(h, ttl), clientv3.WithLease(leaseID)))
+			// the reverse record shares the lease, so that it expires together with the A record
+			if reverse, err := getReversePath(b.Prefix, h, fqdn); err == nil {
+				ops = append(ops, clientv3.OpPut(reverse, formatValue(fqdn, ttl), clientv3.WithLease(leaseID)))
+			}
+		}
+		for _, h := range removed {
+			ops = append(ops, clientv3.OpDelete(string(exist[h].Key)))
+			if reverse, err := getReversePath(b.Prefix, h, fqdn); err == nil {
+				ops = append(ops, clientv3.OpDelete(reverse))
+			}
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
+		txn, err := b.C.Txn(ctx).If(cmps...).Then(ops...).Commit()
+		cancel()
+		if err != nil {
+			b.undoCount(domain, delta)
+			return d, errors.Wrapf(err, errSetRecordWithLease, typeA, path, leaseID)
+		}
+		if txn.Succeeded {
+			return b.Get(&model.DomainOptions{Fqdn: domain})
+		}
+		b.undoCount(domain, delta)
+	}
+
+	return d, errors.Wrapf(model.ErrConflict, errHostsConflict, fqdn, maxCountRetries)
+}
+
+// Used to check the quota of the sub domains before a new sub domain of the domain is created
+func (b *Backend) checkSubQuota(domain, root string) error {
+	quotas, err := b.getQuotas(domain)
+	if err != nil || quotas.MaxSubDomains <= 0 {
+		return err
+	}
+
+	kvs, err := b.lookupKeys(root)
+	if err != nil {
+		return err
+	}
+	subs := make(map[string]bool)
+	for _, v := range kvs {
+		if prefix := findSubPrefix(string(v.Key), root); prefix != "" && !strings.Contains(prefix, "_") {
+			subs[prefix] = true
+		}
+	}
+	if len(subs) >= quotas.MaxSubDomains {
+		return errors.Wrapf(model.ErrQuotaExceeded, errQuotaExceeded, "sub-domain", domain, quotas.MaxSubDomains)
+	}
+	return nil
+}
+
+func (b *Backend) SetCNAME(opts *model.DomainOptions) (model.Domain, error) {
+	return model.Domain{}, nil
+}
+
+func (b *Backend) GetCNAME(opts *model.DomainOptions) (model.Domain, error) {
+	return model.Domain{}, nil
+}
+
+func (b *Backend) UpdateCNAME(opts *model.DomainOptions) (model.Domain, error) {
+	return model.Domain{}, nil
+}
+
+func (b *Backend) DeleteCNAME(opts *model.DomainOptions) error {
+	return nil
+}
+
+func (b *Backend) SetCAA(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("set %s record for domain options: %s", typeCAA, opts.String())
+
+	if err := b.checkCAA(opts); err != nil {
+		return d, err
+	}
+
+	if _, err := b.GetCAA(opts); err == nil {
+		return d, errors.Wrapf(model.ErrAlreadyExists, errExistRecord, typeCAA, opts.Fqdn)
+	}
+
+	if err := b.setCAARecords(opts); err != nil {
+		return d, err
+	}
+
+	return b.GetCAA(opts)
+}
+
+func (b *Backend) GetCAA(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("get %s record for domain options: %s", typeCAA, opts.String())
+
+	if err := b.checkDomainName(opts.Fqdn, 1); err != nil {
+		return d, err
+	}
+
+	path := getCAAPath(b.Prefix, opts.Fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, path, clientv3.WithPrefix())
+	if err != nil {
+		return d, errors.Wrapf(err, errLookupRecords, typeCAA, path)
+	}
+
+	if resp.Count <= 0 {
+		return d, errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeCAA, path)
+	}
+
+	lease, err := b.getLease(resp.Kvs[0].Lease)
+	if err != nil {
+		return d, err
+	}
+
+	records := make([]model.CAA, 0)
+	for _, kv := range resp.Kvs {
+		var r model.CAA
+		if err := json.Unmarshal(kv.Value, &r); err != nil {
+			return d, err
+		}
+		records = append(records, r)
+	}
+
+	d.Fqdn = opts.Fqdn
+	d.CAA = records
+	d.Expiration = getExpiration(lease.TTL)
+
+	return d, nil
+}
+
+func (b *Backend) UpdateCAA(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("update %s record for domain options: %s", typeCAA, opts.String())
+
+	if err := b.checkCAA(opts); err != nil {
+		return d, err
+	}
+
+	if _, err := b.GetCAA(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.setCAARecords(opts); err != nil {
+		return d, err
+	}
+
+	return b.GetCAA(opts)
+}
+
+func (b *Backend) DeleteCAA(opts *model.DomainOptions) error {
+	logrus.Debugf("delete %s record for domain options: %s", typeCAA, opts.String())
+
+	path := getCAAPath(b.Prefix, opts.Fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := b.C.Delete(ctx, path, clientv3.WithPrefix()); err != nil {
+		return errors.Wrapf(err, errDeleteRecord, typeCAA, path)
+	}
+
+	return nil
+}
+
+func (b *Backend) SetMX(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("set %s record for domain options: %s", typeMX, opts.String())
+
+	if err := b.checkMX(opts); err != nil {
+		return d, err
+	}
+
+	if _, err := b.GetMX(opts); err == nil {
+		return d, errors.Wrapf(model.ErrAlreadyExists, errExistRecord, typeMX, opts.Fqdn)
+	}
+
+	if err := b.setMXRecords(opts); err != nil {
+		return d, err
+	}
+
+	return b.GetMX(opts)
+}
+
+func (b *Backend) GetMX(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("get %s record for domain options: %s", typeMX, opts.String())
+
+	if err := b.checkDomainName(opts.Fqdn, 1); err != nil {
+		return d, err
+	}
+
+	path := getMXPath(b.Prefix, opts.Fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, path, clientv3.WithPrefix())
+	if err != nil {
+		return d, errors.Wrapf(err, errLookupRecords, typeMX, path)
+	}
+
+	if resp.Count <= 0 {
+		return d, errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeMX, path)
+	}
+
+	lease, err := b.getLease(resp.Kvs[0].Lease)
+	if err != nil {
+		return d, err
+	}
+
+	records := make([]model.MX, 0)
+	for _, kv := range resp.Kvs {
+		m, err := unmarshalToMap(kv.Value)
+		if err != nil {
+			return d, err
+		}
+		preference, err := strconv.ParseUint(m["priority"], 10, 16)
+		if err != nil {
+			return d, err
+		}
+		if t, err := strconv.ParseInt(m["ttl"], 10, 64); err == nil {
+			d.TTL = t
+		}
+		records = append(records, model.MX{Preference: uint16(preference), Host: m["host"]})
+	}
+
+	d.Fqdn = opts.Fqdn
+	d.MX = records
+	d.Expiration = getExpiration(lease.TTL)
+
+	return d, nil
+}
+
+func (b *Backend) UpdateMX(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("update %s record for domain options: %s", typeMX, opts.String())
+
+	if err := b.checkMX(opts); err != nil {
+		return d, err
+	}
+
+	if _, err := b.GetMX(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.setMXRecords(opts); err != nil {
+		return d, err
+	}
+
+	return b.GetMX(opts)
+}
+
+func (b *Backend) DeleteMX(opts *model.DomainOptions) error {
+	logrus.Debugf("delete %s record for domain options: %s", typeMX, opts.String())
+
+	path := getMXPath(b.Prefix, opts.Fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := b.C.Delete(ctx, path, clientv3.WithPrefix()); err != nil {
+		return errors.Wrapf(err, errDeleteRecord, typeMX, path)
+	}
+
+	return nil
+}
+
+func (b *Backend) SetText(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("set %s record for domain options: %s", typeTXT, opts.String())
+
+	if err := b.checkText(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkTexts(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.setTextRecords(opts, false); err != nil {
+		return d, err
+	}
+
+	return b.GetText(opts)
+}
+
+func (b *Backend) GetText(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("get %s record for domain options: %s", typeTXT, opts.String())
+
+	if err := b.checkDomainName(opts.Fqdn, 2); err != nil {
+		return d, err
+	}
+
+	path := getPath(b.Prefix, opts.Fqdn)
+
+	kvs, err := b.lookupTextKeys(opts.Fqdn)
+	if err != nil {
+		return d, err
+	}
+
+	if len(kvs) <= 0 {
+		return d, errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeTXT, path)
+	}
+
+	lease, err := b.getLease(kvs[0].Lease)
+	if err != nil {
+		return d, err
+	}
+
+	texts := make([]string, 0)
+	for _, kv := range kvs {
+		m, err := unmarshalToMap(kv.Value)
+		if err != nil {
+			return d, err
+		}
+		texts = append(texts, m["text"])
+	}
+
+	d.Fqdn = opts.Fqdn
+	d.Text = texts[0]
+	d.Texts = texts
+	d.Expiration = getExpiration(lease.TTL)
+
+	return d, nil
+}
+
+func (b *Backend) UpdateText(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("update %s record for domain options: %s", typeTXT, opts.String())
+
+	if err := b.checkText(opts); err != nil {
+		return d, err
+	}
+
+	if _, err := b.GetText(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.setTextRecords(opts, true); err != nil {
+		return d, err
+	}
+
+	return b.GetText(opts)
+}
+
+func (b *Backend) DeleteText(opts *model.DomainOptions) error {
+	logrus.Debugf("delete %s record for domain options: %s", typeTXT, opts.String())
+
+	path := getPath(b.Prefix, opts.Fqdn)
+
+	// only the given value is removed when the text is specified
+	if opts.Text != "" {
+		kvs, err := b.lookupTextKeys(opts.Fqdn)
+		if err != nil {
+			return err
+		}
+
+		for _, kv := range kvs {
+			m, err := unmarshalToMap(kv.Value)
+			if err != nil || m["text"] != opts.Text {
+				continue
+			}
+
+			if err := b.addCount(opts.Fqdn, -1); err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+			_, err = b.C.Delete(ctx, string(kv.Key))
+			cancel()
+			if err != nil {
+				b.undoCount(opts.Fqdn, -1)
+				return errors.Wrapf(err, errDeleteRecord, typeTXT, string(kv.Key))
+			}
+			return nil
+		}
+
+		return errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeTXT, path)
+	}
+
+	kvs, err := b.lookupTextKeys(opts.Fqdn)
+	if err != nil {
+		return err
+	}
+	if err := b.addCount(opts.Fqdn, -len(kvs)); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := b.C.Txn(ctx).Then(
+		clientv3.OpDelete(path),
+		clientv3.OpDelete(getTextPath(b.Prefix, opts.Fqdn), clientv3.WithPrefix()),
+	).Commit(); err != nil {
+		b.undoCount(opts.Fqdn, -len(kvs))
+		return errors.Wrapf(err, errDeleteRecord, typeTXT, path)
+	}
+
+	return nil
+}
+
+func (b *Backend) GetToken(fqdn string) (string, error) {
+	logrus.Debugf("get %s record for fqdn: %s", typeToken, fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := getTokenPath(fqdn)
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Count <= 0 {
+		return "", errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeToken, path)
+	}
+
+	if resp.Count > 1 {
+		return "", errors.Errorf(errMultiRecords, typeToken, path)
+	}
+
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (b *Backend) GetPreviousToken(fqdn string) (string, error) {
+	logrus.Debugf("get previous %s record for fqdn: %s", typeToken, fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := getPreviousTokenPath(fqdn)
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Count <= 0 {
+		return "", errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeToken, path)
+	}
+
+	return string(resp.Kvs[0].Value), nil
+}
+
+// RehashToken replaces the legacy plaintext origin of the token with its hash, the token keeps its lease.
+// It is done on the first use of the token, so that the legacy tokens keep working while they are migrated.
+func (b *Backend) RehashToken(fqdn, origin, hashed string) error {
+	logrus.Debugf("rehash %s record for fqdn: %s", typeToken, fqdn)
+
+	path := getTokenPath(fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, errLookupRecords, typeToken, path)
+	}
+	if resp.Count <= 0 {
+		return errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeToken, path)
+	}
+	leaseID := clientv3.LeaseID(resp.Kvs[0].Lease)
+
+	ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	// the token which is replaced by a transfer in between is not overwritten
+	if _, err := b.C.Txn(ctx).If(clientv3.Compare(clientv3.Value(path), "=", origin)).Then(
+		clientv3.OpPut(path, hashed, clientv3.WithLease(leaseID)),
+	).Commit(); err != nil {
+		return errors.Wrapf(err, errSetRecordWithLease, typeToken, path, leaseID)
+	}
+
+	return nil
+}
+
+// GetReplacedTokens returns the tokens of the fqdn which are replaced by the transfers.
+func (b *Backend) GetReplacedTokens(fqdn string) ([]string, error) {
+	logrus.Debugf("get replaced %s records for fqdn: %s", typeToken, fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := fmt.Sprintf("%s/%s/", replacedPath, formatKey(fqdn))
+
+	resp, err := b.C.Get(ctx, path, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrapf(err, errLookupRecords, typeToken, path)
+	}
+
+	tokens := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		tokens = append(tokens, string(kv.Value))
+	}
+
+	return tokens, nil
+}
+
+func (b *Backend) GetTokenScopes(fqdn string) ([]string, error) {
+	logrus.Debugf("get %s scopes for fqdn: %s", typeToken, fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := getScopePath(fqdn)
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return nil, errors.Wrapf(err, errLookupRecords, typeToken, path)
+	}
+
+	// the legacy token has no scopes
+	if resp.Count <= 0 || len(resp.Kvs[0].Value) <= 0 {
+		return nil, nil
+	}
+
+	return strings.Split(string(resp.Kvs[0].Value), ","), nil
+}
+
+func (b *Backend) GetTokenLimits(fqdn string) (limits model.TokenLimits, err error) {
+	logrus.Debugf("get %s limits for fqdn: %s", typeToken, fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := getLimitsPath(fqdn)
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return limits, errors.Wrapf(err, errLookupRecords, typeToken, path)
+	}
+
+	// the token without limits keeps the limits of the deployment
+	if resp.Count <= 0 {
+		return limits, nil
+	}
+
+	err = json.Unmarshal(resp.Kvs[0].Value, &limits)
+	return limits, err
+}
+
+// SetTokenLimits stores the limits with the lease of the token, so that they are renewed and expired together,
+// the limits are deleted when all of them are zero.
+func (b *Backend) SetTokenLimits(fqdn string, limits model.TokenLimits) (model.TokenLimits, error) {
+	logrus.Debugf("set %s limits for fqdn: %s", typeToken, fqdn)
+
+	path := getTokenPath(fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return limits, errors.Wrapf(err, errLookupRecords, typeToken, path)
+	}
+	if resp.Count <= 0 {
+		return limits, errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeToken, path)
+	}
+	leaseID := clientv3.LeaseID(resp.Kvs[0].Lease)
+
+	op := clientv3.OpDelete(getLimitsPath(fqdn))
+	if limits != (model.TokenLimits{}) {
+		v, err := json.Marshal(limits)
+		if err != nil {
+			return limits, err
+		}
+		op = clientv3.OpPut(getLimitsPath(fqdn), string(v), clientv3.WithLease(leaseID))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	// the token must not be deleted in between, otherwise the limits are left without a token
+	txn, err := b.C.Txn(ctx).If(clientv3.Compare(clientv3.CreateRevision(path), "=", resp.Kvs[0].CreateRevision)).Then(op).Commit()
+	if err != nil {
+		return limits, errors.Wrapf(err, errSetRecordWithLease, typeToken, getLimitsPath(fqdn), leaseID)
+	}
+	if !txn.Succeeded {
+		return limits, errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeToken, path)
+	}
+
+	return limits, nil
+}
+
+func (b *Backend) GetTokenCount() (int64, error) {
+	logrus.Debugf("get %s record count", typeToken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, tokenPath, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		if err == rpctypes.ErrKeyNotFound {
+
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return resp.Count, nil
+}
+
+// Healthy reads a single key, so that a round trip to etcd is verified
+func (b *Backend) Healthy() error {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	_, err := b.C.Get(ctx, migrationPath, clientv3.WithCountOnly())
+	return err
+}
+
+func (b *Backend) GetStats() (*model.Stats, error) {
+	logrus.Debugf("get record stats")
+
+	s := &model.Stats{
+		Records: make(map[string]int64, 0),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, tokenPath, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return s, errors.Wrapf(err, errLookupRecords, typeToken, tokenPath)
+	}
+	s.Tokens = resp.Count
+
+	path := b.Prefix + frozenPath
+	resp, err = b.C.Get(ctx, path, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return s, errors.Wrapf(err, errLookupRecords, typeFrozen, path)
+	}
+	s.Frozen = resp.Count
+
+	// A, TXT, CAA and MX records are stored in the same path, the value is needed to tell them apart.
+	// The placeholder of a domain without hosts and the values which are not ip addresses are not A records.
+	path = getPath(b.Prefix, b.Domain)
+	resp, err = b.C.Get(ctx, path, clientv3.WithPrefix())
+	if err != nil {
+		return s, errors.Wrapf(err, errLookupRecords, typeA, path)
+	}
+	for _, kv := range resp.Kvs {
+		m, err := unmarshalToMap(kv.Value)
+		if err != nil {
+			continue
+		}
+		if _, ok := m["text"]; ok {
+			s.Records[typeTXT]++
+			continue
+		}
+		if _, ok := m["tag"]; ok {
+			s.Records[typeCAA]++
+			continue
+		}
+		if _, ok := m["mail"]; ok {
+			s.Records[typeMX]++
+			continue
+		}
+		if net.ParseIP(m["host"]) != nil {
+			s.Records[typeA]++
+		}
+	}
+
+	return s, nil
+}
+
+// ReserveIdempotency creates the key only if it does not exist yet, returns false if it is reserved by another request.
+func (b *Backend) ReserveIdempotency(r *model.Idempotency) (bool, error) {
+	logrus.Debugf("reserve %s record for key: %s", typeIdempotency, r.Key)
+
+	leaseID, _, err := b.grantLease(int64(model.IdempotencyExpiration.Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	r.CreatedOn = time.Now().UnixNano()
+	v, err := json.Marshal(r)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := getIdempotencyPath(r.Key)
+	resp, err := b.C.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(path), "=", 0)).
+		Then(clientv3.OpPut(path, string(v), clientv3.WithLease(clientv3.LeaseID(leaseID)))).
+		Commit()
+	if err != nil {
+		return false, errors.Wrapf(err, errSetRecordWithLease, typeIdempotency, path, leaseID)
+	}
+
+	if !resp.Succeeded {
+		if _, err := b.C.Revoke(ctx, clientv3.LeaseID(leaseID)); err != nil {
+			logrus.Warnf("failed to revoke the unused lease %d, err: %v", leaseID, err)
+		}
+	}
+
+	return resp.Succeeded, nil
+}
+
+// SetIdempotency fills in the reserved key, the lease of the reservation is kept.
+func (b *Backend) SetIdempotency(r *model.Idempotency) error {
+	logrus.Debugf("set %s record for key: %s", typeIdempotency, r.Key)
+
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	_, err = b.C.Put(ctx, getIdempotencyPath(r.Key), string(v), clientv3.WithIgnoreLease())
+	return err
+}
+
+func (b *Backend) GetIdempotency(key string) (*model.Idempotency, error) {
+	logrus.Debugf("get %s record for key: %s", typeIdempotency, key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := getIdempotencyPath(key)
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Count <= 0 {
+		return nil, errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeIdempotency, path)
+	}
+
+	r := &model.Idempotency{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (b *Backend) DeleteIdempotency(key string) error {
+	logrus.Debugf("delete %s record for key: %s", typeIdempotency, key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := getIdempotencyPath(key)
+	if _, err := b.C.Delete(ctx, path); err != nil {
+		return errors.Wrapf(err, errDeleteRecord, typeIdempotency, path)
+	}
+
+	return nil
+}
+
+func (b *Backend) GetMigrationVersion() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := b.Prefix + migrationPath
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return 0, errors.Wrapf(err, errLookupRecords, typeMigration, path)
+	}
+
+	if resp.Count <= 0 {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+}
+
+func (b *Backend) SetMigrationVersion(version int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	path := b.Prefix + migrationPath
+
+	_, err := b.C.Put(ctx, path, strconv.FormatInt(version, 10))
+	return err
+}
+
+// CheckRecords decodes the first values of each record type into the structs of this version, so that the data
+// written by the other versions is known to be readable, it returns the number of the decoded values of each type.
+func (b *Backend) CheckRecords(limit int64) (map[string]int64, error) {
+	counts := make(map[string]int64)
+
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	// A, TXT, CAA and MX records are stored in the same path, the value tells them apart
+	path := getPath(b.Prefix, b.Domain)
+	resp, err := b.C.Get(ctx, path+"/", clientv3.WithPrefix(), clientv3.WithLimit(limit))
+	if err != nil {
+		return counts, errors.Wrapf(err, errLookupRecords, typeA, path)
+	}
+	for _, kv := range resp.Kvs {
+		t, err := decodeRecord(kv.Value)
+		if err != nil {
+			return counts, errors.Wrapf(err, errDecodeRecord, t, string(kv.Key))
+		}
+		counts[t]++
+	}
+
+	resp, err = b.C.Get(ctx, idempotencyPath, clientv3.WithPrefix(), clientv3.WithLimit(limit))
+	if err != nil {
+		return counts, errors.Wrapf(err, errLookupRecords, typeIdempotency, idempotencyPath)
+	}
+	for _, kv := range resp.Kvs {
+		if err := json.Unmarshal(kv.Value, &model.Idempotency{}); err != nil {
+			return counts, errors.Wrapf(err, errDecodeRecord, typeIdempotency, string(kv.Key))
+		}
+		counts[typeIdempotency]++
+	}
+
+	resp, err = b.C.Get(ctx, limitsPath, clientv3.WithPrefix(), clientv3.WithLimit(limit))
+	if err != nil {
+		return counts, errors.Wrapf(err, errLookupRecords, typeToken, limitsPath)
+	}
+	for _, kv := range resp.Kvs {
+		if err := json.Unmarshal(kv.Value, &model.TokenLimits{}); err != nil {
+			return counts, errors.Wrapf(err, errDecodeRecord, typeToken, string(kv.Key))
+		}
+		counts[typeToken]++
+	}
+
+	return counts, nil
+}
+
+// MigrateReverse writes the reverse records of the A records which are written before the reverse records are supported.
+func (b *Backend) MigrateReverse() error {
+	path := getPath(b.Prefix, b.Domain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, path+"/", clientv3.WithPrefix())
+	if err != nil {
+		return errors.Wrapf(err, errLookupRecords, typeA, path)
+	}
+
+	for _, kv := range resp.Kvs {
+		m, err := unmarshalToMap(kv.Value)
+		if err != nil || net.ParseIP(m["host"]) == nil {
+			continue
+		}
+
+		key := string(kv.Key)
+		fqdn := convertToDomain(strings.TrimPrefix(key[:strings.LastIndex(key, "/")], b.Prefix))
+
+		reverse, err := getReversePath(b.Prefix, m["host"], fqdn)
+		if err != nil {
+			continue
+		}
+
+		ttl, _ := strconv.ParseInt(m["ttl"], 10, 64)
+
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		_, err = b.C.Put(ctx, reverse, formatValue(fqdn, ttl), clientv3.WithLease(clientv3.LeaseID(kv.Lease)))
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, errSetRecordWithLease, typePTR, reverse, kv.Lease)
+		}
+	}
+
+	return nil
+}
+
+func (b *Backend) MigrateFrozen(opts *model.MigrateFrozen) error {
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, opts.Path)
+
+	id, _, err := b.grantLease(convertLeaseTTL(opts.Expiration, b.FrozenTTL))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := b.C.Put(ctx, path, "", clientv3.WithLease(clientv3.LeaseID(id))); err != nil {
+		return errors.Wrapf(err, errSetRecordWithLease, typeFrozen, path, id)
+	}
+
+	return nil
+}
+
+func (b *Backend) GetFrozen(prefix string) (f model.Frozen, err error) {
+	logrus.Debugf("get %s record for prefix: %s", typeFrozen, prefix)
+
+	kv, err := b.getFrozen(prefix)
+	if err != nil {
+		return f, err
+	}
+
+	f.Prefix = prefix
+	f.Reserved = len(kv.Value) > 0
+	if kv.Lease != int64(clientv3.NoLease) {
+		lease, err := b.getLease(kv.Lease)
+		if err != nil {
+			return f, err
+		}
+		f.Expiration = getExpiration(lease.TTL)
+	}
+
+	return f, nil
+}
+
+// SetFrozen freezes the prefix for the ttl, e.g. for the reserved customer-branded names. Zero ttl means the default
+// frozen duration and a negative one means it never expires, the prefix of a domain in use is frozen as well.
+func (b *Backend) SetFrozen(prefix string, ttl time.Duration) (f model.Frozen, err error) {
+	logrus.Debugf("set %s record for prefix: %s with ttl: %s", typeFrozen, prefix, ttl)
+
+	if !prefixPattern.MatchString(prefix) {
+		return f, errors.Wrapf(model.ErrInvalidRecord, errNotValidPrefix, prefix)
+	}
+	if ttl == 0 {
+		ttl = b.FrozenTTL
+	}
+
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, prefix)
+
+	var opts []clientv3.OpOption
+	f.Prefix = prefix
+	if ttl > 0 {
+		leaseID, leaseTTL, err := b.grantLease(int64(ttl.Seconds()))
+		if err != nil {
+			return f, err
+		}
+		opts = append(opts, clientv3.WithLease(clientv3.LeaseID(leaseID)))
+		f.Expiration = getExpiration(leaseTTL)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := b.C.Put(ctx, path, "", opts...); err != nil {
+		return f, errors.Wrapf(err, errSetRecord, typeFrozen, path)
+	}
+
+	return f, nil
+}
+
+// DeleteFrozen unfreezes the prefix, the prefix of a domain in use can not be unfrozen.
+func (b *Backend) DeleteFrozen(prefix string) error {
+	logrus.Debugf("delete %s record for prefix: %s", typeFrozen, prefix)
+
+	if b.checkPathExist(getPath(b.Prefix, fmt.Sprintf("%s.%s", prefix, b.Domain))) {
+		return errors.Wrapf(model.ErrConflict, errFrozenInUse, prefix)
+	}
+
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, prefix)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Delete(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, errDeleteRecord, typeFrozen, path)
+	}
+	if resp.Deleted <= 0 {
+		return errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeFrozen, path)
+	}
+
+	return nil
+}
+
+func (b *Backend) MigrateToken(opts *model.MigrateToken) error {
+	path := getTokenPath(strings.Split(opts.Path, "/")[2])
+
+	id, _, err := b.grantLease(convertLeaseTTL(opts.Expiration, b.LeaseTime))
+	if err != nil {
+		return err
+	}
+
+	ops := []clientv3.Op{clientv3.OpPut(path, opts.Token, clientv3.WithLease(clientv3.LeaseID(id)))}
+	if len(opts.Scopes) > 0 {
+		ops = append(ops, clientv3.OpPut(getScopePath(strings.Split(opts.Path, "/")[2]), strings.Join(opts.Scopes, ","), clientv3.WithLease(clientv3.LeaseID(id))))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := b.C.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return errors.Wrapf(err, errSetRecordWithLease, typeToken, path, id)
+	}
+
+	return nil
+}
+
+func (b *Backend) MigrateRecord(opts *model.MigrateRecord) error {
+	if opts.Text != "" {
+		// migrate TXT record
+		dopts := &model.DomainOptions{
+			Fqdn: opts.Fqdn,
+			Text: opts.Text,
 		}
 		if _, err := b.SetText(dopts); err != nil {
 			return err
@@ -563,324 +1940,859 @@ func (b *Backend) MigrateRecord(opts *model.MigrateRecord) error {
 			SubDomain: opts.SubDomain,
 		}
 
-		path := getPath(b.Prefix, dopts.Fqdn)
+		path := getPath(b.Prefix, dopts.Fqdn)
+
+		leaseID, _, _, err := b.setToken(dopts, true)
+		if err != nil {
+			return err
+		}
+
+		// make sure domain record is exist, although no hosts value
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		defer cancel()
+
+		_, err = b.C.Put(ctx, path, formatValue("", 0), clientv3.WithLease(clientv3.LeaseID(leaseID)))
+		if err != nil {
+			return err
+		}
+
+		kvs, err := b.lookupKeys(path)
+		if err != nil {
+			return err
+		}
+
+		subs := make(map[string][]string, 0)
+		hosts := make([]string, 0)
+
+		for _, v := range kvs {
+			k := string(v.Key)
+			prefix := findSubPrefix(k, path)
+
+			m, err := unmarshalToMap(v.Value)
+			if err != nil {
+				return err
+			}
+
+			isText := false
+			if _, ok := m["text"]; ok {
+				isText = true
+			}
+
+			if prefix != "" && !strings.Contains(prefix, "_") && !isText {
+				subs[prefix] = make([]string, 0)
+				continue
+			}
+
+			hosts = append(hosts, m["host"])
+		}
+
+		for k := range subs {
+			n := fmt.Sprintf("%s.%s", k, dopts.Fqdn)
+			p := getPath(b.Prefix, n)
+
+			kvs, err := b.lookupKeys(p)
+			if err != nil {
+				return err
+			}
+
+			ss := make([]string, 0)
+			for _, v := range kvs {
+				m, err := unmarshalToMap(v.Value)
+				if err != nil {
+					return err
+				}
+				ss = append(ss, m["host"])
+			}
+
+			subs[k] = ss
+		}
+
+		if err := b.syncRecords(dopts.Fqdn, dopts.Hosts, hosts, path, clientv3.LeaseID(leaseID), dopts.TTL); err != nil {
+			return errors.Wrapf(err, errSyncRecords, typeA, path)
+		}
+
+		if err := b.setSubRecords(dopts, subs, leaseID); err != nil {
+			return errors.Wrapf(err, errSetSubRecordsWithLease, typeA, dopts.Fqdn, leaseID)
+		}
+
+		// the migrated records are not checked by the quota, the count is seeded again by the next writer
+		b.resetCount(dopts.Fqdn)
+	}
+
+	return nil
+}
+
+func (b *Backend) setRecord(path string, opts *model.DomainOptions, exist bool) (d model.Domain, err error) {
+	leaseID, leaseTTL, token, err := b.setToken(opts, exist)
+	if err != nil {
+		return d, err
+	}
+	d.Token = token
+
+	if !exist {
+		// make sure domain record is exist, although no hosts value
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		defer cancel()
+
+		_, err := b.C.Put(ctx, path, formatValue("", 0), clientv3.WithLease(clientv3.LeaseID(leaseID)))
+		if err != nil {
+			return d, err
+		}
+	}
+
+	kvs, err := b.lookupKeys(path)
+	if err != nil {
+		return d, err
+	}
+
+	subs := make(map[string][]string, 0)
+	hosts := make([]string, 0)
+
+	for _, v := range kvs {
+		k := string(v.Key)
+		prefix := findSubPrefix(k, path)
 
-		leaseID, _, err := b.setToken(dopts, true)
+		m, err := unmarshalToMap(v.Value)
 		if err != nil {
-			return err
+			return d, err
 		}
 
-		// make sure domain record is exist, although no hosts value
-		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-		defer cancel()
+		isText := false
+		if _, ok := m["text"]; ok {
+			isText = true
+		}
+
+		if prefix != "" && !strings.Contains(prefix, "_") && !isText {
+			subs[prefix] = make([]string, 0)
+			continue
+		}
+
+		hosts = append(hosts, m["host"])
+	}
+
+	for k := range subs {
+		n := fmt.Sprintf("%s.%s", k, opts.Fqdn)
+		p := getPath(b.Prefix, n)
 
-		_, err = b.C.Put(ctx, path, formatValue(""), clientv3.WithLease(clientv3.LeaseID(leaseID)))
+		kvs, err := b.lookupKeys(p)
 		if err != nil {
-			return err
+			return d, err
+		}
+
+		ss := make([]string, 0)
+		for _, v := range kvs {
+			m, err := unmarshalToMap(v.Value)
+			if err != nil {
+				return d, err
+			}
+			ss = append(ss, m["host"])
+		}
+
+		subs[k] = ss
+	}
+
+	// the hosts of the domain and its sub domains are replaced as a whole
+	delta := countDelta(opts.Hosts, nil)
+	for _, v := range opts.SubDomain {
+		delta += countDelta(v, nil)
+	}
+	for _, v := range kvs {
+		if m, err := unmarshalToMap(v.Value); err == nil && m["host"] != "" {
+			delta--
+		}
+	}
+	if err := b.addCount(opts.Fqdn, delta); err != nil {
+		return d, err
+	}
+
+	if err := b.syncRecords(opts.Fqdn, opts.Hosts, hosts, path, clientv3.LeaseID(leaseID), opts.TTL); err != nil {
+		b.resetCount(opts.Fqdn)
+		return d, errors.Wrapf(err, errSyncRecords, typeA, path)
+	}
+
+	if err := b.setSubRecords(opts, subs, leaseID); err != nil {
+		b.resetCount(opts.Fqdn)
+		return d, errors.Wrapf(err, errSetSubRecordsWithLease, typeA, opts.Fqdn, leaseID)
+	}
+
+	d.Fqdn = opts.Fqdn
+	d.Hosts = opts.Hosts
+	d.SubDomain = opts.SubDomain
+	d.Expiration = getExpiration(leaseTTL)
+
+	return d, err
+}
+
+func (b *Backend) setSubRecords(opts *model.DomainOptions, origins map[string][]string, leaseID int64) error {
+	for prefix, hosts := range origins {
+		if _, ok := opts.SubDomain[prefix]; !ok {
+			fqdn := fmt.Sprintf("%s.%s", prefix, opts.Fqdn)
+			path := getPath(b.Prefix, fqdn)
+			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+			_, err := b.C.Delete(ctx, path, clientv3.WithPrefix())
+			cancel()
+			if err != nil {
+				return err
+			}
+			if err := b.deleteReverses(fqdn, hosts); err != nil {
+				return err
+			}
 		}
+	}
+
+	for prefix, values := range opts.SubDomain {
+		fqdn := fmt.Sprintf("%s.%s", prefix, opts.Fqdn)
+		path := getPath(b.Prefix, fqdn)
 
 		kvs, err := b.lookupKeys(path)
 		if err != nil {
 			return err
 		}
 
-		subs := make(map[string][]string, 0)
 		hosts := make([]string, 0)
-
 		for _, v := range kvs {
-			k := string(v.Key)
-			prefix := findSubPrefix(k, path)
-
 			m, err := unmarshalToMap(v.Value)
 			if err != nil {
 				return err
 			}
+			hosts = append(hosts, m["host"])
+		}
 
-			isText := false
-			if _, ok := m["text"]; ok {
-				isText = true
+		if err := b.syncRecords(fqdn, values, hosts, path, clientv3.LeaseID(leaseID), opts.TTL); err != nil {
+			return errors.Wrapf(err, errSyncSubRecords, typeA, path)
+		}
+	}
+
+	return nil
+}
+
+// Used to run the jobs of a batch by a bounded pool of workers, the failed jobs are added to the batch error by their names
+func (b *Backend) runJobs(jobs map[string]func() error, batchErr *model.BatchError) {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	sem := make(chan struct{}, batchWorkers)
+
+	for name, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string, job func() error) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := job(); err != nil {
+				lock.Lock()
+				batchErr.Add(name, err)
+				lock.Unlock()
 			}
+		}(name, job)
+	}
 
-			if prefix != "" && !strings.Contains(prefix, "_") && !isText {
-				subs[prefix] = make([]string, 0)
-				continue
+	wg.Wait()
+}
+
+func (b *Backend) syncRecords(fqdn string, new, old []string, path string, leaseID clientv3.LeaseID, ttl int64) error {
+	left := sliceToMap(new)
+	right := sliceToMap(old)
+
+	for r := range right {
+		if _, ok := left[r]; !ok {
+			key := fmt.Sprintf("%s/%s", path, formatKey(r))
+			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+			_, err := b.C.Delete(ctx, key)
+			cancel()
+			if err != nil {
+				return err
 			}
+			if err := b.deleteReverses(fqdn, []string{r}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// existing records are written again, so that the ttl change can take effect
+	for l := range left {
+		key := fmt.Sprintf("%s/%s", path, formatKey(l))
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		_, err := b.C.Put(ctx, key, formatValue(l, ttl), clientv3.WithLease(leaseID))
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		// the reverse record shares the lease, so that it expires together with the A record
+		reverse, err := getReversePath(b.Prefix, l, fqdn)
+		if err != nil {
+			continue
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
+		_, err = b.C.Put(ctx, reverse, formatValue(fqdn, ttl), clientv3.WithLease(leaseID))
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, errSetRecordWithLease, typePTR, reverse, leaseID)
+		}
+	}
+
+	return nil
+}
+
+// Used to delete the reverse records of the hosts owned by the fqdn, the hosts which are not ip have no reverse records.
+func (b *Backend) deleteReverses(fqdn string, hosts []string) error {
+	for _, h := range hosts {
+		path, err := getReversePath(b.Prefix, h, fqdn)
+		if err != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		_, err = b.C.Delete(ctx, path)
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, errDeleteRecord, typePTR, path)
+		}
+	}
+
+	return nil
+}
+
+// Used to set the token of the fqdn, the token which is given to the client is returned when it is hashed at rest,
+// otherwise it is generated from the stored origin by the api.
+func (b *Backend) setToken(opts *model.DomainOptions, exist bool) (int64, int64, string, error) {
+	logrus.Debugf("set %s for fqdn: %s", typeToken, opts.String())
+
+	path := getTokenPath(opts.Fqdn)
+
+	// the existing token is not written again, so that a concurrent transfer is not reverted
+	if exist {
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		defer cancel()
+
+		resp, err := b.C.Get(ctx, path)
+		if err != nil {
+			return 0, -1, "", errors.Wrapf(err, errEmptyRecord, typeToken, path)
+		}
+
+		if resp.Count <= 0 {
+			return 0, -1, "", errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeToken, path)
+		}
+
+		lease, err := b.getLease(resp.Kvs[0].Lease)
+		if err != nil {
+			return 0, -1, "", err
+		}
+
+		return int64(lease.ID), lease.TTL, "", nil
+	}
+
+	// the lease is renewed by its own ttl, so that the token keeps the lease time which it is created with
+	leaseTime, err := b.getLeaseTime(opts)
+	if err != nil {
+		return 0, -1, "", err
+	}
+
+	leaseID, leaseTTL, err := b.grantLease(int64(leaseTime.Seconds()))
+	if err != nil {
+		return 0, -1, "", err
+	}
+
+	stored, token, err := b.newToken()
+	if err != nil {
+		return 0, -1, "", err
+	}
+
+	ops := []clientv3.Op{clientv3.OpPut(path, stored, clientv3.WithLease(clientv3.LeaseID(leaseID)))}
+
+	// the scopes and the owner share the lease of the token, so that they are renewed and expired together
+	if len(opts.Scopes) > 0 {
+		ops = append(ops, clientv3.OpPut(getScopePath(opts.Fqdn), strings.Join(opts.Scopes, ","), clientv3.WithLease(clientv3.LeaseID(leaseID))))
+	}
+	if opts.Owner != "" {
+		ops = append(ops, clientv3.OpPut(getOwnerPath(opts.Fqdn), opts.Owner, clientv3.WithLease(clientv3.LeaseID(leaseID))))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := b.C.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return 0, -1, "", errors.Wrapf(err, errSetRecordWithLease, typeToken, path, leaseID)
+	}
+
+	return leaseID, leaseTTL, token, nil
+}
+
+// Used to generate a token, which is stored as the hmac of the token given to the client when the tokens are hashed at rest,
+// otherwise the plaintext origin is stored and the token given to the client is generated from it.
+func (b *Backend) newToken() (stored string, token string, err error) {
+	origin := util.RandStringWithAll(tokenLength)
+	if b.TokenSecret == "" {
+		return origin, "", nil
+	}
+
+	token, err = util.GenerateToken(origin)
+	if err != nil {
+		return "", "", err
+	}
+	return util.HashToken(b.TokenSecret, token), token, nil
+}
+
+// GetFrozenOwner returns the stored token which the prefix is reserved by, empty means the prefix is frozen without owner.
+func (b *Backend) GetFrozenOwner(prefix string) (string, error) {
+	kv, err := b.getFrozen(prefix)
+	if err != nil {
+		return "", err
+	}
+	return string(kv.Value), nil
+}
+
+// ReserveFrozen freezes the prefix which is neither frozen nor used by a domain, the prefix can only be claimed
+// by a domain created with the token of the reservation until it expires.
+func (b *Backend) ReserveFrozen(prefix string, ttl time.Duration) (f model.Frozen, err error) {
+	logrus.Debugf("reserve %s record for prefix: %s with ttl: %s", typeFrozen, prefix, ttl)
+
+	if !prefixPattern.MatchString(prefix) {
+		return f, errors.Wrapf(model.ErrInvalidRecord, errNotValidPrefix, prefix)
+	}
+	if ttl == 0 {
+		ttl = b.FrozenTTL
+	}
+	if ttl < 0 || ttl > b.FrozenTTL {
+		return f, errors.Wrapf(model.ErrInvalidRecord, errNotValidFrozen, ttl, b.FrozenTTL)
+	}
+
+	leaseID, leaseTTL, err := b.grantLease(int64(ttl.Seconds()))
+	if err != nil {
+		return f, err
+	}
+
+	stored, token, err := b.newToken()
+	if err != nil {
+		return f, err
+	}
+	if token == "" {
+		if token, err = util.GenerateToken(stored); err != nil {
+			return f, err
+		}
+	}
+
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, prefix)
+	domain := getPath(b.Prefix, fmt.Sprintf("%s.%s", prefix, b.Domain))
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	// only one of the concurrent reservations of the prefix wins
+	txn, err := b.C.Txn(ctx).If(
+		clientv3.Compare(clientv3.CreateRevision(path), "=", 0),
+		clientv3.Compare(clientv3.CreateRevision(domain), "=", 0),
+	).Then(clientv3.OpPut(path, stored, clientv3.WithLease(clientv3.LeaseID(leaseID)))).Commit()
+	if err != nil {
+		return f, errors.Wrapf(err, errSetRecordWithLease, typeFrozen, path, leaseID)
+	}
+	if !txn.Succeeded {
+		return f, errors.Wrapf(model.ErrFrozen, errReservedPrefix, prefix)
+	}
+
+	f.Prefix = prefix
+	f.Reserved = true
+	f.Token = token
+	f.Expiration = getExpiration(leaseTTL)
+
+	return f, nil
+}
+
+// RenewFrozen extends the reservation of the prefix by the ttl, the owner must be the stored token of the reservation.
+func (b *Backend) RenewFrozen(prefix, owner string, ttl time.Duration) (f model.Frozen, err error) {
+	logrus.Debugf("renew %s record for prefix: %s with ttl: %s", typeFrozen, prefix, ttl)
+
+	// the prefix frozen without owner is owned by nobody
+	if owner == "" {
+		return f, errors.Wrapf(model.ErrUnauthorized, errNotReservedBy, prefix)
+	}
+
+	if ttl == 0 {
+		ttl = b.FrozenTTL
+	}
+	if ttl < 0 || ttl > b.FrozenTTL {
+		return f, errors.Wrapf(model.ErrInvalidRecord, errNotValidFrozen, ttl, b.FrozenTTL)
+	}
+
+	leaseID, leaseTTL, err := b.grantLease(int64(ttl.Seconds()))
+	if err != nil {
+		return f, err
+	}
+
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, prefix)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
 
-			hosts = append(hosts, m["host"])
-		}
+	txn, err := b.C.Txn(ctx).If(clientv3.Compare(clientv3.Value(path), "=", owner)).
+		Then(clientv3.OpPut(path, owner, clientv3.WithLease(clientv3.LeaseID(leaseID)))).Commit()
+	if err != nil {
+		return f, errors.Wrapf(err, errSetRecordWithLease, typeFrozen, path, leaseID)
+	}
+	if !txn.Succeeded {
+		return f, errors.Wrapf(model.ErrUnauthorized, errNotReservedBy, prefix)
+	}
 
-		for k := range subs {
-			n := fmt.Sprintf("%s.%s", k, dopts.Fqdn)
-			p := getPath(b.Prefix, n)
+	f.Prefix = prefix
+	f.Reserved = true
+	f.Expiration = getExpiration(leaseTTL)
 
-			kvs, err := b.lookupKeys(p)
-			if err != nil {
-				return err
-			}
+	return f, nil
+}
 
-			ss := make([]string, 0)
-			for _, v := range kvs {
-				m, err := unmarshalToMap(v.Value)
-				if err != nil {
-					return err
-				}
-				ss = append(ss, m["host"])
-			}
+// ReleaseFrozen unfreezes the prefix which is reserved by the owner, the prefix can be used by the new domains again.
+func (b *Backend) ReleaseFrozen(prefix, owner string) error {
+	logrus.Debugf("release %s record for prefix: %s", typeFrozen, prefix)
 
-			subs[k] = ss
-		}
+	// the prefix frozen without owner is owned by nobody
+	if owner == "" {
+		return errors.Wrapf(model.ErrUnauthorized, errNotReservedBy, prefix)
+	}
 
-		if err := b.syncRecords(dopts.Hosts, hosts, path, clientv3.LeaseID(leaseID)); err != nil {
-			return errors.Wrapf(err, errSyncRecords, typeA, path)
-		}
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, prefix)
 
-		if err := b.setSubRecords(dopts, subs, leaseID); err != nil {
-			return errors.Wrapf(err, errSetSubRecordsWithLease, typeA, dopts.Fqdn, leaseID)
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	txn, err := b.C.Txn(ctx).If(clientv3.Compare(clientv3.Value(path), "=", owner)).Then(clientv3.OpDelete(path)).Commit()
+	if err != nil {
+		return errors.Wrapf(err, errDeleteRecord, typeFrozen, path)
+	}
+	if !txn.Succeeded {
+		return errors.Wrapf(model.ErrUnauthorized, errNotReservedBy, prefix)
 	}
 
 	return nil
 }
 
-func (b *Backend) setRecord(path string, opts *model.DomainOptions, exist bool) (d model.Domain, err error) {
-	leaseID, leaseTTL, err := b.setToken(opts, exist)
+// Used to get the frozen record of the prefix
+func (b *Backend) getFrozen(prefix string) (*mvccpb.KeyValue, error) {
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, prefix)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, path)
 	if err != nil {
-		return d, err
+		return nil, errors.Wrapf(err, errLookupRecords, typeFrozen, path)
+	}
+	if resp.Count <= 0 {
+		return nil, errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeFrozen, path)
 	}
 
-	if !exist {
-		// make sure domain record is exist, although no hosts value
-		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-		defer cancel()
+	return resp.Kvs[0], nil
+}
 
-		_, err := b.C.Put(ctx, path, formatValue(""), clientv3.WithLease(clientv3.LeaseID(leaseID)))
-		if err != nil {
-			return d, err
-		}
+// Used to claim the prefix reserved by the owner for the new domain at the path, the reservation becomes the frozen
+// prefix of the domain in use. The returned func gives the reservation back to the owner.
+func (b *Backend) claimFrozen(prefix, path, owner string) (func(), error) {
+	if owner == "" {
+		return nil, errors.Wrapf(model.ErrUnauthorized, errNotReservedBy, prefix)
 	}
 
-	kvs, err := b.lookupKeys(path)
+	kv, err := b.getFrozen(prefix)
 	if err != nil {
-		return d, err
+		return nil, err
 	}
 
-	subs := make(map[string][]string, 0)
-	hosts := make([]string, 0)
+	frozen := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, prefix)
 
-	for _, v := range kvs {
-		k := string(v.Key)
-		prefix := findSubPrefix(k, path)
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
 
-		m, err := unmarshalToMap(v.Value)
-		if err != nil {
-			return d, err
-		}
+	txn, err := b.C.Txn(ctx).If(
+		clientv3.Compare(clientv3.Value(frozen), "=", owner),
+		clientv3.Compare(clientv3.CreateRevision(path), "=", 0),
+	).Then(clientv3.OpPut(frozen, "", clientv3.WithLease(clientv3.LeaseID(kv.Lease)))).Commit()
+	if err != nil {
+		return nil, errors.Wrapf(err, errSetRecordWithLease, typeFrozen, frozen, kv.Lease)
+	}
+	if !txn.Succeeded {
+		return nil, errors.Wrapf(model.ErrUnauthorized, errNotReservedBy, prefix)
+	}
 
-		isText := false
-		if _, ok := m["text"]; ok {
-			isText = true
-		}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		defer cancel()
 
-		if prefix != "" && !strings.Contains(prefix, "_") && !isText {
-			subs[prefix] = make([]string, 0)
-			continue
+		if _, err := b.C.Put(ctx, frozen, owner, clientv3.WithLease(clientv3.LeaseID(kv.Lease))); err != nil {
+			logrus.Errorf("failed to give back the reservation of %s, err: %v", prefix, err)
 		}
+	}, nil
+}
 
-		hosts = append(hosts, m["host"])
-	}
+func (b *Backend) lockSlugName(fqdn, slug string, exist bool) error {
+	logrus.Debugf("lock slug name: %s", fqdn)
 
-	for k := range subs {
-		n := fmt.Sprintf("%s.%s", k, opts.Fqdn)
-		p := getPath(b.Prefix, n)
+	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, slug)
 
-		kvs, err := b.lookupKeys(p)
+	var leaseID int64
+	if exist {
+		kvs, err := b.lookupKeys(path)
 		if err != nil {
-			return d, err
+			return err
 		}
 
-		ss := make([]string, 0)
-		for _, v := range kvs {
-			m, err := unmarshalToMap(v.Value)
-			if err != nil {
-				return d, err
-			}
-			ss = append(ss, m["host"])
+		if len(kvs) <= 0 {
+			return errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeA, path)
 		}
 
-		subs[k] = ss
-	}
+		leaseID = kvs[0].Lease
+	} else {
+		id, _, err := b.grantLease(int64(b.FrozenTTL.Seconds()))
+		if err != nil {
+			return err
+		}
 
-	if err := b.syncRecords(opts.Hosts, hosts, path, clientv3.LeaseID(leaseID)); err != nil {
-		return d, errors.Wrapf(err, errSyncRecords, typeA, path)
+		leaseID = id
 	}
 
-	if err := b.setSubRecords(opts, subs, leaseID); err != nil {
-		return d, errors.Wrapf(err, errSetSubRecordsWithLease, typeA, opts.Fqdn, leaseID)
-	}
+	return b.putFrozen(path, leaseID, "")
+}
 
-	d.Fqdn = opts.Fqdn
-	d.Hosts = opts.Hosts
-	d.SubDomain = opts.SubDomain
-	d.Expiration = getExpiration(leaseTTL)
+// Used to freeze the slug name with the lease, the slug name which is frozen without expiration by the admin api is kept as it is.
+// The owner is the stored token which the slug name is reserved by, empty means nobody can claim it.
+func (b *Backend) putFrozen(path string, leaseID int64, owner string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
 
-	return d, err
+	if _, err := b.C.Txn(ctx).If(
+		clientv3.Compare(clientv3.CreateRevision(path), "!=", 0),
+		clientv3.Compare(clientv3.LeaseValue(path), "=", clientv3.NoLease),
+	).Else(clientv3.OpPut(path, owner, clientv3.WithLease(clientv3.LeaseID(leaseID)))).Commit(); err != nil {
+		return errors.Wrapf(err, errSetRecordWithLease, typeFrozen, path, leaseID)
+	}
+
+	return nil
 }
 
-func (b *Backend) setSubRecords(opts *model.DomainOptions, origins map[string][]string, leaseID int64) error {
-	for prefix := range origins {
-		if _, ok := opts.SubDomain[prefix]; !ok {
-			path := getPath(b.Prefix, fmt.Sprintf("%s.%s", prefix, opts.Fqdn))
-			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-			_, err := b.C.Delete(ctx, path, clientv3.WithPrefix())
-			cancel()
-			if err != nil {
-				return err
-			}
-		}
+// Used to replace all the CAA records of the fqdn in one transaction, the records share the lease of the token.
+func (b *Backend) setCAARecords(opts *model.DomainOptions) error {
+	slug := findSlugWithZone(opts.Fqdn, b.Domain)
+	base := fmt.Sprintf("%s.%s", slug, b.Domain)
+
+	leaseID, _, _, err := b.setToken(&model.DomainOptions{Fqdn: base}, true)
+	if err != nil {
+		return err
 	}
 
-	for prefix, values := range opts.SubDomain {
-		path := getPath(b.Prefix, fmt.Sprintf("%s.%s", prefix, opts.Fqdn))
+	path := getCAAPath(b.Prefix, opts.Fqdn)
 
-		kvs, err := b.lookupKeys(path)
+	values := make(map[string]string, len(opts.CAA))
+	for i, r := range opts.CAA {
+		v, err := json.Marshal(r)
 		if err != nil {
 			return err
 		}
+		values[fmt.Sprintf("%s%d", path, i)] = string(v)
+	}
 
-		hosts := make([]string, 0)
-		for _, v := range kvs {
-			m, err := unmarshalToMap(v.Value)
-			if err != nil {
-				return err
-			}
-			hosts = append(hosts, m["host"])
-		}
-
-		if err := b.syncRecords(values, hosts, path, clientv3.LeaseID(leaseID)); err != nil {
-			return errors.Wrapf(err, errSyncSubRecords, typeA, path)
-		}
+	if err := b.replaceKeys(path, values, leaseID); err != nil {
+		return errors.Wrapf(err, errSetRecordWithLease, typeCAA, path, leaseID)
 	}
 
 	return nil
 }
 
-func (b *Backend) syncRecords(new, old []string, path string, leaseID clientv3.LeaseID) error {
-	left := sliceToMap(new)
-	right := sliceToMap(old)
+// Used to replace all the MX records of the fqdn in one transaction, the records share the lease of the token.
+func (b *Backend) setMXRecords(opts *model.DomainOptions) error {
+	slug := findSlugWithZone(opts.Fqdn, b.Domain)
+	base := fmt.Sprintf("%s.%s", slug, b.Domain)
 
-	for r := range right {
-		if _, ok := left[r]; !ok {
-			key := fmt.Sprintf("%s/%s", path, formatKey(r))
-			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-			_, err := b.C.Delete(ctx, key)
-			cancel()
-			if err != nil {
-				return err
-			}
-		}
+	leaseID, _, _, err := b.setToken(&model.DomainOptions{Fqdn: base}, true)
+	if err != nil {
+		return err
 	}
 
-	for l := range left {
-		if _, ok := right[l]; !ok {
-			key := fmt.Sprintf("%s/%s", path, formatKey(l))
-			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-			_, err := b.C.Put(ctx, key, formatValue(l), clientv3.WithLease(leaseID))
-			cancel()
-			if err != nil {
-				return err
-			}
+	path := getMXPath(b.Prefix, opts.Fqdn)
+
+	values := make(map[string]string, len(opts.MX))
+	for i, r := range opts.MX {
+		v, err := formatMXValue(r, opts.TTL)
+		if err != nil {
+			return err
 		}
+		values[fmt.Sprintf("%s%d", path, i)] = v
+	}
+
+	if err := b.replaceKeys(path, values, leaseID); err != nil {
+		return errors.Wrapf(err, errSetRecordWithLease, typeMX, path, leaseID)
 	}
 
 	return nil
 }
 
-func (b *Backend) setToken(opts *model.DomainOptions, exist bool) (int64, int64, error) {
-	logrus.Debugf("set %s for fqdn: %s", typeToken, opts.String())
-
-	var token string
-	var leaseID, leaseTTL int64
-
-	path := getTokenPath(opts.Fqdn)
+// Used to replace the keys under the path with the values in one transaction. The stale keys are deleted one by one,
+// because etcd rejects a transaction which puts a key inside a range it deletes.
+func (b *Backend) replaceKeys(path string, values map[string]string, leaseID int64, extra ...clientv3.Op) error {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
 
-	if exist {
-		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-		defer cancel()
+	resp, err := b.C.Get(ctx, path, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
 
-		resp, err := b.C.Get(ctx, path)
-		if err != nil {
-			return 0, -1, errors.Wrapf(err, errEmptyRecord, typeToken, path)
+	ops := extra
+	for _, kv := range resp.Kvs {
+		if _, ok := values[string(kv.Key)]; !ok {
+			ops = append(ops, clientv3.OpDelete(string(kv.Key)))
 		}
+	}
+	for k, v := range values {
+		ops = append(ops, clientv3.OpPut(k, v, clientv3.WithLease(clientv3.LeaseID(leaseID))))
+	}
 
-		if resp.Count <= 0 {
-			return 0, -1, errors.Errorf(errEmptyRecord, typeToken, path)
-		}
+	_, err = b.C.Txn(ctx).Then(ops...).Commit()
+	return err
+}
 
-		token = string(resp.Kvs[0].Value)
+// Used to set the TXT records of the fqdn in one transaction. Every value has its own key, so that the concurrent
+// challenges of the same name do not overwrite each other, and the legacy value stored at the path itself is moved to its own key.
+func (b *Backend) setTextRecords(opts *model.DomainOptions, replace bool) error {
+	slug := findSlugWithZone(opts.Fqdn, b.Domain)
+	base := fmt.Sprintf("%s.%s", slug, b.Domain)
 
-		lease, err := b.getLease(resp.Kvs[0].Lease)
-		if err != nil {
-			return 0, -1, err
-		}
+	leaseID, _, _, err := b.setToken(&model.DomainOptions{Fqdn: base}, true)
+	if err != nil {
+		return err
+	}
 
-		leaseID = int64(lease.ID)
-		leaseTTL = lease.TTL
-	} else {
-		token = util.RandStringWithAll(tokenLength)
+	path := getPath(b.Prefix, opts.Fqdn)
+	key := getTextKey(b.Prefix, opts.Fqdn, opts.Text)
+	value, err := formatTextValue(opts.Text, opts.TTL)
+	if err != nil {
+		return err
+	}
+	values := map[string]string{key: value}
 
-		id, ttl, err := b.grantLease(int64(b.LeaseTime.Seconds()))
-		if err != nil {
-			return 0, -1, err
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, errLookupRecords, typeTXT, path)
+	}
+
+	extra := make([]clientv3.Op, 0)
+	if resp.Count > 0 {
+		if m, err := unmarshalToMap(resp.Kvs[0].Value); err == nil {
+			if text, ok := m["text"]; ok {
+				extra = append(extra, clientv3.OpDelete(path))
+				if !replace {
+					v, err := formatTextValue(text, opts.TTL)
+					if err != nil {
+						return err
+					}
+					values[getTextKey(b.Prefix, opts.Fqdn, text)] = v
+				}
+			}
 		}
+	}
 
-		leaseID = id
-		leaseTTL = ttl
+	// the set adds the value if it is new, the update replaces all the values of the name with it
+	kvs, err := b.lookupTextKeys(opts.Fqdn)
+	if err != nil {
+		return err
+	}
+	keys := map[string]bool{key: true}
+	if !replace {
+		for _, kv := range kvs {
+			k := string(kv.Key)
+			if k == path {
+				m, _ := unmarshalToMap(kv.Value)
+				k = getTextKey(b.Prefix, opts.Fqdn, m["text"])
+			}
+			keys[k] = true
+		}
+	}
+	delta := len(keys) - len(kvs)
+	if err := b.addCount(opts.Fqdn, delta); err != nil {
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
 	defer cancel()
 
-	if _, err := b.C.Put(ctx, path, token, clientv3.WithLease(clientv3.LeaseID(leaseID))); err != nil {
-		return 0, -1, errors.Wrapf(err, errSetRecordWithLease, typeToken, path, leaseID)
+	if replace {
+		err = b.replaceKeys(getTextPath(b.Prefix, opts.Fqdn), values, leaseID, extra...)
+	} else {
+		for k, v := range values {
+			extra = append(extra, clientv3.OpPut(k, v, clientv3.WithLease(clientv3.LeaseID(leaseID))))
+		}
+		_, err = b.C.Txn(ctx).Then(extra...).Commit()
+	}
+	if err != nil {
+		b.undoCount(opts.Fqdn, delta)
+		return errors.Wrapf(err, errSetRecordWithLease, typeTXT, key, leaseID)
 	}
 
-	return leaseID, leaseTTL, nil
+	return nil
 }
 
-func (b *Backend) lockSlugName(fqdn, slug string, exist bool) error {
-	logrus.Debugf("lock slug name: %s", fqdn)
+// Used to delete the TXT values below the domain, e.g. the acme challenges, which are left behind when the domain is deleted.
+func (b *Backend) deleteTexts(fqdn string) error {
+	path := getPath(b.Prefix, fqdn) + "/"
 
-	path := fmt.Sprintf("%s%s/%s", b.Prefix, frozenPath, slug)
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	resp, err := b.C.Get(ctx, path, clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return errors.Wrapf(err, errLookupRecords, typeTXT, path)
+	}
 
-	var leaseID int64
-	if exist {
-		kvs, err := b.lookupKeys(path)
+	for _, kv := range resp.Kvs {
+		m, err := unmarshalToMap(kv.Value)
 		if err != nil {
-			return err
+			continue
 		}
-
-		if len(kvs) <= 0 {
-			return errors.Errorf(errEmptyRecord, typeA, path)
+		if _, ok := m["text"]; !ok {
+			continue
 		}
 
-		leaseID = kvs[0].Lease
-	} else {
-		id, _, err := b.grantLease(int64(b.FrozenTTL.Seconds()))
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		_, err = b.C.Delete(ctx, string(kv.Key))
+		cancel()
 		if err != nil {
-			return err
+			return errors.Wrapf(err, errDeleteRecord, typeTXT, string(kv.Key))
 		}
-
-		leaseID = id
 	}
 
+	return nil
+}
+
+// Used to lookup the TXT values of the fqdn, including the legacy value stored at the path itself.
+func (b *Backend) lookupTextKeys(fqdn string) ([]*mvccpb.KeyValue, error) {
+	path := getPath(b.Prefix, fqdn)
+
 	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
 	defer cancel()
 
-	if _, err := b.C.Put(ctx, path, "", clientv3.WithLease(clientv3.LeaseID(leaseID))); err != nil {
-		return errors.Wrapf(err, errSetRecordWithLease, typeFrozen, path, leaseID)
+	resp, err := b.C.Get(ctx, path)
+	if err != nil {
+		return nil, errors.Wrapf(err, errLookupRecords, typeTXT, path)
 	}
 
-	return nil
+	kvs := make([]*mvccpb.KeyValue, 0)
+	for _, kv := range resp.Kvs {
+		if m, err := unmarshalToMap(kv.Value); err == nil {
+			if _, ok := m["text"]; ok {
+				kvs = append(kvs, kv)
+			}
+		}
+	}
+
+	path = getTextPath(b.Prefix, fqdn)
+	resp, err = b.C.Get(ctx, path, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrapf(err, errLookupRecords, typeTXT, path)
+	}
+
+	return append(kvs, resp.Kvs...), nil
 }
 
 func (b *Backend) lookupKeys(path string) ([]*mvccpb.KeyValue, error) {
@@ -902,6 +2814,12 @@ func (b *Backend) lookupKeys(path string) ([]*mvccpb.KeyValue, error) {
 			if _, ok := m["text"]; ok {
 				continue
 			}
+			if _, ok := m["tag"]; ok {
+				continue
+			}
+			if _, ok := m["mail"]; ok {
+				continue
+			}
 		} else {
 			v.Value = []byte("")
 		}
@@ -964,6 +2882,367 @@ func (b *Backend) checkSlugName(slug string) bool {
 	return true
 }
 
+// Used to check whether the fqdn is a valid domain name below the root domain, which has at least depth labels more than it.
+func (b *Backend) checkDomainName(fqdn string, depth int) error {
+	if _, ok := dns.IsDomainName(fqdn); !ok || !strings.HasSuffix(fqdn, "."+b.Domain) || len(strings.Split(fqdn, "."))-len(strings.Split(b.Domain, ".")) < depth {
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidDomainName, fqdn)
+	}
+	return nil
+}
+
+// Used to check the hosts of the A records and the sub-domains, every host must be an ip address.
+func (b *Backend) checkHosts(opts *model.DomainOptions) error {
+	for _, h := range opts.Hosts {
+		if net.ParseIP(h) == nil {
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidHost, h, opts.Fqdn)
+		}
+	}
+	for k, v := range opts.SubDomain {
+		if _, ok := dns.IsDomainName(k); !ok || strings.TrimSpace(k) != k {
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidDomainName, k)
+		}
+		for _, h := range v {
+			if net.ParseIP(h) == nil {
+				return errors.Wrapf(model.ErrInvalidRecord, errNotValidHost, h, k)
+			}
+		}
+	}
+	return nil
+}
+
+// Used to get the lease time of the token created with the domain options, empty means use the default lease time.
+func (b *Backend) getLeaseTime(opts *model.DomainOptions) (time.Duration, error) {
+	if opts.LeaseTime == "" {
+		return b.LeaseTime, nil
+	}
+	d, err := time.ParseDuration(opts.LeaseTime)
+	if err != nil || d < b.MinLeaseTime || d > b.MaxLeaseTime {
+		return 0, errors.Wrapf(model.ErrInvalidRecord, errNotValidLeaseTime, opts.LeaseTime, b.MinLeaseTime, b.MaxLeaseTime)
+	}
+	return d, nil
+}
+
+// Used to check whether ttl is in the range of [MinTTL, MaxTTL], zero means use the default ttl.
+func (b *Backend) checkTTL(ttl int64) error {
+	if ttl != 0 && (ttl < b.MinTTL || ttl > b.MaxTTL) {
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidTTL, ttl, b.MinTTL, b.MaxTTL)
+	}
+	return nil
+}
+
+// Used to check the label depth below the slug name, e.g. the depth of sub1.sub2.xxxxxx.lb.rancher.cloud is 2.
+// The max depth of the token raises the max depth of the deployment, it is only looked up when the records are deeper.
+func (b *Backend) checkDepth(opts *model.DomainOptions) error {
+	name, depth := "", 0
+	for k := range opts.SubDomain {
+		if d := len(strings.Split(k, ".")); d > depth {
+			name, depth = k, d
+		}
+	}
+	if opts.Text != "" || len(opts.CAA) > 0 || len(opts.MX) > 0 {
+		if d := len(strings.Split(opts.Fqdn, ".")) - len(strings.Split(b.Domain, ".")) - 1; d > depth {
+			name, depth = opts.Fqdn, d
+		}
+	}
+	if depth <= b.MaxDepth {
+		return nil
+	}
+
+	limits, err := b.GetTokenLimits(findSlugWithZone(opts.Fqdn, b.Domain) + "." + b.Domain)
+	if err != nil {
+		return err
+	}
+	if depth > limits.MaxDepth {
+		maxDepth := b.MaxDepth
+		if limits.MaxDepth > maxDepth {
+			maxDepth = limits.MaxDepth
+		}
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidDepth, name, maxDepth)
+	}
+	return nil
+}
+
+// Used to check the number of the sub-domains of a domain, which are replaced as a whole by set and update.
+func (b *Backend) checkSubDomains(opts *model.DomainOptions) error {
+	quotas, err := b.getQuotas(opts.Fqdn)
+	if err != nil {
+		return err
+	}
+	if quotas.MaxSubDomains > 0 && len(opts.SubDomain) > quotas.MaxSubDomains {
+		return errors.Wrapf(model.ErrQuotaExceeded, errQuotaExceeded, "sub-domain", opts.Fqdn, quotas.MaxSubDomains)
+	}
+	return nil
+}
+
+// Used to check the TXT record of the set and update, the fqdn must be below a domain and the text must fit a character-string.
+func (b *Backend) checkText(opts *model.DomainOptions) error {
+	if err := b.checkDomainName(opts.Fqdn, 2); err != nil {
+		return err
+	}
+
+	if err := b.checkDepth(opts); err != nil {
+		return err
+	}
+
+	if opts.Text == "" {
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidText, typeTXT, opts.Fqdn, "no text")
+	}
+
+	// a character-string of the TXT record is limited to 255 bytes, longer values break the dns responses
+	if len(opts.Text) > maxTextLength {
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidText, typeTXT, opts.Fqdn, fmt.Sprintf("text must not be longer than %d bytes", maxTextLength))
+	}
+
+	return b.checkTTL(opts.TTL)
+}
+
+// Used to check the number of the TXT values of the fqdn, the value which is already set is not counted again.
+func (b *Backend) checkTexts(opts *model.DomainOptions) error {
+	quotas, err := b.getQuotas(opts.Fqdn)
+	if err != nil {
+		return err
+	}
+	if quotas.MaxTexts <= 0 {
+		return nil
+	}
+
+	kvs, err := b.lookupTextKeys(opts.Fqdn)
+	if err != nil {
+		return err
+	}
+
+	key := getTextKey(b.Prefix, opts.Fqdn, opts.Text)
+	for _, kv := range kvs {
+		if string(kv.Key) == key {
+			return nil
+		}
+	}
+	if len(kvs) >= quotas.MaxTexts {
+		return errors.Wrapf(model.ErrQuotaExceeded, errQuotaExceeded, typeTXT, opts.Fqdn, quotas.MaxTexts)
+	}
+	return nil
+}
+
+// Used to get the quotas of the token of the fqdn, the non-zero limits of the token override the quotas of the deployment.
+// The new domain without fqdn gets the quotas of the deployment.
+func (b *Backend) getQuotas(fqdn string) (model.TokenLimits, error) {
+	quotas := model.TokenLimits{MaxSubDomains: b.MaxSubDomains, MaxTexts: b.MaxTexts, MaxRecords: b.MaxRecords}
+	if fqdn == "" {
+		return quotas, nil
+	}
+
+	limits, err := b.GetTokenLimits(findSlugWithZone(fqdn, b.Domain) + "." + b.Domain)
+	if err != nil {
+		return quotas, err
+	}
+	if limits.MaxSubDomains > 0 {
+		quotas.MaxSubDomains = limits.MaxSubDomains
+	}
+	if limits.MaxTexts > 0 {
+		quotas.MaxTexts = limits.MaxTexts
+	}
+	if limits.MaxRecords > 0 {
+		quotas.MaxRecords = limits.MaxRecords
+	}
+	return quotas, nil
+}
+
+// Used to count the A, sub A and TXT records of the token incrementally, the writers add the number of the records
+// which they add or remove, so that the quota of the token is checked without a scan of the domain.
+// The count shares the lease of the token, it is seeded by a scan when it is not kept yet, e.g. for the domains created before.
+func (b *Backend) addCount(fqdn string, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+
+	base := findSlugWithZone(fqdn, b.Domain) + "." + b.Domain
+	path := getCountPath(base)
+
+	quotas, err := b.getQuotas(base)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < maxCountRetries; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+		token, err := b.C.Get(ctx, getTokenPath(base))
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, errLookupRecords, typeToken, getTokenPath(base))
+		}
+		if token.Count <= 0 {
+			return errors.Wrapf(model.ErrNotFound, errEmptyRecord, typeToken, getTokenPath(base))
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
+		resp, err := b.C.Get(ctx, path)
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, errLookupRecords, typeToken, path)
+		}
+
+		var count int
+		var revision int64
+		if resp.Count > 0 {
+			count, err = strconv.Atoi(string(resp.Kvs[0].Value))
+			revision = resp.Kvs[0].ModRevision
+		} else {
+			count, err = b.countRecords(base)
+		}
+		if err != nil {
+			return errors.Wrapf(err, errDecodeRecord, typeToken, path)
+		}
+
+		count += delta
+		if count < 0 {
+			count = 0
+		}
+		if delta > 0 && quotas.MaxRecords > 0 && count > quotas.MaxRecords {
+			return errors.Wrapf(model.ErrQuotaExceeded, errQuotaExceeded, "all", base, quotas.MaxRecords)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), operationTimeout)
+		txn, err := b.C.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(path), "=", revision)).
+			Then(clientv3.OpPut(path, strconv.Itoa(count), clientv3.WithLease(clientv3.LeaseID(token.Kvs[0].Lease)))).Commit()
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, errSetRecordWithLease, typeToken, path, token.Kvs[0].Lease)
+		}
+		if txn.Succeeded {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(model.ErrConflict, errCountConflict, base, maxCountRetries)
+}
+
+// Used to give back the records which are counted but failed to write, the count is reset on error so that it is seeded again
+func (b *Backend) undoCount(fqdn string, delta int) {
+	if err := b.addCount(fqdn, -delta); err != nil {
+		logrus.Errorf("failed to undo the count of %s, err: %v", fqdn, err)
+		b.resetCount(fqdn)
+	}
+}
+
+// Used to drop the count of the records of the token, it is seeded again by the next writer
+func (b *Backend) resetCount(fqdn string) {
+	path := getCountPath(findSlugWithZone(fqdn, b.Domain) + "." + b.Domain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := b.C.Delete(ctx, path); err != nil {
+		logrus.Errorf("failed to reset the count %s, err: %v", path, err)
+	}
+}
+
+// Used to count the A, sub A and TXT records of the domain by a scan, which only seeds the incremental count
+func (b *Backend) countRecords(fqdn string) (int, error) {
+	path := getPath(b.Prefix, fqdn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	resp, err := b.C.Get(ctx, path, clientv3.WithPrefix())
+	if err != nil {
+		return 0, errors.Wrapf(err, errLookupRecords, typeA, path)
+	}
+
+	count := 0
+	for _, kv := range resp.Kvs {
+		// the prefix matches the exact path and the keys below it only, not the sibling domains
+		if k := string(kv.Key); k != path && !strings.HasPrefix(k, path+"/") {
+			continue
+		}
+		m, err := unmarshalToMap(kv.Value)
+		if err != nil {
+			continue
+		}
+		if _, ok := m["text"]; ok {
+			count++
+			continue
+		}
+		if _, ok := m["mail"]; ok {
+			continue
+		}
+		if m["host"] != "" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Used to get how many records are added by replacing the old hosts with the new ones, negative if removed
+func countDelta(new, old []string) int {
+	delta := 0
+	for h := range sliceToMap(new) {
+		if h != "" {
+			delta++
+		}
+	}
+	for h := range sliceToMap(old) {
+		if h != "" {
+			delta--
+		}
+	}
+	return delta
+}
+
+// Used to check the CAA records, the tags of RFC 8659 are supported: issue, issuewild and iodef.
+func (b *Backend) checkCAA(opts *model.DomainOptions) error {
+	if err := b.checkDomainName(opts.Fqdn, 1); err != nil {
+		return err
+	}
+
+	if len(opts.CAA) <= 0 {
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidCAA, typeCAA, opts.Fqdn, "no records")
+	}
+
+	for _, r := range opts.CAA {
+		if r.Flag != 0 && r.Flag != 128 {
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidCAA, typeCAA, r, "flag must be 0 or 128")
+		}
+		switch r.Tag {
+		case "issue", "issuewild":
+		case "iodef":
+			if !strings.HasPrefix(r.Value, "mailto:") && !strings.HasPrefix(r.Value, "http://") && !strings.HasPrefix(r.Value, "https://") {
+				return errors.Wrapf(model.ErrInvalidRecord, errNotValidCAA, typeCAA, r, "iodef value must be a mailto, http or https url")
+			}
+		default:
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidCAA, typeCAA, r, "tag must be issue, issuewild or iodef")
+		}
+	}
+
+	return b.checkDepth(opts)
+}
+
+// Used to check the MX records, the host must be a domain name rather than an ip address.
+// The CNAME records are not stored by this backend, so no MX record can conflict with them.
+func (b *Backend) checkMX(opts *model.DomainOptions) error {
+	if err := b.checkDomainName(opts.Fqdn, 1); err != nil {
+		return err
+	}
+
+	if len(opts.MX) <= 0 {
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidMX, typeMX, opts.Fqdn, "no records")
+	}
+
+	for _, r := range opts.MX {
+		if r.Preference == 0 {
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidMX, typeMX, r, "preference must be in range [1, 65535]")
+		}
+		if _, ok := dns.IsDomainName(r.Host); !ok || r.Host == "" || net.ParseIP(r.Host) != nil || strings.TrimSpace(r.Host) != r.Host {
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidMX, typeMX, r, "host must be a domain name")
+		}
+	}
+
+	if err := b.checkTTL(opts.TTL); err != nil {
+		return err
+	}
+
+	return b.checkDepth(opts)
+}
+
 // Used to check whether path exist.
 func (b *Backend) checkPathExist(path string) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
@@ -983,6 +3262,43 @@ func getPath(path, fqdn string) string {
 	return path + convertToPath(fqdn)
 }
 
+// Used to get a CAA path as etcd preferred, the records are numbered under the path
+// e.g. sample.lb.rancher.cloud => /rdnsv3/cloud/rancher/lb/sample/_caa/
+func getCAAPath(path, fqdn string) string {
+	return getPath(path, fqdn) + caaPath + "/"
+}
+
+// Used to get a MX path as etcd preferred, the records are numbered under the path
+// e.g. sample.lb.rancher.cloud => /rdnsv3/cloud/rancher/lb/sample/_mx/
+func getMXPath(path, fqdn string) string {
+	return getPath(path, fqdn) + mxPath + "/"
+}
+
+// Used to get a TXT path as etcd preferred, the values are keyed by their hash under the path
+// e.g. _acme-challenge.sample.lb.rancher.cloud => /rdnsv3/cloud/rancher/lb/sample/_acme-challenge/_txt/
+func getTextPath(path, fqdn string) string {
+	return getPath(path, fqdn) + txtPath + "/"
+}
+
+// Used to get the key of a TXT value, the same value always gets the same key
+// e.g. abc of _acme-challenge.sample.lb.rancher.cloud => /rdnsv3/cloud/rancher/lb/sample/_acme-challenge/_txt/ba7816bf8f01cfea
+func getTextKey(path, fqdn, text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return fmt.Sprintf("%s%x", getTextPath(path, fqdn), sum[:8])
+}
+
+// Used to convert the expiration of a migrated record to the ttl of its lease in seconds,
+// the record without expiration gets the full ttl and the expired one gets the shortest lease.
+func convertLeaseTTL(expiration *time.Time, ttl time.Duration) int64 {
+	if expiration == nil {
+		return int64(ttl.Seconds())
+	}
+	if left := expiration.Unix() - time.Now().Unix(); left > 0 {
+		return left
+	}
+	return 1
+}
+
 // Used to convert domain to a path as etcd preferred
 // e.g. sample.lb.rancher.cloud => /cloud/rancher/lb/sample
 func convertToPath(domain string) string {
@@ -994,12 +3310,75 @@ func convertToPath(domain string) string {
 	return "/" + strings.Join(ss, "/")
 }
 
+// Used to convert a path to domain, which is the opposite of convertToPath
+// e.g. /cloud/rancher/lb/sample => sample.lb.rancher.cloud
+func convertToDomain(path string) string {
+	ss := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	last := len(ss) - 1
+	for i := 0; i < len(ss)/2; i++ {
+		ss[i], ss[last-i] = ss[last-i], ss[i]
+	}
+	return strings.Join(ss, ".")
+}
+
+// Used to get a reverse path of the ip owned by the fqdn as etcd preferred, every owner has its own key
+// e.g. 1.2.3.4 of sample.lb.rancher.cloud => /rdnsv3/arpa/in-addr/1/2/3/4/sample_lb_rancher_cloud
+func getReversePath(path, ip, fqdn string) (string, error) {
+	arpa, err := dns.ReverseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s", getPath(path, strings.TrimSuffix(arpa, ".")), formatKey(fqdn)), nil
+}
+
 // Used to get a token path as etcd preferred
 // e.g. sample.lb.rancher.cloud => /tokenv3/sample_lb_rancher_cloud
 func getTokenPath(fqdn string) string {
 	return fmt.Sprintf("%s/%s", tokenPath, formatKey(fqdn))
 }
 
+// Used to get the path of the token replaced by a transfer, it is out of the token path so that it is not counted as a token
+// e.g. sample.lb.rancher.cloud => /previoustokenv3/sample_lb_rancher_cloud
+func getPreviousTokenPath(fqdn string) string {
+	return fmt.Sprintf("%s/%s", previousPath, formatKey(fqdn))
+}
+
+// Used to get the path of the record types which the token is restricted to
+// e.g. sample.lb.rancher.cloud => /scopev3/sample_lb_rancher_cloud
+func getScopePath(fqdn string) string {
+	return fmt.Sprintf("%s/%s", scopePath, formatKey(fqdn))
+}
+
+// Used to get the path of the limits which override the limits of the deployment for the token
+// e.g. sample.lb.rancher.cloud => /limitsv3/sample_lb_rancher_cloud
+func getLimitsPath(fqdn string) string {
+	return fmt.Sprintf("%s/%s", limitsPath, formatKey(fqdn))
+}
+
+// Used to get the path of the count of the records of the token, which is kept incrementally for the quota
+// e.g. sample.lb.rancher.cloud => /countv3/sample_lb_rancher_cloud
+func getCountPath(fqdn string) string {
+	return fmt.Sprintf("%s/%s", countPath, formatKey(fqdn))
+}
+
+// Used to get the path of the owner of the domain
+// e.g. sample.lb.rancher.cloud => /ownerv3/sample_lb_rancher_cloud
+func getOwnerPath(fqdn string) string {
+	return fmt.Sprintf("%s/%s", ownerPath, formatKey(fqdn))
+}
+
+// Used to get the path of a token replaced by a transfer, the token is hashed so that the paths do not reveal it
+// e.g. xxxx of sample.lb.rancher.cloud => /replacedtokenv3/sample_lb_rancher_cloud/<sha256 of xxxx>
+func getReplacedTokenPath(fqdn, token string) string {
+	return fmt.Sprintf("%s/%s/%x", replacedPath, formatKey(fqdn), sha256.Sum256([]byte(token)))
+}
+
+// Used to get an idempotency path as etcd preferred, the key is hashed as it is provided by clients
+// e.g. xxxx => /idempotencyv3/<sha256 of xxxx>
+func getIdempotencyPath(key string) string {
+	return fmt.Sprintf("%s/%x", idempotencyPath, sha256.Sum256([]byte(key)))
+}
+
 // Used to format a key as etcd preferred
 // e.g. 1.1.1.1 => 1_1_1_1
 // e.g. sample.lb.rancher.cloud => sample_lb_rancher_cloud
@@ -1009,14 +3388,34 @@ func formatKey(key string) string {
 
 // Used to format a A value as dns preferred
 // e.g. 1.1.1.1 => {"host": "1.1.1.1"}
-func formatValue(value string) string {
+// e.g. 1.1.1.1 with ttl 30 => {"host": "1.1.1.1", "ttl": 30}
+func formatValue(value string, ttl int64) string {
+	if ttl > 0 {
+		return fmt.Sprintf("{\"host\":\"%s\",\"ttl\":%d}", value, ttl)
+	}
 	return fmt.Sprintf("{\"host\":\"%s\"}", value)
 }
 
+// Used to format a MX value as dns preferred
+// e.g. 10 mail.example.com => {"host": "mail.example.com", "priority": 10, "mail": true}
+func formatMXValue(r model.MX, ttl int64) (string, error) {
+	v, err := json.Marshal(struct {
+		Host     string `json:"host"`
+		Priority uint16 `json:"priority"`
+		Mail     bool   `json:"mail"`
+		TTL      int64  `json:"ttl,omitempty"`
+	}{r.Host, r.Preference, true, ttl})
+	return string(v), err
+}
+
 // Used to format a txt value as dns preferred
 // e.g. abc => {"text": "abc"}
-func formatTextValue(value string) string {
-	return fmt.Sprintf("{\"text\":\"%s\"}", value)
+func formatTextValue(value string, ttl int64) (string, error) {
+	v, err := json.Marshal(struct {
+		Text string `json:"text"`
+		TTL  int64  `json:"ttl,omitempty"`
+	}{value, ttl})
+	return string(v), err
 }
 
 // Used to generate a random slug
@@ -1050,10 +3449,57 @@ func getExpiration(ttl int64) *time.Time {
 	return &e
 }
 
+// Used to tell the record type of a stored value and decode it into the struct of the type,
+// the host of an A record must be an ip address, or empty for the placeholder of a domain without hosts.
+func decodeRecord(v []byte) (string, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(v, &m); err != nil {
+		return typeA, err
+	}
+
+	switch {
+	case m["text"] != nil:
+		var r struct {
+			Text string `json:"text"`
+			TTL  int64  `json:"ttl"`
+		}
+		return typeTXT, json.Unmarshal(v, &r)
+	case m["tag"] != nil:
+		var r model.CAA
+		return typeCAA, json.Unmarshal(v, &r)
+	case m["mail"] != nil:
+		var r struct {
+			Host     string `json:"host"`
+			Priority uint16 `json:"priority"`
+			Mail     bool   `json:"mail"`
+			TTL      int64  `json:"ttl"`
+		}
+		return typeMX, json.Unmarshal(v, &r)
+	default:
+		var r struct {
+			Host string `json:"host"`
+			TTL  int64  `json:"ttl"`
+		}
+		if err := json.Unmarshal(v, &r); err != nil {
+			return typeA, err
+		}
+		if r.Host != "" && net.ParseIP(r.Host) == nil {
+			return typeA, errors.Errorf("host %s is not an ip address", r.Host)
+		}
+		return typeA, nil
+	}
+}
+
 func unmarshalToMap(b []byte) (map[string]string, error) {
-	var v map[string]string
-	err := json.Unmarshal(b, &v)
-	return v, err
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(v))
+	for key, value := range v {
+		m[key] = fmt.Sprint(value)
+	}
+	return m, nil
 }
 
 func sliceToMap(ss []string) map[string]bool {
@@ -0,0 +1,903 @@
+package etcdv3
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/util/etcdtest"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/pkg/errors"
+)
+
+const testDomain = "lb.rancher.cloud"
+
+// newTestBackend returns a backend which is backed by an in-memory etcd
+func newTestBackend() (*Backend, *etcdtest.KV) {
+	client, kv := etcdtest.NewClient()
+	return &Backend{
+		Domain:       testDomain,
+		Prefix:       "/rdnsv3",
+		FrozenTTL:    time.Hour,
+		LeaseTime:    240 * time.Hour,
+		MinLeaseTime: time.Hour,
+		MaxLeaseTime: 240 * time.Hour,
+		MinTTL:       10,
+		MaxTTL:       3600,
+		MaxDepth:     2,
+		TokenGrace:   10 * time.Minute,
+		C:            client,
+	}, kv
+}
+
+// setTestDomain creates a domain and returns its fqdn
+func setTestDomain(t *testing.T, b *Backend, opts *model.DomainOptions) string {
+	d, err := b.Set(opts)
+	if err != nil {
+		t.Fatalf("set domain %s: %v", opts.String(), err)
+	}
+	return d.Fqdn
+}
+
+func TestGetStats(t *testing.T) {
+	b, _ := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{
+		Hosts:     []string{"1.1.1.1", "2.2.2.2"},
+		SubDomain: map[string][]string{"sub1": {"9.9.9.9"}},
+	})
+	// the domain without hosts has a placeholder key, which is not an A record
+	setTestDomain(t, b, &model.DomainOptions{Hosts: []string{}})
+
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: "_acme-challenge." + fqdn, Text: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SetCAA(&model.DomainOptions{Fqdn: fqdn, CAA: []model.CAA{{Tag: "issue", Value: "letsencrypt.org"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SetMX(&model.DomainOptions{Fqdn: fqdn, MX: []model.MX{{Preference: 10, Host: "mail.example.com"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := b.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Tokens != 2 || s.Frozen != 2 {
+		t.Errorf("expected 2 tokens and 2 frozen names, got %d and %d", s.Tokens, s.Frozen)
+	}
+
+	// the reverse PTR records of the hosts are not counted either
+	want := map[string]int64{typeA: 3, typeTXT: 1, typeCAA: 1, typeMX: 1}
+	for k, v := range want {
+		if s.Records[k] != v {
+			t.Errorf("expected %d %s records, got %d", v, k, s.Records[k])
+		}
+	}
+	if len(s.Records) != len(want) {
+		t.Errorf("expected the records %v, got %v", want, s.Records)
+	}
+}
+
+func TestCheckDepth(t *testing.T) {
+	b, _ := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}})
+
+	update := func(sub string) error {
+		_, err := b.Update(&model.DomainOptions{Fqdn: fqdn, Hosts: []string{"1.1.1.1"}, SubDomain: map[string][]string{sub: {"2.2.2.2"}}})
+		return err
+	}
+	text := func(name string) error {
+		_, err := b.SetText(&model.DomainOptions{Fqdn: name + "." + fqdn, Text: "abc"})
+		return err
+	}
+
+	// the boundary depth is accepted, one past it is not
+	if err := update("a.b"); err != nil {
+		t.Errorf("expected the sub domain at the max depth, got %v", err)
+	}
+	if err := update("a.b.c"); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected an invalid record past the max depth, got %v", err)
+	}
+	if err := text("a.b"); err != nil {
+		t.Errorf("expected the text at the max depth, got %v", err)
+	}
+	if err := text("a.b.c"); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected an invalid text past the max depth, got %v", err)
+	}
+
+	// the max depth of the token raises the max depth of the deployment
+	if _, err := b.SetTokenLimits(fqdn, model.TokenLimits{MaxDepth: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := update("a.b.c.d"); err != nil {
+		t.Errorf("expected the sub domain at the max depth of the token, got %v", err)
+	}
+	if err := update("a.b.c.d.e"); err == nil || !strings.Contains(err.Error(), "greater than 4") {
+		t.Errorf("expected an invalid record past the max depth of the token, got %v", err)
+	}
+	if err := text("a.b.c.d"); err != nil {
+		t.Errorf("expected the text at the max depth of the token, got %v", err)
+	}
+
+	// the limits of a token do not apply to the other domains
+	other := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}})
+	if _, err := b.Update(&model.DomainOptions{Fqdn: other, Hosts: []string{"1.1.1.1"}, SubDomain: map[string][]string{"a.b.c": {"2.2.2.2"}}}); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected an invalid record of the other domain, got %v", err)
+	}
+
+	// removing the limits restores the max depth of the deployment
+	if _, err := b.SetTokenLimits(fqdn, model.TokenLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := update("a.b.c"); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected an invalid record past the max depth, got %v", err)
+	}
+}
+
+func TestSetTokenLimitsNotFound(t *testing.T) {
+	b, kv := newTestBackend()
+
+	if _, err := b.SetTokenLimits("missing."+testDomain, model.TokenLimits{MaxDepth: 4}); errors.Cause(err) != model.ErrNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if keys := kv.Keys(limitsPath); len(keys) != 0 {
+		t.Errorf("expected no limits, got %v", keys)
+	}
+}
+
+func TestCheckRecords(t *testing.T) {
+	b, kv := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{
+		Hosts:     []string{"1.1.1.1"},
+		SubDomain: map[string][]string{"sub1": {"9.9.9.9"}},
+	})
+	setTestDomain(t, b, &model.DomainOptions{Hosts: []string{}})
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: "_acme-challenge." + fqdn, Text: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SetCAA(&model.DomainOptions{Fqdn: fqdn, CAA: []model.CAA{{Tag: "issue", Value: "letsencrypt.org"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SetMX(&model.DomainOptions{Fqdn: fqdn, MX: []model.MX{{Preference: 10, Host: "mail.example.com"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SetTokenLimits(fqdn, model.TokenLimits{MaxDepth: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := b.CheckRecords(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the placeholders of the domains are decoded as A values without hosts
+	want := map[string]int64{typeA: 4, typeTXT: 1, typeCAA: 1, typeMX: 1, typeToken: 1}
+	for k, v := range want {
+		if counts[k] != v {
+			t.Errorf("expected %d %s records, got %d", v, k, counts[k])
+		}
+	}
+
+	// a value which is not readable by this version fails the check with its key
+	path := getPath(b.Prefix, fqdn) + "/sub2/bad"
+	if _, err := kv.Put(context.Background(), path, `{"host": 1}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.CheckRecords(100); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected a decode error of %s, got %v", path, err)
+	}
+
+	if _, err := kv.Put(context.Background(), path, `{"host": "not-an-ip"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.CheckRecords(100); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected a decode error of %s, got %v", path, err)
+	}
+}
+
+func TestCheckMX(t *testing.T) {
+	b, _ := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}})
+
+	tests := []struct {
+		records []model.MX
+		valid   bool
+	}{
+		{[]model.MX{{Preference: 10, Host: "mail.example.com"}}, true},
+		{[]model.MX{{Preference: 10, Host: "mail." + fqdn}, {Preference: 20, Host: "backup.example.com"}}, true},
+		{[]model.MX{{Preference: 10, Host: "1.1.1.1"}}, false},
+		{[]model.MX{{Preference: 0, Host: "mail.example.com"}}, false},
+		{[]model.MX{{Preference: 10, Host: ""}}, false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		err := b.checkMX(&model.DomainOptions{Fqdn: fqdn, MX: test.records})
+		if test.valid && err != nil {
+			t.Errorf("%v: expected valid, got %v", test.records, err)
+		}
+		if !test.valid && errors.Cause(err) != model.ErrInvalidRecord {
+			t.Errorf("%v: expected an invalid record, got %v", test.records, err)
+		}
+	}
+}
+
+func TestCheckText(t *testing.T) {
+	b, _ := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}})
+
+	tests := []struct {
+		fqdn  string
+		text  string
+		ttl   int64
+		valid bool
+	}{
+		{"_acme-challenge." + fqdn, "abc", 0, true},
+		{"_acme-challenge." + fqdn, strings.Repeat("a", maxTextLength), 60, true},
+		{"_acme-challenge." + fqdn, strings.Repeat("a", maxTextLength+1), 0, false},
+		{"_acme-challenge." + fqdn, "", 0, false},
+		{"_acme-challenge." + fqdn, "abc", 1, false},
+		{fqdn, "abc", 0, false},
+		{"_acme-challenge.example.com", "abc", 0, false},
+		{"a.b._acme-challenge." + fqdn, "abc", 0, false},
+	}
+	for _, test := range tests {
+		err := b.checkText(&model.DomainOptions{Fqdn: test.fqdn, Text: test.text, TTL: test.ttl})
+		if test.valid && err != nil {
+			t.Errorf("%s %d bytes: expected valid, got %v", test.fqdn, len(test.text), err)
+		}
+		if !test.valid && errors.Cause(err) != model.ErrInvalidRecord {
+			t.Errorf("%s %d bytes: expected an invalid record, got %v", test.fqdn, len(test.text), err)
+		}
+	}
+
+	// the set and the update share the check
+	long := &model.DomainOptions{Fqdn: "_acme-challenge." + fqdn, Text: strings.Repeat("a", maxTextLength+1)}
+	if _, err := b.SetText(long); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected the set of a long text invalid, got %v", err)
+	}
+	if _, err := b.UpdateText(long); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected the update of a long text invalid, got %v", err)
+	}
+}
+
+func TestSetFrozen(t *testing.T) {
+	b, kv := newTestBackend()
+
+	frozenLease := func(prefix string) (int64, bool) {
+		resp, err := kv.Get(context.Background(), b.Prefix+frozenPath+"/"+prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Count <= 0 {
+			return 0, false
+		}
+		return resp.Kvs[0].Lease, true
+	}
+
+	// zero ttl is the default frozen duration
+	f, err := b.SetFrozen("brand1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Expiration == nil || f.Expiration.Sub(time.Now()) < b.FrozenTTL-time.Second {
+		t.Errorf("expected the default expiration, got %v", f.Expiration)
+	}
+	if !b.checkSlugName("brand1") {
+		t.Error("expected the prefix to be frozen")
+	}
+
+	// the positive ttl may exceed the default frozen duration, and the prefix is unfrozen when it expires
+	if f, err = b.SetFrozen("brand2", 48*time.Hour); err != nil || f.Expiration.Sub(time.Now()) < 47*time.Hour {
+		t.Errorf("expected the expiration after 48h, got %v %v", f.Expiration, err)
+	}
+	lease, _ := frozenLease("brand2")
+	kv.Expire(clientv3.LeaseID(lease))
+	if _, err := b.GetFrozen("brand2"); errors.Cause(err) != model.ErrNotFound {
+		t.Errorf("expected the expired prefix to be unfrozen, got %v", err)
+	}
+
+	// the negative ttl never expires
+	if f, err = b.SetFrozen("brand3", -1); err != nil || f.Expiration != nil {
+		t.Errorf("expected no expiration, got %v %v", f.Expiration, err)
+	}
+	if f, err = b.GetFrozen("brand3"); err != nil || f.Expiration != nil {
+		t.Errorf("expected no expiration, got %v %v", f.Expiration, err)
+	}
+
+	for _, prefix := range []string{"", "a.b", "bad_prefix!"} {
+		if _, err := b.SetFrozen(prefix, 0); errors.Cause(err) != model.ErrInvalidRecord {
+			t.Errorf("expected the prefix %q to be invalid, got %v", prefix, err)
+		}
+	}
+
+	if err := b.DeleteFrozen("brand3"); err != nil {
+		t.Fatal(err)
+	}
+	if b.checkSlugName("brand3") {
+		t.Error("expected the prefix to be unfrozen")
+	}
+	if err := b.DeleteFrozen("brand3"); errors.Cause(err) != model.ErrNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestSetFrozenInUse(t *testing.T) {
+	b, kv := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}})
+	slug := findSlugWithZone(fqdn, b.Domain)
+	path := b.Prefix + frozenPath + "/" + slug
+
+	if _, err := b.SetFrozen(slug, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteFrozen(slug); errors.Cause(err) != model.ErrConflict {
+		t.Errorf("expected the prefix in use not to be unfrozen, got %v", err)
+	}
+
+	// neither the update nor the release of the domain gives the prefix an expiration
+	if _, err := b.Update(&model.DomainOptions{Fqdn: fqdn, Hosts: []string{"2.2.2.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Release(&model.DomainOptions{Fqdn: fqdn}, 0); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := kv.Get(context.Background(), path)
+	if err != nil || resp.Count != 1 || resp.Kvs[0].Lease != int64(clientv3.NoLease) {
+		t.Errorf("expected the prefix frozen without expiration, got %v %v", resp, err)
+	}
+}
+
+func TestReserveFrozen(t *testing.T) {
+	b, _ := newTestBackend()
+
+	f, err := b.ReserveFrozen("brand", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Reserved || f.Token == "" || f.Expiration == nil {
+		t.Fatalf("expected the reservation with its token, got %+v", f)
+	}
+	if _, err := b.ReserveFrozen("brand", 0); errors.Cause(err) != model.ErrFrozen {
+		t.Errorf("expected the reserved prefix not reserved again, got %v", err)
+	}
+	if _, err := b.ReserveFrozen("other", 2*b.FrozenTTL); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected the ttl over the frozen duration rejected, got %v", err)
+	}
+
+	owner, err := b.GetFrozenOwner("brand")
+	if err != nil || owner == "" {
+		t.Fatalf("expected the owner of the reservation, got %q: %v", owner, err)
+	}
+	if _, err := b.RenewFrozen("brand", "another", 0); errors.Cause(err) != model.ErrUnauthorized {
+		t.Errorf("expected the renew by another owner rejected, got %v", err)
+	}
+	if _, err := b.RenewFrozen("brand", owner, 0); err != nil {
+		t.Errorf("renew: %v", err)
+	}
+
+	// the prefix is only claimed by its owner, the claim unfreezes the reservation
+	if _, err := b.Set(&model.DomainOptions{Hosts: []string{"1.1.1.1"}, Prefix: "brand", Reservation: "another"}); errors.Cause(err) != model.ErrUnauthorized {
+		t.Fatalf("expected the claim by another owner rejected, got %v", err)
+	}
+	d, err := b.Set(&model.DomainOptions{Hosts: []string{"1.1.1.1"}, Prefix: "brand", Reservation: owner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Fqdn != "brand."+testDomain {
+		t.Fatalf("expected the domain of the prefix, got %+v", d)
+	}
+	if f, err := b.GetFrozen("brand"); err != nil || f.Reserved {
+		t.Errorf("expected the prefix frozen by the domain in use, got %+v: %v", f, err)
+	}
+	if _, err := b.Set(&model.DomainOptions{Hosts: []string{"1.1.1.1"}, Prefix: "brand", Reservation: owner}); err == nil {
+		t.Error("expected the claimed prefix not claimed again")
+	}
+
+	// the released domain reserves its prefix for its token
+	origin, err := b.GetToken(d.Fqdn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Release(&model.DomainOptions{Fqdn: d.Fqdn}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := b.GetFrozenOwner("brand"); err != nil || owner != origin {
+		t.Fatalf("expected the released prefix owned by the token of the domain, got %q: %v", owner, err)
+	}
+	if err := b.ReleaseFrozen("brand", "another"); errors.Cause(err) != model.ErrUnauthorized {
+		t.Errorf("expected the release by another owner rejected, got %v", err)
+	}
+	if err := b.ReleaseFrozen("brand", origin); err != nil {
+		t.Fatal(err)
+	}
+	if b.checkSlugName("brand") {
+		t.Error("expected the released reservation unfrozen")
+	}
+
+	// the prefix frozen by the admin is owned by nobody
+	if _, err := b.SetFrozen("admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Set(&model.DomainOptions{Hosts: []string{"1.1.1.1"}, Prefix: "admin"}); errors.Cause(err) != model.ErrUnauthorized {
+		t.Errorf("expected the prefix frozen by the admin not claimed, got %v", err)
+	}
+}
+
+func TestUpsertRecords(t *testing.T) {
+	b, _ := newTestBackend()
+	b.MaxSubDomains = 3
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{
+		Hosts:     []string{"1.1.1.1"},
+		SubDomain: map[string][]string{"kept": {"8.8.8.8"}},
+	})
+
+	d, err := b.UpsertRecords(fqdn, &model.RecordsBatch{
+		Hosts: []string{"2.2.2.2"},
+		SubDomain: map[string][]string{
+			"sub1":        {"9.9.9.9"},
+			"sub2":        {"not-an-ip"},
+			"sub3.sub2.x": {"9.9.9.9"},
+			"sub4":        {"7.7.7.7"},
+			"sub5":        {"6.6.6.6"},
+		},
+		Texts: []model.TextRecord{
+			{Fqdn: "_acme-challenge." + fqdn, Text: "abc"},
+			{Fqdn: "_acme-challenge." + fqdn, Text: "def"},
+			{Fqdn: "_acme-challenge.other." + testDomain, Text: "abc"},
+		},
+	})
+	batchErr, ok := err.(*model.BatchError)
+	if !ok {
+		t.Fatalf("expected a batch error, got %v", err)
+	}
+
+	// kept + sub1 + sub4 fill the quota of 3, so sub5 is over it
+	expected := map[string]error{
+		"sub2." + fqdn:                        model.ErrInvalidRecord,
+		"sub3.sub2.x." + fqdn:                 model.ErrInvalidRecord,
+		"sub5." + fqdn:                        model.ErrQuotaExceeded,
+		"_acme-challenge.other." + testDomain: model.ErrInvalidRecord,
+	}
+	if len(batchErr.Errors) != len(expected) {
+		t.Fatalf("expected %d failed records, got %v", len(expected), batchErr)
+	}
+	for k, cause := range expected {
+		if errors.Cause(batchErr.Errors[k]) != cause {
+			t.Errorf("expected %s failed with %v, got %v", k, cause, batchErr.Errors[k])
+		}
+	}
+
+	// the other records of the batch are applied, and the sub domain not in the batch is kept
+	if len(d.Hosts) != 1 || d.Hosts[0] != "2.2.2.2" {
+		t.Errorf("expected the hosts replaced, got %v", d.Hosts)
+	}
+	for _, k := range []string{"kept", "sub1", "sub4"} {
+		if len(d.SubDomain[k]) != 1 {
+			t.Errorf("expected the sub domain %s, got %v", k, d.SubDomain)
+		}
+	}
+	if _, ok := d.SubDomain["sub5"]; ok {
+		t.Errorf("expected the sub domain over the quota not applied, got %v", d.SubDomain)
+	}
+	if txt, err := b.GetText(&model.DomainOptions{Fqdn: "_acme-challenge." + fqdn}); err != nil || len(txt.Texts) != 2 {
+		t.Errorf("expected both values of the text record applied, got %v: %v", txt.Texts, err)
+	}
+
+	if _, err := b.UpsertRecords("missing."+testDomain, &model.RecordsBatch{}); errors.Cause(err) != model.ErrNotFound {
+		t.Errorf("expected not found for a missing domain, got %v", err)
+	}
+}
+
+func TestAddRemoveAHosts(t *testing.T) {
+	b, kv := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}})
+
+	d, err := b.AddAHosts(fqdn, []string{"1.1.1.1", "2.2.2.2", "2.2.2.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Hosts) != 2 {
+		t.Fatalf("expected the hosts deduplicated, got %v", d.Hosts)
+	}
+
+	if d, err = b.RemoveAHosts(fqdn, []string{"3.3.3.3"}); err != nil || len(d.Hosts) != 2 {
+		t.Fatalf("expected the removal of an absent host a no-op, got %v: %v", d.Hosts, err)
+	}
+
+	// the sub domain without records is created by the first host
+	if d, err = b.AddAHosts("sub1."+fqdn, []string{"9.9.9.9"}); err != nil || len(d.SubDomain["sub1"]) != 1 {
+		t.Fatalf("expected the sub domain created, got %v: %v", d.SubDomain, err)
+	}
+	if _, ok := kv.Value("/rdnsv3/arpa/in-addr/9/9/9/9/" + formatKey("sub1."+fqdn)); !ok {
+		t.Error("expected the reverse record of the added host")
+	}
+
+	// the sub domain is deleted with its last host, the domain itself is kept without hosts
+	if d, err = b.RemoveAHosts("sub1."+fqdn, []string{"9.9.9.9"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.SubDomain["sub1"]; ok {
+		t.Errorf("expected the sub domain deleted, got %v", d.SubDomain)
+	}
+	if keys := kv.Keys(getPath(b.Prefix, "sub1."+fqdn)); len(keys) != 0 {
+		t.Errorf("expected no keys of the sub domain left, got %v", keys)
+	}
+	if keys := kv.Keys("/rdnsv3/arpa/in-addr/9/9/9/9/"); len(keys) != 0 {
+		t.Errorf("expected no reverse records of the sub domain left, got %v", keys)
+	}
+	if d, err = b.RemoveAHosts(fqdn, []string{"1.1.1.1", "2.2.2.2"}); err != nil || len(d.Hosts) != 0 {
+		t.Fatalf("expected the domain kept without hosts, got %v: %v", d.Hosts, err)
+	}
+
+	if _, err := b.AddAHosts(fqdn, []string{"not-an-ip"}); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected an invalid host rejected, got %v", err)
+	}
+	if _, err := b.AddAHosts("missing."+testDomain, []string{"1.1.1.1"}); errors.Cause(err) != model.ErrNotFound {
+		t.Errorf("expected not found for a missing domain, got %v", err)
+	}
+}
+
+func TestAddAHostsConcurrent(t *testing.T) {
+	b, kv := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}})
+
+	// the second adder lands between the read and the write of the first one, which must retry on the changed records
+	kv.BeforeTxn = func() {
+		kv.BeforeTxn = nil
+		if _, err := b.AddAHosts(fqdn, []string{"3.3.3.3"}); err != nil {
+			t.Errorf("second adder: %v", err)
+		}
+	}
+	if _, err := b.AddAHosts(fqdn, []string{"2.2.2.2", "3.3.3.3"}); err != nil {
+		t.Fatalf("first adder: %v", err)
+	}
+
+	d, err := b.Get(&model.DomainOptions{Fqdn: fqdn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Hosts) != 3 {
+		t.Fatalf("expected the hosts of both adders, got %v", d.Hosts)
+	}
+	if count, _ := kv.Value(getCountPath(fqdn)); count != "3" {
+		t.Errorf("expected the host added by both adders counted once, got %s", count)
+	}
+
+	// the new sub domains of the domain are serialized as well, so that the quota is not passed by both
+	b.MaxSubDomains = 1
+	kv.BeforeTxn = func() {
+		kv.BeforeTxn = nil
+		if _, err := b.AddAHosts("sub1."+fqdn, []string{"9.9.9.9"}); err != nil {
+			t.Errorf("second adder: %v", err)
+		}
+	}
+	if _, err := b.AddAHosts("sub2."+fqdn, []string{"9.9.9.9"}); errors.Cause(err) != model.ErrQuotaExceeded {
+		t.Fatalf("expected the second sub domain over the quota, got %v", err)
+	}
+	b.MaxSubDomains = 0
+
+	// the adders of the same sub domain are run for real as well
+	done := make(chan error)
+	for _, h := range []string{"7.7.7.7", "8.8.8.8"} {
+		go func(h string) {
+			_, err := b.AddAHosts("sub2."+fqdn, []string{h})
+			done <- err
+		}(h)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d, err = b.Get(&model.DomainOptions{Fqdn: fqdn}); err != nil || len(d.SubDomain["sub2"]) != 2 {
+		t.Fatalf("expected the hosts of both adders in the sub domain, got %v: %v", d.SubDomain, err)
+	}
+}
+
+func TestDeleteTexts(t *testing.T) {
+	b, kv := newTestBackend()
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{
+		Hosts:     []string{"1.1.1.1"},
+		SubDomain: map[string][]string{"sub1": {"9.9.9.9"}},
+	})
+	other := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"2.2.2.2"}})
+
+	for _, o := range []*model.DomainOptions{
+		{Fqdn: "_acme-challenge." + fqdn, Text: "challenge-1"},
+		{Fqdn: "_acme-challenge." + fqdn, Text: "challenge-2"},
+		{Fqdn: "_acme-challenge.sub1." + fqdn, Text: "challenge-3"},
+		{Fqdn: "_acme-challenge." + other, Text: "kept"},
+	} {
+		if _, err := b.SetText(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.Delete(&model.DomainOptions{Fqdn: fqdn}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range kv.Keys(getPath(b.Prefix, fqdn)) {
+		if v, _ := kv.Value(k); strings.Contains(v, "\"text\"") {
+			t.Errorf("expected no TXT value left below the deleted domain, got %s: %s", k, v)
+		}
+	}
+	if txt, err := b.GetText(&model.DomainOptions{Fqdn: "_acme-challenge." + other}); err != nil || len(txt.Texts) != 1 {
+		t.Errorf("expected the TXT value of the other domain kept, got %v: %v", txt.Texts, err)
+	}
+}
+
+func TestRecordsQuota(t *testing.T) {
+	b, kv := newTestBackend()
+	b.MaxRecords = 5
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{
+		Hosts:     []string{"1.1.1.1", "2.2.2.2"},
+		SubDomain: map[string][]string{"sub1": {"3.3.3.3"}},
+	})
+	acme := "_acme-challenge." + fqdn
+
+	count := func() string {
+		v, _ := kv.Value(getCountPath(fqdn))
+		return v
+	}
+	if c := count(); c != "3" {
+		t.Fatalf("expected the count 3 after the creation, got %q", c)
+	}
+
+	for _, text := range []string{"a", "b"} {
+		if _, err := b.SetText(&model.DomainOptions{Fqdn: acme, Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the value which is already set is not counted again
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: acme, Text: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if c := count(); c != "5" {
+		t.Fatalf("expected the count 5 after the text records, got %q", c)
+	}
+
+	// the quota is hit by the new records of any type
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: acme, Text: "c"}); errors.Cause(err) != model.ErrQuotaExceeded {
+		t.Fatalf("expected the quota exceeded by a text record, got %v", err)
+	}
+	full := &model.DomainOptions{Fqdn: fqdn, Hosts: []string{"1.1.1.1", "2.2.2.2", "4.4.4.4"}, SubDomain: map[string][]string{"sub1": {"3.3.3.3"}}}
+	if _, err := b.Update(full); errors.Cause(err) != model.ErrQuotaExceeded {
+		t.Fatalf("expected the quota exceeded by a host, got %v", err)
+	}
+	if d, err := b.Get(&model.DomainOptions{Fqdn: fqdn}); err != nil || len(d.Hosts) != 2 {
+		t.Fatalf("expected the rejected update not applied, got %v: %v", d.Hosts, err)
+	}
+
+	// the deletes free the quota
+	if err := b.DeleteText(&model.DomainOptions{Fqdn: acme, Text: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Update(full); err != nil {
+		t.Fatalf("expected the host allowed after the delete, got %v", err)
+	}
+	if _, err := b.Update(&model.DomainOptions{Fqdn: fqdn, Hosts: []string{"1.1.1.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if c := count(); c != "2" {
+		t.Fatalf("expected the count 2 after removing the hosts and the sub domain, got %q", c)
+	}
+	if err := b.DeleteText(&model.DomainOptions{Fqdn: acme}); err != nil {
+		t.Fatal(err)
+	}
+	if c := count(); c != "1" {
+		t.Fatalf("expected the count 1 after deleting the text records, got %q", c)
+	}
+
+	// the count which is not kept yet is seeded by a scan of the domain
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: acme, Text: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	b.resetCount(fqdn)
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: acme, Text: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	if c := count(); c != "3" {
+		t.Fatalf("expected the seeded count 3, got %q", c)
+	}
+
+	// the limits of the token override the quota
+	hosts := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}
+	if _, err := b.Update(&model.DomainOptions{Fqdn: fqdn, Hosts: hosts}); errors.Cause(err) != model.ErrQuotaExceeded {
+		t.Fatalf("expected the quota exceeded, got %v", err)
+	}
+	if _, err := b.SetTokenLimits(fqdn, model.TokenLimits{MaxRecords: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Update(&model.DomainOptions{Fqdn: fqdn, Hosts: hosts}); err != nil {
+		t.Fatalf("expected the raised quota, got %v", err)
+	}
+
+	// the count is dropped with the records
+	if err := b.Delete(&model.DomainOptions{Fqdn: fqdn}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.Value(getCountPath(fqdn)); ok {
+		t.Fatal("expected the count deleted with the domain")
+	}
+}
+
+func TestQuotaOverrides(t *testing.T) {
+	b, _ := newTestBackend()
+	b.MaxSubDomains = 1
+	b.MaxTexts = 1
+
+	fqdn := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}})
+	subs := map[string][]string{"sub1": {"2.2.2.2"}, "sub2": {"3.3.3.3"}}
+	acme := "_acme-challenge." + fqdn
+
+	if _, err := b.Update(&model.DomainOptions{Fqdn: fqdn, SubDomain: subs}); errors.Cause(err) != model.ErrQuotaExceeded {
+		t.Fatalf("expected the sub-domain quota exceeded, got %v", err)
+	}
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: acme, Text: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: acme, Text: "b"}); errors.Cause(err) != model.ErrQuotaExceeded {
+		t.Fatalf("expected the TXT quota exceeded, got %v", err)
+	}
+
+	if _, err := b.SetTokenLimits(fqdn, model.TokenLimits{MaxSubDomains: 2, MaxTexts: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Update(&model.DomainOptions{Fqdn: fqdn, SubDomain: subs}); err != nil {
+		t.Fatalf("expected the raised sub-domain quota, got %v", err)
+	}
+	if _, err := b.SetText(&model.DomainOptions{Fqdn: acme, Text: "b"}); err != nil {
+		t.Fatalf("expected the raised TXT quota, got %v", err)
+	}
+
+	// a new domain never picks up the limits of the fqdn given by the client
+	if _, err := b.Set(&model.DomainOptions{Fqdn: fqdn, SubDomain: subs}); errors.Cause(err) != model.ErrQuotaExceeded {
+		t.Fatalf("expected the quota of the deployment for a new domain, got %v", err)
+	}
+}
+
+func TestConvertLeaseTTL(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		expiration *time.Time
+		ttl        time.Duration
+		min, max   int64
+	}{
+		{nil, 240 * time.Hour, 240 * 3600, 240 * 3600},
+		{&future, 240 * time.Hour, 3599, 3600},
+		{&past, 240 * time.Hour, 1, 1},
+	}
+	for _, test := range tests {
+		if got := convertLeaseTTL(test.expiration, test.ttl); got < test.min || got > test.max {
+			t.Errorf("%v: expected the lease ttl in [%d, %d], got %d", test.expiration, test.min, test.max, got)
+		}
+	}
+}
+
+func TestMigrateLeases(t *testing.T) {
+	b, kv := newTestBackend()
+
+	leaseTTL := func(key string) int64 {
+		resp, err := kv.Get(context.Background(), key)
+		if err != nil || resp.Count <= 0 {
+			t.Fatalf("expected %s migrated: %v", key, err)
+		}
+		ttl, err := kv.TimeToLive(context.Background(), clientv3.LeaseID(resp.Kvs[0].Lease))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ttl.TTL
+	}
+
+	// the records migrated with and without expiration expire at the expiration or after the full ttl
+	expiration := time.Now().Add(2 * time.Hour)
+	if err := b.MigrateToken(&model.MigrateToken{Path: "/token/aaaaaa_" + formatKey(testDomain), Token: "a", Expiration: &expiration}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MigrateToken(&model.MigrateToken{Path: "/token/bbbbbb_" + formatKey(testDomain), Token: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MigrateFrozen(&model.MigrateFrozen{Path: "cccccc", Expiration: &expiration}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MigrateFrozen(&model.MigrateFrozen{Path: "dddddd"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key string
+		ttl int64
+	}{
+		{getTokenPath("aaaaaa." + testDomain), 2 * 3600},
+		{getTokenPath("bbbbbb." + testDomain), int64(b.LeaseTime.Seconds())},
+		{b.Prefix + frozenPath + "/cccccc", 2 * 3600},
+		{b.Prefix + frozenPath + "/dddddd", int64(b.FrozenTTL.Seconds())},
+	}
+	for _, test := range tests {
+		if ttl := leaseTTL(test.key); ttl < test.ttl-1 || ttl > test.ttl {
+			t.Errorf("%s: expected the lease ttl %d, got %d", test.key, test.ttl, ttl)
+		}
+	}
+}
+
+func TestGetLeaseTime(t *testing.T) {
+	b, _ := newTestBackend()
+
+	tests := []struct {
+		leaseTime string
+		expected  time.Duration
+		valid     bool
+	}{
+		{"", b.LeaseTime, true},
+		{"6h", 6 * time.Hour, true},
+		{"1h", time.Hour, true},
+		{"240h", 240 * time.Hour, true},
+		{"30m", 0, false},
+		{"241h", 0, false},
+		{"6", 0, false},
+	}
+	for _, test := range tests {
+		d, err := b.getLeaseTime(&model.DomainOptions{LeaseTime: test.leaseTime})
+		if test.valid && (err != nil || d != test.expected) {
+			t.Errorf("%q: expected %s, got %s %v", test.leaseTime, test.expected, d, err)
+		}
+		if !test.valid && errors.Cause(err) != model.ErrInvalidRecord {
+			t.Errorf("%q: expected invalid, got %v", test.leaseTime, err)
+		}
+	}
+}
+
+func TestTokenLeaseTime(t *testing.T) {
+	b, kv := newTestBackend()
+
+	leaseTTL := func(fqdn string) int64 {
+		resp, err := kv.Get(context.Background(), getTokenPath(fqdn))
+		if err != nil || resp.Count <= 0 {
+			t.Fatalf("expected the token of %s: %v", fqdn, err)
+		}
+		ttl, err := kv.TimeToLive(context.Background(), clientv3.LeaseID(resp.Kvs[0].Lease))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ttl.TTL
+	}
+
+	// the demo and the standard domains live side by side with their own lease time
+	demo := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"1.1.1.1"}, LeaseTime: "6h"})
+	standard := setTestDomain(t, b, &model.DomainOptions{Hosts: []string{"2.2.2.2"}})
+	if ttl := leaseTTL(demo); ttl != 6*3600 {
+		t.Errorf("expected the demo lease ttl %d, got %d", 6*3600, ttl)
+	}
+	if ttl := leaseTTL(standard); ttl != int64(b.LeaseTime.Seconds()) {
+		t.Errorf("expected the default lease ttl %d, got %d", int64(b.LeaseTime.Seconds()), ttl)
+	}
+
+	// the renewal extends the domain by its own lease time
+	d, err := b.Renew(&model.DomainOptions{Fqdn: demo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Expiration == nil || d.Expiration.After(time.Now().Add(6*time.Hour+time.Minute)) || d.Expiration.Before(time.Now().Add(6*time.Hour-time.Minute)) {
+		t.Errorf("expected the demo renewed by 6h, got %v", d.Expiration)
+	}
+
+	if _, err := b.Set(&model.DomainOptions{Hosts: []string{"3.3.3.3"}, LeaseTime: "30m"}); errors.Cause(err) != model.ErrInvalidRecord {
+		t.Errorf("expected the lease time below the minimum rejected, got %v", err)
+	}
+}
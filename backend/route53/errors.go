@@ -10,14 +10,32 @@ const (
 	errInsertFrozenToDatabase    = "failed to insert %s's frozen to database"
 	errInsertRecordToDatabase    = "failed to insert %s record: %s to database"
 	errInsertTokenToDatabase     = "failed to insert %s's token to database"
+	errNoPreviousToken           = "previous token of %s is not kept by %s backend"
 	errNoRoute53Record           = "failed to found route53 %s record: %s"
+	errNotValidHost              = "not valid host %s of %s, must be an ip address"
+	errNotValidCNAME             = "not valid CNAME record %s of %s: %s"
+	errNotValidFrozen            = "not valid frozen duration %s, must not be greater than %s"
 	errNotValidGenerateName      = "generate name %s is already exist, will try another"
+	errNotSupportedRecord        = "%s record is not supported by %s backend"
+	errNotSupportedScopes        = "token scopes are not supported by %s backend"
+	errNotSupportedLimits        = "token limits are not supported by %s backend"
+	errNotSupportedHashing       = "hashing tokens at rest is not supported by %s backend"
+	errNotSupportedFrozen        = "frozen prefixes are not managed by %s backend, they are purged by the database"
+	errNotSupportedOwner         = "domain owner is not supported by %s backend"
+	errNotSupportedBatch         = "batch of records is not supported by %s backend"
+	errNotSupportedReservation   = "reserved prefixes are not supported by %s backend"
+	errNotValidDepth             = "not valid depth of %s, must not be greater than %d"
+	errNotValidTTL               = "not valid ttl %d, must be in range [%d, %d]"
+	errNotValidLeaseTime         = "not valid lease time %s, must be in range [%s, %s]"
 	errParseFlag                 = "failed to parse flag: %s"
+	errPartialRelease            = "domain %s is frozen, but failed to delete some records: %s"
 	errQueryAFromDatabase        = "failed to query %s's A record from database"
 	errQueryTokenFromDatabase    = "failed to query %s's token record from database"
 	errQueryTXTFromDatabase      = "failed to query %s's TXT record from database"
 	errQueryCNAMEFromDatabase    = "failed to query %s's CNAME record from database"
 	errRenewFrozenFromDatabase   = "failed to renew %s's frozen record from database"
 	errRenewTokenFromDatabase    = "failed to renew %s's token record from database"
+	errUpdateFrozenFromDatabase  = "failed to update %s's frozen record from database"
+	errUpdateTokenFromDatabase   = "failed to update %s's token record from database"
 	errUpsertRoute53Record       = "failed to upsert route53 %s record: %s"
 )
@@ -3,6 +3,7 @@ package route53
 import (
 	"database/sql"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -25,16 +26,26 @@ const (
 	typeA            = "A"
 	typeTXT          = "TXT"
 	typeCNAME        = "CNAME"
+	typeCAA          = "CAA"
+	typeMX           = "MX"
 	maxSlugHashTimes = 100
+	maxCNAMEDepth    = 8
 	slugLength       = 6
 	tokenLength      = 32
 )
 
 type Backend struct {
-	LeaseTime time.Duration
-	Zone      string
-	ZoneID    string
-	TTL       int64
+	LeaseTime    time.Duration
+	MinLeaseTime time.Duration
+	MaxLeaseTime time.Duration
+	FrozenTime   time.Duration
+	Zone         string
+	ZoneID       string
+	NameServers  []string
+	TTL          int64
+	MinTTL       int64
+	MaxTTL       int64
+	MaxDepth     int
 
 	Svc *route53.Route53
 }
@@ -64,20 +75,68 @@ func NewBackend() (*Backend, error) {
 		return &Backend{}, errors.Wrapf(err, errParseFlag, "database_lease_time")
 	}
 
+	minLease, err := time.ParseDuration(os.Getenv("MIN_LEASE_TIME"))
+	if err != nil {
+		return &Backend{}, errors.Wrapf(err, errParseFlag, "min_lease_time")
+	}
+
+	maxLease, err := time.ParseDuration(os.Getenv("MAX_LEASE_TIME"))
+	if err != nil {
+		return &Backend{}, errors.Wrapf(err, errParseFlag, "max_lease_time")
+	}
+
+	f, err := time.ParseDuration(os.Getenv("FROZEN"))
+	if err != nil {
+		return &Backend{}, errors.Wrapf(err, errParseFlag, "frozen")
+	}
+
 	ttl, err := strconv.ParseInt(os.Getenv("TTL"), 10, 64)
 	if err != nil {
 		return &Backend{}, errors.Wrapf(err, errParseFlag, "ttl")
 	}
 
+	minTTL, err := strconv.ParseInt(os.Getenv("MIN_TTL"), 10, 64)
+	if err != nil {
+		return &Backend{}, errors.Wrapf(err, errParseFlag, "min_ttl")
+	}
+
+	maxTTL, err := strconv.ParseInt(os.Getenv("MAX_TTL"), 10, 64)
+	if err != nil {
+		return &Backend{}, errors.Wrapf(err, errParseFlag, "max_ttl")
+	}
+
+	maxDepth, err := strconv.Atoi(os.Getenv("MAX_SUBDOMAIN_DEPTH"))
+	if err != nil {
+		return &Backend{}, errors.Wrapf(err, errParseFlag, "max_subdomain_depth")
+	}
+
+	ns := make([]string, 0)
+	if z.DelegationSet != nil {
+		for _, n := range z.DelegationSet.NameServers {
+			ns = append(ns, net.JoinHostPort(aws.StringValue(n), "53"))
+		}
+	}
+
 	return &Backend{
-		LeaseTime: d,
-		Zone:      strings.TrimRight(aws.StringValue(z.HostedZone.Name), "."),
-		ZoneID:    aws.StringValue(z.HostedZone.Id),
-		Svc:       svc,
-		TTL:       ttl,
+		LeaseTime:    d,
+		MinLeaseTime: minLease,
+		MaxLeaseTime: maxLease,
+		FrozenTime:   f,
+		Zone:         strings.TrimRight(aws.StringValue(z.HostedZone.Name), "."),
+		ZoneID:       aws.StringValue(z.HostedZone.Id),
+		NameServers:  ns,
+		Svc:          svc,
+		TTL:          ttl,
+		MinTTL:       minTTL,
+		MaxTTL:       maxTTL,
+		MaxDepth:     maxDepth,
 	}, nil
 }
 
+func (b *Backend) GetNameServers() []string {
+	return b.NameServers
+}
+
 func (b *Backend) GetName() string {
 	return Name
 }
@@ -125,7 +184,7 @@ func (b *Backend) Get(opts *model.DomainOptions) (d model.Domain, err error) {
 
 		d.Fqdn = opts.Fqdn
 		d.Hosts = strings.Split(e.Content, ",")
-		d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), int(b.LeaseTime.Nanoseconds()))
+		d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), b.getTokenLeaseTime(token))
 
 		return d, nil
 	}
@@ -136,14 +195,78 @@ func (b *Backend) Get(opts *model.DomainOptions) (d model.Domain, err error) {
 	d.Fqdn = opts.Fqdn
 	d.Hosts = ca[opts.Fqdn]
 	d.SubDomain = cs
-	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), int(b.LeaseTime.Nanoseconds()))
+	if len(a) > 0 {
+		d.TTL = aws.Int64Value(a[0].TTL)
+	}
+	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), b.getTokenLeaseTime(token))
 
 	return d, nil
 }
 
+// ListSubDomains returns the sub domains of the domain from the database, which keeps their creation time.
+func (b *Backend) ListSubDomains(opts *model.DomainOptions) ([]model.SubDomain, error) {
+	emptyName := fmt.Sprintf("%s.%s", "empty", opts.Fqdn)
+
+	e, err := database.GetDatabase().QueryA(emptyName)
+	if err != nil {
+		return nil, errors.Wrapf(err, errQueryAFromDatabase, emptyName)
+	}
+
+	records, err := database.GetDatabase().ListSubA(e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	subs := make([]model.SubDomain, 0, len(records))
+	for _, r := range records {
+		hosts := make([]string, 0)
+		for _, h := range strings.Split(r.Content, ",") {
+			if h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+		createdOn := time.Unix(r.CreatedOn, 0)
+		subs = append(subs, model.SubDomain{
+			Name:      strings.Split(r.Fqdn, ".")[0],
+			Hosts:     hosts,
+			Wildcard:  len(hosts) == 0,
+			CreatedOn: &createdOn,
+		})
+	}
+
+	return subs, nil
+}
+
 func (b *Backend) Set(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("set A record for domain options: %s", opts.String())
 
+	if len(opts.Scopes) > 0 {
+		return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedScopes, Name)
+	}
+	if opts.Owner != "" {
+		return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedOwner, Name)
+	}
+	if opts.Prefix != "" {
+		return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedReservation, Name)
+	}
+
+	ttl, err := b.getTTL(opts)
+	if err != nil {
+		return d, err
+	}
+
+	if _, err := b.getLeaseTime(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkDepth(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkHosts(opts); err != nil {
+		return d, err
+	}
+
 	for i := 0; i < maxSlugHashTimes; i++ {
 		fqdn := fmt.Sprintf("%s.%s", generateSlug(), b.Zone)
 
@@ -169,7 +292,7 @@ func (b *Backend) Set(opts *model.DomainOptions) (d model.Domain, err error) {
 	}
 
 	if opts.Fqdn == "" {
-		return d, errors.Errorf(errGenerateName, opts.String())
+		return d, errors.Wrapf(model.ErrFrozen, errGenerateName, opts.String())
 	}
 
 	// save the slug name to the database in case of the name will be re-generate
@@ -230,7 +353,7 @@ func (b *Backend) Set(opts *model.DomainOptions) (d model.Domain, err error) {
 			Type:            aws.String(typeA),
 			Name:            aws.String(fmt.Sprintf("%s.%s", k, opts.Fqdn)),
 			ResourceRecords: rr,
-			TTL:             aws.Int64(int64(b.TTL)),
+			TTL:             aws.Int64(ttl),
 		}
 
 		if _, err := b.setRecord(rrs, opts, typeA, tID, pID, true); err != nil {
@@ -244,6 +367,19 @@ func (b *Backend) Set(opts *model.DomainOptions) (d model.Domain, err error) {
 func (b *Backend) Update(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("update A record for domain options: %s", opts.String())
 
+	ttl, err := b.getTTL(opts)
+	if err != nil {
+		return d, err
+	}
+
+	if err := b.checkDepth(opts); err != nil {
+		return d, err
+	}
+
+	if err := b.checkHosts(opts); err != nil {
+		return d, err
+	}
+
 	records, err := b.getRecords(opts, typeA)
 	if err != nil {
 		return d, err
@@ -251,9 +387,6 @@ func (b *Backend) Update(opts *model.DomainOptions) (d model.Domain, err error)
 
 	_, a, s, _, _ := b.filterRecords(records.ResourceRecordSets, opts, typeA)
 
-	// convert A & sub domain records to map
-	as, cs := b.convertARecords(a, s)
-
 	rr := make([]*route53.ResourceRecord, 0)
 	for _, h := range opts.Hosts {
 		rr = append(rr, &route53.ResourceRecord{
@@ -265,7 +398,7 @@ func (b *Backend) Update(opts *model.DomainOptions) (d model.Domain, err error)
 		Type:            aws.String(typeA),
 		Name:            aws.String(opts.Fqdn),
 		ResourceRecords: rr,
-		TTL:             aws.Int64(int64(b.TTL)),
+		TTL:             aws.Int64(ttl),
 	}
 
 	e, err := database.GetDatabase().QueryA(fmt.Sprintf("empty.%s", opts.Fqdn))
@@ -295,7 +428,7 @@ func (b *Backend) Update(opts *model.DomainOptions) (d model.Domain, err error)
 			Type:            aws.String(typeA),
 			Name:            aws.String(fmt.Sprintf("%s.%s", k, opts.Fqdn)),
 			ResourceRecords: rr,
-			TTL:             aws.Int64(int64(b.TTL)),
+			TTL:             aws.Int64(ttl),
 		}
 
 		if _, err := b.setRecord(rrs, opts, typeA, e.TID, e.ID, true); err != nil {
@@ -303,28 +436,9 @@ func (b *Backend) Update(opts *model.DomainOptions) (d model.Domain, err error)
 		}
 	}
 
-	// delete useless domain A records
+	// delete useless domain A records, the original record sets are used because route53 requires the same ttl to delete
 	if len(opts.Hosts) <= 0 {
-		name := opts.Fqdn
-		if _, ok := as[name]; ok {
-			rr := make([]*route53.ResourceRecord, 0)
-			for _, h := range as[name] {
-				rr = append(rr, &route53.ResourceRecord{
-					Value: aws.String(h),
-				})
-			}
-
-			rrs := &route53.ResourceRecordSet{
-				Name:            aws.String(name),
-				Type:            aws.String(typeA),
-				ResourceRecords: rr,
-				TTL:             aws.Int64(int64(b.TTL)),
-			}
-
-			if err := b.deleteRecord(rrs, opts, typeA, true); err != nil {
-				return d, err
-			}
-			rrs.Name = aws.String(fmt.Sprintf("\\052.%s", opts.Fqdn))
+		for _, rrs := range a {
 			if err := b.deleteRecord(rrs, opts, typeA, true); err != nil {
 				return d, err
 			}
@@ -332,26 +446,11 @@ func (b *Backend) Update(opts *model.DomainOptions) (d model.Domain, err error)
 	}
 
 	// delete useless sub domain A records
-	for k, v := range cs {
-		if _, ok := opts.SubDomain[k]; !ok {
-			rr := make([]*route53.ResourceRecord, 0)
-			for _, h := range v {
-				rr = append(rr, &route53.ResourceRecord{
-					Value: aws.String(h),
-				})
-			}
-
-			rrs := &route53.ResourceRecordSet{
-				Name:            aws.String(fmt.Sprintf("%s.%s", k, opts.Fqdn)),
-				Type:            aws.String(typeA),
-				ResourceRecords: rr,
-				TTL:             aws.Int64(int64(b.TTL)),
-			}
-
+	for _, rrs := range s {
+		if _, ok := opts.SubDomain[strings.Split(aws.StringValue(rrs.Name), ".")[0]]; !ok {
 			if err := b.deleteRecord(rrs, opts, typeA, true); err != nil {
 				return d, err
 			}
-			continue
 		}
 	}
 
@@ -390,7 +489,7 @@ func (b *Backend) Delete(opts *model.DomainOptions) error {
 		return errors.Wrapf(err, errDeleteAFromDatabase, emptyName)
 	}
 
-	return nil
+	return b.deleteTexts(opts.Fqdn)
 }
 
 func (b *Backend) Renew(opts *model.DomainOptions) (d model.Domain, err error) {
@@ -413,13 +512,111 @@ func (b *Backend) Renew(opts *model.DomainOptions) (d model.Domain, err error) {
 
 	return model.Domain{
 		Fqdn:       opts.Fqdn,
-		Expiration: convertExpiration(time.Unix(0, t.CreatedOn), int(b.LeaseTime.Nanoseconds())),
+		Expiration: convertExpiration(time.Unix(0, t.CreatedOn), b.getTokenLeaseTime(t)),
 	}, nil
 }
 
+func (b *Backend) Transfer(opts *model.DomainOptions) (d model.Domain, err error) {
+	logrus.Debugf("transfer token for domain options: %s", opts.String())
+
+	if opts.Owner != "" {
+		return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedOwner, Name)
+	}
+
+	t, err := database.GetDatabase().QueryToken(opts.Fqdn)
+	if err != nil {
+		return d, errors.Wrapf(err, errQueryTokenFromDatabase, opts.Fqdn)
+	}
+
+	// replace the token only, records and expiration are kept as they are
+	if err := database.GetDatabase().UpdateToken(generateToken(), t.Fqdn); err != nil {
+		return d, errors.Wrapf(err, errUpdateTokenFromDatabase, opts.Fqdn)
+	}
+
+	return model.Domain{
+		Fqdn:       opts.Fqdn,
+		Expiration: convertExpiration(time.Unix(0, t.CreatedOn), b.getTokenLeaseTime(t)),
+	}, nil
+}
+
+func (b *Backend) Release(opts *model.DomainOptions, frozen time.Duration) (d model.Domain, err error) {
+	logrus.Debugf("release records for domain options: %s", opts.String())
+
+	if frozen <= 0 {
+		frozen = b.FrozenTime
+	}
+	if frozen > b.FrozenTime {
+		return d, errors.Wrapf(model.ErrInvalidRecord, errNotValidFrozen, frozen, b.FrozenTime)
+	}
+
+	t, err := database.GetDatabase().QueryToken(opts.Fqdn)
+	if err != nil {
+		return d, errors.Wrapf(err, errQueryTokenFromDatabase, opts.Fqdn)
+	}
+
+	// freeze the slug name first, so that nobody can pick up the name even if some deletions fail,
+	// the frozen record is purged when created_on + FROZEN is reached, so move created_on back for a shorter duration
+	prefix := strings.Split(opts.Fqdn, ".")[0]
+	createdOn := time.Now().Add(frozen - b.FrozenTime)
+	if err := database.GetDatabase().UpdateFrozen(prefix, createdOn.UnixNano()); err != nil {
+		return d, errors.Wrapf(err, errUpdateFrozenFromDatabase, prefix)
+	}
+
+	d.Fqdn = opts.Fqdn
+	d.Expiration = convertExpiration(createdOn, int(b.FrozenTime.Nanoseconds()))
+
+	failed := make([]string, 0)
+
+	// delete A records & sub A records & wildcard records
+	if a, err := b.Get(opts); err == nil && a.Fqdn != "" {
+		if err := b.Delete(opts); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	// delete CNAME records
+	if c, err := b.GetCNAME(opts); err == nil && c.Fqdn != "" {
+		if err := b.DeleteCNAME(opts); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	// delete TXT records
+	ts, _ := database.GetDatabase().QueryExpiredTXTs(t.ID)
+	for _, txt := range ts {
+		if err := b.DeleteText(&model.DomainOptions{Fqdn: txt.Fqdn}); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	// delete token & referenced records, the token can not be used any more
+	if err := database.GetDatabase().DeleteToken(t.Token); err != nil {
+		failed = append(failed, err.Error())
+	}
+
+	if len(failed) > 0 {
+		return d, errors.Errorf(errPartialRelease, opts.Fqdn, strings.Join(failed, "; "))
+	}
+
+	return d, nil
+}
+
 func (b *Backend) SetCNAME(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("set CNAME record for domain options: %s", opts.String())
 
+	if err := b.checkCNAME(opts); err != nil {
+		return d, err
+	}
+
+	ttl, err := b.getTTL(opts)
+	if err != nil {
+		return d, err
+	}
+
+	if _, err := b.getLeaseTime(opts); err != nil {
+		return d, err
+	}
+
 	for i := 0; i < maxSlugHashTimes; i++ {
 		fqdn := fmt.Sprintf("%s.%s", generateSlug(), b.Zone)
 
@@ -445,7 +642,7 @@ func (b *Backend) SetCNAME(opts *model.DomainOptions) (d model.Domain, err error
 	}
 
 	if opts.Fqdn == "" {
-		return d, errors.Errorf(errGenerateName, opts.String())
+		return d, errors.Wrapf(model.ErrFrozen, errGenerateName, opts.String())
 	}
 
 	// save the slug name to the database in case of the name will be re-generate
@@ -467,7 +664,7 @@ func (b *Backend) SetCNAME(opts *model.DomainOptions) (d model.Domain, err error
 				Value: aws.String(opts.CNAME),
 			},
 		},
-		TTL: aws.Int64(int64(b.TTL)),
+		TTL: aws.Int64(ttl),
 	}
 
 	// set CNAME
@@ -505,7 +702,7 @@ func (b *Backend) GetCNAME(opts *model.DomainOptions) (d model.Domain, err error
 
 	d.Fqdn = opts.Fqdn
 	d.CNAME = aws.StringValue(c[0].ResourceRecords[0].Value)
-	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), int(b.LeaseTime.Nanoseconds()))
+	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), b.getTokenLeaseTime(token))
 
 	return d, nil
 }
@@ -513,6 +710,15 @@ func (b *Backend) GetCNAME(opts *model.DomainOptions) (d model.Domain, err error
 func (b *Backend) UpdateCNAME(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("update CNAME record for domain options: %s", opts.String())
 
+	if err := b.checkCNAME(opts); err != nil {
+		return d, err
+	}
+
+	ttl, err := b.getTTL(opts)
+	if err != nil {
+		return d, err
+	}
+
 	records, err := b.getRecords(opts, typeCNAME)
 	if err != nil {
 		return d, err
@@ -535,7 +741,7 @@ func (b *Backend) UpdateCNAME(opts *model.DomainOptions) (d model.Domain, err er
 				Value: aws.String(opts.CNAME),
 			},
 		},
-		TTL: aws.Int64(int64(b.TTL)),
+		TTL: aws.Int64(ttl),
 	}
 
 	if _, err := b.setRecord(rrs, opts, typeCNAME, r.TID, 0, false); err != nil {
@@ -556,7 +762,7 @@ func (b *Backend) UpdateCNAME(opts *model.DomainOptions) (d model.Domain, err er
 
 	d.Fqdn = opts.Fqdn
 	d.CNAME = opts.CNAME
-	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), int(b.LeaseTime.Nanoseconds()))
+	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), b.getTokenLeaseTime(token))
 
 	return d, nil
 }
@@ -580,7 +786,41 @@ func (b *Backend) DeleteCNAME(opts *model.DomainOptions) error {
 		}
 	}
 
-	return nil
+	return b.deleteTexts(opts.Fqdn)
+}
+
+// CAA and MX records are not supported, because the records of the hosted zone are tracked by the database
+// which has no table for them, so that they could not be purged.
+func (b *Backend) SetCAA(opts *model.DomainOptions) (d model.Domain, err error) {
+	return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedRecord, typeCAA, Name)
+}
+
+func (b *Backend) GetCAA(opts *model.DomainOptions) (d model.Domain, err error) {
+	return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedRecord, typeCAA, Name)
+}
+
+func (b *Backend) UpdateCAA(opts *model.DomainOptions) (d model.Domain, err error) {
+	return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedRecord, typeCAA, Name)
+}
+
+func (b *Backend) DeleteCAA(opts *model.DomainOptions) error {
+	return errors.Wrapf(model.ErrNotSupported, errNotSupportedRecord, typeCAA, Name)
+}
+
+func (b *Backend) SetMX(opts *model.DomainOptions) (d model.Domain, err error) {
+	return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedRecord, typeMX, Name)
+}
+
+func (b *Backend) GetMX(opts *model.DomainOptions) (d model.Domain, err error) {
+	return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedRecord, typeMX, Name)
+}
+
+func (b *Backend) UpdateMX(opts *model.DomainOptions) (d model.Domain, err error) {
+	return d, errors.Wrapf(model.ErrNotSupported, errNotSupportedRecord, typeMX, Name)
+}
+
+func (b *Backend) DeleteMX(opts *model.DomainOptions) error {
+	return errors.Wrapf(model.ErrNotSupported, errNotSupportedRecord, typeMX, Name)
 }
 
 func (b *Backend) GetText(opts *model.DomainOptions) (d model.Domain, err error) {
@@ -602,9 +842,15 @@ func (b *Backend) GetText(opts *model.DomainOptions) (d model.Domain, err error)
 		return d, errors.Wrapf(err, errQueryTokenFromDatabase, opts.Fqdn)
 	}
 
+	texts := make([]string, 0)
+	for _, rr := range t[0].ResourceRecords {
+		texts = append(texts, strings.Trim(aws.StringValue(rr.Value), "\""))
+	}
+
 	d.Fqdn = opts.Fqdn
-	d.Text = strings.Trim(aws.StringValue(t[0].ResourceRecords[0].Value), "\"")
-	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), int(b.LeaseTime.Nanoseconds()))
+	d.Text = texts[0]
+	d.Texts = texts
+	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), b.getTokenLeaseTime(token))
 
 	return d, nil
 }
@@ -612,13 +858,18 @@ func (b *Backend) GetText(opts *model.DomainOptions) (d model.Domain, err error)
 func (b *Backend) SetText(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("set TXT record for domain options: %s", opts.String())
 
-	records, err := b.getRecords(opts, typeTXT)
+	if err := b.checkDepth(opts); err != nil {
+		return d, err
+	}
+
+	ttl, err := b.getTTL(opts)
 	if err != nil {
 		return d, err
 	}
 
-	if valid, _, _, _, _ := b.filterRecords(records.ResourceRecordSets, opts, typeTXT); valid {
-		return d, errors.Errorf(errExistRecord, typeTXT, opts.Fqdn)
+	records, err := b.getRecords(opts, typeTXT)
+	if err != nil {
+		return d, err
 	}
 
 	r, err := database.GetDatabase().QueryToken(b.findSlugWithZone(opts.Fqdn))
@@ -626,15 +877,25 @@ func (b *Backend) SetText(opts *model.DomainOptions) (d model.Domain, err error)
 		return d, errors.Wrapf(err, errQueryTokenFromDatabase, opts.Fqdn)
 	}
 
+	value := fmt.Sprintf("\"%s\"", opts.Text)
+
+	// the value is added to the existing values, so that the concurrent challenges of the same name are kept
+	rs := make([]*route53.ResourceRecord, 0)
+	if valid, _, _, t, _ := b.filterRecords(records.ResourceRecordSets, opts, typeTXT); valid && len(t) > 0 {
+		for _, rr := range t[0].ResourceRecords {
+			if aws.StringValue(rr.Value) == value {
+				return b.GetText(opts)
+			}
+			rs = append(rs, rr)
+		}
+	}
+	rs = append(rs, &route53.ResourceRecord{Value: aws.String(value)})
+
 	rrs := &route53.ResourceRecordSet{
-		Name: aws.String(opts.Fqdn),
-		Type: aws.String(typeTXT),
-		ResourceRecords: []*route53.ResourceRecord{
-			{
-				Value: aws.String(fmt.Sprintf("\"%s\"", opts.Text)),
-			},
-		},
-		TTL: aws.Int64(int64(b.TTL)),
+		Name:            aws.String(opts.Fqdn),
+		Type:            aws.String(typeTXT),
+		ResourceRecords: rs,
+		TTL:             aws.Int64(ttl),
 	}
 
 	if _, err := b.setRecord(rrs, opts, typeTXT, r.ID, 0, false); err != nil {
@@ -647,6 +908,15 @@ func (b *Backend) SetText(opts *model.DomainOptions) (d model.Domain, err error)
 func (b *Backend) UpdateText(opts *model.DomainOptions) (d model.Domain, err error) {
 	logrus.Debugf("update TXT record for domain options: %s", opts.String())
 
+	if err := b.checkDepth(opts); err != nil {
+		return d, err
+	}
+
+	ttl, err := b.getTTL(opts)
+	if err != nil {
+		return d, err
+	}
+
 	records, err := b.getRecords(opts, typeTXT)
 	if err != nil {
 		return d, err
@@ -669,7 +939,7 @@ func (b *Backend) UpdateText(opts *model.DomainOptions) (d model.Domain, err err
 				Value: aws.String(fmt.Sprintf("\"%s\"", opts.Text)),
 			},
 		},
-		TTL: aws.Int64(int64(b.TTL)),
+		TTL: aws.Int64(ttl),
 	}
 
 	if _, err := b.setRecord(rrs, opts, typeTXT, r.TID, 0, false); err != nil {
@@ -685,7 +955,8 @@ func (b *Backend) UpdateText(opts *model.DomainOptions) (d model.Domain, err err
 	d.Fqdn = opts.Fqdn
 	d.Hosts = opts.Hosts
 	d.Text = opts.Text
-	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), int(b.LeaseTime.Nanoseconds()))
+	d.Texts = []string{opts.Text}
+	d.Expiration = convertExpiration(time.Unix(0, token.CreatedOn), b.getTokenLeaseTime(token))
 
 	return d, nil
 }
@@ -703,6 +974,39 @@ func (b *Backend) DeleteText(opts *model.DomainOptions) error {
 		return errors.Errorf(errFilterRecords, typeTXT, opts.Fqdn)
 	}
 
+	// only the given value is removed, the record is deleted when it is the last one
+	if opts.Text != "" && len(t) > 0 {
+		value := fmt.Sprintf("\"%s\"", opts.Text)
+
+		rs := make([]*route53.ResourceRecord, 0)
+		for _, rr := range t[0].ResourceRecords {
+			if aws.StringValue(rr.Value) != value {
+				rs = append(rs, rr)
+			}
+		}
+
+		if len(rs) == len(t[0].ResourceRecords) {
+			return errors.Wrapf(model.ErrNotFound, errFilterRecords, typeTXT, opts.Fqdn)
+		}
+
+		if len(rs) > 0 {
+			r, err := database.GetDatabase().QueryTXT(opts.Fqdn)
+			if err != nil {
+				return errors.Wrapf(err, errQueryTXTFromDatabase, opts.Fqdn)
+			}
+
+			rrs := &route53.ResourceRecordSet{
+				Name:            aws.String(opts.Fqdn),
+				Type:            aws.String(typeTXT),
+				ResourceRecords: rs,
+				TTL:             t[0].TTL,
+			}
+
+			_, err = b.setRecord(rrs, opts, typeTXT, r.TID, 0, false)
+			return err
+		}
+	}
+
 	for _, rr := range t {
 		if err := b.deleteRecord(rr, opts, typeTXT, false); err != nil {
 			return err
@@ -717,10 +1021,143 @@ func (b *Backend) GetToken(fqdn string) (string, error) {
 	return t.Token, err
 }
 
+// the token replaced by a transfer is not kept, it is invalid immediately
+func (b *Backend) GetPreviousToken(fqdn string) (string, error) {
+	return "", errors.Wrapf(model.ErrNotFound, errNoPreviousToken, fqdn, Name)
+}
+
+// the tokens replaced by the transfers are not kept, they are taken as unknown tokens
+func (b *Backend) GetReplacedTokens(fqdn string) ([]string, error) {
+	return nil, nil
+}
+
+// the tokens are stored in the database as plaintext, they are not hashed at rest
+func (b *Backend) RehashToken(fqdn, origin, hashed string) error {
+	return errors.Wrapf(model.ErrNotSupported, errNotSupportedHashing, Name)
+}
+
+// the token is not restricted to any record types, the scopes are not supported
+func (b *Backend) GetTokenScopes(fqdn string) ([]string, error) {
+	return nil, nil
+}
+
+// the token has no limits of its own, the limits of the deployment are used
+func (b *Backend) GetTokenLimits(fqdn string) (model.TokenLimits, error) {
+	return model.TokenLimits{}, nil
+}
+
+func (b *Backend) SetTokenLimits(fqdn string, limits model.TokenLimits) (model.TokenLimits, error) {
+	return limits, errors.Wrapf(model.ErrNotSupported, errNotSupportedLimits, Name)
+}
+
+func (b *Backend) UpsertRecords(fqdn string, batch *model.RecordsBatch) (model.Domain, error) {
+	return model.Domain{}, errors.Wrapf(model.ErrNotSupported, errNotSupportedBatch, Name)
+}
+
+// AddAHosts merges the hosts into the A records of the domain, route53 has no transaction so the last update wins.
+func (b *Backend) AddAHosts(fqdn string, hosts []string) (model.Domain, error) {
+	return b.patchHosts(fqdn, hosts, nil)
+}
+
+// RemoveAHosts removes the hosts from the A records of the domain, route53 has no transaction so the last update wins.
+func (b *Backend) RemoveAHosts(fqdn string, hosts []string) (model.Domain, error) {
+	return b.patchHosts(fqdn, nil, hosts)
+}
+
+func (b *Backend) patchHosts(fqdn string, add, remove []string) (d model.Domain, err error) {
+	domain := b.findSlugWithZone(fqdn)
+	origin, err := b.Get(&model.DomainOptions{Fqdn: domain})
+	if err != nil {
+		return d, err
+	}
+
+	opts := &model.DomainOptions{Fqdn: domain, Hosts: origin.Hosts, SubDomain: make(map[string][]string), TTL: origin.TTL}
+	for k, v := range origin.SubDomain {
+		opts.SubDomain[k] = v
+	}
+
+	name := strings.TrimSuffix(strings.TrimSuffix(fqdn, domain), ".")
+	if name == "" {
+		opts.Hosts = mergeHosts(opts.Hosts, add, remove)
+	} else if hosts := mergeHosts(opts.SubDomain[name], add, remove); len(hosts) > 0 {
+		opts.SubDomain[name] = hosts
+	} else {
+		delete(opts.SubDomain, name)
+	}
+
+	return b.Update(opts)
+}
+
+func (b *Backend) GetFrozen(prefix string) (model.Frozen, error) {
+	return model.Frozen{}, errors.Wrapf(model.ErrNotSupported, errNotSupportedFrozen, Name)
+}
+
+func (b *Backend) SetFrozen(prefix string, ttl time.Duration) (model.Frozen, error) {
+	return model.Frozen{}, errors.Wrapf(model.ErrNotSupported, errNotSupportedFrozen, Name)
+}
+
+func (b *Backend) DeleteFrozen(prefix string) error {
+	return errors.Wrapf(model.ErrNotSupported, errNotSupportedFrozen, Name)
+}
+
+// Healthy counts the tokens, which is a cheap query of the database
+func (b *Backend) GetFrozenOwner(prefix string) (string, error) {
+	return "", errors.Wrapf(model.ErrNotSupported, errNotSupportedReservation, Name)
+}
+
+func (b *Backend) ReserveFrozen(prefix string, ttl time.Duration) (model.Frozen, error) {
+	return model.Frozen{}, errors.Wrapf(model.ErrNotSupported, errNotSupportedReservation, Name)
+}
+
+func (b *Backend) RenewFrozen(prefix, owner string, ttl time.Duration) (model.Frozen, error) {
+	return model.Frozen{}, errors.Wrapf(model.ErrNotSupported, errNotSupportedReservation, Name)
+}
+
+func (b *Backend) ReleaseFrozen(prefix, owner string) error {
+	return errors.Wrapf(model.ErrNotSupported, errNotSupportedReservation, Name)
+}
+
+func (b *Backend) Healthy() error {
+	_, err := database.GetDatabase().QueryTokenCount()
+	return err
+}
+
 func (b *Backend) GetTokenCount() (int64, error) {
 	return database.GetDatabase().QueryTokenCount()
 }
 
+func (b *Backend) GetStats() (*model.Stats, error) {
+	return database.GetDatabase().QueryStats()
+}
+
+// ReserveIdempotency inserts the key only if it does not exist or is expired, returns false if it is reserved by another request.
+func (b *Backend) ReserveIdempotency(r *model.Idempotency) (bool, error) {
+	e := time.Now().Add(-model.IdempotencyExpiration)
+	return database.GetDatabase().InsertIdempotency(r, &e)
+}
+
+func (b *Backend) SetIdempotency(r *model.Idempotency) error {
+	return database.GetDatabase().UpdateIdempotency(r)
+}
+
+func (b *Backend) GetIdempotency(key string) (*model.Idempotency, error) {
+	r, err := database.GetDatabase().QueryIdempotency(key)
+	if err != nil {
+		return r, err
+	}
+
+	// the expired record may not be purged yet
+	if time.Unix(0, r.CreatedOn).Add(model.IdempotencyExpiration).Before(time.Now()) {
+		return r, sql.ErrNoRows
+	}
+
+	return r, nil
+}
+
+func (b *Backend) DeleteIdempotency(key string) error {
+	return database.GetDatabase().DeleteIdempotency(key)
+}
+
 func (b *Backend) SetToken(opts *model.DomainOptions, exist bool) (int64, error) {
 	if exist {
 		id, _, err := database.GetDatabase().RenewToken(opts.Fqdn)
@@ -730,15 +1167,28 @@ func (b *Backend) SetToken(opts *model.DomainOptions, exist bool) (int64, error)
 		return id, err
 	}
 
-	return database.GetDatabase().InsertToken(generateToken(), opts.Fqdn)
+	leaseTime, err := b.getLeaseTime(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	// the default lease time is not stored, so that the token follows the changes of it
+	if leaseTime == b.LeaseTime {
+		leaseTime = 0
+	}
+
+	return database.GetDatabase().InsertToken(generateToken(), opts.Fqdn, leaseTime)
 }
 
 func (b *Backend) MigrateFrozen(opts *model.MigrateFrozen) error {
-	return database.GetDatabase().MigrateFrozen(opts.Path, opts.Expiration.UnixNano())
+	return database.GetDatabase().MigrateFrozen(opts.Path, convertCreatedOn(opts.Expiration, b.FrozenTime))
 }
 
 func (b *Backend) MigrateToken(opts *model.MigrateToken) error {
-	return database.GetDatabase().MigrateToken(opts.Token, opts.Path, opts.Expiration.UnixNano())
+	if len(opts.Scopes) > 0 {
+		return errors.Wrapf(model.ErrNotSupported, errNotSupportedScopes, Name)
+	}
+	return database.GetDatabase().MigrateToken(opts.Token, opts.Path, convertCreatedOn(opts.Expiration, b.LeaseTime))
 }
 
 func (b *Backend) MigrateRecord(opts *model.MigrateRecord) error {
@@ -987,7 +1437,7 @@ func (b *Backend) deleteRecord(rrs *route53.ResourceRecordSet, opts *model.Domai
 						Name:            rrs.Name,
 						Type:            aws.String(rType),
 						ResourceRecords: rrs.ResourceRecords,
-						TTL:             aws.Int64(int64(b.TTL)),
+						TTL:             rrs.TTL,
 					},
 				},
 			},
@@ -1089,6 +1539,165 @@ func (b *Backend) convertARecords(a, s []*route53.ResourceRecordSet) (aOutput, s
 	return
 }
 
+// Used to delete the TXT records which are owned by the token of the domain, e.g. the acme challenges below it
+func (b *Backend) deleteTexts(fqdn string) error {
+	t, err := database.GetDatabase().QueryToken(fqdn)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, errQueryTokenFromDatabase, fqdn)
+	}
+
+	ts, err := database.GetDatabase().QueryExpiredTXTs(t.ID)
+	if err != nil {
+		return errors.Wrapf(err, errQueryTXTFromDatabase, fqdn)
+	}
+
+	deleted := make(map[string]bool, len(ts))
+	for _, r := range ts {
+		if deleted[r.Fqdn] {
+			continue
+		}
+		if err := b.DeleteText(&model.DomainOptions{Fqdn: r.Fqdn}); err != nil {
+			return err
+		}
+		deleted[r.Fqdn] = true
+	}
+
+	return nil
+}
+
+// Used to get the ttl of domain options, the default ttl is used when not set
+func (b *Backend) getTTL(opts *model.DomainOptions) (int64, error) {
+	if opts.TTL == 0 {
+		return b.TTL, nil
+	}
+	if opts.TTL < b.MinTTL || opts.TTL > b.MaxTTL {
+		return 0, errors.Wrapf(model.ErrInvalidRecord, errNotValidTTL, opts.TTL, b.MinTTL, b.MaxTTL)
+	}
+	return opts.TTL, nil
+}
+
+// Used to get the lease time of the token created with the domain options, the default lease time is used when not set
+func (b *Backend) getLeaseTime(opts *model.DomainOptions) (time.Duration, error) {
+	if opts.LeaseTime == "" {
+		return b.LeaseTime, nil
+	}
+	d, err := time.ParseDuration(opts.LeaseTime)
+	if err != nil || d < b.MinLeaseTime || d > b.MaxLeaseTime {
+		return 0, errors.Wrapf(model.ErrInvalidRecord, errNotValidLeaseTime, opts.LeaseTime, b.MinLeaseTime, b.MaxLeaseTime)
+	}
+	return d, nil
+}
+
+// Used to get the lease time of the token in nanoseconds, the token without its own lease time has the default one
+func (b *Backend) getTokenLeaseTime(t *model.Token) int {
+	if t.LeaseTime > 0 {
+		return int(t.LeaseTime)
+	}
+	return int(b.LeaseTime.Nanoseconds())
+}
+
+// Used to check the label depth below the slug name, e.g. the depth of sub1.sub2.xxxxxx.lb.rancher.cloud is 2
+func (b *Backend) checkDepth(opts *model.DomainOptions) error {
+	for k := range opts.SubDomain {
+		if len(strings.Split(k, ".")) > b.MaxDepth {
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidDepth, k, b.MaxDepth)
+		}
+	}
+	if opts.Text != "" && len(strings.Split(opts.Fqdn, "."))-len(strings.Split(b.Zone, "."))-1 > b.MaxDepth {
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidDepth, opts.Fqdn, b.MaxDepth)
+	}
+	return nil
+}
+
+// Used to check the hosts of the A records and the sub-domains, every host must be an ip address
+func (b *Backend) checkHosts(opts *model.DomainOptions) error {
+	for _, h := range opts.Hosts {
+		if net.ParseIP(h) == nil {
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidHost, h, opts.Fqdn)
+		}
+	}
+	for k, v := range opts.SubDomain {
+		for _, h := range v {
+			if net.ParseIP(h) == nil {
+				return errors.Wrapf(model.ErrInvalidRecord, errNotValidHost, h, k)
+			}
+		}
+	}
+	return nil
+}
+
+// Used to check the CNAME target, the chain of the targets in the zone must end within the maximum depth
+// and must not come back to the name, e.g. a => b => a. The wildcard CNAME of a slug name is followed as well.
+func (b *Backend) checkCNAME(opts *model.DomainOptions) error {
+	return checkCNAMEChain(opts, b.Zone, func(slug string) (string, error) {
+		r, err := database.GetDatabase().QueryCNAME(slug)
+		if err != nil {
+			return "", errors.Wrapf(err, errQueryCNAMEFromDatabase, slug)
+		}
+		return r.Content, nil
+	})
+}
+
+// Used to follow the CNAME chain of the target in the zone, the lookup returns the CNAME target of a slug name,
+// empty if the slug name has no CNAME record.
+func checkCNAMEChain(opts *model.DomainOptions, zone string, lookup func(slug string) (string, error)) error {
+	target := strings.TrimSuffix(opts.CNAME, ".")
+	if target == "" || strings.ContainsAny(target, " \t") {
+		return errors.Wrapf(model.ErrInvalidRecord, errNotValidCNAME, opts.CNAME, opts.Fqdn, "must be a domain name")
+	}
+
+	seen := make(map[string]bool)
+	if opts.Fqdn != "" {
+		seen[opts.Fqdn] = true
+	}
+	zoneLabels := len(strings.Split(zone, "."))
+	for i := 0; i < maxCNAMEDepth; i++ {
+		labels := strings.Split(target, ".")
+		if !strings.HasSuffix(target, "."+zone) || len(labels) <= zoneLabels {
+			return nil
+		}
+
+		slug := strings.Join(labels[len(labels)-zoneLabels-1:], ".")
+		if seen[slug] {
+			return errors.Wrapf(model.ErrInvalidRecord, errNotValidCNAME, opts.CNAME, opts.Fqdn, "the chain is a loop")
+		}
+		seen[slug] = true
+
+		next, err := lookup(slug)
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		target = strings.TrimSuffix(next, ".")
+	}
+
+	return errors.Wrapf(model.ErrInvalidRecord, errNotValidCNAME, opts.CNAME, opts.Fqdn, fmt.Sprintf("the chain is longer than %d", maxCNAMEDepth))
+}
+
+// Used to merge the hosts, the result keeps the order of the hosts and has no duplicates
+func mergeHosts(hosts, add, remove []string) []string {
+	removed := make(map[string]bool, len(remove))
+	for _, h := range remove {
+		removed[h] = true
+	}
+
+	result := make([]string, 0)
+	exist := make(map[string]bool, len(hosts)+len(add))
+	for _, h := range append(append([]string{}, hosts...), add...) {
+		if removed[h] || exist[h] {
+			continue
+		}
+		exist[h] = true
+		result = append(result, h)
+	}
+	return result
+}
+
 // Used to find slug name:
 //   e.g. yyyy.xxxx.qrn7oq.lb.rancher.cloud => qrn7oq.lb.rancher.cloud
 func (b *Backend) findSlugWithZone(fqdn string) string {
@@ -1110,6 +1719,15 @@ func generateToken() string {
 	return util.RandStringWithAll(tokenLength)
 }
 
+// Used to convert the expiration of a migrated record to the created time which is stored in the database,
+// the records expire when the created time is older than the ttl. The record without expiration gets the full ttl.
+func convertCreatedOn(expiration *time.Time, ttl time.Duration) int64 {
+	if expiration == nil {
+		return time.Now().UnixNano()
+	}
+	return expiration.Add(-ttl).UnixNano()
+}
+
 // Used to convert expiration
 func convertExpiration(create time.Time, ttl int) *time.Time {
 	duration, _ := time.ParseDuration(fmt.Sprintf("%dns", ttl))
@@ -0,0 +1,85 @@
+package route53
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/rdns-server/model"
+
+	"github.com/pkg/errors"
+)
+
+const testZone = "lb.rancher.cloud"
+
+func TestCheckCNAMEChain(t *testing.T) {
+	cnames := map[string]string{
+		"aaaaaa." + testZone: "bbbbbb." + testZone,
+		"bbbbbb." + testZone: "aaaaaa." + testZone + ".",
+		"cccccc." + testZone: "www.example.com",
+		"self00." + testZone: "x.self00." + testZone,
+	}
+	// a chain of 10 slug names which ends out of the zone
+	for i := 0; i < 10; i++ {
+		cnames[string(rune('a'+i))+"chain."+testZone] = string(rune('a'+i+1)) + "chain." + testZone
+	}
+	lookup := func(slug string) (string, error) {
+		return cnames[slug], nil
+	}
+
+	tests := []struct {
+		fqdn  string
+		cname string
+		valid bool
+	}{
+		{"", "www.example.com", true},
+		{"", "cccccc." + testZone, true},
+		{"", "nocname." + testZone, true},
+		{"", testZone, true},
+		{"dddddd." + testZone, "dddddd." + testZone, false},
+		{"dddddd." + testZone, "sub.dddddd." + testZone, false},
+		{"", "aaaaaa." + testZone, false},
+		{"bbbbbb." + testZone, "aaaaaa." + testZone, false},
+		{"", "self00." + testZone, false},
+		{"", "achain." + testZone, false},
+		{"", "hchain." + testZone, true},
+		{"", "", false},
+		{"", "bad name." + testZone, false},
+	}
+	for _, test := range tests {
+		err := checkCNAMEChain(&model.DomainOptions{Fqdn: test.fqdn, CNAME: test.cname}, testZone, lookup)
+		if test.valid && err != nil {
+			t.Errorf("%s => %s: expected valid, got %v", test.fqdn, test.cname, err)
+		}
+		if !test.valid && errors.Cause(err) != model.ErrInvalidRecord {
+			t.Errorf("%s => %s: expected an invalid record, got %v", test.fqdn, test.cname, err)
+		}
+	}
+
+	lookupErr := errors.New("database is down")
+	err := checkCNAMEChain(&model.DomainOptions{CNAME: "aaaaaa." + testZone}, testZone, func(string) (string, error) {
+		return "", lookupErr
+	})
+	if err != lookupErr {
+		t.Errorf("expected the lookup error, got %v", err)
+	}
+}
+
+func TestConvertCreatedOn(t *testing.T) {
+	ttl := 240 * time.Hour
+	future := time.Now().Add(2 * time.Hour)
+	past := time.Now().Add(-2 * time.Hour)
+
+	// the expiration which is reported for the migrated record is the one it is migrated with
+	for _, expiration := range []time.Time{future, past} {
+		created := convertCreatedOn(&expiration, ttl)
+		if got := convertExpiration(time.Unix(0, created), int(ttl.Nanoseconds())); !got.Equal(expiration) {
+			t.Errorf("expected the expiration %s, got %s", expiration, got)
+		}
+	}
+
+	// the record without expiration gets the full ttl
+	created := time.Unix(0, convertCreatedOn(nil, ttl))
+	if d := time.Since(created); d < 0 || d > time.Minute {
+		t.Errorf("expected the record without expiration created now, got %s", created)
+	}
+}
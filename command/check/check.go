@@ -0,0 +1,87 @@
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const probeName = "rdns-check-probe"
+
+var Flag = cli.BoolFlag{
+	Name:  "json",
+	Usage: "used to print the check report as json.",
+}
+
+type Result struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// ProbeName returns the name of the entry which is written and deleted to verify write access.
+func ProbeName() string {
+	return probeName
+}
+
+// Add records the result of a check, the check is failed when err is not nil.
+func (r *Report) Add(name string, err error) {
+	result := Result{
+		Name:   name,
+		Passed: err == nil,
+	}
+	if err != nil {
+		result.Message = err.Error()
+	}
+	r.Results = append(r.Results, result)
+}
+
+// AddWithMessage records the result of a check with the message of the passed check, e.g. what is found.
+func (r *Report) AddWithMessage(name, message string, err error) {
+	r.Add(name, err)
+	if err == nil {
+		r.Results[len(r.Results)-1].Message = message
+	}
+}
+
+// Print writes the report and returns an error if any check is failed, so that the command exits with non-zero.
+func (r *Report) Print(w io.Writer, asJSON bool) error {
+	failed := 0
+	for _, result := range r.Results {
+		if !result.Passed {
+			failed++
+		}
+	}
+
+	if asJSON {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, string(b)); err != nil {
+			return err
+		}
+	} else {
+		for _, result := range r.Results {
+			status := "OK"
+			if !result.Passed {
+				status = "FAILED"
+			}
+			if _, err := fmt.Fprintf(w, "[%s] %s %s\n", status, result.Name, result.Message); err != nil {
+				return err
+			}
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d of %d checks failed", failed, len(r.Results))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package check
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestReportPrint(t *testing.T) {
+	r := &Report{}
+	r.Add("connect", nil)
+	r.AddWithMessage("decode records", "A=2 TXT=1", nil)
+
+	var buf bytes.Buffer
+	if err := r.Print(&buf, false); err != nil {
+		t.Fatalf("expected the passed checks, got %v", err)
+	}
+	if want := "[OK] connect \n[OK] decode records A=2 TXT=1\n"; buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+
+	// the message of a failed check is the error
+	r.AddWithMessage("migrations", "schema version 1, 0 pending", errors.New("boom"))
+
+	buf.Reset()
+	if err := r.Print(&buf, true); err == nil || err.Error() != "1 of 3 checks failed" {
+		t.Errorf("expected 1 failed check, got %v", err)
+	}
+	var printed Report
+	if err := json.Unmarshal(buf.Bytes(), &printed); err != nil {
+		t.Fatalf("not valid json %q: %v", buf.String(), err)
+	}
+	if last := printed.Results[2]; last.Passed || last.Message != "boom" {
+		t.Errorf("expected the failed check with its error, got %+v", last)
+	}
+
+	buf.Reset()
+	r.Print(&buf, false)
+	if !strings.Contains(buf.String(), "[FAILED] migrations boom") {
+		t.Errorf("expected the failed check, got %q", buf.String())
+	}
+}
@@ -1,36 +1,63 @@
 package etcdv3
 
 import (
-	"net/http"
+	"context"
+	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/rancher/rdns-server/backend"
 	"github.com/rancher/rdns-server/backend/etcdv3"
+	"github.com/rancher/rdns-server/command/check"
 	"github.com/rancher/rdns-server/coredns"
+	"github.com/rancher/rdns-server/event"
+	"github.com/rancher/rdns-server/health"
+	"github.com/rancher/rdns-server/loglevel"
 	"github.com/rancher/rdns-server/metric"
+	"github.com/rancher/rdns-server/migration"
 	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/readonly"
 	"github.com/rancher/rdns-server/service"
+	"github.com/rancher/rdns-server/util"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
+// the number of the values of each record type which are decoded by the check
+const checkSampleSize = 100
+
 var (
 	flags = map[string]map[string]string{
-		"DOMAIN":           {"used to set etcd root domain.": "lb.rancher.cloud"},
-		"ETCD_ENDPOINTS":   {"used to set etcd endpoints.": "http://127.0.0.1:2379"},
-		"ETCD_PREFIX_PATH": {"used to set etcd prefix path.": "/rdnsv3"},
-		"ETCD_LEASE_TIME":  {"used to set etcd lease time.": "240h"},
-		"CORE_DNS_FILE":    {"used to set coredns file.": "/etc/rdns/config/Corefile"},
-		"CORE_DNS_PORT":    {"used to set coredns port.": "53"},
-		"CORE_DNS_CPU":     {"used to set coredns cpu, a number (e.g. 3) or a percent (e.g. 50%).": "50%"},
-		"CORE_DNS_DB_FILE": {"used to set coredns file plugin db's file name (e.g. /etc/rdns/config/dbfile).": ""},
-		"CORE_DNS_DB_ZONE": {"used to set coredns file plugin db's zone (e.g. api.lb.rancher.cloud).": ""},
-		"TTL":              {"used to set coredns ttl.": "60"},
+		"DOMAIN":               {"used to set etcd root domain.": "lb.rancher.cloud"},
+		"ETCD_ENDPOINTS":       {"used to set etcd endpoints.": "http://127.0.0.1:2379"},
+		"ETCD_PREFIX_PATH":     {"used to set etcd prefix path.": "/rdnsv3"},
+		"ETCD_LEASE_TIME":      {"used to set etcd lease time.": "240h"},
+		"MIN_LEASE_TIME":       {"used to set the minimum lease time which can be set to a token on creation.": "1h"},
+		"MAX_LEASE_TIME":       {"used to set the maximum lease time which can be set to a token on creation.": "240h"},
+		"CORE_DNS_FILE":        {"used to set coredns file.": "/etc/rdns/config/Corefile"},
+		"CORE_DNS_PORT":        {"used to set coredns port.": "53"},
+		"CORE_DNS_CPU":         {"used to set coredns cpu, a number (e.g. 3) or a percent (e.g. 50%).": "50%"},
+		"CORE_DNS_DB_FILE":     {"used to set coredns file plugin db's file name (e.g. /etc/rdns/config/dbfile).": ""},
+		"CORE_DNS_DB_ZONE":     {"used to set coredns file plugin db's zone (e.g. api.lb.rancher.cloud).": ""},
+		"CORE_DNS_SOA":         {"used to set the soa of the zone apex, mname rname [refresh retry expire minimum] (e.g. ns1.lb.rancher.cloud hostmaster.lb.rancher.cloud).": ""},
+		"CORE_DNS_NS":          {"used to set the comma separated ns names of the zone apex (e.g. ns1.lb.rancher.cloud,ns2.lb.rancher.cloud).": ""},
+		"CORE_DNS_DNSSEC_KEYS": {"used to set the comma separated key files which sign the zone, without the .key/.private suffix (e.g. /etc/rdns/keys/Klb.rancher.cloud.+013+12345).": ""},
+		"TTL":                  {"used to set coredns ttl.": "60"},
+		"SKIP_MIGRATIONS":      {"used to skip the startup migrations.": "false"},
+		"MIN_TTL":              {"used to set the minimum ttl which can be set to a domain.": "10"},
+		"MAX_TTL":              {"used to set the maximum ttl which can be set to a domain.": "3600"},
+		"MAX_SUBDOMAIN_DEPTH":  {"used to set the maximum label depth below a domain.": "2"},
+		"MAX_SUBDOMAINS":       {"used to set the maximum number of the sub-domains of a domain, 0 means no limit.": "100"},
+		"MAX_TEXTS":            {"used to set the maximum number of the TXT values of a name, 0 means no limit.": "20"},
+		"MAX_RECORDS":          {"used to set the maximum number of the A, sub A and TXT records of a token, 0 means no limit.": "1000"},
+		"TOKEN_GRACE_PERIOD":   {"used to set the duration when the token replaced by a transfer is still valid for read-only and renew requests.": "10m"},
+		"TOKEN_SECRET":         {"used to set the server secret which the tokens are hashed at rest with, the legacy plaintext tokens are hashed on their first use, empty stores the tokens as plaintext.": ""},
 	}
 )
 
@@ -66,30 +93,218 @@ func Action(c *cli.Context) error {
 		}
 	}()
 
+	registerMigrations(b)
+
+	if os.Getenv("SKIP_MIGRATIONS") != "true" {
+		if err := migration.Run(b); err != nil {
+			return err
+		}
+	}
+
 	if err := generateCoreFile(); err != nil {
 		return err
 	}
 
+	debugDuration, err := time.ParseDuration(c.GlobalString("debug_duration"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse debug duration")
+	}
+
+	probeInterval, err := time.ParseDuration(c.GlobalString("probe_interval"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse probe interval")
+	}
+
+	rateLimit, err := strconv.ParseFloat(c.GlobalString("rate_limit"), 64)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse rate limit")
+	}
+	rateBurst, err := strconv.Atoi(c.GlobalString("rate_burst"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse rate burst")
+	}
+	service.SetRateLimit(rateLimit, rateBurst)
+
+	service.SetAdminToken(c.GlobalString("admin_token"))
+
+	service.SetGRPC(c.GlobalString("grpc_listen"), c.GlobalString("grpc_tls_cert"), c.GlobalString("grpc_tls_key"), c.GlobalString("grpc_tls_ca"))
+
+	readonly.Set(c.GlobalBool("read_only"))
+
+	service.SetLegacyErrors(c.GlobalBool("legacy_errors"))
+
+	service.SetTokenSecret(os.Getenv("TOKEN_SECRET"))
+
+	windows, err := util.ParseReuseWindows(c.GlobalString("reuse_windows"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse reuse windows")
+	}
+	util.SetReuseWindows(windows)
+
+	shutdownTimeout, err := time.ParseDuration(c.GlobalString("shutdown_timeout"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse shutdown timeout")
+	}
+
 	done := make(chan struct{})
 
 	go metric.StartMetricDaemon(done)
 
+	go metric.StartStatsDaemon(done)
+
+	go loglevel.StartSignalDaemon(done, debugDuration)
+
+	go health.StartHealthDaemon(done, probeInterval)
+
+	if path := c.GlobalString("audit_log"); path != "" {
+		sink, err := event.NewAuditSink(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open audit log")
+		}
+		go event.StartSinkDaemon(done, sink)
+	}
+
+	if url := c.GlobalString("webhook_url"); url != "" {
+		attempts, err := strconv.Atoi(c.GlobalString("webhook_attempts"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse webhook attempts")
+		}
+		sink := event.NewWebhookSink(url, c.GlobalString("webhook_secret"), strings.Split(c.GlobalString("webhook_types"), ","), attempts)
+		go event.StartSinkDaemon(done, sink)
+	}
+
 	go coredns.StartCoreDNSDaemon()
 
-	go func() {
-		if err := http.ListenAndServe(c.GlobalString("listen"), service.NewRouter()); err != nil {
-			logrus.Error(err)
-			done <- struct{}{}
+	err = service.Serve(c.GlobalString("listen"), shutdownTimeout)
+
+	// the daemons are stopped before the deferred close of the backend
+	coredns.StopCoreDNS()
+
+	close(done)
+	return err
+}
+
+func CheckFlags() []cli.Flag {
+	return append(Flags(), check.Flag)
+}
+
+func CheckAction(c *cli.Context) error {
+	if err := setEnvironments(c); err != nil {
+		return errors.Wrapf(err, "failed to set environments")
+	}
+
+	r := &check.Report{}
+
+	b, err := setBackend()
+	r.Add("connect etcd", err)
+	if err != nil {
+		return r.Print(c.App.Writer, c.Bool("json"))
+	}
+	defer b.C.Close()
+
+	_, err = b.GetTokenCount()
+	r.Add("read tokens", err)
+
+	_, err = b.GetStats()
+	r.Add("read records", err)
+
+	counts, err := b.CheckRecords(checkSampleSize)
+	r.AddWithMessage("decode records", formatCounts(counts), err)
+
+	// the pending migrations are applied on startup, they do not fail the check
+	version, ms, err := getMigrationStatus(b)
+	r.AddWithMessage("migrations", fmt.Sprintf("schema version %d, %d pending", version, countPending(ms)), err)
+
+	// write a probe entry and delete it to verify the write access
+	path := fmt.Sprintf("%s/%s", b.Prefix, check.ProbeName())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = b.C.Put(ctx, path, ""); err == nil {
+		_, err = b.C.Delete(ctx, path)
+	}
+	r.Add("write probe", err)
+
+	return r.Print(c.App.Writer, c.Bool("json"))
+}
+
+func MigrationStatusAction(c *cli.Context) error {
+	if err := setEnvironments(c); err != nil {
+		return errors.Wrapf(err, "failed to set environments")
+	}
+
+	b, err := setBackend()
+	if err != nil {
+		return err
+	}
+	defer b.C.Close()
+
+	_, ms, err := getMigrationStatus(b)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		status := "pending"
+		if m.Applied {
+			status = "applied"
 		}
-	}()
+		if _, err := fmt.Fprintf(c.App.Writer, "%d\t%s\t%s\n", m.Version, m.Name, status); err != nil {
+			return err
+		}
+	}
 
-	<-done
 	return nil
 }
 
+// Used to get the schema version of the stored data, which is the version of the latest applied migration
+func getMigrationStatus(b *etcdv3.Backend) (int64, []migration.Status, error) {
+	registerMigrations(b)
+
+	ms, err := migration.List(b)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	version, err := b.GetMigrationVersion()
+	return version, ms, err
+}
+
+func countPending(ms []migration.Status) int {
+	pending := 0
+	for _, m := range ms {
+		if !m.Applied {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Used to format the numbers of the decoded records, e.g. A=10 TXT=2
+func formatCounts(counts map[string]int64) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ss := make([]string, 0, len(keys))
+	for _, k := range keys {
+		ss = append(ss, fmt.Sprintf("%s=%d", k, counts[k]))
+	}
+	return strings.Join(ss, " ")
+}
+
+func registerMigrations(b *etcdv3.Backend) {
+	migration.Register(migration.Migration{
+		Version: 1,
+		Name:    "write reverse records of the existing A records",
+		Up:      b.MigrateReverse,
+	})
+}
+
 func setEnvironments(c *cli.Context) error {
 	if c.GlobalBool("debug") {
-		logrus.SetLevel(logrus.DebugLevel)
+		loglevel.SetBase(logrus.DebugLevel)
 	}
 
 	for k := range flags {
@@ -97,7 +312,7 @@ func setEnvironments(c *cli.Context) error {
 			return err
 		}
 		if os.Getenv(k) == "" {
-			if k == "CORE_DNS_DB_FILE" || k == "CORE_DNS_DB_ZONE" {
+			if k == "CORE_DNS_DB_FILE" || k == "CORE_DNS_DB_ZONE" || k == "CORE_DNS_SOA" || k == "CORE_DNS_NS" || k == "CORE_DNS_DNSSEC_KEYS" || k == "TOKEN_SECRET" {
 				continue
 			}
 			return errors.Errorf("expected argument: %s", strings.ToLower(k))
@@ -133,6 +348,9 @@ func generateCoreFile() error {
 			EtcdEndpoints:  strings.Join(strings.Split(os.Getenv("ETCD_ENDPOINTS"), ","), " "),
 			TTL:            os.Getenv("TTL"),
 			WildCardBound:  strconv.Itoa(len(strings.Split(strings.TrimRight(os.Getenv("DOMAIN"), "."), ".")) + 1),
+			SOA:            os.Getenv("CORE_DNS_SOA"),
+			NS:             strings.Join(strings.Split(os.Getenv("CORE_DNS_NS"), ","), " "),
+			DNSSECKeys:     strings.Join(strings.Split(os.Getenv("CORE_DNS_DNSSEC_KEYS"), ","), " "),
 		}
 		p := template.Must(template.New("corefile-tmpl").Parse(model.CoreFileTmpl))
 		f, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE, os.ModePerm)
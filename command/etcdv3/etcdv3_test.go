@@ -0,0 +1,43 @@
+package etcdv3
+
+import (
+	"testing"
+
+	"github.com/rancher/rdns-server/backend/etcdv3"
+	"github.com/rancher/rdns-server/util/etcdtest"
+)
+
+func TestGetMigrationStatus(t *testing.T) {
+	client, _ := etcdtest.NewClient()
+	b := &etcdv3.Backend{Domain: "lb.rancher.cloud", Prefix: "/rdnsv3", C: client}
+
+	version, ms, err := getMigrationStatus(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 || len(ms) != 1 || countPending(ms) != 1 {
+		t.Errorf("expected schema version 0 with 1 pending migration, got %d %+v", version, ms)
+	}
+
+	if err := b.SetMigrationVersion(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the migrations are registered once however many times the status is read
+	version, ms, err = getMigrationStatus(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || len(ms) != 1 || countPending(ms) != 0 {
+		t.Errorf("expected schema version 1 without pending migrations, got %d %+v", version, ms)
+	}
+}
+
+func TestFormatCounts(t *testing.T) {
+	if s := formatCounts(map[string]int64{"TXT": 1, "A": 10}); s != "A=10 TXT=1" {
+		t.Errorf("expected the sorted counts, got %q", s)
+	}
+	if s := formatCounts(nil); s != "" {
+		t.Errorf("expected no counts, got %q", s)
+	}
+}
@@ -1,17 +1,25 @@
 package route53
 
 import (
-	"net/http"
+	"database/sql"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rancher/rdns-server/backend"
 	"github.com/rancher/rdns-server/backend/route53"
+	"github.com/rancher/rdns-server/command/check"
 	"github.com/rancher/rdns-server/database"
 	"github.com/rancher/rdns-server/database/mysql"
+	"github.com/rancher/rdns-server/event"
+	"github.com/rancher/rdns-server/health"
+	"github.com/rancher/rdns-server/loglevel"
 	"github.com/rancher/rdns-server/metric"
 	"github.com/rancher/rdns-server/purge"
+	"github.com/rancher/rdns-server/readonly"
 	"github.com/rancher/rdns-server/service"
+	"github.com/rancher/rdns-server/util"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -25,8 +33,16 @@ var (
 		"AWS_SECRET_ACCESS_KEY": {"used to set aws secret access key.": ""},
 		"DATABASE":              {"used to set database driver.": "mysql"},
 		"DATABASE_LEASE_TIME":   {"used to set database lease time.": "240h"},
+		"MIN_LEASE_TIME":        {"used to set the minimum lease time which can be set to a token on creation.": "1h"},
+		"MAX_LEASE_TIME":        {"used to set the maximum lease time which can be set to a token on creation.": "240h"},
 		"DSN":                   {"used to set database dsn.": ""},
 		"TTL":                   {"used to set route53 ttl.": "10"},
+		"MIN_TTL":               {"used to set the minimum ttl which can be set to a domain.": "10"},
+		"MAX_TTL":               {"used to set the maximum ttl which can be set to a domain.": "3600"},
+		"MAX_SUBDOMAIN_DEPTH":   {"used to set the maximum label depth below a domain.": "2"},
+		"PURGE_INTERVAL":        {"used to set the interval of purging the expired records.": "10m"},
+		"PURGE_JITTER":          {"used to set the random jitter factor of the purge interval, e.g. 0.1 adds up to 10% of it.": "0.1"},
+		"PURGE_DRY_RUN":         {"used to only log the expired records instead of purging them.": "false"},
 	}
 )
 
@@ -61,26 +77,133 @@ func Action(c *cli.Context) error {
 		return err
 	}
 
+	debugDuration, err := time.ParseDuration(c.GlobalString("debug_duration"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse debug duration")
+	}
+
+	probeInterval, err := time.ParseDuration(c.GlobalString("probe_interval"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse probe interval")
+	}
+
+	rateLimit, err := strconv.ParseFloat(c.GlobalString("rate_limit"), 64)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse rate limit")
+	}
+	rateBurst, err := strconv.Atoi(c.GlobalString("rate_burst"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse rate burst")
+	}
+	service.SetRateLimit(rateLimit, rateBurst)
+
+	service.SetAdminToken(c.GlobalString("admin_token"))
+
+	service.SetGRPC(c.GlobalString("grpc_listen"), c.GlobalString("grpc_tls_cert"), c.GlobalString("grpc_tls_key"), c.GlobalString("grpc_tls_ca"))
+
+	readonly.Set(c.GlobalBool("read_only"))
+
+	service.SetLegacyErrors(c.GlobalBool("legacy_errors"))
+
+	windows, err := util.ParseReuseWindows(c.GlobalString("reuse_windows"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse reuse windows")
+	}
+	util.SetReuseWindows(windows)
+
+	shutdownTimeout, err := time.ParseDuration(c.GlobalString("shutdown_timeout"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse shutdown timeout")
+	}
+
 	done := make(chan struct{})
 
 	go metric.StartMetricDaemon(done)
 
-	go purge.StartPurgerDaemon(done)
+	go metric.StartStatsDaemon(done)
+
+	go loglevel.StartSignalDaemon(done, debugDuration)
 
-	go func() {
-		if err := http.ListenAndServe(c.GlobalString("listen"), service.NewRouter()); err != nil {
-			logrus.Error(err)
-			done <- struct{}{}
+	go health.StartHealthDaemon(done, probeInterval)
+
+	if path := c.GlobalString("audit_log"); path != "" {
+		sink, err := event.NewAuditSink(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open audit log")
 		}
-	}()
+		go event.StartSinkDaemon(done, sink)
+	}
 
-	<-done
-	return nil
+	if url := c.GlobalString("webhook_url"); url != "" {
+		attempts, err := strconv.Atoi(c.GlobalString("webhook_attempts"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse webhook attempts")
+		}
+		sink := event.NewWebhookSink(url, c.GlobalString("webhook_secret"), strings.Split(c.GlobalString("webhook_types"), ","), attempts)
+		go event.StartSinkDaemon(done, sink)
+	}
+
+	purgeInterval, err := time.ParseDuration(os.Getenv("PURGE_INTERVAL"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse purge interval")
+	}
+	purgeJitter, err := strconv.ParseFloat(os.Getenv("PURGE_JITTER"), 64)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse purge jitter")
+	}
+	go purge.StartPurgerDaemon(done, purgeInterval, purgeJitter, os.Getenv("PURGE_DRY_RUN") == "true")
+
+	err = service.Serve(c.GlobalString("listen"), shutdownTimeout)
+
+	// the daemons are stopped before the deferred close of the backend
+	close(done)
+	return err
+}
+
+func CheckFlags() []cli.Flag {
+	return append(Flags(), check.Flag)
+}
+
+func CheckAction(c *cli.Context) error {
+	if err := setEnvironments(c); err != nil {
+		return errors.Wrapf(err, "failed to set environments")
+	}
+
+	r := &check.Report{}
+
+	d, err := setDatabase(c)
+	r.Add("connect database", err)
+	if err != nil {
+		return r.Print(c.App.Writer, c.Bool("json"))
+	}
+	defer d.Close()
+
+	r.Add("connect route53", setBackend())
+
+	_, err = d.QueryTokenCount()
+	r.Add("read tokens", err)
+
+	_, err = d.QueryStats()
+	r.Add("read records", err)
+
+	// the idempotency table is added by the second migration
+	if _, err = d.QueryIdempotency(check.ProbeName()); err == sql.ErrNoRows {
+		err = nil
+	}
+	r.Add("read idempotency", err)
+
+	// write a probe entry and delete it to verify the write access
+	if err = d.InsertFrozen(check.ProbeName()); err == nil {
+		err = d.DeleteFrozen(check.ProbeName())
+	}
+	r.Add("write probe", err)
+
+	return r.Print(c.App.Writer, c.Bool("json"))
 }
 
 func setEnvironments(c *cli.Context) error {
 	if c.GlobalBool("debug") {
-		logrus.SetLevel(logrus.DebugLevel)
+		loglevel.SetBase(logrus.DebugLevel)
 	}
 
 	for k := range flags {
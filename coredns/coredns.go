@@ -52,8 +52,6 @@ func StartCoreDNSDaemon() {
 		Action:     rdns.Setup,
 	})
 
-	caddy.TrapSignals()
-
 	if err := setCPU(cpu); err != nil {
 		logrus.Fatal(err)
 	}
@@ -68,9 +66,19 @@ func StartCoreDNSDaemon() {
 		logrus.Fatal(err)
 	}
 
+	trapSignals(instance)
+
 	instance.Wait()
 }
 
+// StopCoreDNS stops the running instances and executes the shutdown callbacks of the plugins,
+// SIGTERM and SIGINT are not trapped by caddy as they are handled by the api server.
+func StopCoreDNS() {
+	if err := caddy.Stop(); err != nil {
+		logrus.Errorf("failed to stop coredns: %v", err)
+	}
+}
+
 func confLoader(serverType string) (caddy.Input, error) {
 	if conf == "" {
 		return nil, nil
@@ -32,6 +32,12 @@ type ServiceBackend interface {
 	Transferer
 }
 
+// Authority defines an interface for backends that configure the SOA record of the zone apex themselves.
+type Authority interface {
+	// SOA overrides the fields of the SOA record of the zone which are configured.
+	SOA(zone string, soa *dns.SOA)
+}
+
 // Transferer defines an interface for backends that provide AXFR of all records.
 type Transferer interface {
 	// Serial returns a SOA serial number to construct a SOA record.
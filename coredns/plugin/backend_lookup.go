@@ -338,6 +338,19 @@ func TXT(ctx context.Context, b ServiceBackend, zone string, state request.Reque
 	return records, nil
 }
 
+// CAA returns CAA records from Backend or an error.
+func CAA(ctx context.Context, b ServiceBackend, zone string, state request.Request, opt Options) (records []dns.RR, err error) {
+	services, err := b.Services(ctx, state, false, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, serv := range services {
+		records = append(records, serv.NewCAA(state.QName()))
+	}
+	return records, nil
+}
+
 // PTR returns the PTR records from the backend, only services that have a domain name as host are included.
 func PTR(ctx context.Context, b ServiceBackend, zone string, state request.Request, opt Options) (records []dns.RR, err error) {
 	services, err := b.Reverse(ctx, state, true, opt)
@@ -414,6 +427,9 @@ func SOA(ctx context.Context, b ServiceBackend, zone string, state request.Reque
 		Expire:  86400,
 		Minttl:  minTTL,
 	}
+	if a, ok := b.(Authority); ok {
+		a.SOA(zone, soa)
+	}
 	return []dns.RR{soa}, nil
 }
 
@@ -0,0 +1,154 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	etcdcv3 "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+const cacheRetry = 5 * time.Second
+
+// recordCache keeps all kvs under the path prefix in memory and follows the changes with an etcd watch.
+// The kvs are served only while the watch is healthy, otherwise the lookups fall back to etcd.
+type recordCache struct {
+	sync.RWMutex
+	kvs      map[string]*mvccpb.KeyValue
+	keys     []string // sorted, so that the prefix lookups return the kvs in the same order as etcd
+	revision int64
+	synced   bool
+}
+
+func newRecordCache() *recordCache {
+	return &recordCache{kvs: make(map[string]*mvccpb.KeyValue)}
+}
+
+// get returns the kvs as ETCD.get does, ok is false when the cache is not synced.
+func (c *recordCache) get(path string, recursive bool) (r *etcdcv3.GetResponse, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if !c.synced {
+		return nil, false
+	}
+
+	if recursive {
+		if !strings.HasSuffix(path, "/") {
+			path = path + "/"
+		}
+		if kvs := c.prefix(path); len(kvs) > 0 {
+			return &etcdcv3.GetResponse{Kvs: kvs, Count: int64(len(kvs))}, true
+		}
+		path = strings.TrimSuffix(path, "/")
+	}
+
+	if kv, ok := c.kvs[path]; ok {
+		return &etcdcv3.GetResponse{Kvs: []*mvccpb.KeyValue{kv}, Count: 1}, true
+	}
+	return &etcdcv3.GetResponse{}, true
+}
+
+func (c *recordCache) prefix(path string) []*mvccpb.KeyValue {
+	kvs := make([]*mvccpb.KeyValue, 0)
+	for i := sort.SearchStrings(c.keys, path); i < len(c.keys) && strings.HasPrefix(c.keys[i], path); i++ {
+		kvs = append(kvs, c.kvs[c.keys[i]])
+	}
+	return kvs
+}
+
+func (c *recordCache) load(kvs []*mvccpb.KeyValue, revision int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.kvs = make(map[string]*mvccpb.KeyValue, len(kvs))
+	c.keys = make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		c.kvs[string(kv.Key)] = kv
+		c.keys = append(c.keys, string(kv.Key))
+	}
+	sort.Strings(c.keys)
+	c.revision = revision
+	c.synced = true
+}
+
+func (c *recordCache) apply(events []*etcdcv3.Event, revision int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, ev := range events {
+		key := string(ev.Kv.Key)
+		i := sort.SearchStrings(c.keys, key)
+		exist := i < len(c.keys) && c.keys[i] == key
+
+		switch ev.Type {
+		case mvccpb.PUT:
+			if !exist {
+				c.keys = append(c.keys, "")
+				copy(c.keys[i+1:], c.keys[i:])
+				c.keys[i] = key
+			}
+			c.kvs[key] = ev.Kv
+		case mvccpb.DELETE:
+			if exist {
+				c.keys = append(c.keys[:i], c.keys[i+1:]...)
+			}
+			delete(c.kvs, key)
+		}
+	}
+	c.revision = revision
+}
+
+func (c *recordCache) unsync() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.synced = false
+}
+
+// watchRecords loads the path prefix and follows it until ctx is done. The watch starts from the revision of the load,
+// so that no change is missed in between, and the cache is reloaded after the watch is broken.
+func (e *ETCD) watchRecords(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := e.syncCache(ctx); err != nil && ctx.Err() == nil {
+			log.Warningf("records cache is out of sync, lookups fall back to etcd: %v", err)
+		}
+		e.cache.unsync()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(cacheRetry):
+		}
+	}
+}
+
+func (e *ETCD) syncCache(ctx context.Context) error {
+	gctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	r, err := e.Client.Get(gctx, e.PathPrefix, etcdcv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return err
+	}
+	e.cache.load(r.Kvs, r.Header.Revision)
+
+	// the watch is closed when the member loses the leader, instead of lagging silently
+	wch := e.Client.Watch(etcdcv3.WithRequireLeader(ctx), e.PathPrefix, etcdcv3.WithPrefix(), etcdcv3.WithRev(r.Header.Revision+1))
+	for resp := range wch {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		e.cache.apply(resp.Events, resp.Header.Revision)
+		if e.negative != nil {
+			e.negative.purge()
+		}
+		if len(e.Secondaries) > 0 {
+			go e.notify()
+		}
+	}
+
+	return errors.New("watch is closed")
+}
@@ -0,0 +1,124 @@
+package rdns
+
+import (
+	"context"
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/dnssec"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// newTestKey writes a key pair of the zone as dnssec-keygen does and parses it as the Corefile does
+func newTestKey(t *testing.T, zone string) *dnssec.DNSKEY {
+	dir, err := ioutil.TempDir("", "rdns-dnssec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := filepath.Join(dir, "K"+zone)
+	if err := ioutil.WriteFile(base+".key", []byte(k.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(base+".private", []byte(k.PrivateKeyString(priv.(crypto.PrivateKey))), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := dnssec.ParseKeyFile(base+".key", base+".private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// verifyRRSIGs checks that every rrset of the section is signed by the key
+func verifyRRSIGs(t *testing.T, key *dns.DNSKEY, rrs []dns.RR, types ...uint16) {
+	for _, qType := range types {
+		var (
+			rrset []dns.RR
+			sig   *dns.RRSIG
+		)
+		for _, rr := range rrs {
+			if s, ok := rr.(*dns.RRSIG); ok && s.TypeCovered == qType {
+				sig = s
+			} else if rr.Header().Rrtype == qType {
+				rrset = append(rrset, rr)
+			}
+		}
+		if len(rrset) == 0 || sig == nil {
+			t.Errorf("expected the signed %s rrset, got %v", dns.TypeToString[qType], rrs)
+			continue
+		}
+		if err := sig.Verify(key, rrset); err != nil {
+			t.Errorf("verify the %s rrset: %v", dns.TypeToString[qType], err)
+		}
+		if sig.KeyTag != key.KeyTag() || sig.SignerName != key.Hdr.Name {
+			t.Errorf("expected the signature of the key %d %s, got %d %s", key.KeyTag(), key.Hdr.Name, sig.KeyTag, sig.SignerName)
+		}
+	}
+}
+
+func TestServeDNSSigned(t *testing.T) {
+	e, _ := newTestETCD()
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", `{"host":"1.1.1.1"}`)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/2_2_2_2", `{"host":"2.2.2.2"}`)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/_txt/2cf24dba5fb0a30e", `{"text":"hello"}`)
+
+	key := newTestKey(t, "lb.rancher.cloud.")
+	d := dnssec.New([]string{"lb.rancher.cloud."}, []*dnssec.DNSKEY{key}, false, e, cache.New(100))
+
+	query := func(name string, qType uint16, do bool) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qType)
+		m.SetEdns0(4096, do)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := d.ServeDNS(context.Background(), w, m); err != nil {
+			t.Fatalf("%s %s: %v", name, dns.TypeToString[qType], err)
+		}
+		return w.Msg
+	}
+
+	// the answers are signed only when the DO bit is set
+	if r := query("abc.lb.rancher.cloud.", dns.TypeA, false); len(r.Answer) != 2 {
+		t.Fatalf("expected 2 unsigned answers, got %v", r.Answer)
+	}
+	r := query("abc.lb.rancher.cloud.", dns.TypeA, true)
+	if len(r.Answer) != 3 {
+		t.Fatalf("expected 2 answers and their signature, got %v", r.Answer)
+	}
+	verifyRRSIGs(t, key.K, r.Answer, dns.TypeA)
+
+	r = query("abc.lb.rancher.cloud.", dns.TypeTXT, true)
+	verifyRRSIGs(t, key.K, r.Answer, dns.TypeTXT)
+
+	// the DNSKEY is served at the apex
+	r = query("lb.rancher.cloud.", dns.TypeDNSKEY, true)
+	verifyRRSIGs(t, key.K, r.Answer, dns.TypeDNSKEY)
+
+	// the name error is answered with an NSEC record of the query name, the zone is not enumerated
+	r = query("missing.lb.rancher.cloud.", dns.TypeA, true)
+	verifyRRSIGs(t, key.K, r.Ns, dns.TypeSOA, dns.TypeNSEC)
+	for _, rr := range r.Ns {
+		if nsec, ok := rr.(*dns.NSEC); ok && nsec.Hdr.Name != "missing.lb.rancher.cloud." {
+			t.Errorf("expected the NSEC record of the query name, got %s", nsec.Hdr.Name)
+		}
+	}
+}
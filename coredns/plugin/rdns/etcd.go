@@ -1,17 +1,21 @@
 package rdns
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/rancher/rdns-server/coredns/plugin"
 	"github.com/rancher/rdns-server/coredns/plugin/rdns/msg"
 
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/coredns/coredns/plugin/pkg/fall"
 	"github.com/coredns/coredns/plugin/pkg/upstream"
 	"github.com/coredns/coredns/request"
@@ -24,20 +28,68 @@ const (
 	priority    = 10  // default priority when nothing is set
 	ttl         = 300 // default ttl when nothing is set
 	etcdTimeout = 5 * time.Second
+	caaPath     = "/_caa"
+	mxPath      = "/_mx"
 )
 
-var errKeyNotFound = errors.New("key not found")
+var (
+	errKeyNotFound = errors.New("key not found")
+	ipSegment      = regexp.MustCompile(`^\d{1,3}_\d{1,3}_\d{1,3}_\d{1,3}$`) // the key of a sub-A record, e.g. 1_1_1_1
+	pathSeparator  = []byte("/")
+)
 
 type ETCD struct {
 	Next          plugin.Handler
 	Fall          fall.F
+	FallTypes     []uint16 // The query types which fall through, all of them when it is empty
 	Zones         []string
 	PathPrefix    string
 	Upstream      *upstream.Upstream
 	Client        *etcdcv3.Client
-	WildcardBound int8 // Calculate the boundary of WildcardDNS
+	Timeout       time.Duration         // The timeout of each etcd request
+	WildcardBound int8                  // Calculate the boundary of WildcardDNS
+	ZoneConfigs   map[string]zoneConfig // The settings of the zones, PathPrefix and WildcardBound are the defaults
+	CacheRecords  bool                  // Serve the lookups from the records cache which follows etcd
+	LoadBalance   string                // The ordering of the address answers: round_robin, random or none
+	MaxAnswers    int                   // The maximum number of the answers of a response, no limit when it is zero
+	DefaultTTL    uint32                // The ttl of the records which have neither a lease nor their own ttl
+	NegativeTTL   time.Duration
+	NegativeSize  int
+	StaleTTL      time.Duration // The window when the last answer is served while etcd is not available
+	StaleSize     int
+
+	// The SOA fields and the NS names of the zone apex, the defaults are used when they are not set
+	Mname       string
+	Rname       string
+	Refresh     uint32
+	Retry       uint32
+	Expire      uint32
+	Minimum     uint32
+	NameServers []string
+
+	// The networks which are allowed to transfer the zone, and the secondaries which are notified of the changes
+	TransferTo  []*net.IPNet
+	Secondaries []string
 
 	endpoints []string // Stored here as well, to aid in testing.
+	cache     *recordCache
+	rotations rotations
+	negative  *lruCache
+	stale     *lruCache
+}
+
+// zoneConfig is the path prefix and the wildcard bound of a zone which is served from its own etcd prefix.
+type zoneConfig struct {
+	PathPrefix    string
+	WildcardBound int8
+}
+
+// zoneConfig returns the path prefix and the wildcard bound of the zone which the name belongs to
+func (e *ETCD) zoneConfig(name string) (string, int8) {
+	if c, ok := e.ZoneConfigs[plugin.Zones(e.Zones).Matches(name)]; ok {
+		return c.PathPrefix, c.WildcardBound
+	}
+	return e.PathPrefix, e.WildcardBound
 }
 
 // Services implements the ServiceBackend interface.
@@ -48,12 +100,20 @@ func (e *ETCD) Services(ctx context.Context, state request.Request, exact bool,
 	}
 
 	services = msg.Group(services)
+	e.order(state.Name(), state.QType(), services)
 	return services, err
 }
 
-// Reverse implements the ServiceBackend interface.
+// Reverse implements the ServiceBackend interface. The backend writes a reverse record under the path
+// of the arpa name for every fqdn which owns the ip, so that all of them are returned.
 func (e *ETCD) Reverse(ctx context.Context, state request.Request, exact bool, opt plugin.Options) ([]msg.Service, error) {
-	return e.Services(ctx, state, exact, opt)
+	prefix, _ := e.zoneConfig(state.Name())
+	r, err := e.get(ctx, msg.Path(state.Name(), prefix), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.loopNodes(r.Kvs, nil, false, state.QType())
 }
 
 // Lookup implements the ServiceBackend interface.
@@ -69,42 +129,182 @@ func (e *ETCD) IsNameError(err error) bool {
 // Records looks up records in etcd. If exact is true, it will lookup just this
 // name. This is used when find matches when completing SRV lookups for instance.
 func (e *ETCD) Records(ctx context.Context, state request.Request, exact bool) ([]msg.Service, error) {
+	name, qType := state.Name(), state.QType()
+
+	if e.negative != nil {
+		if _, ok := e.negative.get(name, qType); ok {
+			negativeHitCount.Inc()
+			return nil, errKeyNotFound
+		}
+		negativeMissCount.Inc()
+	}
+
+	sx, err := e.records(ctx, state, exact)
+	switch {
+	case err == nil:
+		if e.stale != nil {
+			e.stale.add(name, qType, sx)
+		}
+	case err == errKeyNotFound:
+		if e.negative != nil {
+			e.negative.add(name, qType, nil)
+		}
+		// the stale answer must not mask the name which is deleted
+		if e.stale != nil {
+			e.stale.remove(name, qType)
+		}
+	case e.stale != nil:
+		if v, ok := e.stale.get(name, qType); ok {
+			log.Warningf("serving stale records of %s: %v", name, err)
+			staleCount.Inc()
+			return staleServices(v.([]msg.Service)), nil
+		}
+	}
+	return sx, err
+}
+
+// staleServices returns a copy of the services with the reduced ttl
+func staleServices(services []msg.Service) []msg.Service {
+	sx := make([]msg.Service, len(services))
+	for i, serv := range services {
+		if serv.TTL > staleTTL {
+			serv.TTL = staleTTL
+		}
+		sx[i] = serv
+	}
+	return sx
+}
+
+func (e *ETCD) records(ctx context.Context, state request.Request, exact bool) ([]msg.Service, error) {
 	name := state.Name()
 	qType := state.QType()
 
+	if qType == dns.TypeCAA {
+		return e.caaRecords(ctx, name)
+	}
+	if qType == dns.TypeMX {
+		return e.mxRecords(ctx, name)
+	}
+
 	// No need to lookup the domain which is like zone name
 	// for example:
 	//  name: lb.rancher.cloud.
 	//  zones: [lb.rancher.cloud]
 	// "lb.rancher.cloud." shold not lookup any keys in etcd
-	for _, zone := range e.Zones {
-		if strings.HasPrefix(name, zone) {
-			return nil, nil
-		}
+	zone := plugin.Zones(e.Zones).Matches(name)
+	if zone == name {
+		return nil, nil
 	}
 
-	if e.WildcardBound > 0 && qType != dns.TypeTXT {
-		temp := dns.SplitDomainName(name)
-		if int8(len(temp)) > e.WildcardBound && !e.pathExist(ctx, temp) {
-			start := int8(len(temp)) - e.WildcardBound
+	prefix, bound := e.zoneConfig(zone)
+	path, star := msg.PathWithWildcard(name, prefix)
+
+	// the name deeper than the wildcard bound is rewritten to the wildcard name when it has no keys,
+	// the keys of the name are looked up once and reused when they exist
+	var (
+		r   *etcdcv3.GetResponse
+		err error
+	)
+	if temp := dns.SplitDomainName(name); bound > 0 && qType != dns.TypeTXT && int8(len(temp)) > bound {
+		r, err = e.get(ctx, path, true)
+		if err == errKeyNotFound {
+			start := int8(len(temp)) - bound
 			name = fmt.Sprintf("*.%s", strings.Join(temp[start:], "."))
+			wildcardCount.Inc()
+			path, star = msg.PathWithWildcard(name, prefix)
+			r, err = e.get(ctx, path, !exact)
+		} else if err == nil && exact {
+			r, err = e.get(ctx, path, false)
 		}
+	} else {
+		r, err = e.get(ctx, path, !exact)
 	}
-
-	path, star := msg.PathWithWildcard(name, e.PathPrefix)
-	r, err := e.get(ctx, path, !exact)
 	if err != nil {
 		return nil, err
 	}
-	segments := strings.Split(msg.Path(name, e.PathPrefix), "/")
+	segments := strings.Split(msg.Path(name, prefix), "/")
 
-	kvs := e.filterKvs(r.Kvs, segments, qType)
+	kvs := e.filterKvs(r.Kvs, segments, bound, qType)
+	filteredCount.Add(float64(len(r.Kvs) - len(kvs)))
 
 	return e.loopNodes(kvs, segments, star, state.QType())
 }
 
+// caaRecords looks up the CAA records of the name. CAA lookups climb the tree, so the records
+// of the closest parent are returned when the name has none, up to the zone-level default of the zone apex.
+func (e *ETCD) caaRecords(ctx context.Context, name string) ([]msg.Service, error) {
+	zone := plugin.Zones(e.Zones).Matches(name)
+	labels := dns.SplitDomainName(name)
+
+	for i := range labels {
+		n := dnsutil.Join(labels[i:]...)
+		if !dns.IsSubDomain(zone, n) {
+			break
+		}
+
+		sx, err := e.typedRecords(ctx, n, caaPath, dns.TypeCAA)
+		if err != nil {
+			return nil, err
+		}
+		if len(sx) > 0 {
+			return sx, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// mxRecords looks up the MX records of the name, which are sorted by preference.
+func (e *ETCD) mxRecords(ctx context.Context, name string) ([]msg.Service, error) {
+	sx, err := e.typedRecords(ctx, name, mxPath, dns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(sx, func(i, j int) bool {
+		return sx[i].Priority < sx[j].Priority
+	})
+	return sx, nil
+}
+
+// typedRecords looks up the records which are stored under a typed path of the name, e.g. /_caa or /_mx.
+// A name without such records is not a name error, so that NODATA is returned instead of NXDOMAIN.
+func (e *ETCD) typedRecords(ctx context.Context, name, typed string, qType uint16) ([]msg.Service, error) {
+	prefix, _ := e.zoneConfig(name)
+	r, err := e.get(ctx, msg.Path(name, prefix)+typed, true)
+	if err == errKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return e.loopNodes(r.Kvs, nil, false, qType)
+}
+
 func (e *ETCD) get(ctx context.Context, path string, recursive bool) (*etcdcv3.GetResponse, error) {
-	ctx, cancel := context.WithTimeout(ctx, etcdTimeout)
+	// the cache only follows the default path prefix
+	if e.cache != nil && strings.HasPrefix(path, e.PathPrefix+"/") {
+		if r, ok := e.cache.get(path, recursive); ok {
+			if r.Count == 0 {
+				keyNotFoundCount.Inc()
+				return nil, errKeyNotFound
+			}
+			return r, nil
+		}
+	}
+
+	start := time.Now()
+	r, err := e.getKeys(ctx, path, recursive)
+	etcdDuration.Observe(time.Since(start).Seconds())
+	if err == errKeyNotFound {
+		keyNotFoundCount.Inc()
+	}
+	return r, err
+}
+
+func (e *ETCD) getKeys(ctx context.Context, path string, recursive bool) (*etcdcv3.GetResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
 	defer cancel()
 	if recursive == true {
 		if !strings.HasSuffix(path, "/") {
@@ -180,12 +380,12 @@ Nodes:
 }
 
 // TTL returns the smaller of the etcd TTL and the service's
-// TTL. If neither of these are set (have a zero value), a default is used.
+// TTL. If neither of these are set (have a zero value), the default ttl is used.
 func (e *ETCD) TTL(kv *mvccpb.KeyValue, serv *msg.Service) uint32 {
 	etcdTTL := uint32(kv.Lease)
 
 	if etcdTTL == 0 && serv.TTL == 0 {
-		return ttl
+		return e.DefaultTTL
 	}
 	if etcdTTL == 0 {
 		return serv.TTL
@@ -200,49 +400,45 @@ func (e *ETCD) TTL(kv *mvccpb.KeyValue, serv *msg.Service) uint32 {
 }
 
 // shouldInclude returns true if the service should be included in a list of records, given the qType. For all the
-// currently supported lookup types, the only one to allow for an empty Host field in the service are TXT records.
-// Similarly, the TXT record in turn requires the Text field to be set.
+// currently supported lookup types, the only ones to allow for an empty Host field in the service are TXT and CAA records.
+// Similarly, the TXT record in turn requires the Text field to be set, and the CAA record requires the Tag field.
+// The MX records are only included for MX lookups, so that their hosts are not taken as CNAME targets.
 func shouldInclude(serv *msg.Service, qType uint16) bool {
 	if qType == dns.TypeTXT {
 		return serv.Text != ""
 	}
-	return serv.Host != ""
+	if qType == dns.TypeCAA {
+		return serv.Tag != ""
+	}
+	if qType == dns.TypeMX {
+		return serv.Mail && serv.Host != ""
+	}
+	return serv.Host != "" && !serv.Mail
 }
 
 // filterKvs returns kvs which not contain sub domain records.
-func (e *ETCD) filterKvs(kvs []*mvccpb.KeyValue, segments []string, qType uint16) []*mvccpb.KeyValue {
-	if qType == dns.TypeA {
-		result := make([]*mvccpb.KeyValue, 0)
-		for _, v := range kvs {
-			ss := strings.Split(string(v.Key), "/")
-			s := segments[len(segments)-1:][0]
-			p := `^\d{1,3}_\d{1,3}_\d{1,3}_\d{1,3}$`
-			m, _ := regexp.MatchString(p, s)
-			if s != "*" && m && e.WildcardBound == (int8(len(segments))-3) {
-				continue
-			}
-			if s != "*" && len(ss)-len(segments) == 1 || s == "*" && len(ss)-(len(segments)-1) == 1 {
-				result = append(result, v)
-			}
-		}
-		return result
+func (e *ETCD) filterKvs(kvs []*mvccpb.KeyValue, segments []string, bound int8, qType uint16) []*mvccpb.KeyValue {
+	if qType != dns.TypeA || len(kvs) == 0 {
+		return kvs
 	}
-	return kvs
-}
 
-func (e *ETCD) pathExist(ctx context.Context, ss []string) bool {
-	ctx, cancel := context.WithTimeout(ctx, etcdTimeout)
-	defer cancel()
-
-	path, _ := msg.PathWithWildcard(strings.Join(ss, "."), e.PathPrefix)
+	// the last segment is the same for all kvs, the sub-A records of an address name are never answered
+	s := segments[len(segments)-1]
+	star := s == "*"
+	if !star && ipSegment.MatchString(s) && bound == (int8(len(segments))-3) {
+		return []*mvccpb.KeyValue{}
+	}
 
-	r, err := e.Client.Get(ctx, path, etcdcv3.WithPrefix())
-	if err != nil {
-		return false
+	depth := len(segments) + 1
+	if star {
+		depth = len(segments)
 	}
 
-	if r.Count > 0 {
-		return true
+	result := make([]*mvccpb.KeyValue, 0, len(kvs))
+	for _, v := range kvs {
+		if bytes.Count(v.Key, pathSeparator)+1 == depth {
+			result = append(result, v)
+		}
 	}
-	return false
+	return result
 }
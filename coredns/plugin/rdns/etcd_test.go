@@ -0,0 +1,302 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/rdns-server/coredns/plugin/rdns/msg"
+	"github.com/rancher/rdns-server/util/etcdtest"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/miekg/dns"
+)
+
+const (
+	testPrefix = "rdnsv3"
+	testBound  = 4 // the wildcard bound of lb.rancher.cloud
+)
+
+func testKvs(keys ...string) []*mvccpb.KeyValue {
+	kvs := make([]*mvccpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(k)})
+	}
+	return kvs
+}
+
+// newTestETCD returns the plugin of the lb.rancher.cloud zone which is backed by an in-memory etcd
+func newTestETCD() (*ETCD, *etcdtest.KV) {
+	client, kv := etcdtest.NewClient()
+	return &ETCD{
+		Zones:         []string{"lb.rancher.cloud."},
+		PathPrefix:    testPrefix,
+		Client:        client,
+		Timeout:       etcdTimeout,
+		WildcardBound: testBound,
+		DefaultTTL:    ttl,
+	}, kv
+}
+
+func putService(t *testing.T, e *ETCD, key, value string) {
+	if _, err := e.Client.Put(context.Background(), key, value); err != nil {
+		t.Fatalf("put %s: %v", key, err)
+	}
+}
+
+func testState(name string, qType uint16) request.Request {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qType)
+	return request.Request{W: &test.ResponseWriter{}, Req: m}
+}
+
+func kvKeys(kvs []*mvccpb.KeyValue) []string {
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func TestFilterKvs(t *testing.T) {
+	// the keys of abc.lb.rancher.cloud, which has two hosts and a sub domain with one host
+	kvs := testKvs(
+		"/rdnsv3/cloud/rancher/lb/abc/1_1_1_1",
+		"/rdnsv3/cloud/rancher/lb/abc/2_2_2_2",
+		"/rdnsv3/cloud/rancher/lb/abc/sub1/9_9_9_9",
+	)
+
+	tests := []struct {
+		name  string
+		qType uint16
+		kvs   []*mvccpb.KeyValue
+		want  []string
+	}{
+		{
+			name:  "abc.lb.rancher.cloud.",
+			qType: dns.TypeA,
+			kvs:   kvs,
+			want:  []string{"/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", "/rdnsv3/cloud/rancher/lb/abc/2_2_2_2"},
+		},
+		{
+			// the wildcard name is looked up with the keys of the parent
+			name:  "*.abc.lb.rancher.cloud.",
+			qType: dns.TypeA,
+			kvs:   kvs,
+			want:  []string{"/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", "/rdnsv3/cloud/rancher/lb/abc/2_2_2_2"},
+		},
+		{
+			name:  "sub1.abc.lb.rancher.cloud.",
+			qType: dns.TypeA,
+			kvs:   testKvs("/rdnsv3/cloud/rancher/lb/abc/sub1/9_9_9_9"),
+			want:  []string{"/rdnsv3/cloud/rancher/lb/abc/sub1/9_9_9_9"},
+		},
+		{
+			// the sub-A records of an address name are never answered
+			name:  "1_1_1_1.abc.lb.rancher.cloud.",
+			qType: dns.TypeA,
+			kvs:   testKvs("/rdnsv3/cloud/rancher/lb/abc/1_1_1_1"),
+			want:  []string{},
+		},
+		{
+			// the other query types are not filtered
+			name:  "abc.lb.rancher.cloud.",
+			qType: dns.TypeTXT,
+			kvs:   kvs,
+			want:  kvKeys(kvs),
+		},
+		{
+			name:  "abc.lb.rancher.cloud.",
+			qType: dns.TypeA,
+			kvs:   testKvs(),
+			want:  []string{},
+		},
+	}
+
+	e := &ETCD{}
+	for _, tt := range tests {
+		segments := strings.Split(msg.Path(tt.name, testPrefix), "/")
+		got := kvKeys(e.filterKvs(tt.kvs, segments, testBound, tt.qType))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("filterKvs(%s, %s) = %v, want %v", tt.name, dns.TypeToString[tt.qType], got, tt.want)
+		}
+	}
+}
+
+func TestRecordsNegativeCache(t *testing.T) {
+	e, kv := newTestETCD()
+	e.negative = newLRUCache(100*time.Millisecond, negativeSize)
+	state := testState("missing.lb.rancher.cloud.", dns.TypeA)
+
+	if _, err := e.Records(context.Background(), state, false); err != errKeyNotFound {
+		t.Fatalf("expected errKeyNotFound, got %v", err)
+	}
+	gets := kv.Gets()
+
+	// the name is created after the first lookup, the not found result is still served until it expires
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/missing/1_1_1_1", `{"host":"1.1.1.1"}`)
+	if _, err := e.Records(context.Background(), state, false); err != errKeyNotFound {
+		t.Fatalf("expected the cached errKeyNotFound, got %v", err)
+	}
+	if kv.Gets() != gets {
+		t.Fatalf("expected no etcd request for the cached name, got %d", kv.Gets()-gets)
+	}
+
+	// the other query types of the name are not cached
+	if _, err := e.Records(context.Background(), testState("missing.lb.rancher.cloud.", dns.TypeTXT), false); err != nil {
+		t.Fatalf("expected the TXT lookup to reach etcd, got %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	sx, err := e.Records(context.Background(), state, false)
+	if err != nil {
+		t.Fatalf("expected the expired entry to be looked up again, got %v", err)
+	}
+	if len(sx) != 1 || sx[0].Host != "1.1.1.1" {
+		t.Fatalf("expected the record of the name, got %v", sx)
+	}
+}
+
+func TestRecordsServeStale(t *testing.T) {
+	e, kv := newTestETCD()
+	e.stale = newLRUCache(100*time.Millisecond, staleSize)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", `{"host":"1.1.1.1","ttl":300}`)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/gone/2_2_2_2", `{"host":"2.2.2.2","ttl":300}`)
+
+	for _, name := range []string{"abc.lb.rancher.cloud.", "gone.lb.rancher.cloud."} {
+		if _, err := e.Records(context.Background(), testState(name, dns.TypeA), false); err != nil {
+			t.Fatalf("lookup %s: %v", name, err)
+		}
+	}
+
+	// the deleted name is answered with NXDOMAIN, its stale answer is dropped
+	if _, err := e.Client.Delete(context.Background(), "/rdnsv3/cloud/rancher/lb/gone/2_2_2_2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Records(context.Background(), testState("gone.lb.rancher.cloud.", dns.TypeA), false); err != errKeyNotFound {
+		t.Fatalf("expected errKeyNotFound of the deleted name, got %v", err)
+	}
+
+	kv.Fail(errors.New("etcdserver: request timed out"))
+
+	sx, err := e.Records(context.Background(), testState("abc.lb.rancher.cloud.", dns.TypeA), false)
+	if err != nil {
+		t.Fatalf("expected the stale answer, got %v", err)
+	}
+	if len(sx) != 1 || sx[0].Host != "1.1.1.1" || sx[0].TTL != staleTTL {
+		t.Fatalf("expected the stale record with the reduced ttl, got %+v", sx)
+	}
+	if _, err := e.Records(context.Background(), testState("gone.lb.rancher.cloud.", dns.TypeA), false); err == nil {
+		t.Fatal("expected the deleted name not to be served from the stale cache")
+	}
+
+	// the answer is served with SERVFAIL when the staleness window is over
+	time.Sleep(150 * time.Millisecond)
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetQuestion("abc.lb.rancher.cloud.", dns.TypeA)
+	if _, err := e.ServeDNS(context.Background(), w, m); err == nil {
+		t.Fatal("expected the etcd error after the staleness window")
+	}
+	if w.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %s", dns.RcodeToString[w.Rcode])
+	}
+}
+
+func TestRecordsZoneConfigs(t *testing.T) {
+	e, _ := newTestETCD()
+	e.Zones = append(e.Zones, "example.com.")
+	e.ZoneConfigs = map[string]zoneConfig{"example.com.": {PathPrefix: "other", WildcardBound: 3}}
+
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", `{"host":"1.1.1.1"}`)
+	putService(t, e, "/other/com/example/foo/3_3_3_3", `{"host":"3.3.3.3"}`)
+	// the keys below the default prefix are not served for the zone with its own prefix
+	putService(t, e, "/rdnsv3/com/example/bar/4_4_4_4", `{"host":"4.4.4.4"}`)
+
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"abc.lb.rancher.cloud.", "1.1.1.1", nil},
+		// deeper than the bound 4 of the default zone, the wildcard of the parent is answered
+		{"x.abc.lb.rancher.cloud.", "1.1.1.1", nil},
+		{"foo.example.com.", "3.3.3.3", nil},
+		// deeper than the bound 3 of example.com
+		{"x.foo.example.com.", "3.3.3.3", nil},
+		{"bar.example.com.", "", errKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		sx, err := e.Records(context.Background(), testState(tt.name, dns.TypeA), false)
+		if err != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+			continue
+		}
+		if tt.err != nil {
+			continue
+		}
+		if len(sx) != 1 || sx[0].Host != tt.want {
+			t.Errorf("%s: expected %s, got %+v", tt.name, tt.want, sx)
+		}
+	}
+}
+
+func TestRecordsWildcardGets(t *testing.T) {
+	e, kv := newTestETCD()
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", `{"host":"1.1.1.1"}`)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/sub/9_9_9_9", `{"host":"9.9.9.9"}`)
+
+	tests := []struct {
+		name string
+		want string
+		err  error
+		gets int
+	}{
+		// the name deeper than the wildcard bound which has its own keys is looked up once
+		{"sub.abc.lb.rancher.cloud.", "9.9.9.9", nil, 1},
+		// the name without keys is answered with the wildcard of the parent
+		{"other.abc.lb.rancher.cloud.", "1.1.1.1", nil, 3},
+		{"other.missing.lb.rancher.cloud.", "", errKeyNotFound, 4},
+	}
+
+	for _, tt := range tests {
+		gets := kv.Gets()
+		sx, err := e.Records(context.Background(), testState(tt.name, dns.TypeA), false)
+		if n := kv.Gets() - gets; n != tt.gets {
+			t.Errorf("%s: expected %d etcd gets, got %d", tt.name, tt.gets, n)
+		}
+		if err != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+			continue
+		}
+		if tt.err == nil && (len(sx) != 1 || sx[0].Host != tt.want) {
+			t.Errorf("%s: expected %s, got %+v", tt.name, tt.want, sx)
+		}
+	}
+}
+
+func BenchmarkFilterKvs(b *testing.B) {
+	// 1k keys of a domain, the half of them are the keys of its sub domains
+	keys := make([]string, 0, 1000)
+	for i := 0; i < 500; i++ {
+		keys = append(keys, fmt.Sprintf("/rdnsv3/cloud/rancher/lb/abc/%d_%d_%d_%d", i/256, i%256, 1, 1))
+		keys = append(keys, fmt.Sprintf("/rdnsv3/cloud/rancher/lb/abc/sub%d/%d_%d_%d_%d", i, i/256, i%256, 2, 2))
+	}
+	kvs := testKvs(keys...)
+	segments := strings.Split(msg.Path("abc.lb.rancher.cloud.", testPrefix), "/")
+
+	e := &ETCD{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.filterKvs(kvs, segments, testBound, dns.TypeA)
+	}
+}
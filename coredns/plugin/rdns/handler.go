@@ -25,6 +25,8 @@ func (e *ETCD) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 	)
 
 	switch state.QType() {
+	case dns.TypeAXFR, dns.TypeIXFR:
+		return e.Transfer(ctx, state)
 	case dns.TypeA:
 		records, err = plugin.A(ctx, e, zone, state, nil, opt)
 	case dns.TypeAAAA:
@@ -33,6 +35,8 @@ func (e *ETCD) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 		records, err = plugin.TXT(ctx, e, zone, state, opt)
 	case dns.TypeCNAME:
 		records, err = plugin.CNAME(ctx, e, zone, state, opt)
+	case dns.TypeCAA:
+		records, err = plugin.CAA(ctx, e, zone, state, opt)
 	case dns.TypePTR:
 		records, err = plugin.PTR(ctx, e, zone, state, opt)
 	case dns.TypeMX:
@@ -42,6 +46,10 @@ func (e *ETCD) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 	case dns.TypeSOA:
 		records, err = plugin.SOA(ctx, e, zone, state, opt)
 	case dns.TypeNS:
+		if state.Name() == zone && len(e.NameServers) > 0 {
+			records = e.nsRecords(zone, state)
+			break
+		}
 		if state.Name() == zone {
 			records, extra, err = plugin.NS(ctx, e, zone, state, opt)
 			break
@@ -52,7 +60,7 @@ func (e *ETCD) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 		_, err = plugin.A(ctx, e, zone, state, nil, opt)
 	}
 	if err != nil && e.IsNameError(err) {
-		if e.Fall.Through(state.Name()) {
+		if e.fallThrough(state, false) {
 			return plugin.NextOrFailure(ctx, e.Name(), e.Next, w, r)
 		}
 		// Make err nil when returning here, so we don't log spam for NXDOMAIN.
@@ -63,18 +71,46 @@ func (e *ETCD) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 	}
 
 	if len(records) == 0 {
+		if e.fallThrough(state, true) {
+			return plugin.NextOrFailure(ctx, e.Name(), e.Next, w, r)
+		}
 		return plugin.BackendError(ctx, e, zone, dns.RcodeSuccess, state, err, opt)
 	}
 
+	// a name with thousands of records is not answered with all of them
+	if e.MaxAnswers > 0 && len(records) > e.MaxAnswers {
+		records = records[:e.MaxAnswers]
+	}
+
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Authoritative = true
 	m.Answer = append(m.Answer, records...)
 	m.Extra = append(m.Extra, extra...)
 
+	// the answers which do not fit the advertised buffer size are trimmed with the TC bit, the client retries over TCP
+	state.SizeAndDo(m)
+	m = state.Scrub(m)
 	w.WriteMsg(m)
 	return dns.RcodeSuccess, nil
 }
 
 // Name implements the Handler interface.
 func (e *ETCD) Name() string { return "rdns" }
+
+// fallThrough returns true when the unanswered query is passed to the next plugin. The queries without data
+// are only passed when the fallthrough is limited to the query types, e.g. fallthrough types TXT CAA.
+func (e *ETCD) fallThrough(state request.Request, noData bool) bool {
+	if !e.Fall.Through(state.Name()) {
+		return false
+	}
+	if len(e.FallTypes) == 0 {
+		return !noData
+	}
+	for _, t := range e.FallTypes {
+		if t == state.QType() {
+			return true
+		}
+	}
+	return false
+}
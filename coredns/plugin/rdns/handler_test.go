@@ -0,0 +1,181 @@
+package rdns
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func serveTestQuery(t *testing.T, e *ETCD, name string, qType uint16) (*dnstest.Recorder, int) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qType)
+	return serveTestMsg(t, e, &test.ResponseWriter{}, m)
+}
+
+func serveTestMsg(t *testing.T, e *ETCD, rw dns.ResponseWriter, m *dns.Msg) (*dnstest.Recorder, int) {
+	name, qType := m.Question[0].Name, m.Question[0].Qtype
+	w := dnstest.NewRecorder(rw)
+	rcode, err := e.ServeDNS(context.Background(), w, m)
+	if err != nil {
+		t.Fatalf("%s %s: %v", name, dns.TypeToString[qType], err)
+	}
+	return w, rcode
+}
+
+func TestServeDNSZoneMatch(t *testing.T) {
+	e, _ := newTestETCD()
+	e.Next = test.NextHandler(dns.RcodeRefused, nil)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", `{"host":"1.1.1.1"}`)
+
+	tests := []struct {
+		name   string
+		served bool
+	}{
+		{"abc.lb.rancher.cloud.", true},
+		{"ABC.LB.RANCHER.CLOUD.", true},
+		// the names which only start with the zone or end with its last labels are passed to the next plugin
+		{"lb.rancher.cloud.evil.com.", false},
+		{"abc.lb.rancher.cloud.evil.com.", false},
+		{"abc.evillb.rancher.cloud.", false},
+	}
+
+	for _, tt := range tests {
+		w, rcode := serveTestQuery(t, e, tt.name, dns.TypeA)
+		if !tt.served {
+			if rcode != dns.RcodeRefused || w.Msg != nil {
+				t.Errorf("%s: expected the query to be passed to the next plugin, got %s", tt.name, dns.RcodeToString[rcode])
+			}
+			continue
+		}
+		if w.Msg == nil || len(w.Msg.Answer) != 1 {
+			t.Errorf("%s: expected one answer, got %v", tt.name, w.Msg)
+		}
+	}
+}
+
+func TestServeDNSFallthroughTypes(t *testing.T) {
+	tests := []struct {
+		types []uint16
+		name  string
+		qType uint16
+		next  bool
+		rcode int
+	}{
+		// the default fallthrough passes the name errors only
+		{nil, "missing.lb.rancher.cloud.", dns.TypeA, true, 0},
+		{nil, "abc.lb.rancher.cloud.", dns.TypeTXT, false, dns.RcodeSuccess},
+		// fallthrough types TXT CAA, the address misses are answered authoritatively
+		{[]uint16{dns.TypeTXT, dns.TypeCAA}, "missing.lb.rancher.cloud.", dns.TypeA, false, dns.RcodeNameError},
+		{[]uint16{dns.TypeTXT, dns.TypeCAA}, "missing.lb.rancher.cloud.", dns.TypeAAAA, false, dns.RcodeNameError},
+		{[]uint16{dns.TypeTXT, dns.TypeCAA}, "abc.lb.rancher.cloud.", dns.TypeTXT, true, 0},
+		{[]uint16{dns.TypeTXT, dns.TypeCAA}, "abc.lb.rancher.cloud.", dns.TypeCAA, true, 0},
+		{[]uint16{dns.TypeTXT, dns.TypeCAA}, "missing.lb.rancher.cloud.", dns.TypeTXT, true, 0},
+		{[]uint16{dns.TypeTXT, dns.TypeCAA}, "abc.lb.rancher.cloud.", dns.TypeA, false, dns.RcodeSuccess},
+	}
+
+	for _, tt := range tests {
+		e, _ := newTestETCD()
+		e.Next = test.NextHandler(dns.RcodeRefused, nil)
+		e.Fall.SetZonesFromArgs(nil)
+		e.FallTypes = tt.types
+		putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", `{"host":"1.1.1.1"}`)
+
+		w, rcode := serveTestQuery(t, e, tt.name, tt.qType)
+		if tt.next {
+			if rcode != dns.RcodeRefused || w.Msg != nil {
+				t.Errorf("%v %s %s: expected the query to fall through", tt.types, tt.name, dns.TypeToString[tt.qType])
+			}
+			continue
+		}
+		if w.Msg == nil {
+			t.Errorf("%v %s %s: expected the query to be answered", tt.types, tt.name, dns.TypeToString[tt.qType])
+			continue
+		}
+		if w.Msg.Rcode != tt.rcode {
+			t.Errorf("%v %s %s: expected %s, got %s", tt.types, tt.name, dns.TypeToString[tt.qType], dns.RcodeToString[tt.rcode], dns.RcodeToString[w.Msg.Rcode])
+		}
+	}
+}
+
+func TestServeDNSTruncation(t *testing.T) {
+	e, _ := newTestETCD()
+	for i := 1; i <= 50; i++ {
+		putService(t, e, fmt.Sprintf("/rdnsv3/cloud/rancher/lb/abc/10_0_0_%d", i), fmt.Sprintf(`{"host":"10.0.0.%d"}`, i))
+	}
+
+	// the answers do not fit the 512 bytes of a UDP query without EDNS0
+	m := new(dns.Msg)
+	m.SetQuestion("abc.lb.rancher.cloud.", dns.TypeA)
+	w, _ := serveTestMsg(t, e, &test.ResponseWriter{}, m)
+	if !w.Msg.Truncated {
+		t.Fatal("expected the TC bit over UDP")
+	}
+	if n := len(w.Msg.Answer); n == 0 || n >= 50 {
+		t.Fatalf("expected the trimmed answers, got %d", n)
+	}
+	if w.Len > dns.MinMsgSize {
+		t.Fatalf("expected the response to fit %d bytes, got %d", dns.MinMsgSize, w.Len)
+	}
+
+	// the advertised buffer size is respected
+	m = new(dns.Msg)
+	m.SetQuestion("abc.lb.rancher.cloud.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	w, _ = serveTestMsg(t, e, &test.ResponseWriter{}, m)
+	if w.Msg.Truncated || len(w.Msg.Answer) != 50 {
+		t.Fatalf("expected all the answers with the 4096 bytes buffer, got %d truncated %v", len(w.Msg.Answer), w.Msg.Truncated)
+	}
+
+	// the client retries over TCP and gets the full set
+	m = new(dns.Msg)
+	m.SetQuestion("abc.lb.rancher.cloud.", dns.TypeA)
+	w, _ = serveTestMsg(t, e, &test.ResponseWriter{TCP: true}, m)
+	if w.Msg.Truncated || len(w.Msg.Answer) != 50 {
+		t.Fatalf("expected all the answers over TCP, got %d truncated %v", len(w.Msg.Answer), w.Msg.Truncated)
+	}
+
+	// max_answers caps the answers over TCP as well
+	e.MaxAnswers = 10
+	w, _ = serveTestMsg(t, e, &test.ResponseWriter{TCP: true}, m)
+	if len(w.Msg.Answer) != 10 {
+		t.Fatalf("expected 10 answers, got %d", len(w.Msg.Answer))
+	}
+}
+
+func TestServeDNSTTL(t *testing.T) {
+	e, _ := newTestETCD()
+	e.DefaultTTL = 120
+
+	// the values are written as the etcd-v3 backend stores the records created with a ttl
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/1_1_1_1", `{"host":"1.1.1.1","ttl":30}`)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/sub1/2_2_2_2", `{"host":"2.2.2.2","ttl":30}`)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/abc/_acme-challenge/_txt/2cf24dba5fb0a30e", `{"text":"hello","ttl":30}`)
+	putService(t, e, "/rdnsv3/cloud/rancher/lb/def/3_3_3_3", `{"host":"3.3.3.3"}`)
+
+	tests := []struct {
+		name  string
+		qType uint16
+		ttl   uint32
+	}{
+		{"abc.lb.rancher.cloud.", dns.TypeA, 30},
+		{"sub1.abc.lb.rancher.cloud.", dns.TypeA, 30},
+		{"_acme-challenge.abc.lb.rancher.cloud.", dns.TypeTXT, 30},
+		// the record without a ttl gets the default_ttl of the Corefile
+		{"def.lb.rancher.cloud.", dns.TypeA, 120},
+	}
+
+	for _, tt := range tests {
+		w, _ := serveTestQuery(t, e, tt.name, tt.qType)
+		if w.Msg == nil || len(w.Msg.Answer) != 1 {
+			t.Errorf("%s: expected one answer, got %v", tt.name, w.Msg)
+			continue
+		}
+		if ttl := w.Msg.Answer[0].Header().Ttl; ttl != tt.ttl {
+			t.Errorf("%s: expected the ttl %d, got %d", tt.name, tt.ttl, ttl)
+		}
+	}
+}
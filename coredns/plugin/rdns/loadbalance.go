@@ -0,0 +1,77 @@
+package rdns
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+
+	"github.com/rancher/rdns-server/coredns/plugin/rdns/msg"
+
+	"github.com/miekg/dns"
+)
+
+// The orderings of the address answers.
+const (
+	orderNone       = "none"
+	orderRoundRobin = "round_robin"
+	orderRandom     = "random"
+)
+
+// the rotations are dropped when there are too many names, they only need to vary the first answer
+const maxRotations = 10000
+
+type rotations struct {
+	sync.Mutex
+	next map[string]int
+}
+
+// order rotates or shuffles the address services of the A and AAAA answers, the other services keep their positions,
+// so that the CNAME answers are not broken.
+func (e *ETCD) order(name string, qType uint16, services []msg.Service) {
+	if e.LoadBalance == "" || e.LoadBalance == orderNone || (qType != dns.TypeA && qType != dns.TypeAAAA) {
+		return
+	}
+
+	idx := make([]int, 0, len(services))
+	for i, serv := range services {
+		if net.ParseIP(serv.Host) != nil {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) < 2 {
+		return
+	}
+
+	addrs := make([]msg.Service, len(idx))
+	for i, j := range idx {
+		addrs[i] = services[j]
+	}
+
+	switch e.LoadBalance {
+	case orderRoundRobin:
+		n := e.rotations.rotate(name, len(addrs))
+		addrs = append(addrs[n:], addrs[:n]...)
+	case orderRandom:
+		rand.Shuffle(len(addrs), func(i, j int) {
+			addrs[i], addrs[j] = addrs[j], addrs[i]
+		})
+	}
+
+	for i, j := range idx {
+		services[j] = addrs[i]
+	}
+}
+
+// rotate returns the offset of the next answer of the name
+func (r *rotations) rotate(name string, n int) int {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.next == nil || len(r.next) >= maxRotations {
+		r.next = make(map[string]int)
+	}
+
+	i := r.next[name] % n
+	r.next[name] = i + 1
+	return i
+}
@@ -0,0 +1,106 @@
+package rdns
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// The defaults of the negative and the stale caches.
+const (
+	negativeTTL  = 5 * time.Second
+	negativeSize = 10000
+	staleSize    = 10000
+	staleTTL     = 30 // the ttl of the stale answers, so that the resolvers ask again soon
+)
+
+type queryKey struct {
+	name  string
+	qType uint16
+}
+
+type lruEntry struct {
+	key    queryKey
+	value  interface{}
+	expire time.Time
+}
+
+// lruCache keeps the results of the queries for the ttl, the least recently used entries are evicted when it is full.
+type lruCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[queryKey]*list.Element
+	lru     *list.List
+}
+
+func newLRUCache(ttl time.Duration, size int) *lruCache {
+	return &lruCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[queryKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the value of the query which is not expired yet
+func (c *lruCache) get(name string, qType uint16) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	k := queryKey{name: name, qType: qType}
+	el, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expire) {
+		c.lru.Remove(el)
+		delete(c.entries, k)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *lruCache) add(name string, qType uint16, value interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	k := queryKey{name: name, qType: qType}
+	expire := time.Now().Add(c.ttl)
+	if el, ok := c.entries[k]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expire = value, expire
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[k] = c.lru.PushFront(&lruEntry{key: k, value: value, expire: expire})
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) remove(name string, qType uint16) {
+	c.Lock()
+	defer c.Unlock()
+
+	k := queryKey{name: name, qType: qType}
+	if el, ok := c.entries[k]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, k)
+	}
+}
+
+// purge drops all entries, it is called when the records change
+func (c *lruCache) purge() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = make(map[queryKey]*list.Element)
+	c.lru.Init()
+}
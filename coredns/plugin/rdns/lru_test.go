@@ -0,0 +1,54 @@
+package rdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestLRUCacheExpiry(t *testing.T) {
+	c := newLRUCache(50*time.Millisecond, 10)
+	c.add("a.lb.rancher.cloud.", dns.TypeA, 1)
+
+	if v, ok := c.get("a.lb.rancher.cloud.", dns.TypeA); !ok || v.(int) != 1 {
+		t.Fatalf("expected the entry, got %v %v", v, ok)
+	}
+	if _, ok := c.get("a.lb.rancher.cloud.", dns.TypeTXT); ok {
+		t.Fatal("expected no entry of the other query type")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.get("a.lb.rancher.cloud.", dns.TypeA); ok {
+		t.Fatal("expected the entry to be expired")
+	}
+	if len(c.entries) != 0 || c.lru.Len() != 0 {
+		t.Fatalf("expected the expired entry to be removed, got %d entries", len(c.entries))
+	}
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	c := newLRUCache(time.Minute, 2)
+	c.add("a.lb.rancher.cloud.", dns.TypeA, nil)
+	c.add("b.lb.rancher.cloud.", dns.TypeA, nil)
+
+	// a is used, so that b is the least recently used entry
+	if _, ok := c.get("a.lb.rancher.cloud.", dns.TypeA); !ok {
+		t.Fatal("expected the entry of a")
+	}
+	c.add("c.lb.rancher.cloud.", dns.TypeA, nil)
+
+	for name, want := range map[string]bool{"a.lb.rancher.cloud.": true, "b.lb.rancher.cloud.": false, "c.lb.rancher.cloud.": true} {
+		if _, ok := c.get(name, dns.TypeA); ok != want {
+			t.Errorf("get(%s) = %v, want %v", name, ok, want)
+		}
+	}
+	if c.lru.Len() != 2 {
+		t.Fatalf("expected the cache to be bounded to 2 entries, got %d", c.lru.Len())
+	}
+
+	c.purge()
+	if _, ok := c.get("a.lb.rancher.cloud.", dns.TypeA); ok {
+		t.Fatal("expected no entry after the purge")
+	}
+}
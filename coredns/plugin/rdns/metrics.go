@@ -0,0 +1,61 @@
+package rdns
+
+import (
+	"github.com/rancher/rdns-server/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics about the etcd lookups of the rdns plugin.
+var (
+	etcdDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rdns",
+		Name:      "etcd_request_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time (in seconds) each etcd get request took.",
+	})
+
+	keyNotFoundCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rdns",
+		Name:      "key_not_found_total",
+		Help:      "Counter of the etcd get requests which found no keys.",
+	})
+
+	wildcardCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rdns",
+		Name:      "wildcard_rewrites_total",
+		Help:      "Counter of the names which are rewritten to the wildcard name by the wildcard bound.",
+	})
+
+	filteredCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rdns",
+		Name:      "filtered_kvs_total",
+		Help:      "Counter of the keys which are filtered out as sub domain records.",
+	})
+
+	negativeHitCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rdns",
+		Name:      "negative_cache_hits_total",
+		Help:      "Counter of the lookups which are answered by the negative cache.",
+	})
+
+	negativeMissCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rdns",
+		Name:      "negative_cache_misses_total",
+		Help:      "Counter of the lookups which are not found in the negative cache.",
+	})
+
+	staleCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rdns",
+		Name:      "served_stale_total",
+		Help:      "Counter of the lookups which are answered by the stale records while etcd is not available.",
+	})
+)
@@ -20,6 +20,11 @@ type Service struct {
 	Mail     bool   `json:"mail,omitempty"` // Be an MX record. Priority becomes Preference.
 	TTL      uint32 `json:"ttl,omitempty"`
 
+	// Be a CAA record when the Tag is set.
+	Flag  uint8  `json:"flag,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	Value string `json:"value,omitempty"`
+
 	// When a SRV record with a "Host: IP-address" is added, we synthesize
 	// a srv.Target domain name.  Normally we convert the full Key where
 	// the record lives to a DNS name and use this as the srv.Target.  When
@@ -78,6 +83,11 @@ func (s *Service) NewTXT(name string) *dns.TXT {
 	return &dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: s.TTL}, Txt: split255(s.Text)}
 }
 
+// NewCAA returns a new CAA record based on the Service.
+func (s *Service) NewCAA(name string) *dns.CAA {
+	return &dns.CAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: s.TTL}, Flag: s.Flag, Tag: s.Tag, Value: s.Value}
+}
+
 // NewPTR returns a new PTR record based on the Service.
 func (s *Service) NewPTR(name string, target string) *dns.PTR {
 	return &dns.PTR{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: s.TTL}, Ptr: dns.Fqdn(target)}
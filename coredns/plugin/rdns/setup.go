@@ -1,16 +1,22 @@
 package rdns
 
 import (
+	"context"
 	"crypto/tls"
+	"net"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	mwtls "github.com/coredns/coredns/plugin/pkg/tls"
 	"github.com/coredns/coredns/plugin/pkg/upstream"
 	etcdcv3 "github.com/coreos/etcd/clientv3"
 	"github.com/mholt/caddy"
+	"github.com/miekg/dns"
 )
 
 var log = clog.NewWithPlugin("rdns")
@@ -21,6 +27,33 @@ func Setup(c *caddy.Controller) error {
 		return plugin.Error("rdns", err)
 	}
 
+	if e.NegativeTTL > 0 {
+		e.negative = newLRUCache(e.NegativeTTL, e.NegativeSize)
+	}
+
+	if e.StaleTTL > 0 {
+		e.stale = newLRUCache(e.StaleTTL, e.StaleSize)
+	}
+
+	if e.CacheRecords {
+		ctx, cancel := context.WithCancel(context.Background())
+		e.cache = newRecordCache()
+		c.OnStartup(func() error {
+			go e.watchRecords(ctx)
+			return nil
+		})
+		c.OnShutdown(func() error {
+			cancel()
+			return nil
+		})
+	}
+
+	// the metrics are exposed by the prometheus plugin as well, when it is enabled
+	c.OnStartup(func() error {
+		metrics.MustRegister(c, etcdDuration, keyNotFoundCount, wildcardCount, filteredCount, negativeHitCount, negativeMissCount, staleCount)
+		return nil
+	})
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		e.Next = next
 		return e
@@ -30,7 +63,7 @@ func Setup(c *caddy.Controller) error {
 }
 
 func etcdParse(c *caddy.Controller) (*ETCD, error) {
-	etc := ETCD{PathPrefix: "skydns"}
+	etc := ETCD{PathPrefix: "skydns", Timeout: etcdTimeout, DefaultTTL: ttl, NegativeTTL: negativeTTL, NegativeSize: negativeSize, StaleSize: staleSize}
 	var (
 		tlsConfig *tls.Config
 		err       error
@@ -53,7 +86,22 @@ func etcdParse(c *caddy.Controller) (*ETCD, error) {
 			case "stubzones":
 				// ignored, remove later.
 			case "fallthrough":
-				etc.Fall.SetZonesFromArgs(c.RemainingArgs())
+				// fallthrough [ZONES...] or fallthrough types TYPES...
+				args := c.RemainingArgs()
+				if len(args) > 0 && args[0] == "types" {
+					if len(args) == 1 {
+						return &ETCD{}, c.ArgErr()
+					}
+					for _, arg := range args[1:] {
+						t, ok := dns.StringToType[strings.ToUpper(arg)]
+						if !ok {
+							return &ETCD{}, c.Errf("not valid fallthrough type: %s", arg)
+						}
+						etc.FallTypes = append(etc.FallTypes, t)
+					}
+					args = nil
+				}
+				etc.Fall.SetZonesFromArgs(args)
 			case "debug":
 				/* it is a noop now */
 			case "path":
@@ -86,6 +134,154 @@ func etcdParse(c *caddy.Controller) (*ETCD, error) {
 					return &ETCD{}, c.Errf("credentials requires 2 arguments, username and password")
 				}
 				username, password = args[0], args[1]
+			case "timeout":
+				if !c.NextArg() {
+					return &ETCD{}, c.ArgErr()
+				}
+				d, err := time.ParseDuration(c.Val())
+				if err != nil || d <= 0 {
+					return &ETCD{}, c.Errf("not valid timeout: %s", c.Val())
+				}
+				etc.Timeout = d
+			case "cache_records":
+				if !c.NextArg() {
+					return &ETCD{}, c.ArgErr()
+				}
+				switch c.Val() {
+				case "on":
+					etc.CacheRecords = true
+				case "off":
+					etc.CacheRecords = false
+				default:
+					return &ETCD{}, c.Errf("cache_records value must be on or off: %s", c.Val())
+				}
+			case "negative_cache":
+				// negative_cache TTL [SIZE], the zero ttl disables it
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return &ETCD{}, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d < 0 {
+					return &ETCD{}, c.Errf("not valid negative_cache ttl: %s", args[0])
+				}
+				etc.NegativeTTL = d
+				if len(args) == 2 {
+					n, err := strconv.Atoi(args[1])
+					if err != nil || n <= 0 {
+						return &ETCD{}, c.Errf("not valid negative_cache size: %s", args[1])
+					}
+					etc.NegativeSize = n
+				}
+			case "serve_stale":
+				// serve_stale WINDOW [SIZE]
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return &ETCD{}, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d < 0 {
+					return &ETCD{}, c.Errf("not valid serve_stale window: %s", args[0])
+				}
+				etc.StaleTTL = d
+				if len(args) == 2 {
+					n, err := strconv.Atoi(args[1])
+					if err != nil || n <= 0 {
+						return &ETCD{}, c.Errf("not valid serve_stale size: %s", args[1])
+					}
+					etc.StaleSize = n
+				}
+			case "max_answers":
+				if !c.NextArg() {
+					return &ETCD{}, c.ArgErr()
+				}
+				n, err := strconv.Atoi(c.Val())
+				if err != nil || n < 0 {
+					return &ETCD{}, c.Errf("not valid max_answers: %s", c.Val())
+				}
+				etc.MaxAnswers = n
+			case "default_ttl":
+				if !c.NextArg() {
+					return &ETCD{}, c.ArgErr()
+				}
+				v, err := strconv.ParseUint(c.Val(), 10, 32)
+				if err != nil || v == 0 {
+					return &ETCD{}, c.Errf("not valid default_ttl: %s", c.Val())
+				}
+				etc.DefaultTTL = uint32(v)
+			case "loadbalance":
+				if !c.NextArg() {
+					return &ETCD{}, c.ArgErr()
+				}
+				switch c.Val() {
+				case orderRoundRobin, orderRandom, orderNone:
+					etc.LoadBalance = c.Val()
+				default:
+					return &ETCD{}, c.Errf("loadbalance value must be round_robin, random or none: %s", c.Val())
+				}
+			case "soa":
+				// soa MNAME RNAME [REFRESH RETRY EXPIRE MINIMUM]
+				args := c.RemainingArgs()
+				if len(args) != 2 && len(args) != 6 {
+					return &ETCD{}, c.Errf("soa requires 2 or 6 arguments, mname rname [refresh retry expire minimum]")
+				}
+				etc.Mname, etc.Rname = args[0], args[1]
+				if len(args) == 6 {
+					timers := make([]uint32, 0, 4)
+					for _, a := range args[2:] {
+						v, err := strconv.ParseUint(a, 10, 32)
+						if err != nil {
+							return &ETCD{}, err
+						}
+						timers = append(timers, uint32(v))
+					}
+					etc.Refresh, etc.Retry, etc.Expire, etc.Minimum = timers[0], timers[1], timers[2], timers[3]
+				}
+			case "ns":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return &ETCD{}, c.ArgErr()
+				}
+				etc.NameServers = args
+			case "transfer":
+				if !c.NextArg() || c.Val() != "to" {
+					return &ETCD{}, c.ArgErr()
+				}
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return &ETCD{}, c.ArgErr()
+				}
+				for _, arg := range args {
+					if _, n, err := net.ParseCIDR(arg); err == nil {
+						etc.TransferTo = append(etc.TransferTo, n)
+						continue
+					}
+					ip := net.ParseIP(arg)
+					if ip == nil {
+						return &ETCD{}, c.Errf("not valid transfer address: %s", arg)
+					}
+					// the single addresses are the secondaries, they are notified of the changes as well
+					etc.TransferTo = append(etc.TransferTo, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+					etc.Secondaries = append(etc.Secondaries, net.JoinHostPort(arg, "53"))
+				}
+			case "zone":
+				// zone NAME PATH [WILDCARDBOUND], the zone is served from its own path prefix
+				args := c.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return &ETCD{}, c.ArgErr()
+				}
+				zc := zoneConfig{PathPrefix: args[1]}
+				if len(args) == 3 {
+					v, err := strconv.ParseInt(args[2], 10, 8)
+					if err != nil || v < 0 {
+						return &ETCD{}, c.Errf("not valid wildcardbound of zone %s: %s", args[0], args[2])
+					}
+					zc.WildcardBound = int8(v)
+				}
+				if etc.ZoneConfigs == nil {
+					etc.ZoneConfigs = make(map[string]zoneConfig)
+				}
+				etc.ZoneConfigs[plugin.Host(args[0]).Normalize()] = zc
 			case "wildcardbound":
 				if !c.NextArg() {
 					return &ETCD{}, c.ArgErr()
@@ -104,6 +300,11 @@ func etcdParse(c *caddy.Controller) (*ETCD, error) {
 				}
 			}
 		}
+		for zone := range etc.ZoneConfigs {
+			if plugin.Zones(etc.Zones).Matches(zone) != zone {
+				return &ETCD{}, c.Errf("zone %s is not served by the plugin", zone)
+			}
+		}
 		client, err := newEtcdClient(endpoints, tlsConfig, username, password)
 		if err != nil {
 			return &ETCD{}, err
@@ -0,0 +1,159 @@
+package rdns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mholt/caddy"
+	"github.com/miekg/dns"
+)
+
+func TestSetupEtcdClient(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		errMsg    string
+		timeout   time.Duration
+	}{
+		{`rdns lb.rancher.cloud`, false, "", etcdTimeout},
+		{`rdns lb.rancher.cloud {
+			timeout 2s
+		}`, false, "", 2 * time.Second},
+		{`rdns lb.rancher.cloud {
+			timeout
+		}`, true, "Wrong argument count", 0},
+		{`rdns lb.rancher.cloud {
+			timeout 0s
+		}`, true, "not valid timeout", 0},
+		{`rdns lb.rancher.cloud {
+			timeout soon
+		}`, true, "not valid timeout", 0},
+		{`rdns lb.rancher.cloud {
+			credentials
+		}`, true, "Wrong argument count", 0},
+		{`rdns lb.rancher.cloud {
+			credentials root
+		}`, true, "credentials requires 2 arguments", 0},
+		// the bad tls files fail when the Corefile is parsed, not at the first query
+		{`rdns lb.rancher.cloud {
+			tls /nonexistent/cert.pem /nonexistent/key.pem /nonexistent/ca.pem
+		}`, true, "no such file or directory", 0},
+		{`rdns lb.rancher.cloud {
+			tls a b c d
+		}`, true, "maximum of three arguments", 0},
+	}
+
+	for i, tt := range tests {
+		c := caddy.NewTestController("dns", tt.input)
+		e, err := etcdParse(c)
+
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("test %d: expected error, got none for input %s", i, tt.input)
+			} else if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("test %d: expected error containing %q, got %v", i, tt.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if e.Timeout != tt.timeout {
+			t.Errorf("test %d: expected timeout %s, got %s", i, tt.timeout, e.Timeout)
+		}
+		if len(e.endpoints) != 1 || e.endpoints[0] != defaultEndpoint {
+			t.Errorf("test %d: expected the default endpoint, got %v", i, e.endpoints)
+		}
+		e.Client.Close()
+	}
+}
+
+func TestSetupDefaultTTL(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		ttl       uint32
+	}{
+		{`rdns lb.rancher.cloud`, false, ttl},
+		{`rdns lb.rancher.cloud {
+			default_ttl 60
+		}`, false, 60},
+		{`rdns lb.rancher.cloud {
+			default_ttl 0
+		}`, true, 0},
+		{`rdns lb.rancher.cloud {
+			default_ttl 5m
+		}`, true, 0},
+	}
+
+	for i, tt := range tests {
+		c := caddy.NewTestController("dns", tt.input)
+		e, err := etcdParse(c)
+
+		if tt.shouldErr {
+			if err == nil || !strings.Contains(err.Error(), "not valid default_ttl") {
+				t.Errorf("test %d: expected the default_ttl rejected, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if e.DefaultTTL != tt.ttl {
+			t.Errorf("test %d: expected default ttl %d, got %d", i, tt.ttl, e.DefaultTTL)
+		}
+		e.Client.Close()
+	}
+}
+
+func TestSetupFallthroughTypes(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		types     []uint16
+		zones     []string
+	}{
+		{`rdns lb.rancher.cloud {
+			fallthrough
+		}`, false, nil, []string{"."}},
+		{`rdns lb.rancher.cloud {
+			fallthrough in-addr.arpa
+		}`, false, nil, []string{"in-addr.arpa."}},
+		{`rdns lb.rancher.cloud {
+			fallthrough types TXT caa
+		}`, false, []uint16{dns.TypeTXT, dns.TypeCAA}, []string{"."}},
+		{`rdns lb.rancher.cloud {
+			fallthrough types
+		}`, true, nil, nil},
+		{`rdns lb.rancher.cloud {
+			fallthrough types TXT NOPE
+		}`, true, nil, nil},
+	}
+
+	for i, tt := range tests {
+		c := caddy.NewTestController("dns", tt.input)
+		e, err := etcdParse(c)
+
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("test %d: expected error, got none for input %s", i, tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(e.FallTypes, tt.types) {
+			t.Errorf("test %d: expected the types %v, got %v", i, tt.types, e.FallTypes)
+		}
+		if !reflect.DeepEqual(e.Fall.Zones, tt.zones) {
+			t.Errorf("test %d: expected the zones %v, got %v", i, tt.zones, e.Fall.Zones)
+		}
+		e.Client.Close()
+	}
+}
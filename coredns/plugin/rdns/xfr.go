@@ -2,24 +2,219 @@ package rdns
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
 	"time"
 
-	"github.com/coredns/coredns/request"
+	"github.com/rancher/rdns-server/coredns/plugin"
+	"github.com/rancher/rdns-server/coredns/plugin/rdns/msg"
 
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/request"
+	etcdcv3 "github.com/coreos/etcd/clientv3"
 	"github.com/miekg/dns"
 )
 
-// Serial implements the Transferer interface.
+const (
+	transferLength = 60000 // Start a new envelope before the message reaches the 64k limit.
+	notifyTimeout  = 2 * time.Second
+)
+
+// Serial implements the Transferer interface. The serial is the latest etcd revision, so that it changes when records change.
 func (e *ETCD) Serial(state request.Request) uint32 {
-	return uint32(time.Now().Unix())
+	if e.cache != nil {
+		e.cache.RLock()
+		revision, synced := e.cache.revision, e.cache.synced
+		e.cache.RUnlock()
+		if synced {
+			return uint32(revision)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+
+	prefix, _ := e.zoneConfig(state.Name())
+	r, err := e.Client.Get(ctx, prefix, etcdcv3.WithCountOnly())
+	if err != nil {
+		return uint32(time.Now().Unix())
+	}
+	return uint32(r.Header.Revision)
 }
 
 // MinTTL implements the Transferer interface.
 func (e *ETCD) MinTTL(state request.Request) uint32 {
+	if e.Minimum > 0 {
+		return e.Minimum
+	}
 	return 30
 }
 
-// Transfer implements the Transferer interface.
+// SOA implements the Authority interface.
+func (e *ETCD) SOA(zone string, soa *dns.SOA) {
+	if e.Mname != "" {
+		soa.Ns = dns.Fqdn(e.Mname)
+	}
+	if e.Rname != "" {
+		soa.Mbox = dns.Fqdn(e.Rname)
+	}
+	if e.Refresh > 0 {
+		soa.Refresh = e.Refresh
+	}
+	if e.Retry > 0 {
+		soa.Retry = e.Retry
+	}
+	if e.Expire > 0 {
+		soa.Expire = e.Expire
+	}
+}
+
+// nsRecords returns the NS records of the zone apex from the configured name servers.
+func (e *ETCD) nsRecords(zone string, state request.Request) []dns.RR {
+	records := make([]dns.RR, 0, len(e.NameServers))
+	for _, ns := range e.NameServers {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: e.MinTTL(state)},
+			Ns:  dns.Fqdn(ns),
+		})
+	}
+	return records
+}
+
+// Transfer implements the Transferer interface. The zone is only transferred to the allowed networks,
+// it is sent in SOA-wrapped envelopes which are kept under the message size limit.
 func (e *ETCD) Transfer(ctx context.Context, state request.Request) (int, error) {
-	return dns.RcodeServerFailure, nil
+	if !e.transferAllowed(state) {
+		m := new(dns.Msg)
+		m.SetRcode(state.Req, dns.RcodeRefused)
+		state.W.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	}
+
+	zone := plugin.Zones(e.Zones).Matches(state.Name())
+	if zone != state.Name() {
+		return dns.RcodeNotAuth, nil
+	}
+
+	soa, err := plugin.SOA(ctx, e, zone, state, plugin.Options{})
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+
+	records, err := e.zoneRecords(ctx, zone)
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	records = append(append(soa, records...), soa...)
+
+	ch := make(chan *dns.Envelope)
+	tr := new(dns.Transfer)
+	go tr.Out(state.W, state.Req, ch)
+
+	log.Infof("Outgoing transfer of %d records of zone %s to %s started", len(records), zone, state.IP())
+	j, l := 0, 0
+	for i, r := range records {
+		l += dns.Len(r)
+		if l > transferLength {
+			ch <- &dns.Envelope{RR: records[j:i]}
+			l = dns.Len(r)
+			j = i
+		}
+	}
+	if j < len(records) {
+		ch <- &dns.Envelope{RR: records[j:]}
+	}
+	close(ch)
+
+	state.W.Hijack()
+	return dns.RcodeSuccess, nil
+}
+
+func (e *ETCD) transferAllowed(state request.Request) bool {
+	ip := net.ParseIP(state.IP())
+	for _, n := range e.TransferTo {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneRecords converts all kvs of the zone to the records, the owner name is the path without the key of the value,
+// e.g. /rdnsv3/cloud/rancher/lb/sample/1_1_1_1 => sample.lb.rancher.cloud.
+func (e *ETCD) zoneRecords(ctx context.Context, zone string) ([]dns.RR, error) {
+	prefix, _ := e.zoneConfig(zone)
+	r, err := e.get(ctx, msg.Path(zone, prefix), true)
+	if err == errKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reverse := dnsutil.IsReverse(zone) > 0
+	records := make([]dns.RR, 0, len(r.Kvs))
+	for _, kv := range r.Kvs {
+		serv := new(msg.Service)
+		if err := json.Unmarshal(kv.Value, serv); err != nil {
+			return nil, fmt.Errorf("%s: %s", kv.Key, err.Error())
+		}
+		serv.TTL = e.TTL(kv, serv)
+		if serv.Priority == 0 {
+			serv.Priority = priority
+		}
+
+		key := string(kv.Key)
+		segments := strings.Split(key, "/")
+		switch {
+		case len(segments) > 2 && strings.HasPrefix(segments[len(segments)-2], "_"):
+			// the typed records, e.g. /_txt/<hash>, /_caa/0 or /_mx/0
+			key = strings.Join(segments[:len(segments)-2], "/")
+		case serv.Text != "" && serv.Host == "":
+			// the legacy TXT record is stored at the path of the name itself
+		default:
+			key = strings.Join(segments[:len(segments)-1], "/")
+		}
+		name := msg.Domain(key)
+
+		switch {
+		case serv.Tag != "":
+			records = append(records, serv.NewCAA(name))
+		case serv.Mail && serv.Host != "":
+			records = append(records, serv.NewMX(name))
+		case serv.Text != "":
+			records = append(records, serv.NewTXT(name))
+		case serv.Host == "":
+			// the placeholder of the domain which has no hosts yet
+		case reverse:
+			records = append(records, serv.NewPTR(name, serv.Host))
+		default:
+			what, ip := serv.HostType()
+			switch what {
+			case dns.TypeA:
+				records = append(records, serv.NewA(name, ip))
+			case dns.TypeAAAA:
+				records = append(records, serv.NewAAAA(name, ip))
+			case dns.TypeCNAME:
+				records = append(records, serv.NewCNAME(name, serv.Host))
+			}
+		}
+	}
+	return records, nil
+}
+
+// notify sends the NOTIFY of the zones to the secondaries, so that they pull the changes.
+func (e *ETCD) notify() {
+	for _, zone := range e.Zones {
+		m := new(dns.Msg)
+		m.SetNotify(zone)
+		c := &dns.Client{Timeout: notifyTimeout}
+		for _, s := range e.Secondaries {
+			if _, _, err := c.Exchange(m, s); err != nil {
+				log.Warningf("failed to notify %s of zone %s: %v", s, zone, err)
+			}
+		}
+	}
 }
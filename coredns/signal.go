@@ -0,0 +1,60 @@
+package coredns
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mholt/caddy"
+	"github.com/sirupsen/logrus"
+)
+
+// exit is replaced by the tests
+var exit = os.Exit
+
+// Used to trap the signals which caddy.TrapSignals handles besides SIGTERM and SIGINT, those two are handled
+// by the api server to drain the in-flight requests before coredns is stopped.
+func trapSignals(instance *caddy.Instance) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1)
+
+	go func() {
+		for s := range sig {
+			instance = handleSignal(s, instance, reload)
+		}
+	}()
+}
+
+// Used to handle the signal as caddy does: SIGHUP is ignored, SIGQUIT quits immediately after the cleanups
+// and SIGUSR1 reloads the Corefile. Returns the instance which is running after the signal.
+func handleSignal(s os.Signal, instance *caddy.Instance, reload func(*caddy.Instance) (*caddy.Instance, error)) *caddy.Instance {
+	switch s {
+	case syscall.SIGHUP:
+		// ignore; this signal is sometimes sent outside of the user's control
+		logrus.Debugf("coredns ignored %s", s)
+	case syscall.SIGQUIT:
+		logrus.Infof("coredns received %s, quitting immediately", s)
+		for _, f := range caddy.OnProcessExit {
+			f()
+		}
+		exit(0)
+	case syscall.SIGUSR1:
+		logrus.Infof("coredns received %s, reloading the corefile", s)
+		i, err := reload(instance)
+		if err != nil {
+			logrus.Errorf("failed to reload coredns: %v", err)
+			return instance
+		}
+		return i
+	}
+	return instance
+}
+
+// Used to restart the instance with the current Corefile, the instance is kept if the restart fails
+func reload(instance *caddy.Instance) (*caddy.Instance, error) {
+	f, err := caddy.LoadCaddyfile(CoreType)
+	if err != nil {
+		return instance, err
+	}
+	return instance.Restart(f)
+}
@@ -0,0 +1,50 @@
+package coredns
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/mholt/caddy"
+)
+
+func TestHandleSignal(t *testing.T) {
+	instance := &caddy.Instance{}
+	restarted := &caddy.Instance{}
+
+	reloads := 0
+	reload := func(i *caddy.Instance) (*caddy.Instance, error) {
+		reloads++
+		return restarted, nil
+	}
+
+	if i := handleSignal(syscall.SIGHUP, instance, reload); i != instance || reloads != 0 {
+		t.Fatalf("expected SIGHUP ignored, got %d reloads", reloads)
+	}
+
+	if i := handleSignal(syscall.SIGUSR1, instance, reload); i != restarted || reloads != 1 {
+		t.Fatalf("expected SIGUSR1 to reload the instance, got %d reloads", reloads)
+	}
+
+	failed := func(i *caddy.Instance) (*caddy.Instance, error) {
+		return i, errors.New("invalid corefile")
+	}
+	if i := handleSignal(syscall.SIGUSR1, restarted, failed); i != restarted {
+		t.Fatal("expected the running instance kept when the reload fails")
+	}
+
+	code := -1
+	cleanups := 0
+	osExit := exit
+	exit = func(c int) { code = c }
+	caddy.OnProcessExit = append(caddy.OnProcessExit, func() { cleanups++ })
+	defer func() {
+		exit = osExit
+		caddy.OnProcessExit = caddy.OnProcessExit[:len(caddy.OnProcessExit)-1]
+	}()
+
+	handleSignal(syscall.SIGQUIT, instance, reload)
+	if code != 0 || cleanups != 1 || reloads != 1 {
+		t.Fatalf("expected SIGQUIT to exit after the cleanups, got code %d and %d cleanups", code, cleanups)
+	}
+}
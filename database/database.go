@@ -14,16 +14,24 @@ type Database interface {
 	InsertFrozen(prefix string) error
 	QueryFrozen(prefix string) (string, error)
 	RenewFrozen(prefix string) error
+	UpdateFrozen(prefix string, createdOn int64) error
 	DeleteFrozen(prefix string) error
 	DeleteExpiredFrozen(*time.Time) error
 	MigrateFrozen(prefix string, expiration int64) error
-	InsertToken(token, name string) (int64, error)
+	InsertToken(token, name string, leaseTime time.Duration) (int64, error)
 	QueryTokenCount() (int64, error)
+	QueryStats() (*model.Stats, error)
 	QueryToken(name string) (*model.Token, error)
-	QueryExpiredTokens(*time.Time) ([]*model.Token, error)
+	QueryExpiredTokens(now *time.Time, leaseTime time.Duration) ([]*model.Token, error)
 	RenewToken(name string) (int64, int64, error)
+	UpdateToken(token, name string) error
 	DeleteToken(prefix string) error
 	MigrateToken(token, name string, expiration int64) error
+	InsertIdempotency(r *model.Idempotency, expiration *time.Time) (bool, error)
+	UpdateIdempotency(*model.Idempotency) error
+	QueryIdempotency(key string) (*model.Idempotency, error)
+	DeleteIdempotency(key string) error
+	DeleteExpiredIdempotency(*time.Time) error
 	InsertA(*model.RecordA) (int64, error)
 	UpdateA(*model.RecordA) (int64, error)
 	QueryA(name string) (*model.RecordA, error)
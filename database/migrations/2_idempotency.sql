@@ -0,0 +1,15 @@
+-- +migrate Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS idempotency (
+    id INT AUTO_INCREMENT,
+    idempotency_key VARCHAR(255) NOT NULL UNIQUE,
+    hash VARCHAR(255) NOT NULL,
+    fqdn VARCHAR(255) NOT NULL,
+    created_on BIGINT NOT NULL,
+    PRIMARY KEY (id),
+    INDEX index_created_on_idempotency (created_on)
+) ENGINE=INNODB DEFAULT CHARSET=utf8;
+
+-- +migrate Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE IF EXISTS idempotency;
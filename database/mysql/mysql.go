@@ -2,10 +2,15 @@ package mysql
 
 import (
 	"database/sql"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rancher/rdns-server/model"
 
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
 	// in order to make build through
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -21,14 +26,26 @@ type Database struct {
 	Db *sql.DB
 }
 
+type options struct {
+	maxConns        int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
 func NewDatabase(dsn string) (*Database, error) {
+	dsn, opts, err := parseDSN(dsn)
+	if err != nil {
+		return &Database{}, err
+	}
+
 	db, err := sql.Open(DriverName, dsn)
 	if err != nil {
 		return &Database{}, err
 	}
 
-	db.SetMaxOpenConns(maxOpenConnections)
-	db.SetMaxIdleConns(maxIdleConnections)
+	db.SetMaxOpenConns(opts.maxConns)
+	db.SetMaxIdleConns(opts.maxIdleConns)
+	db.SetConnMaxLifetime(opts.connMaxLifetime)
 
 	if err := db.Ping(); err != nil {
 		return &Database{}, err
@@ -37,6 +54,54 @@ func NewDatabase(dsn string) (*Database, error) {
 	return &Database{db}, err
 }
 
+// Used to parse the pool options from the dsn query parameters, which are removed from the dsn
+// because the mysql driver treats the unknown parameters as system variables,
+// e.g. user:password@tcp(127.0.0.1:3306)/rdns?maxConns=100&maxIdleConns=50&connMaxLifetime=1h
+func parseDSN(dsn string) (string, *options, error) {
+	opts := &options{
+		maxConns:     maxOpenConnections,
+		maxIdleConns: maxIdleConnections,
+	}
+
+	i := strings.Index(dsn, "?")
+	if i < 0 {
+		logrus.Infof("database pool options: maxConns=%d, maxIdleConns=%d, connMaxLifetime=%s", opts.maxConns, opts.maxIdleConns, opts.connMaxLifetime)
+		return dsn, opts, nil
+	}
+
+	params := make([]string, 0)
+	for _, param := range strings.Split(dsn[i+1:], "&") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			params = append(params, param)
+			continue
+		}
+
+		var err error
+		switch kv[0] {
+		case "maxConns":
+			opts.maxConns, err = strconv.Atoi(kv[1])
+		case "maxIdleConns":
+			opts.maxIdleConns, err = strconv.Atoi(kv[1])
+		case "connMaxLifetime":
+			opts.connMaxLifetime, err = time.ParseDuration(kv[1])
+		default:
+			params = append(params, param)
+			continue
+		}
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "failed to parse dsn parameter: %s", kv[0])
+		}
+	}
+
+	logrus.Infof("database pool options: maxConns=%d, maxIdleConns=%d, connMaxLifetime=%s", opts.maxConns, opts.maxIdleConns, opts.connMaxLifetime)
+
+	if len(params) == 0 {
+		return dsn[:i], opts, nil
+	}
+	return dsn[:i] + "?" + strings.Join(params, "&"), opts, nil
+}
+
 func (d *Database) InsertFrozen(prefix string) error {
 	st, err := d.Db.Prepare("INSERT INTO frozen_prefix (prefix, created_on) VALUES ( ?, ? )")
 	if err != nil {
@@ -74,6 +139,17 @@ func (d *Database) RenewFrozen(prefix string) error {
 	return err
 }
 
+func (d *Database) UpdateFrozen(prefix string, createdOn int64) error {
+	st, err := d.Db.Prepare("INSERT INTO frozen_prefix (prefix, created_on) VALUES ( ?, ? ) ON DUPLICATE KEY UPDATE created_on = ?")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	_, err = st.Exec(prefix, createdOn, createdOn)
+	return err
+}
+
 func (d *Database) DeleteFrozen(prefix string) error {
 	st, err := d.Db.Prepare("DELETE FROM frozen_prefix WHERE prefix = ?")
 	if err != nil {
@@ -107,14 +183,14 @@ func (d *Database) MigrateFrozen(prefix string, expiration int64) error {
 	return err
 }
 
-func (d *Database) InsertToken(token, name string) (int64, error) {
-	st, err := d.Db.Prepare("INSERT INTO token (token, fqdn, created_on) VALUES( ?, ?, ? )")
+func (d *Database) InsertToken(token, name string, leaseTime time.Duration) (int64, error) {
+	st, err := d.Db.Prepare("INSERT INTO token (token, fqdn, created_on, lease_time) VALUES( ?, ?, ?, ? )")
 	if err != nil {
 		return 0, err
 	}
 	defer st.Close()
 
-	resp, err := st.Exec(token, name, time.Now().UnixNano())
+	resp, err := st.Exec(token, name, time.Now().UnixNano(), leaseTime.Nanoseconds())
 	if err != nil {
 		return 0, err
 	}
@@ -137,6 +213,38 @@ func (d *Database) QueryTokenCount() (int64, error) {
 	return result, nil
 }
 
+func (d *Database) QueryStats() (*model.Stats, error) {
+	s := &model.Stats{
+		Records: make(map[string]int64, 0),
+	}
+
+	tables := map[string]string{
+		"token":         "token",
+		"frozen_prefix": "frozen",
+		"record_a":      "A",
+		"sub_record_a":  "SUB",
+		"record_txt":    "TXT",
+		"record_cname":  "CNAME",
+	}
+
+	for table, key := range tables {
+		var result int64
+		if err := d.Db.QueryRow("SELECT count(*) FROM " + table).Scan(&result); err != nil {
+			return s, err
+		}
+		switch key {
+		case "token":
+			s.Tokens = result
+		case "frozen":
+			s.Frozen = result
+		default:
+			s.Records[key] = result
+		}
+	}
+
+	return s, nil
+}
+
 func (d *Database) QueryToken(name string) (*model.Token, error) {
 	r := &model.Token{}
 	st, err := d.Db.Prepare("SELECT * FROM token WHERE fqdn = ?")
@@ -145,29 +253,30 @@ func (d *Database) QueryToken(name string) (*model.Token, error) {
 	}
 	defer st.Close()
 
-	if err := st.QueryRow(name).Scan(&r.ID, &r.Token, &r.Fqdn, &r.CreatedOn); err != nil {
+	if err := st.QueryRow(name).Scan(&r.ID, &r.Token, &r.Fqdn, &r.CreatedOn, &r.LeaseTime); err != nil {
 		return r, err
 	}
 
 	return r, nil
 }
 
-func (d *Database) QueryExpiredTokens(t *time.Time) ([]*model.Token, error) {
+// the token without its own lease time expires after the default lease time
+func (d *Database) QueryExpiredTokens(now *time.Time, leaseTime time.Duration) ([]*model.Token, error) {
 	result := make([]*model.Token, 0)
-	st, err := d.Db.Prepare("SELECT * FROM token WHERE created_on <= ?")
+	st, err := d.Db.Prepare("SELECT * FROM token WHERE created_on + IF(lease_time > 0, lease_time, ?) <= ?")
 	if err != nil {
 		return result, err
 	}
 	defer st.Close()
 
-	rows, err := st.Query(t.UnixNano())
+	rows, err := st.Query(leaseTime.Nanoseconds(), now.UnixNano())
 	if err != nil {
 		return result, err
 	}
 
 	for rows.Next() {
 		temp := &model.Token{}
-		if err := rows.Scan(&temp.ID, &temp.Token, &temp.Fqdn, &temp.CreatedOn); err != nil {
+		if err := rows.Scan(&temp.ID, &temp.Token, &temp.Fqdn, &temp.CreatedOn, &temp.LeaseTime); err != nil {
 			return result, err
 		}
 		result = append(result, temp)
@@ -197,6 +306,17 @@ func (d *Database) RenewToken(name string) (int64, int64, error) {
 	return id, t, nil
 }
 
+func (d *Database) UpdateToken(token, name string) error {
+	st, err := d.Db.Prepare("UPDATE token SET token = ? WHERE fqdn = ?")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	_, err = st.Exec(token, name)
+	return err
+}
+
 func (d *Database) DeleteToken(token string) error {
 	st, err := d.Db.Prepare("DELETE FROM token WHERE token = ?")
 	if err != nil {
@@ -223,6 +343,80 @@ func (d *Database) MigrateToken(token, name string, expiration int64) error {
 	return nil
 }
 
+// InsertIdempotency inserts the key atomically, the existing row is only replaced if it is created before the expiration.
+// Returns false if the key is held by another row.
+func (d *Database) InsertIdempotency(r *model.Idempotency, expiration *time.Time) (bool, error) {
+	st, err := d.Db.Prepare("INSERT INTO idempotency (idempotency_key, hash, fqdn, created_on) VALUES ( ?, ?, ?, ? ) " +
+		"ON DUPLICATE KEY UPDATE hash = IF(created_on <= ?, VALUES(hash), hash), fqdn = IF(created_on <= ?, VALUES(fqdn), fqdn), created_on = IF(created_on <= ?, VALUES(created_on), created_on)")
+	if err != nil {
+		return false, err
+	}
+	defer st.Close()
+
+	e := expiration.UnixNano()
+	r.CreatedOn = time.Now().UnixNano()
+	result, err := st.Exec(r.Key, r.Hash, r.Fqdn, r.CreatedOn, e, e, e)
+	if err != nil {
+		return false, err
+	}
+
+	// 1 for the inserted row, 2 for the replaced expired row and 0 for the row which is kept
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (d *Database) UpdateIdempotency(r *model.Idempotency) error {
+	st, err := d.Db.Prepare("UPDATE idempotency SET fqdn = ? WHERE idempotency_key = ?")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	_, err = st.Exec(r.Fqdn, r.Key)
+	return err
+}
+
+func (d *Database) QueryIdempotency(key string) (*model.Idempotency, error) {
+	r := &model.Idempotency{}
+	st, err := d.Db.Prepare("SELECT * FROM idempotency WHERE idempotency_key = ?")
+	if err != nil {
+		return r, err
+	}
+	defer st.Close()
+
+	if err := st.QueryRow(key).Scan(&r.ID, &r.Key, &r.Hash, &r.Fqdn, &r.CreatedOn); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+func (d *Database) DeleteIdempotency(key string) error {
+	st, err := d.Db.Prepare("DELETE FROM idempotency WHERE idempotency_key = ?")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	_, err = st.Exec(key)
+	return err
+}
+
+func (d *Database) DeleteExpiredIdempotency(t *time.Time) error {
+	st, err := d.Db.Prepare("DELETE FROM idempotency WHERE created_on <= ?")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	_, err = st.Exec(t.UnixNano())
+	return err
+}
+
 func (d *Database) InsertA(a *model.RecordA) (int64, error) {
 	st, err := d.Db.Prepare("INSERT INTO record_a (fqdn, type, content, created_on, tid) VALUES (?, ?, ?, ?, ?)")
 	if err != nil {
@@ -299,7 +493,7 @@ func (d *Database) InsertSubA(a *model.SubRecordA) (int64, error) {
 }
 
 func (d *Database) UpdateSubA(a *model.SubRecordA) (int64, error) {
-	st, err := d.Db.Prepare("UPDATE sub_record_a SET type = ?, content = ?, created_on = ?, pid = ? WHERE fqdn = ?")
+	st, err := d.Db.Prepare("UPDATE sub_record_a SET type = ?, content = ?, updated_on = ?, pid = ? WHERE fqdn = ?")
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,103 @@
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const auditSize = 4096
+
+var (
+	auditLock   sync.RWMutex
+	auditQueues []chan Event
+
+	auditDropCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rancher_dns_audit_dropped_total",
+		Help: "The number of the audit events dropped because the audit sink is too slow",
+	})
+)
+
+// Sink writes the audit events, e.g. to a file, stdout or a webhook.
+type Sink interface {
+	Write(e Event) error
+}
+
+type writerSink struct {
+	w io.Writer
+}
+
+// NewWriterSink returns a sink which writes every event as a JSON line.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "%s\n", b)
+	return err
+}
+
+// NewAuditSink returns the stdout sink for "-", otherwise the file sink which appends to the path.
+func NewAuditSink(path string) (Sink, error) {
+	if path == "-" {
+		return NewWriterSink(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterSink(f), nil
+}
+
+// StartSinkDaemon writes the published events to the sink until done, the publishers never wait for the sink,
+// the events are dropped and counted when the queue of the sink is full.
+func StartSinkDaemon(done chan struct{}, sink Sink) {
+	q := make(chan Event, auditSize)
+	auditLock.Lock()
+	auditQueues = append(auditQueues, q)
+	auditLock.Unlock()
+
+	for {
+		select {
+		case <-done:
+			return
+		case e := <-q:
+			if err := sink.Write(e); err != nil {
+				logrus.Errorf("failed to write audit event %d: %v", e.ID, err)
+			}
+		}
+	}
+}
+
+// Fingerprint returns a short hash of the token, so that the token is never logged as it is.
+func Fingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+func audit(e Event) {
+	auditLock.RLock()
+	defer auditLock.RUnlock()
+
+	for _, q := range auditQueues {
+		select {
+		case q <- e:
+		default:
+			auditDropCounter.Inc()
+		}
+	}
+}
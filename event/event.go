@@ -0,0 +1,123 @@
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	TypeCreated     = "created"
+	TypeUpdated     = "updated"
+	TypeDeleted     = "deleted"
+	TypeRenewed     = "renewed"
+	TypeTransferred = "transferred"
+	TypeReleased    = "released"
+	TypePurged      = "purged"
+
+	bufferSize     = 1024
+	subscriberSize = 64
+)
+
+var (
+	lock        sync.Mutex
+	lastID      int64
+	buffer      = make([]Event, 0, bufferSize)
+	subscribers = make(map[chan Event]struct{})
+)
+
+type Event struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	Fqdn      string    `json:"fqdn"`
+	ValueType string    `json:"valueType"`
+	Token     string    `json:"token,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Publish sends the event to all subscribers, the slow subscriber is disconnected instead of blocking the publisher.
+func Publish(eventType, fqdn, valueType string) {
+	PublishBy(eventType, fqdn, valueType, "", "", "")
+}
+
+// PublishBy publishes the event with the token, the source address and the id of the request,
+// only the fingerprint of the token is kept in the event.
+func PublishBy(eventType, fqdn, valueType, token, source, requestID string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	lastID++
+	e := Event{
+		ID:        lastID,
+		Type:      eventType,
+		Fqdn:      fqdn,
+		ValueType: valueType,
+		Token:     Fingerprint(token),
+		Source:    source,
+		RequestID: requestID,
+		Timestamp: time.Now(),
+	}
+
+	audit(e)
+
+	if len(buffer) >= bufferSize {
+		buffer = buffer[1:]
+	}
+	buffer = append(buffer, e)
+
+	for c := range subscribers {
+		select {
+		case c <- e:
+		default:
+			logrus.Warnf("event subscriber is too slow, disconnect it")
+			delete(subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// Subscribe returns the buffered events after lastEventID and a channel of the new events,
+// the channel is closed when the subscriber is disconnected.
+func Subscribe(lastEventID int64) ([]Event, chan Event) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	missed := make([]Event, 0)
+	if lastEventID > 0 {
+		for _, e := range buffer {
+			if e.ID > lastEventID {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	c := make(chan Event, subscriberSize)
+	subscribers[c] = struct{}{}
+
+	return missed, c
+}
+
+// Unsubscribe removes the subscriber if it is not disconnected yet.
+func Unsubscribe(c chan Event) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := subscribers[c]; ok {
+		delete(subscribers, c)
+		close(c)
+	}
+}
+
+// CloseSubscribers disconnects all subscribers, so that their streams are not holding the server on shutdown.
+func CloseSubscribers() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for c := range subscribers {
+		delete(subscribers, c)
+		close(c)
+	}
+}
@@ -0,0 +1,98 @@
+package event
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	signatureHeader = "X-RDNS-Signature"
+	webhookTimeout  = 5 * time.Second
+	webhookBackoff  = time.Second
+)
+
+type webhookSink struct {
+	url      string
+	secret   []byte
+	types    map[string]bool
+	attempts int
+	client   *http.Client
+}
+
+// NewWebhookSink returns a sink which posts the events of the value types to the url, all value types are posted if types is empty.
+// The body is signed with the HMAC-SHA256 of the secret, the failed delivery is retried with exponential backoff.
+func NewWebhookSink(url, secret string, types []string, attempts int) Sink {
+	s := &webhookSink{
+		url:      url,
+		secret:   []byte(secret),
+		types:    make(map[string]bool, len(types)),
+		attempts: attempts,
+		client:   &http.Client{Timeout: webhookTimeout},
+	}
+	for _, t := range types {
+		if t = strings.TrimSpace(t); t != "" {
+			s.types[strings.ToUpper(t)] = true
+		}
+	}
+	return s
+}
+
+func (s *webhookSink) Write(e Event) error {
+	if len(s.types) > 0 && !s.types[strings.ToUpper(e.ValueType)] {
+		return nil
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := webhookBackoff
+	for i := 1; i <= s.attempts; i++ {
+		if err = s.post(body); err == nil {
+			return nil
+		}
+		logrus.Warnf("failed to deliver event %d to webhook, attempt %d/%d: %v", e.ID, i, s.attempts, err)
+		if i < s.attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	// dead letter, the event is kept in the log so that it can be replayed by hand
+	logrus.WithField("event", string(body)).Errorf("gave up delivering event %d to webhook", e.ID)
+	return err
+}
+
+func (s *webhookSink) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) > 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(body)
+		req.Header.Set(signatureHeader, fmt.Sprintf("sha256=%x", mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rancher/rdns-server/backend"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	lock    sync.RWMutex
+	lastErr = errors.New("backend is not probed yet")
+
+	readyGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_dns_ready",
+		Help: "Whether the backend of the rancher dns server is healthy (1) or not (0)",
+	})
+)
+
+// StartHealthDaemon probes the backend every interval, so that the readiness follows the backend state.
+func StartHealthDaemon(done chan struct{}, interval time.Duration) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			probe()
+			time.Sleep(interval)
+		}
+	}
+}
+
+// Ready returns the error of the latest probe, nil means the backend is healthy.
+func Ready() error {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return lastErr
+}
+
+func probe() {
+	err := backend.GetBackend().Healthy()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err != nil && lastErr == nil {
+		logrus.Warnf("backend becomes unhealthy: %v", err)
+	}
+	if err == nil && lastErr != nil {
+		logrus.Infof("backend becomes healthy")
+	}
+
+	lastErr = err
+	if err != nil {
+		readyGauge.Set(0)
+	} else {
+		readyGauge.Set(1)
+	}
+}
@@ -0,0 +1,104 @@
+package loglevel
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rancher/rdns-server/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	lock     sync.Mutex
+	base     = logrus.InfoLevel
+	deadline *time.Time
+	timer    *time.Timer
+
+	levelGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rancher_dns_log_level",
+		Help: "The current log level of the rancher dns server",
+	}, []string{"level"})
+)
+
+// SetBase sets the level which is reverted to when a temporary level expires.
+func SetBase(level logrus.Level) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	base = level
+	if deadline == nil {
+		setLevel(level)
+	}
+}
+
+// Set changes the log level, the level is reverted to the base level after ttl if ttl is greater than zero.
+func Set(level logrus.Level, ttl time.Duration) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+		timer = nil
+	}
+	deadline = nil
+
+	if ttl > 0 {
+		d := time.Now().Add(ttl)
+		deadline = &d
+		timer = time.AfterFunc(ttl, revert)
+	} else {
+		base = level
+	}
+
+	setLevel(level)
+	logrus.Infof("log level is set to %s", level.String())
+}
+
+// Get returns the current log level and the revert deadline.
+func Get() model.LogLevel {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return model.LogLevel{
+		Level:    logrus.GetLevel().String(),
+		Deadline: deadline,
+	}
+}
+
+// StartSignalDaemon switches to debug level for the duration when SIGUSR1 is received.
+func StartSignalDaemon(done chan struct{}, duration time.Duration) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-c:
+			Set(logrus.DebugLevel, duration)
+		}
+	}
+}
+
+func revert() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	timer = nil
+	deadline = nil
+	setLevel(base)
+	logrus.Infof("log level is reverted to %s", base.String())
+}
+
+func setLevel(level logrus.Level) {
+	logrus.SetLevel(level)
+	levelGauge.Reset()
+	levelGauge.WithLabelValues(level.String()).Set(1)
+}
@@ -33,6 +33,12 @@ func main() {
 			EnvVar: "DEBUG",
 			Usage:  "used to set debug mode.",
 		},
+		cli.StringFlag{
+			Name:   "debug_duration",
+			EnvVar: "DEBUG_DURATION",
+			Usage:  "used to set the duration of debug mode which is switched on by SIGUSR1.",
+			Value:  "10m",
+		},
 		cli.StringFlag{
 			Name:   "listen",
 			EnvVar: "LISTEN",
@@ -45,6 +51,100 @@ func main() {
 			Usage:  "used to set the duration when the domain name can be used again.",
 			Value:  "2160h",
 		},
+		cli.StringFlag{
+			Name:   "audit_log",
+			EnvVar: "AUDIT_LOG",
+			Usage:  "used to set the file which the record changes are audited to, - means stdout, empty disables it.",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "webhook_url",
+			EnvVar: "WEBHOOK_URL",
+			Usage:  "used to set the url which the record changes are posted to, empty disables it.",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "webhook_secret",
+			EnvVar: "WEBHOOK_SECRET",
+			Usage:  "used to set the secret which signs the webhook body in the X-RDNS-Signature header.",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "webhook_types",
+			EnvVar: "WEBHOOK_TYPES",
+			Usage:  "used to set the comma separated record types which are posted to the webhook, empty means all.",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "webhook_attempts",
+			EnvVar: "WEBHOOK_ATTEMPTS",
+			Usage:  "used to set the attempts of delivering an event to the webhook.",
+			Value:  "5",
+		},
+		cli.StringFlag{
+			Name:   "probe_interval",
+			EnvVar: "PROBE_INTERVAL",
+			Usage:  "used to set the interval of probing the backend for readiness.",
+			Value:  "10s",
+		},
+		cli.StringFlag{
+			Name:   "rate_limit",
+			EnvVar: "RATE_LIMIT",
+			Usage:  "used to set the requests per second of the mutating requests with the same token, 0 disables it.",
+			Value:  "5",
+		},
+		cli.StringFlag{
+			Name:   "rate_burst",
+			EnvVar: "RATE_BURST",
+			Usage:  "used to set the burst of the mutating requests with the same token.",
+			Value:  "10",
+		},
+		cli.StringFlag{
+			Name:   "admin_token",
+			EnvVar: "ADMIN_TOKEN",
+			Usage:  "used to set the bearer token of the admin api, empty disables the admin api.",
+		},
+		cli.BoolFlag{
+			Name:   "read_only",
+			EnvVar: "READ_ONLY",
+			Usage:  "used to start in read-only mode, the mutating requests are rejected until it is switched off by the admin api.",
+		},
+		cli.BoolFlag{
+			Name:   "legacy_errors",
+			EnvVar: "LEGACY_ERRORS",
+			Usage:  "used to return the legacy error body without the error code, details and request id.",
+		},
+		cli.StringFlag{
+			Name:   "reuse_windows",
+			EnvVar: "REUSE_WINDOWS",
+			Usage:  "used to set the windows when the result of a listing is reused, e.g. subdomains=2s,stats=2s,tokens=2s,get=0s.",
+		},
+		cli.StringFlag{
+			Name:   "shutdown_timeout",
+			EnvVar: "SHUTDOWN_TIMEOUT",
+			Usage:  "used to set the duration of draining the in-flight requests on SIGTERM or SIGINT.",
+			Value:  "30s",
+		},
+		cli.StringFlag{
+			Name:   "grpc_listen",
+			EnvVar: "GRPC_LISTEN",
+			Usage:  "used to set listen port of the grpc api, empty disables the grpc api.",
+		},
+		cli.StringFlag{
+			Name:   "grpc_tls_cert",
+			EnvVar: "GRPC_TLS_CERT",
+			Usage:  "used to set the cert file of the grpc api, empty serves it without tls.",
+		},
+		cli.StringFlag{
+			Name:   "grpc_tls_key",
+			EnvVar: "GRPC_TLS_KEY",
+			Usage:  "used to set the key file of the grpc api.",
+		},
+		cli.StringFlag{
+			Name:   "grpc_tls_ca",
+			EnvVar: "GRPC_TLS_CA",
+			Usage:  "used to set the ca file which the client certs of the grpc api are verified with, empty does not verify the client certs.",
+		},
 	}
 	app.Commands = []cli.Command{
 		{
@@ -53,6 +153,14 @@ func main() {
 			Usage:   "use aws route53 backend",
 			Flags:   route53.Flags(),
 			Action:  route53.Action,
+			Subcommands: []cli.Command{
+				{
+					Name:   "check",
+					Usage:  "check the connectivity and schema of route53 backend",
+					Flags:  route53.CheckFlags(),
+					Action: route53.CheckAction,
+				},
+			},
 		},
 		{
 			Name:    "etcdv3",
@@ -60,6 +168,26 @@ func main() {
 			Usage:   "use etcd-v3 backend",
 			Flags:   etcdv3.Flags(),
 			Action:  etcdv3.Action,
+			Subcommands: []cli.Command{
+				{
+					Name:   "check",
+					Usage:  "check the connectivity and data of etcd-v3 backend",
+					Flags:  etcdv3.CheckFlags(),
+					Action: etcdv3.CheckAction,
+				},
+				{
+					Name:  "migrations",
+					Usage: "manage the startup migrations of etcd-v3 backend",
+					Subcommands: []cli.Command{
+						{
+							Name:   "status",
+							Usage:  "list the applied and pending migrations",
+							Flags:  etcdv3.Flags(),
+							Action: etcdv3.MigrationStatusAction,
+						},
+					},
+				},
+			},
 		},
 	}
 	if err := app.Run(os.Args); err != nil {
@@ -75,7 +203,7 @@ func beforeFunc(c *cli.Context) error {
 }
 
 func versionPrinter(c *cli.Context) {
-	if _, err := fmt.Fprintf(c.App.Writer, DNSVersion); err != nil {
+	if _, err := fmt.Fprint(c.App.Writer, DNSVersion); err != nil {
 		logrus.Error(err)
 	}
 }
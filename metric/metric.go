@@ -1,9 +1,12 @@
 package metric
 
 import (
+	"sync"
 	"time"
 
 	"github.com/rancher/rdns-server/backend"
+	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/util"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -12,11 +15,29 @@ import (
 
 var (
 	queryDuration = 5 * time.Second
+	statsDuration = 1 * time.Minute
+	reuseDuration = 2 * time.Second
+
+	// flight shares the result of the counting calls, which may scan the whole backend
+	flight util.Group
 
 	tokenGauge = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "rancher_dns_tokens",
 		Help: "The number of the rancher dns tokens",
 	})
+
+	frozenGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_dns_frozen",
+		Help: "The number of the rancher dns frozen prefixes",
+	})
+
+	recordGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rancher_dns_records",
+		Help: "The number of the rancher dns records",
+	}, []string{"type"})
+
+	statsLock   sync.RWMutex
+	cachedStats = &model.Stats{Records: make(map[string]int64, 0)}
 )
 
 func StartMetricDaemon(done chan struct{}) {
@@ -25,7 +46,7 @@ func StartMetricDaemon(done chan struct{}) {
 		case <-done:
 			return
 		default:
-			count, err := backend.GetBackend().GetTokenCount()
+			count, err := GetTokenCount()
 			if err != nil {
 				logrus.Errorf("failed to count token numbers: %s", err.Error())
 			}
@@ -34,3 +55,60 @@ func StartMetricDaemon(done chan struct{}) {
 		}
 	}
 }
+
+// StartStatsDaemon refreshes the cached stats periodically, so that the stats api has no need to scan the backend.
+func StartStatsDaemon(done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			refreshStats()
+			time.Sleep(statsDuration)
+		}
+	}
+}
+
+// GetStats returns the latest cached stats.
+func GetStats() model.Stats {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+
+	s := *cachedStats
+	s.Records = make(map[string]int64, len(cachedStats.Records))
+	for k, v := range cachedStats.Records {
+		s.Records[k] = v
+	}
+	return s
+}
+
+// GetTokenCount returns the token count, the concurrent calls share one backend call.
+func GetTokenCount() (int64, error) {
+	v, err := flight.Do("tokens", util.ReuseWindow("tokens", reuseDuration), func() (interface{}, error) {
+		return backend.GetBackend().GetTokenCount()
+	})
+	count, _ := v.(int64)
+	return count, err
+}
+
+func refreshStats() {
+	v, err := flight.Do("stats", util.ReuseWindow("stats", reuseDuration), func() (interface{}, error) {
+		return backend.GetBackend().GetStats()
+	})
+	s, _ := v.(*model.Stats)
+	if err != nil {
+		logrus.Errorf("failed to refresh stats: %s", err.Error())
+		return
+	}
+	now := time.Now()
+	s.UpdatedOn = &now
+
+	frozenGauge.Set(float64(s.Frozen))
+	for k, v := range s.Records {
+		recordGauge.WithLabelValues(k).Set(float64(v))
+	}
+
+	statsLock.Lock()
+	cachedStats = s
+	statsLock.Unlock()
+}
@@ -0,0 +1,87 @@
+package migration
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var migrations = make([]Migration, 0)
+
+// Migration is a one-time data fix which is applied in the order of version.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func() error
+}
+
+// Store persists the version of the latest applied migration.
+type Store interface {
+	GetMigrationVersion() (int64, error)
+	SetMigrationVersion(version int64) error
+}
+
+type Status struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+// Register adds the migration, the migration of the same version replaces the registered one.
+func Register(m Migration) {
+	for i := range migrations {
+		if migrations[i].Version == m.Version {
+			migrations[i] = m
+			return
+		}
+	}
+	migrations = append(migrations, m)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+// Run applies the migrations which are newer than the stored version, a failed migration aborts the rest.
+func Run(s Store) error {
+	current, err := s.GetMigrationVersion()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get migration version")
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		logrus.Infof("applying migration %d: %s", m.Version, m.Name)
+		if err := m.Up(); err != nil {
+			return errors.Wrapf(err, "failed to apply migration %d: %s", m.Version, m.Name)
+		}
+
+		if err := s.SetMigrationVersion(m.Version); err != nil {
+			return errors.Wrapf(err, "failed to set migration version %d: %s", m.Version, m.Name)
+		}
+	}
+
+	return nil
+}
+
+// List returns all the registered migrations with whether they are applied or pending.
+func List(s Store) ([]Status, error) {
+	current, err := s.GetMigrationVersion()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get migration version")
+	}
+
+	result := make([]Status, 0)
+	for _, m := range migrations {
+		result = append(result, Status{
+			Version: m.Version,
+			Name:    m.Name,
+			Applied: m.Version <= current,
+		})
+	}
+
+	return result, nil
+}
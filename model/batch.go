@@ -0,0 +1,58 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RecordsBatch upserts the records of a domain in one request, the hosts are replaced when they are given,
+// the sub domains and the text records which are not in the batch are kept.
+type RecordsBatch struct {
+	Hosts     []string            `json:"hosts"`
+	SubDomain map[string][]string `json:"subdomain"`
+	Texts     []TextRecord        `json:"texts"`
+	TTL       int64               `json:"ttl"`
+}
+
+// TextRecord is a TXT value below the domain, e.g. {"fqdn": "_acme-challenge.xxxxxx.lb.rancher.cloud", "text": "abc"}.
+type TextRecord struct {
+	Fqdn string `json:"fqdn"`
+	Text string `json:"text"`
+}
+
+func ParseRecordsBatch(r *http.Request) (*RecordsBatch, error) {
+	var batch RecordsBatch
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&batch)
+	return &batch, err
+}
+
+// BatchError is returned when some records of a batch are failed, the other records are applied.
+// The errors are keyed by the fqdn of the failed record.
+type BatchError struct {
+	Errors map[string]error
+}
+
+func (e *BatchError) Add(fqdn string, err error) {
+	if e.Errors == nil {
+		e.Errors = make(map[string]error)
+	}
+	e.Errors[fqdn] = err
+}
+
+func (e *BatchError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for k := range e.Errors {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	ss := make([]string, 0, len(names))
+	for _, k := range names {
+		ss = append(ss, fmt.Sprintf("%s: %v", k, e.Errors[k]))
+	}
+	return fmt.Sprintf("%d records of the batch are failed: %s", len(ss), strings.Join(ss, "; "))
+}
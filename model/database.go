@@ -1,12 +1,20 @@
 package model
 
-import "database/sql"
+import (
+	"database/sql"
+	"time"
+)
+
+// IdempotencyExpiration is how long an idempotency key is remembered.
+const IdempotencyExpiration = 24 * time.Hour
 
 type Token struct {
 	ID        int64  `db:"id"`
 	Token     string `db:"token"`
 	Fqdn      string `db:"fqdn"`
 	CreatedOn int64  `db:"created_on"`
+	// zero means the default lease time
+	LeaseTime int64 `db:"lease_time"`
 }
 
 type FrozenPrefix struct {
@@ -15,6 +23,14 @@ type FrozenPrefix struct {
 	CreatedOn int64  `db:"created_on"`
 }
 
+type Idempotency struct {
+	ID        int64  `db:"id"`
+	Key       string `db:"idempotency_key"`
+	Hash      string `db:"hash"`
+	Fqdn      string `db:"fqdn"`
+	CreatedOn int64  `db:"created_on"`
+}
+
 type RecordA struct {
 	ID        int64         `db:"id"`
 	Fqdn      string        `db:"fqdn"`
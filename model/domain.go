@@ -12,11 +12,35 @@ type Domain struct {
 	Hosts      []string            `json:"hosts,omitempty"`
 	SubDomain  map[string][]string `json:"subdomain,omitempty"`
 	Text       string              `json:"text,omitempty"`
+	Texts      []string            `json:"texts,omitempty"`
 	CNAME      string              `json:"cname,omitempty"`
+	CAA        []CAA               `json:"caa,omitempty"`
+	MX         []MX                `json:"mx,omitempty"`
+	TTL        int64               `json:"ttl,omitempty"`
+	Owner      string              `json:"owner,omitempty"`
 	Expiration *time.Time          `json:"expiration,omitempty"`
+	// the sub domains with their details, only returned by the sub domains api
+	SubDomains []SubDomain `json:"subdomains,omitempty"`
+	// the token given to the client, which is only known on creation and transfer when the tokens are hashed at rest
+	Token string `json:"-"`
+}
+
+// SubDomain is a child of the domain, which is answered by the wildcard of the domain when it has no hosts.
+type SubDomain struct {
+	Name     string   `json:"name"`
+	Hosts    []string `json:"hosts"`
+	Wildcard bool     `json:"wildcard"`
+	// nil if the backend does not keep the creation time
+	CreatedOn *time.Time `json:"createdOn,omitempty"`
 }
 
 func (d *Domain) String() string {
+	if len(d.MX) > 0 {
+		return fmt.Sprintf("{Fqdn: %s, MX: %s, Expiration: %s}", d.Fqdn, d.MX, d.Expiration.Format(time.RFC3339Nano))
+	}
+	if len(d.CAA) > 0 {
+		return fmt.Sprintf("{Fqdn: %s, CAA: %s, Expiration: %s}", d.Fqdn, d.CAA, d.Expiration.Format(time.RFC3339Nano))
+	}
 	if d.CNAME != "" {
 		return fmt.Sprintf("{Fqdn: %s, CNAME: %s, Expiration: %s}", d.Fqdn, d.CNAME, d.Expiration.Format(time.RFC3339Nano))
 	}
@@ -35,10 +59,28 @@ type DomainOptions struct {
 	SubDomain map[string][]string `json:"subdomain"`
 	Text      string              `json:"text"`
 	CNAME     string              `json:"cname"`
+	CAA       []CAA               `json:"caa"`
+	MX        []MX                `json:"mx"`
+	TTL       int64               `json:"ttl"`
 	Normal    bool                `json:"normal"`
+	Scopes    []string            `json:"scopes"`
+	// the owner of the domain, e.g. the team which holds the token, it can be changed by a transfer
+	Owner string `json:"owner"`
+	// the lease time of the token created with the domain, e.g. 6h, empty means the default lease time
+	LeaseTime string `json:"leaseTime"`
+	// the reserved prefix which the domain is created with, the request must carry the token of the reservation
+	Prefix string `json:"prefix"`
+	// the stored token which the prefix is reserved by, it is matched by the service and never read from the request
+	Reservation string `json:"-"`
 }
 
 func (d *DomainOptions) String() string {
+	if len(d.MX) > 0 {
+		return fmt.Sprintf("{Fqdn: %s, MX: %s}", d.Fqdn, d.MX)
+	}
+	if len(d.CAA) > 0 {
+		return fmt.Sprintf("{Fqdn: %s, CAA: %s}", d.Fqdn, d.CAA)
+	}
 	if d.CNAME != "" {
 		return fmt.Sprintf("{Fqdn: %s, CNAME: %s}", d.Fqdn, d.CNAME)
 	}
@@ -51,6 +93,109 @@ func (d *DomainOptions) String() string {
 	return fmt.Sprintf("{Fqdn: %s, Hosts: %s}", d.Fqdn, d.Hosts)
 }
 
+// CAA is the certification authority authorization record of RFC 8659,
+// e.g. {"flag": 0, "tag": "issue", "value": "letsencrypt.org"}.
+type CAA struct {
+	Flag  uint8  `json:"flag"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+func (c CAA) String() string {
+	return fmt.Sprintf("%d %s \"%s\"", c.Flag, c.Tag, c.Value)
+}
+
+// MX is the mail exchange record, the host must be a domain name,
+// e.g. {"preference": 10, "host": "mail.example.com"}.
+type MX struct {
+	Preference uint16 `json:"preference"`
+	Host       string `json:"host"`
+}
+
+func (m MX) String() string {
+	return fmt.Sprintf("%d %s", m.Preference, m.Host)
+}
+
+// TokenScopes are the record types which a token can be restricted to, the token without scopes has full access.
+var TokenScopes = []string{"a", "cname", "txt", "caa", "mx"}
+
+// TokenLimits override the limits of the deployment for a token, the zero values keep the limits of the deployment.
+type TokenLimits struct {
+	// the max label depth below the domain, which is only raised for the known deep-hierarchy users
+	MaxDepth int `json:"maxDepth,omitempty"`
+	// the requests per second and the burst of the mutating requests
+	RateLimit float64 `json:"rateLimit,omitempty"`
+	RateBurst int     `json:"rateBurst,omitempty"`
+	// the quotas of the sub-domains and the TXT values of a name, and of all the records of the token
+	MaxSubDomains int `json:"maxSubDomains,omitempty"`
+	MaxTexts      int `json:"maxTexts,omitempty"`
+	MaxRecords    int `json:"maxRecords,omitempty"`
+}
+
+func ParseTokenLimits(r *http.Request) (*TokenLimits, error) {
+	var limits TokenLimits
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&limits)
+	return &limits, err
+}
+
+// DomainPatch follows the JSON merge patch semantics: the omitted fields are kept,
+// a null sub domain is deleted, and the add & remove lists are merged into the current hosts.
+type DomainPatch struct {
+	Hosts     []string            `json:"hosts"`
+	SubDomain map[string][]string `json:"subdomain"`
+	TTL       int64               `json:"ttl"`
+	Add       DomainPatchHosts    `json:"add"`
+	Remove    DomainPatchHosts    `json:"remove"`
+}
+
+type DomainPatchHosts struct {
+	Hosts     []string            `json:"hosts"`
+	SubDomain map[string][]string `json:"subdomain"`
+}
+
+// Replaced reports whether the patch replaces any field, which is written by an update of the whole domain.
+func (p *DomainPatch) Replaced() bool {
+	return p.Hosts != nil || p.SubDomain != nil || p.TTL > 0
+}
+
+// Apply merges the replaced fields of the patch into the current domain and returns the options to update,
+// the add & remove lists are merged by the backend.
+func (p *DomainPatch) Apply(d Domain) *DomainOptions {
+	opts := &DomainOptions{
+		Fqdn:      d.Fqdn,
+		Hosts:     d.Hosts,
+		SubDomain: make(map[string][]string, len(d.SubDomain)),
+		TTL:       d.TTL,
+	}
+	for k, v := range d.SubDomain {
+		opts.SubDomain[k] = v
+	}
+
+	if p.Hosts != nil {
+		opts.Hosts = p.Hosts
+	}
+	for k, v := range p.SubDomain {
+		if v == nil {
+			delete(opts.SubDomain, k)
+			continue
+		}
+		opts.SubDomain[k] = v
+	}
+	if p.TTL > 0 {
+		opts.TTL = p.TTL
+	}
+
+	return opts
+}
+
+func ParseDomainPatch(r *http.Request) (*DomainPatch, error) {
+	var patch DomainPatch
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&patch)
+	return &patch, err
+}
+
 func ParseDomainOptions(r *http.Request) (*DomainOptions, error) {
 	var opts DomainOptions
 	decoder := json.NewDecoder(r.Body)
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Frozen is a prefix (the slug name of a domain) which can not be used by the new domains until it expires,
+// the frozen prefix without expiration never expires.
+type Frozen struct {
+	Prefix     string     `json:"prefix"`
+	Expiration *time.Time `json:"expiration,omitempty"`
+	// the prefix reserved by a token can only be claimed by the domain created with the token
+	Reserved bool `json:"reserved,omitempty"`
+	// the token of the reservation, which is only returned when the prefix is reserved
+	Token string `json:"token,omitempty"`
+}
+
+type FrozenOptions struct {
+	// the duration which the prefix is frozen for, e.g. 720h, empty means the default frozen duration
+	// and a negative one (e.g. -1s) means it never expires
+	TTL string `json:"ttl"`
+}
+
+func ParseFrozenOptions(r *http.Request) (*FrozenOptions, error) {
+	var opts FrozenOptions
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&opts)
+	return &opts, err
+}
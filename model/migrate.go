@@ -23,6 +23,7 @@ type MigrateFrozen struct {
 type MigrateToken struct {
 	Path       string     `json:"path"`
 	Token      string     `json:"token"`
+	Scopes     []string   `json:"scopes"`
 	Expiration *time.Time `json:"expiration"`
 }
 
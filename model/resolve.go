@@ -0,0 +1,16 @@
+package model
+
+type ResolveResult struct {
+	Fqdn        string             `json:"fqdn"`
+	Type        string             `json:"type"`
+	Expected    []string           `json:"expected"`
+	NameServers []NameServerResult `json:"nameservers"`
+}
+
+type NameServerResult struct {
+	NameServer string   `json:"nameserver"`
+	Answers    []string `json:"answers"`
+	Match      bool     `json:"match"`
+	Latency    string   `json:"latency"`
+	Error      string   `json:"error,omitempty"`
+}
@@ -1,8 +1,130 @@
 package model
 
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	CodeInvalidRequest = "ERR_INVALID_REQUEST"
+	CodeUnauthorized   = "ERR_UNAUTHORIZED"
+	CodeForbidden      = "ERR_FORBIDDEN"
+	CodeNotFound       = "ERR_NOT_FOUND"
+	CodeConflict       = "ERR_CONFLICT"
+	CodeDomainFrozen   = "ERR_DOMAIN_FROZEN"
+	CodeAlreadyExists  = "ERR_ALREADY_EXISTS"
+	CodeQuotaExceeded  = "ERR_QUOTA_EXCEEDED"
+	CodeUnprocessable  = "ERR_UNPROCESSABLE"
+	CodeTooManyRequest = "ERR_TOO_MANY_REQUESTS"
+	CodeInternal       = "ERR_INTERNAL"
+	CodeNotImplemented = "ERR_NOT_IMPLEMENTED"
+	CodeUnavailable    = "ERR_UNAVAILABLE"
+	CodePartialFailure = "ERR_PARTIAL_FAILURE"
+)
+
+// ErrNotFound is the cause of the errors returned when a record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidRecord is the cause of the errors returned when a record is not valid.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// ErrQuotaExceeded is the cause of the errors returned when a domain has too many records.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrNotSupported is the cause of the errors returned when a backend can not handle a record type.
+var ErrNotSupported = errors.New("not supported")
+
+// ErrReadOnly is the cause of the errors returned when the server is in read-only mode.
+var ErrReadOnly = errors.New("read-only mode")
+
+// ErrFrozen is the cause of the errors returned when a domain name is frozen and can not be used.
+var ErrFrozen = errors.New("domain frozen")
+
+// ErrUnauthorized is the cause of the errors returned when a token is no longer valid, e.g. it is replaced by a transfer.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrConflict is the cause of the errors returned when a request conflicts with another one in progress.
+var ErrConflict = errors.New("conflict")
+
+// ErrAlreadyExists is the cause of the errors returned when a record to create already exists.
+var ErrAlreadyExists = errors.New("already exists")
+
 type Response struct {
-	Status  int    `json:"status"`
-	Message string `json:"msg"`
-	Data    Domain `json:"data,omitempty"`
-	Token   string `json:"token"`
+	Status    int               `json:"status"`
+	Code      string            `json:"code,omitempty"`
+	Message   string            `json:"msg"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+	Data      Domain            `json:"data,omitempty"`
+	Token     string            `json:"token"`
+}
+
+// GetErrorCodeOf returns the machine-readable error code of the typed error, the other errors get the code of the http status.
+func GetErrorCodeOf(cause error, httpStatus int) string {
+	switch cause {
+	case ErrFrozen:
+		return CodeDomainFrozen
+	case ErrAlreadyExists:
+		return CodeAlreadyExists
+	case ErrQuotaExceeded:
+		return CodeQuotaExceeded
+	default:
+		return GetErrorCode(httpStatus)
+	}
+}
+
+// GetErrorCode returns the machine-readable error code of the http status.
+func GetErrorCode(httpStatus int) string {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequest
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
+type LogLevel struct {
+	Level    string     `json:"level"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+type LogLevelOptions struct {
+	Level string `json:"level"`
+	TTL   string `json:"ttl"`
+}
+
+func ParseLogLevelOptions(r *http.Request) (*LogLevelOptions, error) {
+	var opts LogLevelOptions
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&opts)
+	return &opts, err
+}
+
+type ReadOnly struct {
+	ReadOnly bool `json:"readOnly"`
+}
+
+func ParseReadOnlyOptions(r *http.Request) (*ReadOnly, error) {
+	var opts ReadOnly
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&opts)
+	return &opts, err
 }
@@ -0,0 +1,12 @@
+package model
+
+import (
+	"time"
+)
+
+type Stats struct {
+	Tokens    int64            `json:"tokens"`
+	Frozen    int64            `json:"frozen"`
+	Records   map[string]int64 `json:"records"`
+	UpdatedOn *time.Time       `json:"updatedOn,omitempty"`
+}
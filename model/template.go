@@ -7,13 +7,25 @@ var CoreFileTmpl = `
         reload 0
     }
     {{- end}}
-    rdns {{.Domain}} {
+    rdns {{.Domain}} in-addr.arpa ip6.arpa {
         path {{.EtcdPrefixPath}}
         endpoint {{.EtcdEndpoints}}
         upstream 8.8.8.8:53 8.8.4.4:53
         wildcardbound {{.WildCardBound}}
+        {{- if .SOA}}
+        soa {{.SOA}}
+        {{- end}}
+        {{- if .NS}}
+        ns {{.NS}}
+        {{- end}}
+        fallthrough in-addr.arpa ip6.arpa
     }
     cache {{.TTL}} {{.Domain}}
+    {{- if .DNSSECKeys}}
+    dnssec {{.Domain}} {
+        key file {{.DNSSECKeys}}
+    }
+    {{- end}}
     loadbalance
     forward . 8.8.8.8:53 8.8.4.4:53
     log stdout
@@ -28,4 +40,7 @@ type CoreFile struct {
 	EtcdEndpoints  string
 	TTL            string
 	WildCardBound  string
+	SOA            string
+	NS             string
+	DNSSECKeys     string
 }
@@ -7,41 +7,117 @@ import (
 
 	"github.com/rancher/rdns-server/backend"
 	"github.com/rancher/rdns-server/database"
+	"github.com/rancher/rdns-server/event"
 	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/readonly"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
-	flagFrozen            = "FROZEN"
-	flagLeaseTime         = "DATABASE_LEASE_TIME"
-	intervalSeconds int64 = 600
+	flagFrozen    = "FROZEN"
+	flagLeaseTime = "DATABASE_LEASE_TIME"
+	maxBackoff    = 6 * time.Hour
 )
 
+var (
+	breakerGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_dns_purge_breaker_open",
+		Help: "Whether the purge circuit breaker is open (1) or closed (0)",
+	})
+
+	failureCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rancher_dns_purge_store_failures_total",
+		Help: "The number of the purge cycles failed by store errors",
+	})
+
+	purgedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_dns_purged_tokens_total",
+		Help: "The number of the expired tokens handled by the purge cycles, the dry run ones are not deleted",
+	}, []string{"dry_run"})
+)
+
+// purger backs off when the store keeps failing, only the systemic store errors are counted,
+// the failures of deleting a single domain are not.
 type purger struct {
+	interval time.Duration
+	dryRun   bool
+	failures int
+	open     bool
+	nextRun  time.Time
+	// the clock of the cycles, which is faked by the tests
+	now func() time.Time
 }
 
-func StartPurgerDaemon(done chan struct{}) {
-	p := &purger{}
-	go wait.JitterUntil(p.purge, time.Duration(intervalSeconds)*time.Second, .1, true, done)
+// StartPurgerDaemon runs the purge cycles every interval plus a random jitter factor of it, a cycle which takes
+// longer than the interval delays the next one instead of overlapping. The dry run only logs the expired records.
+func StartPurgerDaemon(done chan struct{}, interval time.Duration, jitter float64, dryRun bool) {
+	p := &purger{interval: interval, dryRun: dryRun, now: time.Now}
+	go wait.JitterUntil(p.purge, interval, jitter, true, done)
 }
 
 func (p *purger) purge() {
+	if p.now().Before(p.nextRun) {
+		logrus.Debugf("skip purge process until %s", p.nextRun.Format(time.RFC3339))
+		return
+	}
+
+	// nothing is deleted while the records may be migrated
+	if readonly.Enabled() {
+		logrus.Debugf("skip purge process in read-only mode")
+		return
+	}
+
+	// probe with a cheap call before closing the breaker
+	if p.open {
+		if _, err := database.GetDatabase().QueryTokenCount(); err != nil {
+			p.fail(err)
+			return
+		}
+		logrus.Infof("purge circuit breaker is closed")
+		p.open = false
+		breakerGauge.Set(0)
+	}
+
 	logrus.Debugf("running purge process")
 
-	// check frozen records, delete the frozen record which is expired
-	if err := database.GetDatabase().DeleteExpiredFrozen(calculateFrozenTime()); err != nil {
-		logrus.Error(err)
+	if !p.dryRun {
+		// check frozen records, delete the frozen record which is expired
+		if err := database.GetDatabase().DeleteExpiredFrozen(calculateFrozenTime(p.now())); err != nil {
+			p.fail(err)
+			return
+		}
+
+		// check idempotency records, delete the idempotency record which is expired
+		e := p.now().Add(-model.IdempotencyExpiration)
+		if err := database.GetDatabase().DeleteExpiredIdempotency(&e); err != nil {
+			logrus.Error(err)
+		}
 	}
 
 	// check token records, delete the token record which is expired
 	// this ensures that associated records are also deleted
-	tokens, err := database.GetDatabase().QueryExpiredTokens(calculateTTLTime())
+	now := p.now()
+	tokens, err := database.GetDatabase().QueryExpiredTokens(&now, calculateLeaseTime())
 	if err != nil {
-		logrus.Error(err)
+		p.fail(err)
+		return
+	}
+	p.failures = 0
+
+	if p.dryRun {
+		for _, token := range tokens {
+			logrus.Infof("dry run: expired token of %s would be purged", token.Fqdn)
+		}
+		purgedCounter.WithLabelValues("true").Add(float64(len(tokens)))
+		logrus.Infof("dry run: %d expired tokens would be purged", len(tokens))
+		return
 	}
 
+	purged := 0
 	for _, token := range tokens {
 		// delete route53 A records & sub A records & wildcard records
 		opts := &model.DomainOptions{
@@ -79,26 +155,54 @@ func (p *purger) purge() {
 		// delete token records & referenced records
 		if err := database.GetDatabase().DeleteToken(token.Token); err != nil {
 			logrus.Error(err)
+			continue
 		}
+		event.Publish(event.TypePurged, token.Fqdn, "TOKEN")
+		purged++
+	}
+
+	purgedCounter.WithLabelValues("false").Add(float64(purged))
+	if len(tokens) > 0 {
+		logrus.Infof("purged %d of %d expired tokens", purged, len(tokens))
+	}
+}
+
+// Used to open the breaker and back off the next cycle exponentially
+func (p *purger) fail(err error) {
+	failureCounter.Inc()
+	p.failures++
+
+	backoff := p.interval
+	for i := 1; i < p.failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
 	}
+	p.nextRun = p.now().Add(backoff)
+
+	if !p.open {
+		p.open = true
+		breakerGauge.Set(1)
+		logrus.Warnf("purge circuit breaker is open")
+	}
+	logrus.Errorf("purge process failed %d times, next run at %s: %v", p.failures, p.nextRun.Format(time.RFC3339), err)
 }
 
-func calculateFrozenTime() *time.Time {
+func calculateFrozenTime(now time.Time) *time.Time {
 	f, err := time.ParseDuration(os.Getenv(flagFrozen))
 	if err != nil {
 		logrus.Fatalf(errEmptyEnv, flagFrozen)
 	}
 	d, _ := time.ParseDuration(fmt.Sprintf("%dns", int(f.Nanoseconds())))
-	e := time.Now().Add(-d)
+	e := now.Add(-d)
 	return &e
 }
 
-func calculateTTLTime() *time.Time {
+func calculateLeaseTime() time.Duration {
 	t, err := time.ParseDuration(os.Getenv(flagLeaseTime))
 	if err != nil {
 		logrus.Fatalf(errEmptyEnv, flagLeaseTime)
 	}
-	duration, _ := time.ParseDuration(fmt.Sprintf("%dns", int(t.Nanoseconds())))
-	e := time.Now().Add(-duration)
-	return &e
+	return t
 }
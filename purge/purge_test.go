@@ -0,0 +1,219 @@
+package purge
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rancher/rdns-server/backend"
+	"github.com/rancher/rdns-server/database"
+	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/readonly"
+)
+
+// fakeDatabase keeps the tokens in memory, the methods which are not used by the purger are not implemented
+type fakeDatabase struct {
+	database.Database
+	tokens  map[string]*model.Token
+	err     error
+	queries int
+}
+
+func (d *fakeDatabase) QueryTokenCount() (int64, error) {
+	d.queries++
+	return int64(len(d.tokens)), d.err
+}
+
+func (d *fakeDatabase) DeleteExpiredFrozen(*time.Time) error {
+	d.queries++
+	return d.err
+}
+
+func (d *fakeDatabase) DeleteExpiredIdempotency(*time.Time) error {
+	return nil
+}
+
+func (d *fakeDatabase) QueryExpiredTokens(now *time.Time, leaseTime time.Duration) ([]*model.Token, error) {
+	d.queries++
+	if d.err != nil {
+		return nil, d.err
+	}
+	tokens := make([]*model.Token, 0)
+	for _, t := range d.tokens {
+		// the token without its own lease time expires after the default lease time
+		lease := leaseTime
+		if t.LeaseTime > 0 {
+			lease = time.Duration(t.LeaseTime)
+		}
+		if !time.Unix(0, t.CreatedOn).Add(lease).After(*now) {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func (d *fakeDatabase) QueryExpiredTXTs(id int64) ([]*model.RecordTXT, error) {
+	return nil, nil
+}
+
+func (d *fakeDatabase) DeleteToken(token string) error {
+	for k, t := range d.tokens {
+		if t.Token == token {
+			delete(d.tokens, k)
+		}
+	}
+	return nil
+}
+
+// fakeBackend records the deleted domains
+type fakeBackend struct {
+	backend.Backend
+	deleted []string
+}
+
+func (b *fakeBackend) Get(opts *model.DomainOptions) (model.Domain, error) {
+	return model.Domain{Fqdn: opts.Fqdn}, nil
+}
+
+func (b *fakeBackend) Delete(opts *model.DomainOptions) error {
+	b.deleted = append(b.deleted, opts.Fqdn)
+	return nil
+}
+
+func (b *fakeBackend) GetCNAME(opts *model.DomainOptions) (model.Domain, error) {
+	return model.Domain{}, nil
+}
+
+// fakeClock is moved forward by the tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestPurger(t *testing.T, dryRun bool) (*purger, *fakeClock, *fakeDatabase, *fakeBackend) {
+	os.Setenv(flagFrozen, "720h")
+	os.Setenv(flagLeaseTime, "240h")
+
+	clock := &fakeClock{t: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}
+	db := &fakeDatabase{tokens: map[string]*model.Token{
+		"a": {ID: 1, Token: "a", Fqdn: "aaaaaa.lb.rancher.cloud", CreatedOn: clock.t.UnixNano()},
+		"b": {ID: 2, Token: "b", Fqdn: "bbbbbb.lb.rancher.cloud", CreatedOn: clock.t.Add(time.Hour).UnixNano()},
+	}}
+	b := &fakeBackend{}
+	database.SetDatabase(db)
+	backend.SetBackend(b)
+
+	return &purger{interval: 10 * time.Minute, dryRun: dryRun, now: clock.now}, clock, db, b
+}
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	p, clock, db, b := newTestPurger(t, false)
+
+	p.purge()
+	if len(b.deleted) != 0 {
+		t.Fatalf("expected nothing purged before the lease time, got %v", b.deleted)
+	}
+
+	clock.t = clock.t.Add(240*time.Hour + time.Minute)
+	p.purge()
+	if len(b.deleted) != 1 || b.deleted[0] != "aaaaaa.lb.rancher.cloud" || len(db.tokens) != 1 {
+		t.Fatalf("expected only the first token purged, got %v", b.deleted)
+	}
+
+	clock.t = clock.t.Add(time.Hour)
+	p.purge()
+	if len(b.deleted) != 2 || len(db.tokens) != 0 {
+		t.Fatalf("expected both tokens purged, got %v", b.deleted)
+	}
+}
+
+func TestPurgeDryRun(t *testing.T) {
+	p, clock, db, b := newTestPurger(t, true)
+
+	clock.t = clock.t.Add(480 * time.Hour)
+	p.purge()
+	if len(b.deleted) != 0 || len(db.tokens) != 2 {
+		t.Fatalf("expected nothing deleted by the dry run, got %v", b.deleted)
+	}
+}
+
+func TestPurgeBackoff(t *testing.T) {
+	p, clock, db, b := newTestPurger(t, false)
+	db.err = errors.New("database is down")
+
+	p.purge()
+	if !p.open || p.failures != 1 || !p.nextRun.Equal(clock.t.Add(p.interval)) {
+		t.Fatalf("expected the breaker open until one interval later, got open %v failures %d next run %s", p.open, p.failures, p.nextRun)
+	}
+
+	// the cycles before the next run do not touch the database
+	queries := db.queries
+	clock.t = clock.t.Add(p.interval / 2)
+	p.purge()
+	if db.queries != queries {
+		t.Fatalf("expected the cycle skipped before the next run, got %d queries", db.queries-queries)
+	}
+
+	// the probe fails again and the backoff is doubled
+	clock.t = clock.t.Add(p.interval / 2)
+	p.purge()
+	if p.failures != 2 || !p.nextRun.Equal(clock.t.Add(2*p.interval)) {
+		t.Fatalf("expected the backoff doubled, got failures %d next run %s", p.failures, p.nextRun)
+	}
+
+	// the backoff is capped
+	for i := 0; i < 20; i++ {
+		clock.t = p.nextRun
+		p.purge()
+	}
+	if d := p.nextRun.Sub(clock.t); d != maxBackoff {
+		t.Fatalf("expected the backoff capped at %s, got %s", maxBackoff, d)
+	}
+
+	// the breaker is closed when the probe passes, and the expired tokens are purged
+	db.err = nil
+	clock.t = p.nextRun.Add(240 * time.Hour)
+	p.purge()
+	if p.open || p.failures != 0 || len(b.deleted) != 2 {
+		t.Fatalf("expected the breaker closed and the tokens purged, got open %v failures %d deleted %v", p.open, p.failures, b.deleted)
+	}
+}
+
+func TestPurgeReadOnly(t *testing.T) {
+	p, clock, db, b := newTestPurger(t, false)
+	readonly.Set(true)
+	defer readonly.Set(false)
+
+	clock.t = clock.t.Add(480 * time.Hour)
+	p.purge()
+	if db.queries != 0 || len(b.deleted) != 0 {
+		t.Fatalf("expected the purge paused in read-only mode, got %d queries and deleted %v", db.queries, b.deleted)
+	}
+
+	readonly.Set(false)
+	p.purge()
+	if len(b.deleted) != 2 {
+		t.Fatalf("expected the purge resumed, got deleted %v", b.deleted)
+	}
+}
+
+func TestPurgeMixedLeaseTimes(t *testing.T) {
+	p, clock, db, b := newTestPurger(t, false)
+	db.tokens["c"] = &model.Token{ID: 3, Token: "c", Fqdn: "cccccc.lb.rancher.cloud", CreatedOn: clock.t.UnixNano(), LeaseTime: int64(6 * time.Hour)}
+
+	clock.t = clock.t.Add(6 * time.Hour)
+	p.purge()
+	if len(b.deleted) != 1 || b.deleted[0] != "cccccc.lb.rancher.cloud" {
+		t.Fatalf("expected only the demo token purged after 6h, got %v", b.deleted)
+	}
+
+	clock.t = clock.t.Add(234 * time.Hour)
+	p.purge()
+	if len(b.deleted) != 2 || b.deleted[1] != "aaaaaa.lb.rancher.cloud" {
+		t.Fatalf("expected the first standard token purged after the default lease time, got %v", b.deleted)
+	}
+}
@@ -0,0 +1,35 @@
+package readonly
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	enabled int32
+
+	readOnlyGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_dns_read_only",
+		Help: "Whether the rancher dns server rejects the mutations (1) or not (0)",
+	})
+)
+
+// Set switches the read-only mode, the mutating requests are rejected and the purger is paused while it is enabled.
+func Set(on bool) {
+	v := int32(0)
+	if on {
+		v = 1
+	}
+	if atomic.SwapInt32(&enabled, v) != v {
+		logrus.Infof("read-only mode is set to %t", on)
+	}
+	readOnlyGauge.Set(float64(v))
+}
+
+// Enabled returns whether the read-only mode is enabled.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
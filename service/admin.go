@@ -0,0 +1,36 @@
+package service
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const adminPrefix = "/admin"
+
+var adminToken string
+
+// SetAdminToken sets the credential of the admin api, the admin api is disabled when it is empty.
+func SetAdminToken(token string) {
+	adminToken = token
+}
+
+// Used to authenticate the admin api with the admin token, the domain tokens are not accepted
+func adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			returnHTTPError(w, http.StatusForbidden, errors.New("admin api is disabled, admin token is not set"))
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			returnHTTPError(w, http.StatusForbidden, errors.New("forbidden to use"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rancher/rdns-server/event"
+
+	"github.com/pkg/errors"
+)
+
+func getEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		returnHTTPError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	var lastEventID int64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		v, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			returnHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+		lastEventID = v
+	}
+
+	missed, c := event.Subscribe(lastEventID)
+	defer event.Unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for _, e := range missed {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e event.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
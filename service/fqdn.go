@@ -0,0 +1,28 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/rancher/rdns-server/util"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Used to normalize the fqdn of the request path, so that the names differ only in case or the trailing dot
+// are the same records. The vars are shared with the handlers and the other middlewares.
+func fqdnMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if fqdn, ok := vars["fqdn"]; ok {
+			name, err := util.NormalizeFqdn(fqdn)
+			if err != nil {
+				returnHTTPError(w, http.StatusBadRequest, errors.Wrapf(err, "not valid fqdn %s", fqdn))
+				return
+			}
+			vars["fqdn"] = name
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
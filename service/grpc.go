@@ -0,0 +1,409 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+
+	rdnspb "github.com/rancher/rdns-server/api/proto"
+	"github.com/rancher/rdns-server/backend"
+	"github.com/rancher/rdns-server/event"
+	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/readonly"
+	"github.com/rancher/rdns-server/util"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const grpcRequestIDKey = "x-request-id"
+
+var grpcConfig struct {
+	addr, certFile, keyFile, caFile string
+}
+
+// SetGRPC sets the address of the grpc api and its tls files, empty address disables the grpc api.
+// The api is served without tls when the cert is empty, the client certs are verified when the ca is set.
+func SetGRPC(addr, certFile, keyFile, caFile string) {
+	grpcConfig.addr = addr
+	grpcConfig.certFile = certFile
+	grpcConfig.keyFile = keyFile
+	grpcConfig.caFile = caFile
+}
+
+// grpcServer serves the management api over grpc, it calls the same backend methods as the REST handlers,
+// so that the records are changed in the same way.
+type grpcServer struct{}
+
+// NewGRPCServer returns the grpc server of the management api with the tls of SetGRPC.
+func NewGRPCServer() (*grpc.Server, error) {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpcWriteInterceptor),
+	}
+
+	if grpcConfig.certFile != "" {
+		creds, err := grpcCredentials(grpcConfig.certFile, grpcConfig.keyFile, grpcConfig.caFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	s := grpc.NewServer(opts...)
+	rdnspb.RegisterRDNSServer(s, &grpcServer{})
+	return s, nil
+}
+
+// Used to start the grpc api if it is enabled, the returned function stops it gracefully
+func startGRPC() (func(context.Context), error) {
+	if grpcConfig.addr == "" {
+		return func(context.Context) {}, nil
+	}
+
+	s, err := NewGRPCServer()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create the grpc server")
+	}
+
+	l, err := net.Listen("tcp", grpcConfig.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %s", grpcConfig.addr)
+	}
+
+	go func() {
+		if err := s.Serve(l); err != nil {
+			logrus.Errorf("failed to serve the grpc api: %v", err)
+		}
+	}()
+
+	return func(ctx context.Context) {
+		stopped := make(chan struct{})
+		go func() {
+			s.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			s.Stop()
+		}
+		logrus.Info("the grpc server is shutdown")
+	}, nil
+}
+
+func grpcCredentials(certFile, keyFile, caFile string) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load the grpc cert")
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the grpc ca")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("not valid grpc ca %s", caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(config), nil
+}
+
+// Used to reject the mutations in read-only mode and track them as in-flight writes, all unary methods are mutations
+func grpcWriteInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if readonly.Enabled() {
+		return nil, status.Error(codes.Unavailable, errors.Wrapf(model.ErrReadOnly, "failed to change records").Error())
+	}
+
+	if !beginWrite() {
+		return nil, status.Error(codes.Unavailable, "the server is shutting down")
+	}
+	defer writes.Done()
+
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) CreateDomain(ctx context.Context, req *rdnspb.CreateDomainRequest) (*rdnspb.Domain, error) {
+	opts := &model.DomainOptions{
+		Hosts:     req.Hosts,
+		SubDomain: fromGRPCSubDomain(req.SubDomain),
+		TTL:       req.Ttl,
+		LeaseTime: req.LeaseTime,
+		Owner:     req.Owner,
+	}
+
+	d, err := backend.GetBackend().Set(opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	publishGRPCEvent(ctx, event.TypeCreated, d.Fqdn, "A")
+
+	token := d.Token
+	if token == "" {
+		if token, err = generateToken(d.Fqdn); err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	res := toGRPCDomain(d)
+	res.Token = token
+	return res, nil
+}
+
+func (s *grpcServer) RenewDomain(ctx context.Context, req *rdnspb.DomainRequest) (*rdnspb.Domain, error) {
+	fqdn, err := authorizeGRPC(ctx, req.Fqdn, "", true)
+	if err != nil {
+		return nil, err
+	}
+	if err := reserveGRPCRate(fqdn); err != nil {
+		return nil, err
+	}
+
+	d, err := backend.GetBackend().Renew(&model.DomainOptions{Fqdn: fqdn})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	publishGRPCEvent(ctx, event.TypeRenewed, fqdn, "A")
+	return toGRPCDomain(d), nil
+}
+
+func (s *grpcServer) DeleteDomain(ctx context.Context, req *rdnspb.DomainRequest) (*rdnspb.Empty, error) {
+	fqdn, err := authorizeGRPC(ctx, req.Fqdn, "a", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := reserveGRPCRate(fqdn); err != nil {
+		return nil, err
+	}
+
+	if err := backend.GetBackend().Delete(&model.DomainOptions{Fqdn: fqdn}); err != nil {
+		return nil, grpcError(err)
+	}
+
+	publishGRPCEvent(ctx, event.TypeDeleted, fqdn, "A")
+	return &rdnspb.Empty{}, nil
+}
+
+func (s *grpcServer) SetTexts(ctx context.Context, req *rdnspb.SetTextsRequest) (*rdnspb.Domain, error) {
+	fqdn, err := authorizeGRPC(ctx, req.Fqdn, "txt", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := reserveGRPCRate(fqdn); err != nil {
+		return nil, err
+	}
+
+	// the texts are upserted like the text records of a batch of the REST api
+	batch := &model.RecordsBatch{TTL: req.Ttl}
+	for _, text := range req.Texts {
+		batch.Texts = append(batch.Texts, model.TextRecord{Fqdn: fqdn, Text: text})
+	}
+
+	return upsertGRPC(ctx, getTokenFqdn(fqdn), batch, "TXT")
+}
+
+func (s *grpcServer) SetSubRecords(ctx context.Context, req *rdnspb.SetSubRecordsRequest) (*rdnspb.Domain, error) {
+	fqdn, err := authorizeGRPC(ctx, req.Fqdn, "a", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := reserveGRPCRate(fqdn); err != nil {
+		return nil, err
+	}
+
+	batch := &model.RecordsBatch{SubDomain: fromGRPCSubDomain(req.SubDomain), TTL: req.Ttl}
+	return upsertGRPC(ctx, fqdn, batch, "A")
+}
+
+func (s *grpcServer) WatchRecords(req *rdnspb.WatchRecordsRequest, stream rdnspb.RDNS_WatchRecordsServer) error {
+	ctx := stream.Context()
+
+	// the admin token watches all domains, the token of a domain only watches the domain and its names
+	fqdn := ""
+	if adminToken == "" || grpcToken(ctx) != adminToken {
+		if req.Fqdn == "" {
+			return status.Error(codes.PermissionDenied, "must specific the fqdn")
+		}
+		name, err := authorizeGRPC(ctx, req.Fqdn, "", true)
+		if err != nil {
+			return err
+		}
+		fqdn = getTokenFqdn(name)
+	}
+
+	_, events := event.Subscribe(0)
+	defer event.Unsubscribe(events)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "the event stream is closed")
+			}
+			if fqdn != "" && e.Fqdn != fqdn && !strings.HasSuffix(e.Fqdn, "."+fqdn) {
+				continue
+			}
+			err := stream.Send(&rdnspb.RecordEvent{
+				Id:        e.ID,
+				Type:      e.Type,
+				Fqdn:      e.Fqdn,
+				ValueType: e.ValueType,
+				RequestId: e.RequestID,
+				Timestamp: e.Timestamp.UnixNano(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Used to upsert the batch of the domain as the REST api does, the partial failure is returned as an aborted error
+func upsertGRPC(ctx context.Context, fqdn string, batch *model.RecordsBatch, valueType string) (*rdnspb.Domain, error) {
+	defer lockDomain(fqdn)()
+
+	d, err := backend.GetBackend().UpsertRecords(fqdn, batch)
+	batchErr, partial := err.(*model.BatchError)
+	if err != nil && !partial {
+		return nil, grpcError(err)
+	}
+
+	publishGRPCEvent(ctx, event.TypeUpdated, fqdn, valueType)
+	if partial {
+		return nil, status.Errorf(codes.Aborted, "some records are failed: %s", batchErr.Error())
+	}
+	return toGRPCDomain(d), nil
+}
+
+// Used to check the token of the metadata as the token middleware does, returns the normalized fqdn
+func authorizeGRPC(ctx context.Context, fqdn, scope string, allowPrevious bool) (string, error) {
+	name, err := util.NormalizeFqdn(fqdn)
+	if err != nil || name == "" {
+		return "", status.Errorf(codes.InvalidArgument, "not valid fqdn %s", fqdn)
+	}
+
+	token := grpcToken(ctx)
+	if !compareToken(name, token, allowPrevious) {
+		// the token replaced by a transfer is invalid, not forbidden
+		if isReplacedToken(name, token) {
+			return "", status.Error(codes.Unauthenticated, "token is replaced by a transfer")
+		}
+		return "", status.Error(codes.PermissionDenied, "forbidden to use")
+	}
+	if !allowScope(name, scope) {
+		return "", status.Errorf(codes.PermissionDenied, "token is not allowed to change %s records", strings.ToUpper(scope))
+	}
+
+	return name, nil
+}
+
+// Used to limit the mutations of the token as the rate limit middleware does
+func reserveGRPCRate(fqdn string) error {
+	fqdn = getTokenFqdn(fqdn)
+
+	limits, err := backend.GetBackend().GetTokenLimits(fqdn)
+	if err != nil {
+		logrus.Errorf("failed to get token limits %s, err: %v", fqdn, err)
+	}
+
+	if d := reserveRate(fqdn, limits); d > 0 {
+		return status.Errorf(codes.ResourceExhausted, "too many requests, retry after %d seconds", int(math.Ceil(d.Seconds())))
+	}
+	return nil
+}
+
+func grpcToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		return strings.TrimPrefix(values[0], "Bearer ")
+	}
+	return ""
+}
+
+// Used to publish the event with the token, the peer address and the request id of the metadata
+func publishGRPCEvent(ctx context.Context, eventType, fqdn, valueType string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := ""
+	if values := md.Get(grpcRequestIDKey); len(values) > 0 && len(values[0]) <= maxRequestID {
+		requestID = values[0]
+	}
+
+	source := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		source, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+
+	event.PublishBy(eventType, fqdn, valueType, grpcToken(ctx), source, requestID)
+}
+
+// Used to convert the error of the backend to the grpc status with the code of its http status
+func grpcError(err error) error {
+	code := codes.Internal
+	switch getErrorStatus(err) {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+func toGRPCDomain(d model.Domain) *rdnspb.Domain {
+	res := &rdnspb.Domain{
+		Fqdn:  d.Fqdn,
+		Hosts: d.Hosts,
+		Texts: d.Texts,
+		Ttl:   d.TTL,
+	}
+	if len(d.SubDomain) > 0 {
+		res.SubDomain = make(map[string]*rdnspb.Hosts, len(d.SubDomain))
+		for k, v := range d.SubDomain {
+			res.SubDomain[k] = &rdnspb.Hosts{Hosts: v}
+		}
+	}
+	if d.Expiration != nil {
+		res.Expiration = d.Expiration.Unix()
+	}
+	return res
+}
+
+func fromGRPCSubDomain(subs map[string]*rdnspb.Hosts) map[string][]string {
+	if len(subs) == 0 {
+		return nil
+	}
+	res := make(map[string][]string, len(subs))
+	for k, v := range subs {
+		res[k] = v.GetHosts()
+	}
+	return res
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	rdnspb "github.com/rancher/rdns-server/api/proto"
+	"github.com/rancher/rdns-server/readonly"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPCClient serves the grpc api over an in-memory listener with the backend of newTestRouter
+func newTestGRPCClient(t *testing.T) (rdnspb.RDNSClient, func()) {
+	newTestRouter(t)
+
+	s, err := NewGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := bufconn.Listen(1 << 20)
+	go s.Serve(l)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+		return l.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rdnspb.NewRDNSClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token, grpcRequestIDKey, "grpc-test")
+}
+
+func TestGRPCLifecycle(t *testing.T) {
+	client, stop := newTestGRPCClient(t)
+	defer stop()
+
+	d, err := client.CreateDomain(context.Background(), &rdnspb.CreateDomainRequest{Hosts: []string{"1.1.1.1"}, LeaseTime: "6h"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if d.Fqdn == "" || d.Token == "" || len(d.Hosts) != 1 {
+		t.Fatalf("expected the domain with its token, got %v", d)
+	}
+	fqdn, token := d.Fqdn, d.Token
+
+	watchCtx, cancel := context.WithCancel(withToken(token))
+	defer cancel()
+	watch, err := client.WatchRecords(watchCtx, &rdnspb.WatchRecordsRequest{Fqdn: fqdn})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	if _, err := client.RenewDomain(withToken("wrong"), &rdnspb.DomainRequest{Fqdn: fqdn}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected the wrong token denied, got %v", err)
+	}
+
+	renewed, err := client.RenewDomain(withToken(token), &rdnspb.DomainRequest{Fqdn: strings.ToUpper(fqdn) + "."})
+	if err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if renewed.Fqdn != fqdn || renewed.Expiration < time.Now().Add(5*time.Hour).Unix() || renewed.Expiration > time.Now().Add(7*time.Hour).Unix() {
+		t.Fatalf("expected the domain renewed by its lease time, got %v", renewed)
+	}
+
+	subs, err := client.SetSubRecords(withToken(token), &rdnspb.SetSubRecordsRequest{Fqdn: fqdn, SubDomain: map[string]*rdnspb.Hosts{"sub1": {Hosts: []string{"2.2.2.2"}}}})
+	if err != nil {
+		t.Fatalf("set sub records: %v", err)
+	}
+	if len(subs.SubDomain["sub1"].GetHosts()) != 1 || len(subs.Hosts) != 1 {
+		t.Fatalf("expected the sub records added and the hosts kept, got %v", subs)
+	}
+
+	if _, err := client.SetTexts(withToken(token), &rdnspb.SetTextsRequest{Fqdn: "_acme-challenge." + fqdn, Texts: []string{"challenge-1", "challenge-2"}}); err != nil {
+		t.Fatalf("set texts: %v", err)
+	}
+
+	// the same backend is served by the REST api
+	if code, res := doRequest(t, NewRouter(), http.MethodGet, "/v1/domain/_acme-challenge."+fqdn+"/txt", token, ""); code != http.StatusOK || len(res.Data.Texts) != 2 {
+		t.Fatalf("expected the texts served by the REST api, got %d %v", code, res.Data.Texts)
+	}
+
+	if _, err := client.DeleteDomain(withToken(token), &rdnspb.DomainRequest{Fqdn: fqdn}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := client.RenewDomain(withToken(token), &rdnspb.DomainRequest{Fqdn: fqdn}); err == nil {
+		t.Fatal("expected the renew of the deleted domain failed")
+	}
+
+	expected := []string{"renewed A " + fqdn, "updated A " + fqdn, "updated TXT " + fqdn, "deleted A " + fqdn}
+	for _, e := range expected {
+		got, err := watch.Recv()
+		if err != nil {
+			t.Fatalf("watch: %v", err)
+		}
+		if s := got.Type + " " + got.ValueType + " " + got.Fqdn; s != e || got.RequestId != "grpc-test" {
+			t.Fatalf("expected the event %q, got %q with the request id %q", e, s, got.RequestId)
+		}
+	}
+}
+
+func TestGRPCReadOnly(t *testing.T) {
+	client, stop := newTestGRPCClient(t)
+	defer stop()
+	defer readonly.Set(false)
+
+	d, err := client.CreateDomain(context.Background(), &rdnspb.CreateDomainRequest{Hosts: []string{"1.1.1.1"}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	readonly.Set(true)
+	if _, err := client.RenewDomain(withToken(d.Token), &rdnspb.DomainRequest{Fqdn: d.Fqdn}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the renew rejected in read-only mode, got %v", err)
+	}
+	if _, err := client.CreateDomain(context.Background(), &rdnspb.CreateDomainRequest{Hosts: []string{"1.1.1.1"}}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the create rejected in read-only mode, got %v", err)
+	}
+
+	readonly.Set(false)
+	if _, err := client.RenewDomain(withToken(d.Token), &rdnspb.DomainRequest{Fqdn: d.Fqdn}); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+}
@@ -1,22 +1,88 @@
 package service
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/rancher/rdns-server/backend"
+	"github.com/rancher/rdns-server/event"
+	"github.com/rancher/rdns-server/health"
+	"github.com/rancher/rdns-server/loglevel"
+	"github.com/rancher/rdns-server/metric"
 	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/readonly"
+	"github.com/rancher/rdns-server/util"
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+const idempotencyHeader = "Idempotency-Key"
+
+// the default reuse window of the sub domain listing, which scans all the records of a domain
+const subDomainsReuse = 2 * time.Second
+
+// flight shares the result of the concurrent read-only lookups
+var flight util.Group
+
+// Used to get domain records, the concurrent requests for the same domain share one lookup
+func getDomainShared(opts *model.DomainOptions) (model.Domain, error) {
+	key := fmt.Sprintf("get:%s:%t", opts.Fqdn, opts.Normal)
+	v, err := flight.Do(key, util.ReuseWindow("get", 0), func() (interface{}, error) {
+		return backend.GetBackend().Get(opts)
+	})
+	d, _ := v.(model.Domain)
+	return d, err
+}
+
+// Used to list the sub domains, the result is shared by the concurrent requests and reused within the reuse window
+func listSubDomainsShared(opts *model.DomainOptions) ([]model.SubDomain, error) {
+	key := fmt.Sprintf("subdomains:%s", opts.Fqdn)
+	v, err := flight.Do(key, util.ReuseWindow("subdomains", subDomainsReuse), func() (interface{}, error) {
+		return backend.GetBackend().ListSubDomains(opts)
+	})
+	records, _ := v.([]model.SubDomain)
+	return records, err
+}
+
+var legacyErrors bool
+
+// SetLegacyErrors sets whether the error responses keep the legacy body, which only has the status and the message.
+func SetLegacyErrors(enabled bool) {
+	legacyErrors = enabled
+}
+
 func returnHTTPError(w http.ResponseWriter, httpStatus int, err error) {
-	logrus.Errorf("got a response error: %v", err)
+	returnHTTPErrorWithDetails(w, httpStatus, err, nil)
+}
+
+// Used to return the error with the details which help the client to handle it, e.g. how long to wait before retrying
+func returnHTTPErrorWithDetails(w http.ResponseWriter, httpStatus int, err error, details map[string]string) {
+	requestID := w.Header().Get(requestIDHeader)
+	logrus.WithField("request_id", requestID).Errorf("got a response error: %v", err)
 	o := model.Response{
-		Status:  httpStatus,
-		Message: err.Error(),
+		Status:    httpStatus,
+		Code:      model.GetErrorCodeOf(errors.Cause(err), httpStatus),
+		Message:   err.Error(),
+		Details:   details,
+		RequestID: requestID,
+	}
+	if legacyErrors {
+		o = model.Response{
+			Status:  httpStatus,
+			Message: err.Error(),
+		}
 	}
 	res, _ := json.Marshal(o)
 
@@ -25,6 +91,31 @@ func returnHTTPError(w http.ResponseWriter, httpStatus int, err error) {
 	w.Write(res)
 }
 
+func getErrorStatus(err error) int {
+	if cause := errors.Cause(err); cause == sql.ErrNoRows || cause == model.ErrNotFound {
+		return http.StatusNotFound
+	}
+	if errors.Cause(err) == model.ErrInvalidRecord {
+		return http.StatusBadRequest
+	}
+	if errors.Cause(err) == model.ErrUnauthorized {
+		return http.StatusUnauthorized
+	}
+	if errors.Cause(err) == model.ErrQuotaExceeded {
+		return http.StatusForbidden
+	}
+	if errors.Cause(err) == model.ErrNotSupported {
+		return http.StatusNotImplemented
+	}
+	if errors.Cause(err) == model.ErrReadOnly {
+		return http.StatusServiceUnavailable
+	}
+	if cause := errors.Cause(err); cause == model.ErrConflict || cause == model.ErrFrozen || cause == model.ErrAlreadyExists {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 func returnSuccess(w http.ResponseWriter, d model.Domain, msg string) {
 	o := model.Response{
 		Status:  http.StatusOK,
@@ -42,10 +133,17 @@ func returnSuccess(w http.ResponseWriter, d model.Domain, msg string) {
 }
 
 func returnSuccessWithToken(w http.ResponseWriter, d model.Domain, msg string) {
-	token, err := generateToken(d.Fqdn)
-	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
-		return
+	token := d.Token
+	if token == "" {
+		t, err := generateToken(d.Fqdn)
+		if err != nil {
+			returnHTTPError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if t == "" && msg == "" {
+			msg = "token is hashed at rest, it is only returned by the first response"
+		}
+		token = t
 	}
 	o := model.Response{
 		Status:  http.StatusOK,
@@ -63,9 +161,42 @@ func returnSuccessWithToken(w http.ResponseWriter, d model.Domain, msg string) {
 	w.Write(res)
 }
 
+// Used to return the domain after a batch which is partially applied, the details tell the error of every failed record
+func returnPartialSuccess(w http.ResponseWriter, d model.Domain, batchErr *model.BatchError) {
+	requestID := w.Header().Get(requestIDHeader)
+	logrus.WithField("request_id", requestID).Errorf("got a partial failure: %v", batchErr)
+
+	details := make(map[string]string, len(batchErr.Errors))
+	for k, v := range batchErr.Errors {
+		details[k] = v.Error()
+	}
+	o := model.Response{
+		Status:    http.StatusMultiStatus,
+		Code:      model.CodePartialFailure,
+		Message:   batchErr.Error(),
+		Details:   details,
+		RequestID: requestID,
+		Data:      d,
+	}
+	res, err := json.Marshal(o)
+	if err != nil {
+		returnHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(res)
+}
+
 func returnSuccessNoData(w http.ResponseWriter) {
+	returnSuccessWithMessage(w, "")
+}
+
+func returnSuccessWithMessage(w http.ResponseWriter, msg string) {
 	o := model.Response{
-		Status: http.StatusOK,
+		Status:  http.StatusOK,
+		Message: msg,
 	}
 	res, _ := json.Marshal(o)
 
@@ -79,10 +210,24 @@ func apiHandler(f http.Handler) http.Handler {
 
 func createDomain(w http.ResponseWriter, r *http.Request) {
 	vals := r.URL.Query()
+	b := backend.GetBackend()
+
+	// the retried request with the same idempotency key gets the original domain instead of creating a new one
+	key := r.Header.Get(idempotencyHeader)
+	hash := ""
+	if key != "" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			returnHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hash = fmt.Sprintf("%x", sha256.Sum256(body))
+	}
 
 	opts, err := model.ParseDomainOptions(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 
@@ -90,12 +235,70 @@ func createDomain(w http.ResponseWriter, r *http.Request) {
 		opts.Normal = true
 	}
 
-	b := backend.GetBackend()
+	if err := checkScopes(opts.Scopes); err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the reserved prefix is only claimed with the token of its reservation
+	if opts.Prefix != "" {
+		owner, ok := matchFrozenOwner(w, r, opts.Prefix)
+		if !ok {
+			return
+		}
+		opts.Reservation = owner
+	}
+
+	// the key is reserved before the domain is created, so only one of the concurrent requests creates it
+	i := &model.Idempotency{Key: key, Hash: hash}
+	if key != "" {
+		reserved, err := b.ReserveIdempotency(i)
+		if err != nil {
+			returnHTTPError(w, getErrorStatus(err), err)
+			return
+		}
+
+		if !reserved {
+			i, err = b.GetIdempotency(key)
+			if err != nil || (i.Hash == hash && i.Fqdn == "") {
+				returnHTTPErrorWithDetails(w, http.StatusConflict, errors.Wrapf(model.ErrConflict, "idempotency key %s is being used by another request, try again", key), map[string]string{"idempotencyKey": key})
+				return
+			}
+			if i.Hash != hash {
+				returnHTTPErrorWithDetails(w, http.StatusUnprocessableEntity, errors.Errorf("idempotency key %s is already used by another request", key), map[string]string{"idempotencyKey": key})
+				return
+			}
+
+			d, err := b.Get(&model.DomainOptions{Fqdn: i.Fqdn, Normal: opts.Normal})
+			if err != nil {
+				returnHTTPError(w, getErrorStatus(err), err)
+				return
+			}
+			returnSuccessWithToken(w, d, "")
+			return
+		}
+	}
+
 	d, err := b.Set(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		// the reservation is released for the retried request to create the domain
+		if key != "" {
+			if err := b.DeleteIdempotency(key); err != nil {
+				requestLogger(r).Errorf("failed to release idempotency key %s, err: %v", key, err)
+			}
+		}
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
+
+	publishEvent(r, event.TypeCreated, d.Fqdn, "A")
+	if key != "" {
+		i.Fqdn = d.Fqdn
+		if err := b.SetIdempotency(i); err != nil {
+			requestLogger(r).Errorf("failed to save idempotency key %s, err: %v", key, err)
+		}
+	}
+
 	returnSuccessWithToken(w, d, "")
 }
 
@@ -110,14 +313,56 @@ func getDomain(w http.ResponseWriter, r *http.Request) {
 		opts.Normal = true
 	}
 
-	b := backend.GetBackend()
-	d, err := b.Get(opts)
+	d, err := getDomainShared(opts)
 	if err != nil {
+		if getErrorStatus(err) == http.StatusNotFound {
+			returnHTTPError(w, http.StatusNotFound, err)
+			return
+		}
 		msg = err.Error()
 	}
 	returnSuccess(w, d, msg)
 }
 
+func getSubDomains(w http.ResponseWriter, r *http.Request) {
+	vals := r.URL.Query()
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	opts := &model.DomainOptions{Fqdn: fqdn}
+
+	d, err := getDomainShared(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	records, err := listSubDomainsShared(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	// only the sub domain records are returned, filter by name if specified
+	subs := make(map[string][]string, 0)
+	for k, v := range d.SubDomain {
+		if len(vals["name"]) > 0 && vals["name"][0] != k {
+			continue
+		}
+		subs[k] = v
+	}
+
+	details := make([]model.SubDomain, 0)
+	for _, s := range records {
+		if len(vals["name"]) > 0 && vals["name"][0] != s.Name {
+			continue
+		}
+		details = append(details, s)
+	}
+
+	returnSuccess(w, model.Domain{Fqdn: d.Fqdn, SubDomain: subs, SubDomains: details, Expiration: d.Expiration}, "")
+}
+
 func renewDomain(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	fqdn := vars["fqdn"]
@@ -127,10 +372,105 @@ func renewDomain(w http.ResponseWriter, r *http.Request) {
 	b := backend.GetBackend()
 	d, err := b.Renew(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeRenewed, fqdn, "A")
+	returnSuccess(w, d, "")
+}
+
+func transferDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	// the body is optional, it only carries the new owner of the domain
+	body, err := model.ParseDomainOptions(r)
+	if err != nil && err != io.EOF {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	opts := &model.DomainOptions{Fqdn: fqdn, Owner: body.Owner}
+
+	b := backend.GetBackend()
+	d, err := b.Transfer(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeTransferred, fqdn, "TOKEN")
+	returnSuccessWithToken(w, d, "")
+}
+
+func releaseDomain(w http.ResponseWriter, r *http.Request) {
+	vals := r.URL.Query()
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	var frozen time.Duration
+	if len(vals["frozen"]) > 0 && vals["frozen"][0] != "" {
+		f, err := time.ParseDuration(vals["frozen"][0])
+		if err != nil {
+			returnHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+		frozen = f
+	}
+
+	opts := &model.DomainOptions{Fqdn: fqdn}
+
+	b := backend.GetBackend()
+	d, err := b.Release(opts, frozen)
+	if d.Fqdn == "" {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeReleased, fqdn, "A")
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	requestLogger(r).WithFields(logrus.Fields{
+		"frozen": d.Expiration,
+	}).Infof("domain released")
+
+	returnSuccess(w, d, msg)
+}
+
+func upsertRecords(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	batch, err := model.ParseRecordsBatch(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the A scope is checked by the token middleware, the text records of the batch need the TXT scope as well
+	if len(batch.Texts) > 0 && !allowScope(fqdn, "txt") {
+		returnHTTPError(w, http.StatusForbidden, errors.New("token is not allowed to change TXT records"))
+		return
+	}
+
+	defer lockDomain(fqdn)()
+
+	b := backend.GetBackend()
+	d, err := b.UpsertRecords(fqdn, batch)
+	batchErr, partial := err.(*model.BatchError)
+	if err != nil && !partial {
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
+	publishEvent(r, event.TypeUpdated, fqdn, "A")
+	if partial {
+		returnPartialSuccess(w, d, batchErr)
+		return
+	}
 	returnSuccess(w, d, "")
 }
 
@@ -141,7 +481,7 @@ func updateDomain(w http.ResponseWriter, r *http.Request) {
 
 	opts, err := model.ParseDomainOptions(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 	if len(vals["normal"]) > 0 && vals["normal"][0] == "true" {
@@ -149,13 +489,83 @@ func updateDomain(w http.ResponseWriter, r *http.Request) {
 	}
 	opts.Fqdn = fqdn
 
+	defer lockDomain(fqdn)()
+
 	b := backend.GetBackend()
 	d, err := b.Update(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeUpdated, fqdn, "A")
+	returnSuccess(w, d, "")
+}
+
+func patchDomain(w http.ResponseWriter, r *http.Request) {
+	vals := r.URL.Query()
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	patch, err := model.ParseDomainPatch(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	opts := &model.DomainOptions{Fqdn: fqdn}
+	if len(vals["normal"]) > 0 && vals["normal"][0] == "true" {
+		opts.Normal = true
+	}
+
+	b := backend.GetBackend()
+	d, err := b.Get(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	// the replaced fields are written like a put, the add & remove lists are merged by the backend in a transaction
+	if patch.Replaced() {
+		pOpts := patch.Apply(d)
+		pOpts.Fqdn = fqdn
+		pOpts.Normal = opts.Normal
+
+		if d, err = b.Update(pOpts); err != nil {
+			returnHTTPError(w, getErrorStatus(err), err)
+			return
+		}
+	}
+
+	adds := map[string][]string{fqdn: patch.Add.Hosts}
+	for k, v := range patch.Add.SubDomain {
+		adds[fmt.Sprintf("%s.%s", k, fqdn)] = v
+	}
+	removes := map[string][]string{fqdn: patch.Remove.Hosts}
+	for k, v := range patch.Remove.SubDomain {
+		removes[fmt.Sprintf("%s.%s", k, fqdn)] = v
+	}
+
+	for name, hosts := range adds {
+		if len(hosts) <= 0 {
+			continue
+		}
+		if d, err = b.AddAHosts(name, hosts); err != nil {
+			returnHTTPError(w, getErrorStatus(err), err)
+			return
+		}
+	}
+	for name, hosts := range removes {
+		if len(hosts) <= 0 {
+			continue
+		}
+		if d, err = b.RemoveAHosts(name, hosts); err != nil {
+			returnHTTPError(w, getErrorStatus(err), err)
+			return
+		}
+	}
+
+	publishEvent(r, event.TypeUpdated, fqdn, "A")
 	returnSuccess(w, d, "")
 }
 
@@ -172,10 +582,11 @@ func deleteDomain(w http.ResponseWriter, r *http.Request) {
 	b := backend.GetBackend()
 	err := b.Delete(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
+	publishEvent(r, event.TypeDeleted, fqdn, "A")
 	returnSuccessNoData(w)
 }
 
@@ -184,7 +595,7 @@ func createDomainCNAME(w http.ResponseWriter, r *http.Request) {
 
 	opts, err := model.ParseDomainOptions(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 
@@ -195,9 +606,10 @@ func createDomainCNAME(w http.ResponseWriter, r *http.Request) {
 	b := backend.GetBackend()
 	d, err := b.SetCNAME(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
+	publishEvent(r, event.TypeCreated, d.Fqdn, "CNAME")
 	returnSuccessWithToken(w, d, "")
 }
 
@@ -215,6 +627,10 @@ func getDomainCNAME(w http.ResponseWriter, r *http.Request) {
 	b := backend.GetBackend()
 	d, err := b.GetCNAME(opts)
 	if err != nil {
+		if getErrorStatus(err) == http.StatusNotFound {
+			returnHTTPError(w, http.StatusNotFound, err)
+			return
+		}
 		msg = err.Error()
 	}
 	returnSuccess(w, d, msg)
@@ -227,7 +643,7 @@ func updateDomainCNAME(w http.ResponseWriter, r *http.Request) {
 
 	opts, err := model.ParseDomainOptions(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 	if len(vals["normal"]) > 0 && vals["normal"][0] == "true" {
@@ -238,10 +654,11 @@ func updateDomainCNAME(w http.ResponseWriter, r *http.Request) {
 	b := backend.GetBackend()
 	d, err := b.UpdateCNAME(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
+	publishEvent(r, event.TypeUpdated, fqdn, "CNAME")
 	returnSuccess(w, d, "")
 }
 
@@ -258,10 +675,11 @@ func deleteDomainCNAME(w http.ResponseWriter, r *http.Request) {
 	b := backend.GetBackend()
 	err := b.DeleteCNAME(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
+	publishEvent(r, event.TypeDeleted, fqdn, "CNAME")
 	returnSuccessNoData(w)
 }
 
@@ -270,7 +688,7 @@ func createDomainText(w http.ResponseWriter, r *http.Request) {
 	fqdn := vars["fqdn"]
 	opts, err := model.ParseDomainOptions(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 	opts.Fqdn = fqdn
@@ -278,10 +696,11 @@ func createDomainText(w http.ResponseWriter, r *http.Request) {
 	b := backend.GetBackend()
 	d, err := b.SetText(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
+	publishEvent(r, event.TypeCreated, fqdn, "TXT")
 	returnSuccess(w, d, "")
 }
 
@@ -294,6 +713,10 @@ func getDomainText(w http.ResponseWriter, r *http.Request) {
 	b := backend.GetBackend()
 	d, err := b.GetText(opts)
 	if err != nil {
+		if getErrorStatus(err) == http.StatusNotFound {
+			returnHTTPError(w, http.StatusNotFound, err)
+			return
+		}
 		msg = err.Error()
 	}
 	returnSuccess(w, d, msg)
@@ -305,17 +728,18 @@ func updateDomainText(w http.ResponseWriter, r *http.Request) {
 
 	opts, err := model.ParseDomainOptions(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 	opts.Fqdn = fqdn
 	b := backend.GetBackend()
 	d, err := b.UpdateText(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
+	publishEvent(r, event.TypeUpdated, fqdn, "TXT")
 	returnSuccess(w, d, "")
 }
 
@@ -323,32 +747,479 @@ func deleteDomainText(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	fqdn := vars["fqdn"]
 
-	opts := &model.DomainOptions{Fqdn: fqdn}
+	// only the given value is deleted when the text query is set
+	opts := &model.DomainOptions{Fqdn: fqdn, Text: r.URL.Query().Get("text")}
 	b := backend.GetBackend()
 	err := b.DeleteText(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeDeleted, fqdn, "TXT")
+	returnSuccessNoData(w)
+}
+
+func createDomainCAA(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+	opts, err := model.ParseDomainOptions(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts.Fqdn = fqdn
+
+	b := backend.GetBackend()
+	d, err := b.SetCAA(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeCreated, fqdn, "CAA")
+	returnSuccess(w, d, "")
+}
+
+func getDomainCAA(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	opts := &model.DomainOptions{Fqdn: fqdn}
+	b := backend.GetBackend()
+	d, err := b.GetCAA(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+	returnSuccess(w, d, "")
+}
+
+func updateDomainCAA(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	opts, err := model.ParseDomainOptions(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts.Fqdn = fqdn
+	b := backend.GetBackend()
+	d, err := b.UpdateCAA(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeUpdated, fqdn, "CAA")
+	returnSuccess(w, d, "")
+}
+
+func deleteDomainCAA(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	opts := &model.DomainOptions{Fqdn: fqdn}
+	b := backend.GetBackend()
+	err := b.DeleteCAA(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
+	publishEvent(r, event.TypeDeleted, fqdn, "CAA")
 	returnSuccessNoData(w)
 }
 
+func createDomainMX(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+	opts, err := model.ParseDomainOptions(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts.Fqdn = fqdn
+
+	b := backend.GetBackend()
+	d, err := b.SetMX(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeCreated, fqdn, "MX")
+	returnSuccess(w, d, "")
+}
+
+func getDomainMX(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	opts := &model.DomainOptions{Fqdn: fqdn}
+	b := backend.GetBackend()
+	d, err := b.GetMX(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+	returnSuccess(w, d, "")
+}
+
+func updateDomainMX(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	opts, err := model.ParseDomainOptions(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts.Fqdn = fqdn
+	b := backend.GetBackend()
+	d, err := b.UpdateMX(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeUpdated, fqdn, "MX")
+	returnSuccess(w, d, "")
+}
+
+func deleteDomainMX(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	opts := &model.DomainOptions{Fqdn: fqdn}
+	b := backend.GetBackend()
+	err := b.DeleteMX(opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	publishEvent(r, event.TypeDeleted, fqdn, "MX")
+	returnSuccessNoData(w)
+}
+
+func getStats(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(metric.GetStats())
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+func getLogLevel(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(loglevel.Get())
+	if err != nil {
+		returnHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+func setLogLevel(w http.ResponseWriter, r *http.Request) {
+	opts, err := model.ParseLogLevelOptions(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	level, err := logrus.ParseLevel(opts.Level)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var ttl time.Duration
+	if opts.TTL != "" {
+		ttl, err = time.ParseDuration(opts.TTL)
+		if err != nil {
+			returnHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	loglevel.Set(level, ttl)
+
+	getLogLevel(w, r)
+}
+
+func getReadOnly(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(model.ReadOnly{ReadOnly: readonly.Enabled()})
+	if err != nil {
+		returnHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+func setReadOnly(w http.ResponseWriter, r *http.Request) {
+	opts, err := model.ParseReadOnlyOptions(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	readonly.Set(opts.ReadOnly)
+
+	getReadOnly(w, r)
+}
+
+func getTokenLimits(w http.ResponseWriter, r *http.Request) {
+	fqdn := mux.Vars(r)["fqdn"]
+
+	b := backend.GetBackend()
+	limits, err := b.GetTokenLimits(fqdn)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	returnTokenLimits(w, limits)
+}
+
+func setTokenLimits(w http.ResponseWriter, r *http.Request) {
+	fqdn := mux.Vars(r)["fqdn"]
+
+	opts, err := model.ParseTokenLimits(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	if opts.MaxDepth < 0 {
+		returnHTTPError(w, http.StatusBadRequest, errors.Errorf("not valid max depth %d, must not be negative", opts.MaxDepth))
+		return
+	}
+	if opts.MaxSubDomains < 0 || opts.MaxTexts < 0 || opts.MaxRecords < 0 {
+		returnHTTPError(w, http.StatusBadRequest, errors.New("not valid quotas, must not be negative"))
+		return
+	}
+	if opts.RateLimit < 0 || opts.RateBurst < 0 {
+		returnHTTPError(w, http.StatusBadRequest, errors.Errorf("not valid rate limit %v and burst %d, must not be negative", opts.RateLimit, opts.RateBurst))
+		return
+	}
+
+	b := backend.GetBackend()
+	limits, err := b.SetTokenLimits(fqdn, *opts)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	returnTokenLimits(w, limits)
+}
+
+func returnTokenLimits(w http.ResponseWriter, limits model.TokenLimits) {
+	res, err := json.Marshal(limits)
+	if err != nil {
+		returnHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+func getFrozen(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["prefix"]
+
+	b := backend.GetBackend()
+	f, err := b.GetFrozen(prefix)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	returnFrozen(w, f)
+}
+
+func setFrozen(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["prefix"]
+
+	ttl, err := parseFrozenTTL(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	b := backend.GetBackend()
+	f, err := b.SetFrozen(prefix, ttl)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	requestLogger(r).WithFields(logrus.Fields{
+		"prefix": prefix,
+		"frozen": f.Expiration,
+	}).Infof("prefix frozen")
+
+	returnFrozen(w, f)
+}
+
+func deleteFrozen(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["prefix"]
+
+	b := backend.GetBackend()
+	if err := b.DeleteFrozen(prefix); err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	requestLogger(r).WithField("prefix", prefix).Infof("prefix unfrozen")
+
+	returnFrozen(w, model.Frozen{Prefix: prefix})
+}
+
+func reserveFrozen(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["prefix"]
+
+	ttl, err := parseFrozenTTL(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	b := backend.GetBackend()
+	f, err := b.ReserveFrozen(prefix, ttl)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	requestLogger(r).WithFields(logrus.Fields{
+		"prefix": prefix,
+		"frozen": f.Expiration,
+	}).Infof("prefix reserved")
+
+	returnFrozen(w, f)
+}
+
+func renewFrozen(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["prefix"]
+
+	ttl, err := parseFrozenTTL(r)
+	if err != nil {
+		returnHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	owner, ok := matchFrozenOwner(w, r, prefix)
+	if !ok {
+		return
+	}
+
+	b := backend.GetBackend()
+	f, err := b.RenewFrozen(prefix, owner, ttl)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	returnFrozen(w, f)
+}
+
+func releaseFrozen(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["prefix"]
+
+	owner, ok := matchFrozenOwner(w, r, prefix)
+	if !ok {
+		return
+	}
+
+	b := backend.GetBackend()
+	if err := b.ReleaseFrozen(prefix, owner); err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	requestLogger(r).WithField("prefix", prefix).Infof("prefix released")
+
+	returnFrozen(w, model.Frozen{Prefix: prefix})
+}
+
+// Used to parse the duration which the prefix is frozen for, zero means the default frozen duration
+func parseFrozenTTL(r *http.Request) (time.Duration, error) {
+	opts, err := model.ParseFrozenOptions(r)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if opts.TTL == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(opts.TTL)
+}
+
+func returnFrozen(w http.ResponseWriter, f model.Frozen) {
+	res, err := json.Marshal(f)
+	if err != nil {
+		returnHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// Used to publish the event with the token and the source address of the request
+func publishEvent(r *http.Request, eventType, fqdn, valueType string) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+	event.PublishBy(eventType, fqdn, valueType, token, source, getRequestID(r))
+
+	requestLogger(r).WithFields(logrus.Fields{
+		"fqdn":       fqdn,
+		"value_type": valueType,
+		"operation":  eventType,
+	}).Debugf("record %s", eventType)
+}
+
 func ping(w http.ResponseWriter, r *http.Request) {
 	returnSuccessNoData(w)
 }
 
+func healthz(w http.ResponseWriter, r *http.Request) {
+	returnSuccessNoData(w)
+}
+
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if err := health.Ready(); err != nil {
+		returnHTTPError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	// the server is still ready in read-only mode as the reads are served
+	if readonly.Enabled() {
+		returnSuccessWithMessage(w, "read-only mode")
+		return
+	}
+	returnSuccessNoData(w)
+}
+
 func migrateRecord(w http.ResponseWriter, r *http.Request) {
 	opts, err := model.ParseMigrateRecord(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	b := backend.GetBackend()
 	err = b.MigrateRecord(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
@@ -358,14 +1229,14 @@ func migrateRecord(w http.ResponseWriter, r *http.Request) {
 func migrateFrozen(w http.ResponseWriter, r *http.Request) {
 	opts, err := model.ParseMigrateFrozen(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	b := backend.GetBackend()
 	err = b.MigrateFrozen(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
@@ -375,14 +1246,14 @@ func migrateFrozen(w http.ResponseWriter, r *http.Request) {
 func migrateToken(w http.ResponseWriter, r *http.Request) {
 	opts, err := model.ParseMigrateToken(r)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	b := backend.GetBackend()
 	err = b.MigrateToken(opts)
 	if err != nil {
-		returnHTTPError(w, http.StatusInternalServerError, err)
+		returnHTTPError(w, getErrorStatus(err), err)
 		return
 	}
 
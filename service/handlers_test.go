@@ -0,0 +1,633 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/rdns-server/backend"
+	"github.com/rancher/rdns-server/backend/etcdv3"
+	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/util/etcdtest"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const testDomain = "lb.rancher.cloud"
+
+// newTestRouter serves the api with an etcd-v3 backend which is backed by an in-memory etcd
+func newTestRouter(t *testing.T) (*mux.Router, *etcdv3.Backend, *etcdtest.KV) {
+	client, kv := etcdtest.NewClient()
+	b := &etcdv3.Backend{
+		Domain:       testDomain,
+		Prefix:       "/rdnsv3",
+		FrozenTTL:    time.Hour,
+		LeaseTime:    240 * time.Hour,
+		MinLeaseTime: time.Hour,
+		MaxLeaseTime: 240 * time.Hour,
+		MinTTL:       10,
+		MaxTTL:       3600,
+		MaxDepth:     2,
+		TokenGrace:   10 * time.Minute,
+		C:            client,
+	}
+	backend.SetBackend(b)
+	return NewRouter(), b, kv
+}
+
+func doRequest(t *testing.T, router http.Handler, method, path, token, body string) (int, model.Response) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var res model.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%s %s: not valid response %q: %v", method, path, w.Body.String(), err)
+	}
+	return w.Code, res
+}
+
+// createTestDomain creates a domain and returns its fqdn and token
+func createTestDomain(t *testing.T, router http.Handler, body string) (string, string) {
+	code, res := doRequest(t, router, http.MethodPost, "/v1/domain", "", body)
+	if code != http.StatusOK {
+		t.Fatalf("create domain: %d %s", code, res.Message)
+	}
+	return res.Data.Fqdn, res.Token
+}
+
+func TestTransferDomain(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"], "subdomain": {"sub1": ["9.9.9.9"]}, "owner": "team-a"}`)
+
+	_, before := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, token, "")
+	if before.Data.Owner != "team-a" {
+		t.Fatalf("expected the owner team-a, got %q", before.Data.Owner)
+	}
+
+	code, res := doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/transfer", token, `{"owner": "team-b"}`)
+	if code != http.StatusOK {
+		t.Fatalf("transfer: %d %s", code, res.Message)
+	}
+	if res.Token == "" || res.Token == token {
+		t.Fatalf("expected a new token, got %q", res.Token)
+	}
+	newToken := res.Token
+
+	// the records and the expiration are kept, the owner is updated
+	code, after := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, newToken, "")
+	if code != http.StatusOK {
+		t.Fatalf("get with the new token: %d %s", code, after.Message)
+	}
+	if after.Data.Owner != "team-b" {
+		t.Errorf("expected the owner team-b, got %q", after.Data.Owner)
+	}
+	if len(after.Data.Hosts) != 1 || after.Data.Hosts[0] != "1.1.1.1" || len(after.Data.SubDomain["sub1"]) != 1 {
+		t.Errorf("expected the records to be kept, got %+v", after.Data)
+	}
+	if d := after.Data.Expiration.Sub(*before.Data.Expiration); d < -time.Second || d > time.Second {
+		t.Errorf("expected the expiration %s to be kept, got %s", before.Data.Expiration, after.Data.Expiration)
+	}
+
+	// the old token is valid for reads during the grace period, the mutations with it are unauthorized
+	if code, res := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, token, ""); code != http.StatusOK {
+		t.Errorf("get with the old token: %d %s", code, res.Message)
+	}
+	for _, r := range []struct{ method, path, body string }{
+		{http.MethodPost, "/v1/domain/" + fqdn + "/transfer", ""},
+		{http.MethodPut, "/v1/domain/" + fqdn, `{"hosts": ["2.2.2.2"]}`},
+	} {
+		code, res := doRequest(t, router, r.method, r.path, token, r.body)
+		if code != http.StatusUnauthorized || res.Code != model.CodeUnauthorized {
+			t.Errorf("%s %s with the old token: expected 401, got %d %s", r.method, r.path, code, res.Code)
+		}
+	}
+
+	// an unknown token is still forbidden
+	code, res = doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/transfer", "dW5rbm93bg==", "")
+	if code != http.StatusForbidden {
+		t.Errorf("transfer with an unknown token: expected 403, got %d %s", code, res.Message)
+	}
+
+	// the body is optional, the owner is kept without it
+	code, res = doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/transfer", newToken, "")
+	if code != http.StatusOK {
+		t.Fatalf("transfer without body: %d %s", code, res.Message)
+	}
+	_, after = doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, res.Token, "")
+	if after.Data.Owner != "team-b" {
+		t.Errorf("expected the owner team-b to be kept, got %q", after.Data.Owner)
+	}
+}
+
+func TestTransferConcurrentRenew(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+
+	for i := 0; i < 20; i++ {
+		fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+
+		var (
+			wg                        sync.WaitGroup
+			transferCode, renewCode   int
+			transferRes, renewResLast model.Response
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			transferCode, transferRes = doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/transfer", token, "")
+		}()
+		go func() {
+			defer wg.Done()
+			renewCode, renewResLast = doRequest(t, router, http.MethodPut, "/v1/domain/"+fqdn+"/renew", token, "")
+		}()
+		wg.Wait()
+
+		if transferCode != http.StatusOK {
+			t.Fatalf("transfer: %d %s", transferCode, transferRes.Message)
+		}
+		// the renew with the old token succeeds before the transfer and during the grace period after it
+		if renewCode != http.StatusOK {
+			t.Fatalf("renew: %d %s", renewCode, renewResLast.Message)
+		}
+
+		// the renew does not revert the transfer
+		if code, res := doRequest(t, router, http.MethodPut, "/v1/domain/"+fqdn, transferRes.Token, `{"hosts": ["2.2.2.2"]}`); code != http.StatusOK {
+			t.Fatalf("update with the new token: %d %s", code, res.Message)
+		}
+		if code, _ := doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/transfer", token, ""); code != http.StatusUnauthorized {
+			t.Fatalf("transfer with the old token: expected 401, got %d", code)
+		}
+	}
+}
+
+func TestPatchConcurrentAdds(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+
+	for i := 0; i < 20; i++ {
+		fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"], "subdomain": {"sub1": ["9.9.9.9"]}}`)
+
+		var wg sync.WaitGroup
+		codes := make([]int, 2)
+		for j, h := range []string{"2.2.2.2", "3.3.3.3"} {
+			wg.Add(1)
+			go func(j int, h string) {
+				defer wg.Done()
+				codes[j], _ = doRequest(t, router, http.MethodPatch, "/v1/domain/"+fqdn, token, `{"add": {"hosts": ["`+h+`"], "subdomain": {"sub2": ["`+h+`"]}}}`)
+			}(j, h)
+		}
+		wg.Wait()
+
+		for _, code := range codes {
+			if code != http.StatusOK {
+				t.Fatalf("patch: expected 200, got %d", code)
+			}
+		}
+		_, res := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, token, "")
+		if len(res.Data.Hosts) != 3 || len(res.Data.SubDomain["sub2"]) != 2 {
+			t.Fatalf("expected the hosts of both patches, got %v %v", res.Data.Hosts, res.Data.SubDomain)
+		}
+	}
+}
+
+func TestPatchDomain(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1", "3.3.3.3"], "subdomain": {"sub1": ["9.9.9.9"], "sub2": ["8.8.8.8"]}}`)
+
+	code, res := doRequest(t, router, http.MethodPatch, "/v1/domain/"+fqdn, token,
+		`{"subdomain": {"sub1": null}, "add": {"hosts": ["5.5.5.5", "1.1.1.1"]}, "remove": {"hosts": ["3.3.3.3", "4.4.4.4"], "subdomain": {"sub2": ["8.8.8.8"]}}}`)
+	if code != http.StatusOK {
+		t.Fatalf("patch: %d %s", code, res.Message)
+	}
+	if len(res.Data.Hosts) != 2 || len(res.Data.SubDomain) != 0 {
+		t.Fatalf("expected the hosts merged and both sub domains deleted, got %v %v", res.Data.Hosts, res.Data.SubDomain)
+	}
+
+	if code, _ := doRequest(t, router, http.MethodPatch, "/v1/domain/"+fqdn, token, `{"add": {"hosts": ["not-an-ip"]}}`); code != http.StatusBadRequest {
+		t.Errorf("expected an invalid host rejected, got %d", code)
+	}
+}
+
+func TestTransferConcurrentTransfers(t *testing.T) {
+	router, _, kv := newTestRouter(t)
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+
+	// both transfers read the token before either of them replaces it
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	kv.BeforeTxn = func() {
+		arrived.Done()
+		arrived.Wait()
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	tokens := make([]string, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var res model.Response
+			codes[i], res = doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/transfer", token, "")
+			tokens[i] = res.Token
+		}(i)
+	}
+	wg.Wait()
+	kv.BeforeTxn = nil
+
+	winner := -1
+	for i, code := range codes {
+		switch code {
+		case http.StatusOK:
+			winner = i
+		case http.StatusUnauthorized:
+		default:
+			t.Fatalf("expected 200 and 401, got %v", codes)
+		}
+	}
+	if winner < 0 || codes[0] == codes[1] {
+		t.Fatalf("expected one transfer to win, got %v", codes)
+	}
+	if code, res := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, tokens[winner], ""); code != http.StatusOK {
+		t.Fatalf("get with the token of the winner: %d %s", code, res.Message)
+	}
+}
+
+func TestGetErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errors.Wrap(model.ErrNotFound, "failed to get"), http.StatusNotFound, model.CodeNotFound},
+		{errors.Wrap(model.ErrInvalidRecord, "failed to set"), http.StatusBadRequest, model.CodeInvalidRequest},
+		{errors.Wrap(model.ErrUnauthorized, "failed to transfer"), http.StatusUnauthorized, model.CodeUnauthorized},
+		{errors.Wrap(model.ErrQuotaExceeded, "failed to set"), http.StatusForbidden, model.CodeQuotaExceeded},
+		{errors.Wrap(model.ErrConflict, "failed to create"), http.StatusConflict, model.CodeConflict},
+		{errors.Wrap(model.ErrFrozen, "failed to create"), http.StatusConflict, model.CodeDomainFrozen},
+		{errors.Wrap(model.ErrAlreadyExists, "failed to set"), http.StatusConflict, model.CodeAlreadyExists},
+		{errors.Wrap(model.ErrNotSupported, "failed to set"), http.StatusNotImplemented, model.CodeNotImplemented},
+		{errors.Wrap(model.ErrReadOnly, "failed to set"), http.StatusServiceUnavailable, model.CodeUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError, model.CodeInternal},
+	}
+	for _, test := range tests {
+		status := getErrorStatus(test.err)
+		if status != test.status {
+			t.Errorf("%v: expected status %d, got %d", test.err, test.status, status)
+		}
+		if code := model.GetErrorCodeOf(errors.Cause(test.err), status); code != test.code {
+			t.Errorf("%v: expected code %s, got %s", test.err, test.code, code)
+		}
+	}
+}
+
+func TestLegacyErrors(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+	defer SetLegacyErrors(false)
+
+	errorBody := func() map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/domain/missing."+testDomain, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("not valid response %q: %v", w.Body.String(), err)
+		}
+		return body
+	}
+
+	body := errorBody()
+	if body["code"] != model.CodeForbidden || body["requestId"] == nil {
+		t.Errorf("expected the code and the request id, got %v", body)
+	}
+
+	SetLegacyErrors(true)
+	body = errorBody()
+	for _, key := range []string{"code", "details", "requestId"} {
+		if _, ok := body[key]; ok {
+			t.Errorf("expected no %s in the legacy body, got %v", key, body)
+		}
+	}
+	if body["status"] != float64(http.StatusForbidden) || body["msg"] == "" {
+		t.Errorf("expected the status and the message in the legacy body, got %v", body)
+	}
+}
+
+func TestTokenLimits(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+	deep := `{"hosts": ["1.1.1.1"], "subdomain": {"a.b.c": ["2.2.2.2"]}}`
+
+	if code, res := doRequest(t, router, http.MethodPut, "/v1/domain/"+fqdn, token, deep); code != http.StatusBadRequest {
+		t.Errorf("expected status %d past the max depth, got %d %s", http.StatusBadRequest, code, res.Message)
+	}
+
+	// the domain token can not raise its own limits
+	if code, _ := doRequest(t, router, http.MethodPut, "/admin/domain/"+fqdn+"/limits", token, `{"maxDepth": 3}`); code != http.StatusForbidden {
+		t.Errorf("expected status %d with the domain token, got %d", http.StatusForbidden, code)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/domain/"+fqdn+"/limits", strings.NewReader(`{"maxDepth": 3}`))
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"maxDepth":3}` {
+		t.Fatalf("expected the limits, got %d %s", w.Code, w.Body.String())
+	}
+
+	if code, res := doRequest(t, router, http.MethodPut, "/v1/domain/"+fqdn, token, deep); code != http.StatusOK {
+		t.Errorf("expected status %d at the max depth of the token, got %d %s", http.StatusOK, code, res.Message)
+	}
+
+	if code, _ := doRequest(t, router, http.MethodPut, "/admin/domain/missing."+testDomain+"/limits", "admin", `{"maxDepth": 3}`); code != http.StatusNotFound {
+		t.Errorf("expected status %d for a missing domain, got %d", http.StatusNotFound, code)
+	}
+	if code, _ := doRequest(t, router, http.MethodPut, "/admin/domain/"+fqdn+"/limits", "admin", `{"maxDepth": -1}`); code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a negative max depth, got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestListSubDomainsShared(t *testing.T) {
+	router, _, kv := newTestRouter(t)
+
+	fqdn, _ := createTestDomain(t, router, `{"hosts": ["1.1.1.1"], "subdomain": {"sub1": ["2.2.2.2"], "sub2": ["3.3.3.3"]}}`)
+	opts := &model.DomainOptions{Fqdn: fqdn}
+
+	// the gets of one listing, which is not shared as its fqdn is different
+	other, _ := createTestDomain(t, router, `{"hosts": ["1.1.1.1"], "subdomain": {"sub1": ["2.2.2.2"], "sub2": ["3.3.3.3"]}}`)
+	before := kv.Gets()
+	if _, err := listSubDomainsShared(&model.DomainOptions{Fqdn: other}); err != nil {
+		t.Fatal(err)
+	}
+	scan := kv.Gets() - before
+	if scan <= 0 {
+		t.Fatalf("expected the listing to get from etcd, got %d gets", scan)
+	}
+
+	before = kv.Gets()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if records, err := listSubDomainsShared(opts); err != nil || len(records) != 2 {
+				t.Errorf("expected 2 sub domains, got %v %v", records, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if gets := kv.Gets() - before; gets != scan {
+		t.Errorf("expected the 50 listings to share one scan of %d gets, got %d", scan, gets)
+	}
+}
+
+func TestFrozenPrefix(t *testing.T) {
+	router, b, _ := newTestRouter(t)
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+
+	adminRequest := func(method, path, body string) (int, model.Frozen) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var f model.Frozen
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &f); err != nil {
+				t.Fatalf("%s %s: not valid response %q: %v", method, path, w.Body.String(), err)
+			}
+		}
+		return w.Code, f
+	}
+
+	if code, f := adminRequest(http.MethodPut, "/admin/frozen/brand", `{"ttl": "-1s"}`); code != http.StatusOK || f.Prefix != "brand" || f.Expiration != nil {
+		t.Errorf("expected the prefix frozen without expiration, got %d %+v", code, f)
+	}
+	if code, f := adminRequest(http.MethodGet, "/admin/frozen/brand", ""); code != http.StatusOK || f.Expiration != nil {
+		t.Errorf("expected the prefix frozen without expiration, got %d %+v", code, f)
+	}
+
+	// the default frozen duration is used without a ttl
+	if code, f := adminRequest(http.MethodPut, "/admin/frozen/brand", ""); code != http.StatusOK || f.Expiration == nil || f.Expiration.Sub(time.Now()) < b.FrozenTTL-time.Second {
+		t.Errorf("expected the default expiration, got %d %+v", code, f)
+	}
+
+	if code, _ := adminRequest(http.MethodPut, "/admin/frozen/brand", `{"ttl": "forever"}`); code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a bad ttl, got %d", http.StatusBadRequest, code)
+	}
+	if code, _ := adminRequest(http.MethodPut, "/admin/frozen/Bad_Prefix", `{}`); code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a bad prefix, got %d", http.StatusBadRequest, code)
+	}
+
+	if code, _ := adminRequest(http.MethodDelete, "/admin/frozen/brand", ""); code != http.StatusOK {
+		t.Errorf("expected the prefix unfrozen, got %d", code)
+	}
+	if code, _ := adminRequest(http.MethodGet, "/admin/frozen/brand", ""); code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unfrozen prefix, got %d", http.StatusNotFound, code)
+	}
+
+	// the prefix of a domain in use is not unfrozen
+	fqdn, _ := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+	if code, _ := adminRequest(http.MethodDelete, "/admin/frozen/"+strings.Split(fqdn, ".")[0], ""); code != http.StatusConflict {
+		t.Errorf("expected status %d for the prefix in use, got %d", http.StatusConflict, code)
+	}
+}
+
+func TestReservePrefix(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+
+	frozenRequest := func(method, path, token, body string) (int, model.Frozen) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var f model.Frozen
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &f); err != nil {
+				t.Fatalf("%s %s: not valid response %q: %v", method, path, w.Body.String(), err)
+			}
+		}
+		return w.Code, f
+	}
+
+	code, a := frozenRequest(http.MethodPost, "/v1/frozen/brand", "", `{"ttl": "30m"}`)
+	if code != http.StatusOK || a.Token == "" || !a.Reserved {
+		t.Fatalf("expected the prefix reserved with a token, got %d %+v", code, a)
+	}
+	if code, _ := frozenRequest(http.MethodPost, "/v1/frozen/brand", "", ""); code != http.StatusConflict {
+		t.Errorf("expected status %d for the reserved prefix, got %d", http.StatusConflict, code)
+	}
+	_, other := frozenRequest(http.MethodPost, "/v1/frozen/other", "", "")
+
+	// the token of another reservation neither renews nor claims the prefix
+	if code, _ := frozenRequest(http.MethodPut, "/v1/frozen/brand/renew", other.Token, ""); code != http.StatusForbidden {
+		t.Errorf("expected status %d for the renew by another token, got %d", http.StatusForbidden, code)
+	}
+	if code, res := doRequest(t, router, http.MethodPost, "/v1/domain", other.Token, `{"hosts": ["1.1.1.1"], "prefix": "brand"}`); code != http.StatusForbidden {
+		t.Fatalf("expected status %d for the claim by another token, got %d %s", http.StatusForbidden, code, res.Message)
+	}
+	if code, f := frozenRequest(http.MethodPut, "/v1/frozen/brand/renew", a.Token, `{"ttl": "1h"}`); code != http.StatusOK || f.Expiration == nil || f.Expiration.Sub(time.Now()) < 59*time.Minute {
+		t.Errorf("expected the reservation renewed, got %d %+v", code, f)
+	}
+
+	code, res := doRequest(t, router, http.MethodPost, "/v1/domain", a.Token, `{"hosts": ["1.1.1.1"], "prefix": "brand"}`)
+	if code != http.StatusOK || res.Data.Fqdn != "brand."+testDomain || res.Token == "" {
+		t.Fatalf("expected the prefix claimed, got %d %s %+v", code, res.Message, res.Data)
+	}
+	if code, f := frozenRequest(http.MethodGet, "/admin/frozen/brand", "admin", ""); code != http.StatusOK || f.Reserved {
+		t.Errorf("expected the reservation unfrozen by the claim, got %d %+v", code, f)
+	}
+	if code, _ := frozenRequest(http.MethodDelete, "/v1/frozen/brand", a.Token, ""); code != http.StatusForbidden {
+		t.Errorf("expected status %d for the release of the claimed prefix, got %d", http.StatusForbidden, code)
+	}
+
+	if code, _ := frozenRequest(http.MethodDelete, "/v1/frozen/other", other.Token, ""); code != http.StatusOK {
+		t.Errorf("expected the reservation released, got %d", code)
+	}
+	if code, _ := frozenRequest(http.MethodGet, "/admin/frozen/other", "admin", ""); code != http.StatusNotFound {
+		t.Errorf("expected status %d for the released prefix, got %d", http.StatusNotFound, code)
+	}
+}
+
+// assertNotStored fails if any stored value contains the token
+func assertNotStored(t *testing.T, kv *etcdtest.KV, token string) {
+	for _, k := range kv.Keys("") {
+		if v, _ := kv.Value(k); strings.Contains(v, token) {
+			t.Errorf("expected the token not to be stored, found in %s: %s", k, v)
+		}
+	}
+}
+
+func TestTokenHashedAtRest(t *testing.T) {
+	router, b, kv := newTestRouter(t)
+	b.TokenSecret = "secret"
+	SetTokenSecret("secret")
+	defer SetTokenSecret("")
+
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+	if token == "" {
+		t.Fatal("expected the token on creation")
+	}
+	assertNotStored(t, kv, token)
+	if v, _ := kv.Value("/tokenv3/" + strings.Replace(fqdn, ".", "_", -1)); !strings.HasPrefix(v, "hmac-sha256:") {
+		t.Errorf("expected the hashed token, got %q", v)
+	}
+
+	if code, res := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, token, ""); code != http.StatusOK {
+		t.Errorf("get with the token: %d %s", code, res.Message)
+	}
+
+	// the new token of a transfer is hashed as well, and the old one is told apart by its hash
+	code, res := doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/transfer", token, "")
+	if code != http.StatusOK || res.Token == "" {
+		t.Fatalf("transfer: %d %s", code, res.Message)
+	}
+	assertNotStored(t, kv, res.Token)
+	if code, res := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, res.Token, ""); code != http.StatusOK {
+		t.Errorf("get with the new token: %d %s", code, res.Message)
+	}
+	if code, _ := doRequest(t, router, http.MethodPut, "/v1/domain/"+fqdn, token, `{"hosts": ["2.2.2.2"]}`); code != http.StatusUnauthorized {
+		t.Errorf("update with the old token: expected 401, got %d", code)
+	}
+
+	// the token can not be generated again for the retried creation
+	req := httptest.NewRequest(http.MethodPost, "/v1/domain", strings.NewReader(`{"hosts": ["1.1.1.1"]}`))
+	req.Header.Set(idempotencyHeader, "key1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodPost, "/v1/domain", strings.NewReader(`{"hosts": ["1.1.1.1"]}`))
+	req.Header.Set(idempotencyHeader, "key1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var retried model.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &retried); err != nil || w.Code != http.StatusOK || retried.Token != "" || retried.Message == "" {
+		t.Errorf("expected the retried creation without the token, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTokenRehashedOnFirstUse(t *testing.T) {
+	router, b, kv := newTestRouter(t)
+
+	// the legacy token is stored as the plaintext origin
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+	path := "/tokenv3/" + strings.Replace(fqdn, ".", "_", -1)
+	origin, _ := kv.Value(path)
+
+	b.TokenSecret = "secret"
+	SetTokenSecret("secret")
+	defer SetTokenSecret("")
+
+	if code, res := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, token, ""); code != http.StatusOK {
+		t.Fatalf("get with the legacy token: %d %s", code, res.Message)
+	}
+	if v, _ := kv.Value(path); !strings.HasPrefix(v, "hmac-sha256:") {
+		t.Errorf("expected the legacy token to be hashed on its first use, got %q", v)
+	}
+	assertNotStored(t, kv, origin)
+	assertNotStored(t, kv, token)
+
+	// the legacy token keeps working with its hash, and keeps the lease of the domain
+	if code, res := doRequest(t, router, http.MethodPut, "/v1/domain/"+fqdn+"/renew", token, ""); code != http.StatusOK {
+		t.Errorf("renew with the rehashed token: %d %s", code, res.Message)
+	}
+	if code, _ := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, "d3Jvbmc=", ""); code != http.StatusForbidden {
+		t.Errorf("get with a wrong token: expected 403, got %d", code)
+	}
+}
+
+func TestUpsertRecordsPartial(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+
+	body := `{"hosts": ["2.2.2.2"], "subdomain": {"sub1": ["9.9.9.9"], "sub2": ["not-an-ip"]}, "texts": [{"fqdn": "_acme-challenge.` + fqdn + `", "text": "abc"}]}`
+	code, res := doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/records", token, body)
+	if code != http.StatusMultiStatus || res.Code != model.CodePartialFailure {
+		t.Fatalf("expected 207 %s, got %d %s", model.CodePartialFailure, code, res.Code)
+	}
+	if len(res.Details) != 1 || res.Details["sub2."+fqdn] == "" {
+		t.Fatalf("expected only sub2 in the details, got %v", res.Details)
+	}
+	if len(res.Data.Hosts) != 1 || res.Data.Hosts[0] != "2.2.2.2" || len(res.Data.SubDomain["sub1"]) != 1 {
+		t.Fatalf("expected the other records applied, got %v", res.Data)
+	}
+
+	code, res = doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/records", token, `{"subdomain": {"sub3": ["8.8.8.8"]}}`)
+	if code != http.StatusOK || len(res.Details) != 0 {
+		t.Fatalf("expected 200 for a batch without failed records, got %d %v", code, res.Details)
+	}
+
+	if code, _ := doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/records", "", `{"hosts": ["3.3.3.3"]}`); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without the token, got %d", code)
+	}
+}
+
+func TestUpsertRecordsScope(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"], "scopes": ["a"]}`)
+
+	body := `{"texts": [{"fqdn": "_acme-challenge.` + fqdn + `", "text": "abc"}]}`
+	if code, _ := doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/records", token, body); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for the text records without the txt scope, got %d", code)
+	}
+	if code, res := doRequest(t, router, http.MethodPost, "/v1/domain/"+fqdn+"/records", token, `{"subdomain": {"sub1": ["9.9.9.9"]}}`); code != http.StatusOK {
+		t.Fatalf("expected the A records allowed by the a scope, got %d %s", code, res.Message)
+	}
+}
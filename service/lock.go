@@ -0,0 +1,42 @@
+package service
+
+import (
+	"sync"
+)
+
+// the lock is kept in memory, so the read-modify-writes of the same domain sent to different replicas can still race
+var (
+	domainLock  sync.Mutex
+	domainLocks = make(map[string]*refMutex)
+)
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+// Used to serialize the changes of the domain A records, returns the func which releases the lock
+func lockDomain(fqdn string) func() {
+	domainLock.Lock()
+	m, ok := domainLocks[fqdn]
+	if !ok {
+		m = &refMutex{}
+		domainLocks[fqdn] = m
+	}
+	m.refs++
+	domainLock.Unlock()
+
+	m.Lock()
+
+	return func() {
+		m.Unlock()
+
+		domainLock.Lock()
+		defer domainLock.Unlock()
+
+		m.refs--
+		if m.refs <= 0 {
+			delete(domainLocks, fqdn)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/rdns-server/backend"
+	"github.com/rancher/rdns-server/model"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// the limiter which is not used for a while is dropped, it is full again anyway
+const rateIdle = 10 * time.Minute
+
+var (
+	rateLock     sync.Mutex
+	rateLimit    rate.Limit
+	rateBurst    int
+	rateLimiters = make(map[string]*tokenLimiter)
+)
+
+type tokenLimiter struct {
+	limiter *rate.Limiter
+	limit   rate.Limit
+	burst   int
+	last    time.Time
+}
+
+// SetRateLimit sets the requests per second and the burst of the mutating requests of a token,
+// every replica enforces the full limit as the limiters are kept in memory. The zero limit disables it,
+// the limits of a token override it.
+func SetRateLimit(limit float64, burst int) {
+	rateLock.Lock()
+	defer rateLock.Unlock()
+
+	rateLimit = rate.Limit(limit)
+	rateBurst = burst
+	rateLimiters = make(map[string]*tokenLimiter)
+}
+
+// Used to reserve a mutating request of the token fqdn, returns how long to wait if the limit is exceeded
+func reserveRate(fqdn string, limits model.TokenLimits) time.Duration {
+	rateLock.Lock()
+	defer rateLock.Unlock()
+
+	limit, burst := rateLimit, rateBurst
+	if limits.RateLimit > 0 {
+		limit = rate.Limit(limits.RateLimit)
+	}
+	if limits.RateBurst > 0 {
+		burst = limits.RateBurst
+	}
+	if limit <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	for k, v := range rateLimiters {
+		if now.Sub(v.last) >= rateIdle {
+			delete(rateLimiters, k)
+		}
+	}
+
+	// the limiter is renewed when the limits of the token are changed
+	l, ok := rateLimiters[fqdn]
+	if !ok || l.limit != limit || l.burst != burst {
+		l = &tokenLimiter{limiter: rate.NewLimiter(limit, burst), limit: limit, burst: burst}
+		rateLimiters[fqdn] = l
+	}
+	l.last = now
+
+	r := l.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return rateIdle
+	}
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return d
+	}
+	return 0
+}
+
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// reads and the requests without fqdn are not limited, the token of the fqdn is already checked,
+		// so the limiter is keyed by the token fqdn which is shared by the sub domains & text records
+		fqdn, ok := mux.Vars(r)["fqdn"]
+		if r.Method != http.MethodGet && ok {
+			fqdn = getTokenFqdn(fqdn)
+
+			limits, err := backend.GetBackend().GetTokenLimits(fqdn)
+			if err != nil {
+				logrus.Errorf("failed to get token limits %s, err: %v", fqdn, err)
+			}
+
+			if d := reserveRate(fqdn, limits); d > 0 {
+				retry := strconv.Itoa(int(math.Ceil(d.Seconds())))
+				w.Header().Set("Retry-After", retry)
+				returnHTTPErrorWithDetails(w, http.StatusTooManyRequests, errors.Errorf("too many requests, retry after %s seconds", retry), map[string]string{"retryAfter": retry})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
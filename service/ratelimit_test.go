@@ -0,0 +1,89 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func renewRequest(router http.Handler, fqdn, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/v1/domain/"+fqdn+"/renew", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	SetRateLimit(0.1, 3)
+	defer SetRateLimit(0, 0)
+
+	router, _, _ := newTestRouter(t)
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+	other, otherToken := createTestDomain(t, router, `{"hosts": ["2.2.2.2"]}`)
+
+	for i := 0; i < 3; i++ {
+		if w := renewRequest(router, fqdn, token); w.Code != http.StatusOK {
+			t.Fatalf("request %d in the burst: %d %s", i, w.Code, w.Body.String())
+		}
+	}
+
+	w := renewRequest(router, fqdn, token)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 after the burst, got %d %s", w.Code, w.Body.String())
+	}
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retry < 1 || retry > 10 {
+		t.Fatalf("expected Retry-After within 10 seconds, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// the text records share the limiter of the token fqdn
+	if code, _ := doRequest(t, router, http.MethodPost, "/v1/domain/_acme-challenge."+fqdn+"/txt", token, `{"text": "challenge"}`); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for the text record of the limited token, got %d", code)
+	}
+
+	// the reads and the other tokens are not limited
+	if code, _ := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, token, ""); code != http.StatusOK {
+		t.Fatalf("expected the read to pass, got %d", code)
+	}
+	if w := renewRequest(router, other, otherToken); w.Code != http.StatusOK {
+		t.Fatalf("expected the other token to pass, got %d %s", w.Code, w.Body.String())
+	}
+
+	// the rejected requests are not reserved, so an invalid token does not drain the limiter either
+	for i := 0; i < 5; i++ {
+		if w := renewRequest(router, other, "dW5rbm93bg=="); w.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for an invalid token, got %d", w.Code)
+		}
+	}
+	if w := renewRequest(router, other, otherToken); w.Code != http.StatusOK {
+		t.Fatalf("expected the other token to keep its burst, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRateLimitOverride(t *testing.T) {
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+	SetRateLimit(0.1, 1)
+	defer SetRateLimit(0, 0)
+
+	router, _, _ := newTestRouter(t)
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+
+	if code, _ := doRequest(t, router, http.MethodPut, "/admin/domain/"+fqdn+"/limits", "admin", `{"rateBurst": 5}`); code != http.StatusOK {
+		t.Fatalf("set limits: %d", code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := renewRequest(router, fqdn, token); w.Code != http.StatusOK {
+			t.Fatalf("request %d in the raised burst: %d %s", i, w.Code, w.Body.String())
+		}
+	}
+	if w := renewRequest(router, fqdn, token); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 after the raised burst, got %d", w.Code)
+	}
+
+	if code, _ := doRequest(t, router, http.MethodPut, "/admin/domain/"+fqdn+"/limits", "admin", `{"rateLimit": -1}`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a negative rate limit, got %d", code)
+	}
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/readonly"
+
+	"github.com/pkg/errors"
+)
+
+// the seconds which the client is told to wait before retrying a mutation in read-only mode
+const readOnlyRetryAfter = "60"
+
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// reads and admin requests are served, so that the mode can be switched off again
+		if r.Method != http.MethodGet && !strings.HasPrefix(r.URL.Path, "/admin") && readonly.Enabled() {
+			w.Header().Set("Retry-After", readOnlyRetryAfter)
+			err := errors.Wrapf(model.ErrReadOnly, "failed to change records")
+			returnHTTPErrorWithDetails(w, getErrorStatus(err), err, map[string]string{"retryAfter": readOnlyRetryAfter})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/rdns-server/health"
+	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/readonly"
+)
+
+func TestReadOnly(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+	defer readonly.Set(false)
+
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+
+	done := make(chan struct{})
+	defer close(done)
+	go health.StartHealthDaemon(done, 10*time.Millisecond)
+	for health.Ready() != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	if code, _ := doRequest(t, router, http.MethodPost, "/admin/readonly", "", `{"readOnly": true}`); code != http.StatusForbidden {
+		t.Fatalf("expected the mode switched only with the admin token, got %d", code)
+	}
+	if code, res := doRequest(t, router, http.MethodPost, "/admin/readonly", "admin", `{"readOnly": true}`); code != http.StatusOK || !readonly.Enabled() {
+		t.Fatalf("expected the read-only mode on, got %d %s", code, res.Message)
+	}
+
+	// the reads keep working
+	if code, res := doRequest(t, router, http.MethodGet, "/v1/domain/"+fqdn, token, ""); code != http.StatusOK || len(res.Data.Hosts) != 1 {
+		t.Fatalf("expected the domain served in read-only mode, got %d %s", code, res.Message)
+	}
+	if code, res := doRequest(t, router, http.MethodGet, "/readyz", "", ""); code != http.StatusOK || res.Message != "read-only mode" {
+		t.Fatalf("expected readyz to report the read-only mode, got %d %q", code, res.Message)
+	}
+	if value := metricValue(t, router, "rancher_dns_read_only"); value != "1" {
+		t.Fatalf("expected the read-only metric 1, got %q", value)
+	}
+
+	// the renew fails with a retry
+	req := httptest.NewRequest(http.MethodPut, "/v1/domain/"+fqdn+"/renew", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != readOnlyRetryAfter {
+		t.Fatalf("expected the renew rejected with 503 and Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if code, res := doRequest(t, router, http.MethodPost, "/v1/domain", "", `{"hosts": ["2.2.2.2"]}`); code != http.StatusServiceUnavailable || res.Code != model.CodeUnavailable {
+		t.Fatalf("expected the create rejected with %s, got %d %s", model.CodeUnavailable, code, res.Code)
+	}
+
+	if code, _ := doRequest(t, router, http.MethodPost, "/admin/readonly", "admin", `{"readOnly": false}`); code != http.StatusOK || readonly.Enabled() {
+		t.Fatal("expected the read-only mode off")
+	}
+	if code, res := doRequest(t, router, http.MethodPut, "/v1/domain/"+fqdn+"/renew", token, ""); code != http.StatusOK {
+		t.Fatalf("expected the renew to pass after the read-only mode, got %d %s", code, res.Message)
+	}
+	if value := metricValue(t, router, "rancher_dns_read_only"); value != "0" {
+		t.Fatalf("expected the read-only metric 0, got %q", value)
+	}
+}
+
+// metricValue returns the value of the metric without labels from the /metrics output
+func metricValue(t *testing.T, router http.Handler, name string) string {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			return strings.TrimPrefix(line, name+" ")
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return ""
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rancher/rdns-server/util"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDLength = 16
+	maxRequestID    = 128 // the longer id of the client is replaced, so that it does not flood the logs
+)
+
+type requestIDKey struct{}
+
+// Used to accept the request id of the client or generate one, it is returned in the response header
+// and carried by the logs and the events of the request.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestID {
+			id = util.RandStringWithAll(requestIDLength)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Used to get the request id from the context of the request
+func getRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// Used to get the logger of the request, the entries carry the request id, the method and the fqdn
+func requestLogger(r *http.Request) *logrus.Entry {
+	fields := logrus.Fields{
+		"request_id": getRequestID(r),
+		"method":     r.Method,
+	}
+	if fqdn := mux.Vars(r)["fqdn"]; fqdn != "" {
+		fields["fqdn"] = fqdn
+	}
+	return logrus.WithFields(fields)
+}
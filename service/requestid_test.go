@@ -0,0 +1,98 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logHook captures the log entries of the tests
+type logHook struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (h *logHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *logHook) Fire(e *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func TestRequestIDLogs(t *testing.T) {
+	router, _, _ := newTestRouter(t)
+
+	hook := &logHook{}
+	logrus.AddHook(hook)
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.DebugLevel)
+	defer func() {
+		logrus.SetLevel(level)
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	}()
+
+	send := func(method, path, token, body, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(requestIDHeader, id)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: %d %s", method, path, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get(requestIDHeader); got != id {
+			t.Fatalf("expected the request id %s in the response, got %q", id, got)
+		}
+		return w
+	}
+
+	fqdn, token := createTestDomain(t, router, `{"hosts": ["1.1.1.1"]}`)
+	hook.entries = nil
+
+	send(http.MethodPost, "/v1/domain", "", `{"hosts": ["1.1.1.1"]}`, "create-1")
+	send(http.MethodPut, "/v1/domain/"+fqdn, token, `{"hosts": ["2.2.2.2"]}`, "update-1")
+
+	found := map[string]logrus.Fields{}
+	for _, e := range hook.entries {
+		if id, ok := e.Data["request_id"].(string); ok {
+			found[id] = e.Data
+		}
+	}
+	for _, id := range []string{"create-1", "update-1"} {
+		data, ok := found[id]
+		if !ok {
+			t.Fatalf("expected a log entry with the request id %s, got %d entries", id, len(hook.entries))
+		}
+		if data["value_type"] != "A" || data["fqdn"] == "" || data["operation"] == "" {
+			t.Fatalf("expected the fqdn, value type and operation in the entry of %s, got %v", id, data)
+		}
+	}
+	if found["update-1"]["fqdn"] != fqdn || found["update-1"]["operation"] != "updated" {
+		t.Fatalf("expected the update of %s, got %v", fqdn, found["update-1"])
+	}
+
+	// the request id of the client is replaced when it is too long
+	w := send(http.MethodGet, "/v1/domain/"+fqdn, token, "", "short")
+	if w.Header().Get(requestIDHeader) != "short" {
+		t.Fatal("expected the request id of the client kept")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/v1/domain/"+fqdn, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(requestIDHeader, strings.Repeat("x", maxRequestID+1))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); len(got) != requestIDLength {
+		t.Fatalf("expected a generated request id, got %q", got)
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/rdns-server/backend"
+	"github.com/rancher/rdns-server/model"
+
+	"github.com/gorilla/mux"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	resolveTimeout  = 2 * time.Second
+	resolveInterval = 5 * time.Second
+)
+
+var (
+	resolveLock sync.Mutex
+	resolveLast = make(map[string]time.Time)
+)
+
+func resolveDomain(w http.ResponseWriter, r *http.Request) {
+	vals := r.URL.Query()
+	vars := mux.Vars(r)
+	fqdn := vars["fqdn"]
+
+	// the resolve check is rate-limited per token, since the token is bound to the fqdn
+	if !allowResolve(r.Header.Get("Authorization")) {
+		returnHTTPError(w, http.StatusTooManyRequests, errors.Errorf("too many resolve requests for %s", fqdn))
+		return
+	}
+
+	rType := strings.ToUpper(vals.Get("type"))
+	if rType == "" {
+		rType = "A"
+	}
+
+	expected, err := getExpectedAnswers(fqdn, rType)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return
+	}
+
+	result := model.ResolveResult{
+		Fqdn:        fqdn,
+		Type:        rType,
+		Expected:    expected,
+		NameServers: make([]model.NameServerResult, 0),
+	}
+	for _, ns := range backend.GetBackend().GetNameServers() {
+		result.NameServers = append(result.NameServers, queryNameServer(ns, fqdn, rType, expected))
+	}
+
+	res, err := json.Marshal(result)
+	if err != nil {
+		returnHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+func allowResolve(token string) bool {
+	resolveLock.Lock()
+	defer resolveLock.Unlock()
+
+	now := time.Now()
+	for k, v := range resolveLast {
+		if now.Sub(v) >= resolveInterval {
+			delete(resolveLast, k)
+		}
+	}
+
+	if _, ok := resolveLast[token]; ok {
+		return false
+	}
+	resolveLast[token] = now
+	return true
+}
+
+func getExpectedAnswers(fqdn, rType string) ([]string, error) {
+	b := backend.GetBackend()
+	opts := &model.DomainOptions{Fqdn: fqdn}
+
+	switch rType {
+	case "A":
+		d, err := b.Get(opts)
+		return d.Hosts, err
+	case "TXT":
+		d, err := b.GetText(opts)
+		return d.Texts, err
+	case "CNAME":
+		d, err := b.GetCNAME(opts)
+		return []string{strings.TrimRight(d.CNAME, ".")}, err
+	default:
+		return nil, errors.Errorf("not supported record type: %s", rType)
+	}
+}
+
+func queryNameServer(ns, fqdn, rType string, expected []string) model.NameServerResult {
+	result := model.NameServerResult{
+		NameServer: ns,
+		Answers:    make([]string, 0),
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(fqdn), dns.StringToType[rType])
+
+	c := &dns.Client{Timeout: resolveTimeout}
+	in, rtt, err := c.Exchange(m, ns)
+	result.Latency = rtt.String()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, rr := range in.Answer {
+		switch v := rr.(type) {
+		case *dns.A:
+			result.Answers = append(result.Answers, v.A.String())
+		case *dns.TXT:
+			result.Answers = append(result.Answers, strings.Join(v.Txt, ""))
+		case *dns.CNAME:
+			result.Answers = append(result.Answers, strings.TrimRight(v.Target, "."))
+		}
+	}
+
+	result.Match = equalAnswers(result.Answers, expected)
+	return result
+}
+
+func equalAnswers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := append([]string{}, a...)
+	y := append([]string{}, b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -17,6 +18,8 @@ type Route struct {
 
 type Routes []Route
 
+const domainPrefix = "/v1"
+
 var routes = Routes{
 	Route{
 		"ping",
@@ -24,6 +27,18 @@ var routes = Routes{
 		"/ping",
 		ping,
 	},
+	Route{
+		"healthz",
+		"GET",
+		"/healthz",
+		healthz,
+	},
+	Route{
+		"readyz",
+		"GET",
+		"/readyz",
+		readyz,
+	},
 	Route{
 		"getDomain",
 		"GET",
@@ -42,18 +57,54 @@ var routes = Routes{
 		"/v1/domain/{fqdn}",
 		updateDomain,
 	},
+	Route{
+		"patchDomain",
+		"PATCH",
+		"/v1/domain/{fqdn}",
+		patchDomain,
+	},
 	Route{
 		"deleteDomain",
 		"DELETE",
 		"/v1/domain/{fqdn}",
 		deleteDomain,
 	},
+	Route{
+		"getSubDomains",
+		"GET",
+		"/v1/domain/{fqdn}/subdomains",
+		getSubDomains,
+	},
+	Route{
+		"resolveDomain",
+		"GET",
+		"/v1/domain/{fqdn}/resolve",
+		resolveDomain,
+	},
 	Route{
 		"renewDomain",
 		"PUT",
 		"/v1/domain/{fqdn}/renew",
 		renewDomain,
 	},
+	Route{
+		"transferDomain",
+		"POST",
+		"/v1/domain/{fqdn}/transfer",
+		transferDomain,
+	},
+	Route{
+		"releaseDomain",
+		"POST",
+		"/v1/domain/{fqdn}/release",
+		releaseDomain,
+	},
+	Route{
+		"upsertRecords",
+		"POST",
+		"/v1/domain/{fqdn}/records",
+		upsertRecords,
+	},
 	Route{
 		"createDomainCNAME",
 		"POST",
@@ -102,6 +153,138 @@ var routes = Routes{
 		"/v1/domain/{fqdn}/txt",
 		deleteDomainText,
 	},
+	Route{
+		"createDomainCAA",
+		"POST",
+		"/v1/domain/{fqdn}/caa",
+		createDomainCAA,
+	},
+	Route{
+		"getDomainCAA",
+		"GET",
+		"/v1/domain/{fqdn}/caa",
+		getDomainCAA,
+	},
+	Route{
+		"updateDomainCAA",
+		"PUT",
+		"/v1/domain/{fqdn}/caa",
+		updateDomainCAA,
+	},
+	Route{
+		"deleteDomainCAA",
+		"DELETE",
+		"/v1/domain/{fqdn}/caa",
+		deleteDomainCAA,
+	},
+	Route{
+		"createDomainMX",
+		"POST",
+		"/v1/domain/{fqdn}/mx",
+		createDomainMX,
+	},
+	Route{
+		"getDomainMX",
+		"GET",
+		"/v1/domain/{fqdn}/mx",
+		getDomainMX,
+	},
+	Route{
+		"updateDomainMX",
+		"PUT",
+		"/v1/domain/{fqdn}/mx",
+		updateDomainMX,
+	},
+	Route{
+		"deleteDomainMX",
+		"DELETE",
+		"/v1/domain/{fqdn}/mx",
+		deleteDomainMX,
+	},
+	Route{
+		"getStats",
+		"GET",
+		"/admin/stats",
+		getStats,
+	},
+	Route{
+		"getEvents",
+		"GET",
+		"/admin/events",
+		getEvents,
+	},
+	Route{
+		"getLogLevel",
+		"GET",
+		"/admin/loglevel",
+		getLogLevel,
+	},
+	Route{
+		"setLogLevel",
+		"PUT",
+		"/admin/loglevel",
+		setLogLevel,
+	},
+	Route{
+		"getReadOnly",
+		"GET",
+		"/admin/readonly",
+		getReadOnly,
+	},
+	Route{
+		"setReadOnly",
+		"POST",
+		"/admin/readonly",
+		setReadOnly,
+	},
+	Route{
+		"getTokenLimits",
+		"GET",
+		"/admin/domain/{fqdn}/limits",
+		getTokenLimits,
+	},
+	Route{
+		"setTokenLimits",
+		"PUT",
+		"/admin/domain/{fqdn}/limits",
+		setTokenLimits,
+	},
+	Route{
+		"getFrozen",
+		"GET",
+		"/admin/frozen/{prefix}",
+		getFrozen,
+	},
+	Route{
+		"setFrozen",
+		"PUT",
+		"/admin/frozen/{prefix}",
+		setFrozen,
+	},
+	Route{
+		"deleteFrozen",
+		"DELETE",
+		"/admin/frozen/{prefix}",
+		deleteFrozen,
+	},
+	Route{
+		"reserveFrozen",
+		"POST",
+		"/v1/frozen/{prefix}",
+		reserveFrozen,
+	},
+	Route{
+		"renewFrozen",
+		"PUT",
+		"/v1/frozen/{prefix}/renew",
+		renewFrozen,
+	},
+	Route{
+		"releaseFrozen",
+		"DELETE",
+		"/v1/frozen/{prefix}",
+		releaseFrozen,
+	},
 	Route{
 		"migrateRecords",
 		"POST",
@@ -125,18 +308,41 @@ var routes = Routes{
 func NewRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
+	// the admin api is authenticated by the admin token, the domain api by the token of the domain
+	admin := router.PathPrefix(adminPrefix).Subrouter()
+	domain := router.PathPrefix(domainPrefix).Subrouter()
+
 	logrus.Debugf("setting HTTP handlers")
 	for _, route := range routes {
-		router.
+		r, pattern := router, route.Pattern
+		switch {
+		case strings.HasPrefix(pattern, adminPrefix+"/"):
+			r, pattern = admin, strings.TrimPrefix(pattern, adminPrefix)
+		case strings.HasPrefix(pattern, domainPrefix+"/"):
+			r, pattern = domain, strings.TrimPrefix(pattern, domainPrefix)
+		}
+		r.
 			Methods(route.Method).
-			Path(route.Pattern).
+			Path(pattern).
 			Name(route.Name).
 			Handler(apiHandler(route.HandlerFunc))
 	}
 
 	router.Handle("/metrics", promhttp.Handler())
 
-	router.Use(tokenMiddleware)
+	router.Use(requestIDMiddleware)
+
+	router.Use(fqdnMiddleware)
+
+	router.Use(shutdownMiddleware)
+
+	router.Use(readOnlyMiddleware)
+
+	admin.Use(adminMiddleware)
+
+	domain.Use(tokenMiddleware)
+
+	domain.Use(rateLimitMiddleware)
 
 	return router
 }
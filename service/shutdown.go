@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rancher/rdns-server/event"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	writeLock sync.RWMutex
+	draining  bool
+	writes    sync.WaitGroup
+)
+
+// Serve serves the API on the address, and the grpc api if it is enabled, until SIGTERM or SIGINT is received,
+// then the new mutating requests are rejected and the in-flight ones are drained within the timeout before it returns.
+func Serve(addr string, timeout time.Duration) error {
+	server := &http.Server{Addr: addr, Handler: NewRouter()}
+	// the event streams are never idle, they are closed for the shutdown to not wait for them
+	server.RegisterOnShutdown(event.CloseSubscribers)
+
+	stopGRPC, err := startGRPC()
+	if err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sig)
+
+	select {
+	case err := <-errc:
+		stopGRPC(context.Background())
+		return err
+	case s := <-sig:
+		logrus.Infof("received %s, draining the in-flight requests", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := drainWrites(ctx); err != nil {
+		logrus.Warn(err)
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		return errors.Wrapf(err, "failed to shutdown the api server")
+	}
+
+	// the watch streams of the grpc api are ended by the shutdown of the event subscribers
+	stopGRPC(ctx)
+
+	logrus.Info("the api server is shutdown")
+	return nil
+}
+
+// Used to stop accepting the mutating requests and wait for the in-flight ones until the context is done
+func drainWrites(ctx context.Context) error {
+	writeLock.Lock()
+	draining = true
+	writeLock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		writes.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "failed to drain the in-flight writes")
+	}
+}
+
+// Used to track the request as an in-flight write, returns false if the writes are not accepted anymore
+func beginWrite() bool {
+	writeLock.RLock()
+	defer writeLock.RUnlock()
+
+	if draining {
+		return false
+	}
+	writes.Add(1)
+	return true
+}
+
+func shutdownMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// reads are still served until the server is shutdown
+		if r.Method == http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !beginWrite() {
+			w.Header().Set("Connection", "close")
+			returnHTTPError(w, http.StatusServiceUnavailable, errors.New("the server is shutting down"))
+			return
+		}
+		defer writes.Done()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrainWrites(t *testing.T) {
+	defer func() {
+		writeLock.Lock()
+		draining = false
+		writeLock.Unlock()
+	}()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	completed := make(chan struct{})
+	handler := shutdownMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			close(started)
+			<-release
+			close(completed)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/v1/domain/aaaaaa.lb.rancher.cloud", nil))
+		return w.Code
+	}
+
+	go serve(http.MethodPut)
+	<-started
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- drainWrites(context.Background())
+	}()
+
+	// the drain waits for the slow write, the new writes are rejected but the reads are served
+	for !isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+	if code := serve(http.MethodPost); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the new write rejected with 503, got %d", code)
+	}
+	if code := serve(http.MethodGet); code != http.StatusOK {
+		t.Fatalf("expected the read served while draining, got %d", code)
+	}
+	select {
+	case <-drained:
+		t.Fatal("expected the drain to wait for the in-flight write")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	select {
+	case <-completed:
+	default:
+		t.Fatal("expected the write completed before the drain returned")
+	}
+}
+
+func TestDrainWritesTimeout(t *testing.T) {
+	defer func() {
+		writeLock.Lock()
+		draining = false
+		writeLock.Unlock()
+	}()
+
+	if !beginWrite() {
+		t.Fatal("expected the write accepted")
+	}
+	defer writes.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := drainWrites(ctx); err == nil {
+		t.Fatal("expected the drain to time out with a write in flight")
+	}
+}
+
+func isDraining() bool {
+	writeLock.RLock()
+	defer writeLock.RUnlock()
+	return draining
+}
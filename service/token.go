@@ -1,18 +1,26 @@
 package service
 
 import (
-	"encoding/base64"
 	"net/http"
 	"strings"
 
 	"github.com/rancher/rdns-server/backend"
+	"github.com/rancher/rdns-server/model"
+	"github.com/rancher/rdns-server/util"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 )
 
+var tokenSecret string
+
+// SetTokenSecret sets the server secret which the tokens are hashed at rest with, so that the legacy plaintext tokens are hashed on their first use.
+func SetTokenSecret(secret string) {
+	tokenSecret = secret
+}
+
+// Used to generate the token of the fqdn from the stored origin, the token hashed at rest can not be generated again
 func generateToken(fqdn string) (string, error) {
 	b := backend.GetBackend()
 	origin, err := b.GetToken(fqdn)
@@ -20,17 +28,20 @@ func generateToken(fqdn string) (string, error) {
 		logrus.Errorf("failed to get token origin %s, err: %v", fqdn, err)
 		return "", err
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(origin), bcrypt.MinCost)
+	if util.IsHashedToken(origin) {
+		return "", nil
+	}
+
+	token, err := util.GenerateToken(origin)
 	if err != nil {
 		logrus.Errorf("failed to generate token with %s, err: %v", fqdn, err)
 		return "", err
 	}
-
-	token := base64.StdEncoding.EncodeToString(hash)
 	return token, nil
 }
 
-func compareToken(fqdn, token string) bool {
+// Used to get the fqdn which the token belongs to
+func getTokenFqdn(fqdn string) string {
 	// normal text record & acme text record need special treatment
 	fqdnLen := len(strings.Split(fqdn, "."))
 	rootDomainLen := len(strings.Split(backend.GetBackend().GetZone(), "."))
@@ -39,12 +50,11 @@ func compareToken(fqdn, token string) bool {
 		sp := strings.SplitAfterN(fqdn, ".", diffLen)
 		fqdn = sp[len(sp)-1]
 	}
+	return fqdn
+}
 
-	hash, err := base64.StdEncoding.DecodeString(token)
-	if err != nil {
-		logrus.Errorf("failed to decode token: %s", fqdn)
-		return false
-	}
+func compareToken(fqdn, token string, allowPrevious bool) bool {
+	fqdn = getTokenFqdn(fqdn)
 
 	b := backend.GetBackend()
 	origin, err := b.GetToken(fqdn)
@@ -53,32 +63,148 @@ func compareToken(fqdn, token string) bool {
 		return false
 	}
 
-	err = bcrypt.CompareHashAndPassword(hash, []byte(origin))
+	if util.MatchToken(tokenSecret, token, origin) {
+		// the legacy plaintext origin is replaced with the hash of the token on its first use
+		if tokenSecret != "" && !util.IsHashedToken(origin) {
+			if err := b.RehashToken(fqdn, origin, util.HashToken(tokenSecret, token)); err != nil {
+				logrus.Errorf("failed to rehash token %s, err: %v", fqdn, err)
+			}
+		}
+		logrus.Debugf("token **** matched with fqdn %s", fqdn)
+		return true
+	}
+
+	// the token replaced by a transfer is still valid during the grace period
+	if allowPrevious {
+		if previous, err := b.GetPreviousToken(fqdn); err == nil && util.MatchToken(tokenSecret, token, previous) {
+			logrus.Debugf("previous token **** matched with fqdn %s", fqdn)
+			return true
+		}
+	}
+
+	logrus.WithField("fqdn", fqdn).Errorf("failed to compare token ****")
+	return false
+}
+
+// Used to check whether the token is replaced by a transfer, so that it is told apart from an unknown token
+func isReplacedToken(fqdn, token string) bool {
+	fqdn = getTokenFqdn(fqdn)
+
+	replaced, err := backend.GetBackend().GetReplacedTokens(fqdn)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"token": token,
-			"fqdn":  fqdn,
-		}).Errorf("failed to compare token, err: %v", err)
+		logrus.Errorf("failed to get replaced tokens %s, err: %v", fqdn, err)
 		return false
 	}
-	logrus.Debugf("token **** matched with fqdn %s", fqdn)
-	return true
+	for _, origin := range replaced {
+		if util.MatchToken(tokenSecret, token, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Used to match the token of the request with the owner of the reserved prefix, returns the stored token of the owner.
+// The error response is written when the token does not match.
+func matchFrozenOwner(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	owner, err := backend.GetBackend().GetFrozenOwner(prefix)
+	if err != nil {
+		returnHTTPError(w, getErrorStatus(err), err)
+		return "", false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if owner == "" || !util.MatchToken(tokenSecret, token, owner) {
+		returnHTTPError(w, http.StatusForbidden, errors.Errorf("prefix %s is not reserved by the token", prefix))
+		return "", false
+	}
+	return owner, true
+}
+
+// Used to get the record type which the request changes, the empty scope means no scope is required
+func getRequestScope(r *http.Request) string {
+	if r.Method == http.MethodGet || strings.HasSuffix(r.URL.Path, "/renew") {
+		return ""
+	}
+	for _, scope := range model.TokenScopes {
+		if strings.HasSuffix(r.URL.Path, "/"+scope) {
+			return scope
+		}
+	}
+	// the A & sub A records, and the transfer & release which change the whole domain
+	return "a"
+}
+
+func checkScopes(scopes []string) error {
+	for _, s := range scopes {
+		valid := false
+		for _, scope := range model.TokenScopes {
+			if s == scope {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return errors.Errorf("not valid token scope %s, must be one of %s", s, strings.Join(model.TokenScopes, ","))
+		}
+	}
+	return nil
+}
+
+func allowScope(fqdn, scope string) bool {
+	if scope == "" {
+		return true
+	}
+
+	scopes, err := backend.GetBackend().GetTokenScopes(getTokenFqdn(fqdn))
+	if err != nil {
+		logrus.Errorf("failed to get token scopes %s, err: %v", fqdn, err)
+		return false
+	}
+
+	// the token without scopes has full access
+	if len(scopes) <= 0 {
+		return true
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
 
 func tokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// createDomain and ping and metrics have no need to check token
+		// createDomain has no need to check token, ping and health and metrics and admin are not served by the domain api
 		logrus.Debugf("request URL path: %s", r.URL.Path)
-		if (r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/txt")) ||
-			(r.Method != http.MethodPost && !strings.HasPrefix(r.URL.Path, "/ping") && !strings.HasPrefix(r.URL.Path, "/metrics")) {
+
+		// the reservations of the prefixes are checked against the token of their owner by the handlers
+		if _, ok := mux.Vars(r)["prefix"]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if (r.Method == http.MethodPost && (strings.Contains(r.URL.Path, "/txt") || strings.HasSuffix(r.URL.Path, "/caa") || strings.HasSuffix(r.URL.Path, "/mx") || strings.HasSuffix(r.URL.Path, "/transfer") || strings.HasSuffix(r.URL.Path, "/release") || strings.HasSuffix(r.URL.Path, "/records"))) ||
+			r.Method != http.MethodPost {
 			authorization := r.Header.Get("Authorization")
-			token := strings.TrimLeft(authorization, "Bearer ")
+			token := strings.TrimPrefix(authorization, "Bearer ")
 			fqdn, ok := mux.Vars(r)["fqdn"]
 			if ok {
-				if !compareToken(fqdn, token) {
+				// read-only and renew requests are allowed with the token replaced by a transfer
+				allowPrevious := r.Method == http.MethodGet || (r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/renew"))
+				if !compareToken(fqdn, token, allowPrevious) {
+					// the token replaced by a transfer is invalid, not forbidden
+					if isReplacedToken(fqdn, token) {
+						returnHTTPError(w, http.StatusUnauthorized, errors.Wrap(model.ErrUnauthorized, "token is replaced by a transfer"))
+						return
+					}
 					returnHTTPError(w, http.StatusForbidden, errors.New("forbidden to use"))
 					return
 				}
+				if scope := getRequestScope(r); !allowScope(fqdn, scope) {
+					returnHTTPError(w, http.StatusForbidden, errors.Errorf("token is not allowed to change %s records", strings.ToUpper(scope)))
+					return
+				}
 			} else {
 				returnHTTPError(w, http.StatusForbidden, errors.New("must specific the fqdn"))
 				return
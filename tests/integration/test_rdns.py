@@ -1,6 +1,8 @@
 import os
 import json
 import time
+import uuid
+import threading
 import requests
 
 
@@ -107,21 +109,92 @@ def test_server_apis():  # NOQA
     response = get_domain_test(url, token)
     assert response != ""
     result = response.json()
-    assert result['status'] == 200
-    assert result['data'] == {}
+    assert result['status'] == 404
+    assert result['code'] == "ERR_NOT_FOUND"
 
     # check acme text record
     acme_url = build_url(BASE_URL, "/_acme-challenge." + fqdn, "/txt")
     response = get_domain_test(acme_url, token)
     assert response != ""
     result = response.json()
+    assert result['status'] == 404
+    assert result['code'] == "ERR_NOT_FOUND"
+
+
+def test_scoped_token():  # NOQA
+    # test create with a txt-only token
+    url = build_url(BASE_URL, "", "")
+    response = create_domain_test(url,
+                                  {
+                                      'hosts': ["1.1.1.1"],
+                                      'scopes': ["txt"],
+                                  })
+    assert response != ""
+    result = response.json()
+    if result['status'] == 501:
+        # the backend does not support token scopes
+        return
     assert result['status'] == 200
-    assert result['data'] == {}
+    token = result['token']
+    fqdn = result['data']['fqdn']
+
+    # check the A record can not be updated
+    url = build_url(BASE_URL, "/" + fqdn, "")
+    response = update_domain_test(url, token, {'hosts': ["2.2.2.2"]})
+    assert response.status_code == 403
+
+    # check the text record can be created
+    acme_url = build_url(BASE_URL, "/_acme-challenge." + fqdn, "/txt")
+    response = create_domain_text_test(acme_url, token, {'text': "scoped"})
+    assert response != ""
+    result = response.json()
+    assert result['status'] == 200
+
+
+def test_concurrent_idempotency_key():  # NOQA
+    # test concurrent creates with the same idempotency key
+    url = build_url(BASE_URL, "", "")
+    key = str(uuid.uuid4())
+    data = {'hosts': ["1.1.1.1"]}
+    results = []
+
+    def create():
+        response = create_domain_test(url, data, key)
+        results.append(response.json())
+
+    threads = [threading.Thread(target=create) for _ in range(5)]
+    for t in threads:
+        t.start()
+    for t in threads:
+        t.join()
+
+    # only one domain is created, the others get it or are asked to retry
+    fqdns = set()
+    for result in results:
+        assert result['status'] in [200, 409]
+        if result['status'] == 200:
+            fqdns.add(result['data']['fqdn'])
+        else:
+            assert result['code'] == "ERR_CONFLICT"
+    assert len(fqdns) == 1
+
+    # test the retry gets the same domain
+    response = create_domain_test(url, data, key)
+    result = response.json()
+    assert result['status'] == 200
+    assert result['data']['fqdn'] in fqdns
+
+    # test the key can not be used with another payload
+    response = create_domain_test(url, {'hosts': ["2.2.2.2"]}, key)
+    result = response.json()
+    assert result['status'] == 422
 
 
 # This method creates the domain
-def create_domain_test(url, data):
+def create_domain_test(url, data, key=""):
     headers = build_header("")
+    if key != "":
+        headers["Idempotency-Key"] = key
     response = requests.post(url, data=json.dumps(data), headers=headers)
     return response
 
@@ -0,0 +1,481 @@
+// Package etcdtest provides an in-memory etcd key-value store with leases, which stands in for an etcd cluster
+// in the tests of the etcd-v3 backend and the rdns plugin.
+package etcdtest
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// KV implements clientv3.KV and clientv3.Lease in memory. The leases never expire by themselves, Expire drops one
+// with its keys as etcd does when the lease times out.
+type KV struct {
+	mu        sync.Mutex
+	rev       int64
+	kvs       map[string]*mvccpb.KeyValue
+	leases    map[clientv3.LeaseID]int64
+	nextLease clientv3.LeaseID
+
+	// the number of the range requests, including those of the transactions
+	gets int
+
+	// the error of every request while the cluster is not available
+	err error
+
+	// BeforeTxn is called before a transaction is committed, e.g. to interleave a concurrent write
+	BeforeTxn func()
+}
+
+// New returns an empty store.
+func New() *KV {
+	return &KV{
+		kvs:    make(map[string]*mvccpb.KeyValue),
+		leases: make(map[clientv3.LeaseID]int64),
+	}
+}
+
+// NewClient returns a client which is backed by a new empty store.
+func NewClient() (*clientv3.Client, *KV) {
+	kv := New()
+	return &clientv3.Client{KV: kv, Lease: kv}, kv
+}
+
+// Gets returns the number of the range requests which are served.
+func (s *KV) Gets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+// Keys returns the sorted keys which start with the prefix.
+func (s *KV) Keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0)
+	for k := range s.kvs {
+		if len(k) >= len(prefix) && k[:len(prefix)] == prefix {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Value returns the value of the key and whether it exists.
+func (s *KV) Value(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kv, ok := s.kvs[key]
+	if !ok {
+		return "", false
+	}
+	return string(kv.Value), true
+}
+
+// Fail makes every request fail with the error, e.g. to simulate an unavailable cluster. A nil error recovers it.
+func (s *KV) Fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Expire drops the lease and the keys which are attached to it.
+func (s *KV) Expire(id clientv3.LeaseID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoke(id)
+}
+
+func (s *KV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	r, err := s.Do(ctx, clientv3.OpPut(key, val, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return r.Put(), nil
+}
+
+func (s *KV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	r, err := s.Do(ctx, clientv3.OpGet(key, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(), nil
+}
+
+func (s *KV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	r, err := s.Do(ctx, clientv3.OpDelete(key, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return r.Del(), nil
+}
+
+func (s *KV) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	return &clientv3.CompactResponse{}, nil
+}
+
+func (s *KV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	if err := s.check(ctx); err != nil {
+		return clientv3.OpResponse{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.apply(op)
+	if err != nil {
+		return clientv3.OpResponse{}, err
+	}
+
+	switch {
+	case op.IsGet():
+		return (*clientv3.GetResponse)(r.GetResponseRange()).OpResponse(), nil
+	case op.IsPut():
+		return (*clientv3.PutResponse)(r.GetResponsePut()).OpResponse(), nil
+	default:
+		return (*clientv3.DeleteResponse)(r.GetResponseDeleteRange()).OpResponse(), nil
+	}
+}
+
+func (s *KV) Txn(ctx context.Context) clientv3.Txn {
+	return &txn{kv: s, ctx: ctx}
+}
+
+func (s *KV) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextLease++
+	s.leases[s.nextLease] = ttl
+	return &clientv3.LeaseGrantResponse{ResponseHeader: s.header(), ID: s.nextLease, TTL: ttl}, nil
+}
+
+func (s *KV) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.leases[id]; !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	s.revoke(id)
+	return &clientv3.LeaseRevokeResponse{Header: s.header()}, nil
+}
+
+func (s *KV) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ttl, ok := s.leases[id]
+	if !ok {
+		return &clientv3.LeaseTimeToLiveResponse{ResponseHeader: s.header(), ID: id, TTL: -1}, nil
+	}
+	return &clientv3.LeaseTimeToLiveResponse{ResponseHeader: s.header(), ID: id, TTL: ttl, GrantedTTL: ttl}, nil
+}
+
+func (s *KV) Leases(ctx context.Context) (*clientv3.LeaseLeasesResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	leases := make([]clientv3.LeaseStatus, 0, len(s.leases))
+	for id := range s.leases {
+		leases = append(leases, clientv3.LeaseStatus{ID: id})
+	}
+	return &clientv3.LeaseLeasesResponse{ResponseHeader: s.header(), Leases: leases}, nil
+}
+
+func (s *KV) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	r, err := s.KeepAliveOnce(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c := make(chan *clientv3.LeaseKeepAliveResponse, 1)
+	c <- r
+	return c, nil
+}
+
+func (s *KV) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ttl, ok := s.leases[id]
+	if !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	return &clientv3.LeaseKeepAliveResponse{ResponseHeader: s.header(), ID: id, TTL: ttl}, nil
+}
+
+func (s *KV) Close() error {
+	return nil
+}
+
+func (s *KV) check(ctx context.Context) error {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (s *KV) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: s.rev}
+}
+
+func (s *KV) revoke(id clientv3.LeaseID) {
+	delete(s.leases, id)
+	for k, kv := range s.kvs {
+		if clientv3.LeaseID(kv.Lease) == id {
+			delete(s.kvs, k)
+		}
+	}
+}
+
+// Used to return the sorted keys of the range of the op
+func (s *KV) rangeKeys(key, end []byte) []string {
+	keys := make([]string, 0)
+	for k := range s.kvs {
+		b := []byte(k)
+		switch {
+		case len(end) == 0:
+			if !bytes.Equal(b, key) {
+				continue
+			}
+		case bytes.Equal(end, []byte{0}):
+			if bytes.Compare(b, key) < 0 {
+				continue
+			}
+		default:
+			if bytes.Compare(b, key) < 0 || bytes.Compare(b, end) >= 0 {
+				continue
+			}
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Used to apply the op, the lock must be held
+func (s *KV) apply(op clientv3.Op) (*pb.ResponseOp, error) {
+	o := reflect.ValueOf(op)
+
+	switch {
+	case op.IsGet():
+		s.gets++
+		keys := s.rangeKeys(op.KeyBytes(), op.RangeBytes())
+		r := &pb.RangeResponse{Header: s.header(), Count: int64(len(keys))}
+		if op.IsCountOnly() {
+			return &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: r}}, nil
+		}
+		if limit := o.FieldByName("limit").Int(); limit > 0 && int64(len(keys)) > limit {
+			keys = keys[:limit]
+			r.More = true
+		}
+		for _, k := range keys {
+			kv := *s.kvs[k]
+			if op.IsKeysOnly() {
+				kv.Value = nil
+			}
+			r.Kvs = append(r.Kvs, &kv)
+		}
+		return &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: r}}, nil
+	case op.IsPut():
+		key := string(op.KeyBytes())
+		lease := o.FieldByName("leaseID").Int()
+		prev, exist := s.kvs[key]
+		if o.FieldByName("ignoreLease").Bool() {
+			if !exist {
+				return nil, rpctypes.ErrKeyNotFound
+			}
+			lease = prev.Lease
+		} else if _, ok := s.leases[clientv3.LeaseID(lease)]; lease != 0 && !ok {
+			return nil, rpctypes.ErrLeaseNotFound
+		}
+
+		s.rev++
+		kv := &mvccpb.KeyValue{Key: []byte(key), Value: op.ValueBytes(), Lease: lease, CreateRevision: s.rev, ModRevision: s.rev, Version: 1}
+		if exist {
+			kv.CreateRevision = prev.CreateRevision
+			kv.Version = prev.Version + 1
+		}
+		s.kvs[key] = kv
+		return &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: &pb.PutResponse{Header: s.header()}}}, nil
+	case op.IsDelete():
+		keys := s.rangeKeys(op.KeyBytes(), op.RangeBytes())
+		if len(keys) > 0 {
+			s.rev++
+		}
+		for _, k := range keys {
+			delete(s.kvs, k)
+		}
+		r := &pb.DeleteRangeResponse{Header: s.header(), Deleted: int64(len(keys))}
+		return &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: r}}, nil
+	default:
+		cmps, thenOps, elseOps := op.Txn()
+		r, err := s.commit(cmps, thenOps, elseOps)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.ResponseOp{Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: (*pb.TxnResponse)(r)}}, nil
+	}
+}
+
+// Used to commit the transaction, the lock must be held
+func (s *KV) commit(cmps []clientv3.Cmp, thenOps, elseOps []clientv3.Op) (*clientv3.TxnResponse, error) {
+	succeeded := true
+	for _, c := range cmps {
+		if !s.compare(c) {
+			succeeded = false
+			break
+		}
+	}
+
+	ops := thenOps
+	if !succeeded {
+		ops = elseOps
+	}
+
+	// the ops are applied on a copy, so that a failing op leaves the store unchanged
+	kvs := make(map[string]*mvccpb.KeyValue, len(s.kvs))
+	for k, v := range s.kvs {
+		kvs[k] = v
+	}
+	rev := s.rev
+
+	r := &clientv3.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		resp, err := s.apply(op)
+		if err != nil {
+			s.kvs, s.rev = kvs, rev
+			return nil, err
+		}
+		r.Responses = append(r.Responses, resp)
+	}
+
+	// all the writes of a transaction share one revision as in etcd
+	if s.rev > rev+1 {
+		for k, v := range s.kvs {
+			if v.ModRevision > rev {
+				c := *v
+				c.ModRevision = rev + 1
+				if c.CreateRevision > rev {
+					c.CreateRevision = rev + 1
+				}
+				s.kvs[k] = &c
+			}
+		}
+		s.rev = rev + 1
+	}
+	r.Header = s.header()
+	return r, nil
+}
+
+func (s *KV) compare(c clientv3.Cmp) bool {
+	p := pb.Compare(c)
+	kv, ok := s.kvs[string(p.Key)]
+	if !ok {
+		kv = &mvccpb.KeyValue{}
+	}
+
+	var result int
+	switch p.Target {
+	case pb.Compare_VALUE:
+		if !ok {
+			return false
+		}
+		result = bytes.Compare(kv.Value, p.GetValue())
+	case pb.Compare_VERSION:
+		result = compareInt(kv.Version, p.GetVersion())
+	case pb.Compare_CREATE:
+		result = compareInt(kv.CreateRevision, p.GetCreateRevision())
+	case pb.Compare_MOD:
+		result = compareInt(kv.ModRevision, p.GetModRevision())
+	case pb.Compare_LEASE:
+		result = compareInt(kv.Lease, p.GetLease())
+	}
+
+	switch p.Result {
+	case pb.Compare_EQUAL:
+		return result == 0
+	case pb.Compare_NOT_EQUAL:
+		return result != 0
+	case pb.Compare_GREATER:
+		return result > 0
+	default:
+		return result < 0
+	}
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+type txn struct {
+	kv      *KV
+	ctx     context.Context
+	cmps    []clientv3.Cmp
+	thenOps []clientv3.Op
+	elseOps []clientv3.Op
+}
+
+func (t *txn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.cmps = append(t.cmps, cs...)
+	return t
+}
+
+func (t *txn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.thenOps = append(t.thenOps, ops...)
+	return t
+}
+
+func (t *txn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.elseOps = append(t.elseOps, ops...)
+	return t
+}
+
+func (t *txn) Commit() (*clientv3.TxnResponse, error) {
+	if t.kv.BeforeTxn != nil {
+		t.kv.BeforeTxn()
+	}
+	if err := t.kv.check(t.ctx); err != nil {
+		return nil, err
+	}
+
+	t.kv.mu.Lock()
+	defer t.kv.mu.Unlock()
+	return t.kv.commit(t.cmps, t.thenOps, t.elseOps)
+}
@@ -0,0 +1,13 @@
+package util
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// NormalizeFqdn returns the canonical name which the records are stored with: lowercase, without the trailing dot,
+// and the non-ASCII labels are converted to punycode, e.g. Bücher.LB.Rancher.Cloud. => xn--bcher-kva.lb.rancher.cloud
+func NormalizeFqdn(fqdn string) (string, error) {
+	return idna.ToASCII(strings.ToLower(strings.TrimSuffix(fqdn, ".")))
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestNormalizeFqdn(t *testing.T) {
+	tests := []struct {
+		name     string
+		fqdn     string
+		expected string
+	}{
+		{"canonical", "abc.lb.rancher.cloud", "abc.lb.rancher.cloud"},
+		{"mixed case", "Foo.LB.Rancher.Cloud", "foo.lb.rancher.cloud"},
+		{"trailing dot", "foo.lb.rancher.cloud.", "foo.lb.rancher.cloud"},
+		{"mixed case with trailing dot", "_ACME-Challenge.Foo.lb.rancher.cloud.", "_acme-challenge.foo.lb.rancher.cloud"},
+		{"non-ASCII label", "Bücher.lb.rancher.cloud", "xn--bcher-kva.lb.rancher.cloud"},
+		{"punycode label", "xn--bcher-kva.lb.rancher.cloud", "xn--bcher-kva.lb.rancher.cloud"},
+	}
+	for _, test := range tests {
+		fqdn, err := NormalizeFqdn(test.fqdn)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if fqdn != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, fqdn)
+		}
+	}
+}
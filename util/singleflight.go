@@ -0,0 +1,97 @@
+package util
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Group deduplicates the concurrent calls with the same key, so that the expensive
+// read-only operations (e.g. list and count) are executed only once and the result is shared.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg      sync.WaitGroup
+	val     interface{}
+	err     error
+	expires time.Time
+}
+
+// Do executes fn once for the concurrent calls with the same key, the result is reused for
+// the calls arriving within the reuse window after fn returns. Mutating operations must not use it.
+func (g *Group) Do(key string, reuse time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok && (c.expires.IsZero() || time.Now().Before(c.expires)) {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+
+	g.mu.Lock()
+	if reuse > 0 && c.err == nil {
+		c.expires = time.Now().Add(reuse)
+	} else {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	c.wg.Done()
+
+	return c.val, c.err
+}
+
+var (
+	windowsMu sync.RWMutex
+	windows   = make(map[string]time.Duration)
+)
+
+// SetReuseWindows sets the reuse windows of the methods, e.g. {"subdomains": 2s}, the other methods keep their defaults.
+func SetReuseWindows(w map[string]time.Duration) {
+	windowsMu.Lock()
+	defer windowsMu.Unlock()
+	windows = w
+}
+
+// ReuseWindow returns the reuse window of the method, or the default of the method when it is not set.
+func ReuseWindow(method string, def time.Duration) time.Duration {
+	windowsMu.RLock()
+	defer windowsMu.RUnlock()
+	if w, ok := windows[method]; ok {
+		return w
+	}
+	return def
+}
+
+// ParseReuseWindows parses the reuse windows of the methods, e.g. subdomains=2s,stats=5s,get=0s
+func ParseReuseWindows(s string) (map[string]time.Duration, error) {
+	w := make(map[string]time.Duration)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("not valid reuse window %s, must be method=duration", item)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d < 0 {
+			return nil, errors.Errorf("not valid reuse window %s, must be method=duration", item)
+		}
+		w[kv[0]] = d
+	}
+	return w, nil
+}
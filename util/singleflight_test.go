@@ -0,0 +1,87 @@
+package util
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupDo(t *testing.T) {
+	var g Group
+	var calls int32
+
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return "result", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := g.Do("list", time.Hour, fn); err != nil || v != "result" {
+				t.Errorf("expected the shared result, got %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+
+	// the calls without a reuse window only share the result of the call in progress
+	g.Do("get", 0, fn)
+	g.Do("get", 0, fn)
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestGroupDoReuseExpired(t *testing.T) {
+	var g Group
+	var calls int32
+
+	fn := func() (interface{}, error) {
+		return atomic.AddInt32(&calls, 1), nil
+	}
+
+	g.Do("list", 20*time.Millisecond, fn)
+	if v, _ := g.Do("list", 20*time.Millisecond, fn); v != int32(1) {
+		t.Errorf("expected the reused result, got %v", v)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if v, _ := g.Do("list", 20*time.Millisecond, fn); v != int32(2) {
+		t.Errorf("expected a new call after the reuse window, got %v", v)
+	}
+}
+
+func TestParseReuseWindows(t *testing.T) {
+	defer SetReuseWindows(nil)
+
+	w, err := ParseReuseWindows("subdomains=2s, stats=5s,get=0s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetReuseWindows(w)
+
+	tests := map[string]time.Duration{"subdomains": 2 * time.Second, "stats": 5 * time.Second, "get": 0, "tokens": time.Minute}
+	for method, want := range tests {
+		if got := ReuseWindow(method, time.Minute); got != want {
+			t.Errorf("expected the reuse window %s of %s, got %s", want, method, got)
+		}
+	}
+
+	for _, s := range []string{"subdomains", "subdomains=2", "=2s", "stats=-1s"} {
+		if _, err := ParseReuseWindows(s); err == nil {
+			t.Errorf("expected an error of %q", s)
+		}
+	}
+	if w, err := ParseReuseWindows(""); err != nil || len(w) != 0 {
+		t.Errorf("expected no reuse windows, got %v %v", w, err)
+	}
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the prefix of the stored tokens which are hashed at rest, the other stored tokens are the legacy plaintext origins
+const hashedTokenPrefix = "hmac-sha256:"
+
+// GenerateToken returns the token which is given to the client for the origin, it is the bcrypt hash of the origin.
+func GenerateToken(origin string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(origin), bcrypt.MinCost)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash), nil
+}
+
+// HashToken returns the value which is stored for the token when the tokens are hashed at rest,
+// it is keyed by the server secret so that the stored value can not be used to forge a token.
+func HashToken(secret, token string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return hashedTokenPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsHashedToken returns whether the stored value is a hashed token rather than a legacy plaintext origin.
+func IsHashedToken(stored string) bool {
+	return strings.HasPrefix(stored, hashedTokenPrefix)
+}
+
+// MatchToken returns whether the token matches the stored value, which is either the hash of the token
+// or the legacy plaintext origin which the token is generated from.
+func MatchToken(secret, token, stored string) bool {
+	if IsHashedToken(stored) {
+		return secret != "" && hmac.Equal([]byte(HashToken(secret, token)), []byte(stored))
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(stored)) == nil
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchToken(t *testing.T) {
+	origin := RandStringWithAll(32)
+	token, err := GenerateToken(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := HashToken("secret", token)
+
+	if !IsHashedToken(hashed) || IsHashedToken(origin) {
+		t.Errorf("expected only %q to be hashed", hashed)
+	}
+	if strings.Contains(hashed, token) || strings.Contains(hashed, origin) {
+		t.Errorf("expected the hash %q not to contain the token", hashed)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		stored string
+		match  bool
+	}{
+		{"legacy origin", "", token, origin, true},
+		{"legacy origin with secret", "secret", token, origin, true},
+		{"hashed", "secret", token, hashed, true},
+		{"hashed with another secret", "another", token, hashed, false},
+		{"hashed without secret", "", token, hashed, false},
+		{"the hash as token", "secret", hashed, hashed, false},
+		{"the origin as token", "", origin, origin, false},
+		{"wrong token", "secret", "d3Jvbmc=", hashed, false},
+	}
+	for _, test := range tests {
+		if match := MatchToken(test.secret, test.token, test.stored); match != test.match {
+			t.Errorf("%s: expected match %t, got %t", test.name, test.match, match)
+		}
+	}
+}